@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	cli "github.com/urfave/cli"
+	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
+	"github.com/xenolog/l23/u1804"
+)
+
+// Exit codes of drift checking, compatible with monitoring plugins
+const (
+	CheckOK       = 0 // network scheme, runtime and stored config agree
+	CheckWarning  = 1 // stored config differs from network scheme or runtime
+	CheckCritical = 2 // runtime differs from network scheme
+	CheckUnknown  = 3 // drift can't be checked
+)
+
+// Drift -- differences between two descriptions of network topology by
+// network primitives and config files
+type Drift struct {
+	Title   string
+	Names   []string // network primitives and config files in order of finding
+	Changes map[string][]string
+	Skipped string // reason, why descriptions can't be compared
+}
+
+func NewDrift(title string) *Drift {
+	return &Drift{
+		Title:   title,
+		Names:   []string{},
+		Changes: make(map[string][]string),
+	}
+}
+
+func (s *Drift) add(name string, changes ...string) {
+	if _, ok := s.Changes[name]; !ok {
+		s.Names = append(s.Names, name)
+	}
+	s.Changes[name] = append(s.Changes[name], changes...)
+}
+
+// addDivergence -- add differences, found by divergence(), in the order of
+// network primitives
+func (s *Drift) addDivergence(order []string, diverged map[string][]string) {
+	for _, name := range order {
+		if changes, ok := diverged[name]; ok {
+			s.add(name, changes...)
+		}
+	}
+}
+
+func (s *Drift) IsEqual() bool {
+	return len(s.Names) == 0
+}
+
+// DriftReport -- results of comparing network scheme, runtime and stored
+// network config with each other
+type DriftReport struct {
+	SchemeRuntime *Drift
+	SchemeStored  *Drift
+	RuntimeStored *Drift
+}
+
+func NewDriftReport() *DriftReport {
+	return &DriftReport{
+		SchemeRuntime: NewDrift("Network scheme <-> runtime"),
+		SchemeStored:  NewDrift("Network scheme <-> stored config"),
+		RuntimeStored: NewDrift("Runtime <-> stored config"),
+	}
+}
+
+// ExitCode -- returns exit code, corresponded to found differences. If no
+// one difference found, but some descriptions were not compared, drift is
+// unknown
+func (s *DriftReport) ExitCode() int {
+	switch {
+	case !s.SchemeRuntime.IsEqual():
+		return CheckCritical
+	case !s.SchemeStored.IsEqual() || !s.RuntimeStored.IsEqual():
+		return CheckWarning
+	case s.SchemeRuntime.Skipped != "" || s.SchemeStored.Skipped != "" || s.RuntimeStored.Skipped != "":
+		return CheckUnknown
+	}
+	return CheckOK
+}
+
+// Print -- print found differences and status line
+func (s *DriftReport) Print(w io.Writer) {
+	for _, drift := range []*Drift{s.SchemeRuntime, s.SchemeStored, s.RuntimeStored} {
+		fmt.Fprintf(w, "%s:\n", drift.Title)
+		switch {
+		case drift.Skipped != "":
+			fmt.Fprintf(w, "    not checked: %s\n", drift.Skipped)
+		case drift.IsEqual():
+			fmt.Fprintf(w, "    no differences\n")
+		}
+		for _, name := range drift.Names {
+			fmt.Fprintf(w, "    %s: %s\n", name, strings.Join(drift.Changes[name], ", "))
+		}
+	}
+	switch s.ExitCode() {
+	case CheckCritical:
+		fmt.Fprintf(w, "CRITICAL: runtime differs from network scheme\n")
+	case CheckWarning:
+		fmt.Fprintf(w, "WARNING: stored config differs from network scheme or runtime\n")
+	case CheckUnknown:
+		fmt.Fprintf(w, "UNKNOWN: no differences found, but not everything was checked\n")
+	default:
+		fmt.Fprintf(w, "OK: network scheme, runtime and stored config agree\n")
+	}
+}
+
+// RunCheck -- compare network scheme, current network topology and stored
+// network config with each other and report differences
+func RunCheck(c *cli.Context) (err error) {
+	var (
+		changes      *changeSet
+		ns           *NetworkScheme
+		configPlugin plugin.ConfigPlugin
+	)
+	if changes, err = observeChanges(c); err != nil {
+		return cli.NewExitError("", CheckUnknown)
+	}
+	// network config is generated for network scheme with unresolved match
+	// rules, like by 'store' command
+	if ns, err = loadNetworkScheme(c.GlobalString("ns")); err != nil {
+		return cli.NewExitError("", CheckUnknown)
+	}
+	if configPlugin, err = generateNetConfig(c, ns); err != nil {
+		return cli.NewExitError("", CheckUnknown)
+	}
+	path := c.GlobalString("store-config")
+	if path == "stdout" || path == "tty" {
+		Log.Error("Stored network config can't be checked into '%s'", path)
+		return cli.NewExitError("", CheckUnknown)
+	}
+	if path == "" {
+		path = configPlugin.DefaultPath()
+	}
+
+	report, err := checkDrift(changes.observed, changes.wanted, configPlugin, path)
+	if err != nil {
+		Log.Error("%v", err)
+		return cli.NewExitError("", CheckUnknown)
+	}
+	report.Print(os.Stdout)
+	if code := report.ExitCode(); code != CheckOK {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
+
+// checkDrift -- compare observed and wanted network topology with network
+// config, stored into given path. Config files are compared with ones,
+// generated by config plugin. Network topology, described by stored config,
+// is compared with runtime only if stored config can be parsed back
+func checkDrift(observed, wanted *npstate.TopologyState, configPlugin plugin.ConfigPlugin, path string) (rv *DriftReport, err error) {
+	rv = NewDriftReport()
+	rv.SchemeRuntime.addDivergence(wanted.Order, divergence(observed, wanted))
+
+	generated := configPlugin.Files(path)
+	stored := []plugin.ConfigFile{}
+	for _, file := range generated {
+		data, err := ioutil.ReadFile(file.Path)
+		switch {
+		case os.IsNotExist(err):
+			rv.SchemeStored.add(file.Path, "does not exist")
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("can't read network config '%s': %v", file.Path, err)
+		case !bytes.Equal(data, file.Content):
+			rv.SchemeStored.add(file.Path, "differs from generated one")
+		}
+		stored = append(stored, plugin.ConfigFile{Path: file.Path, Content: data, Mode: file.Mode})
+	}
+	if cleaner, ok := configPlugin.(plugin.ConfigCleaner); ok {
+		stale, err := staleConfigs(cleaner, path, generated)
+		if err != nil {
+			return nil, fmt.Errorf("can't find network config files into '%s': %v", path, err)
+		}
+		for _, name := range stale {
+			rv.SchemeStored.add(name, "is not described by network scheme")
+		}
+	}
+
+	// config, generated from network scheme, is parsed too, because network
+	// scheme can describe more, than network config
+	wantedStored, _, err := parseNetConfig(configPlugin, generated)
+	switch {
+	case err != nil:
+		return nil, err
+	case wantedStored == nil:
+		rv.RuntimeStored.Skipped = "stored config can't be parsed back, only netplan one is supported"
+		return rv, nil
+	case len(stored) == 0:
+		rv.RuntimeStored.Skipped = "stored config not found"
+		return rv, nil
+	}
+	actualStored, unsupported, err := parseNetConfig(configPlugin, stored)
+	if err != nil {
+		rv.RuntimeStored.Skipped = err.Error()
+		return rv, nil
+	}
+	for _, u := range unsupported {
+		// "path: network.path.to.key"
+		pair := strings.SplitN(u, ": ", 2)
+		rv.SchemeStored.add(pair[0], "unsupported "+pair[len(pair)-1])
+	}
+	rv.SchemeStored.addDivergence(wantedStored.Order, divergence(actualStored, wantedStored))
+	for _, name := range actualStored.Order {
+		if _, ok := wantedStored.NP[name]; !ok {
+			rv.SchemeStored.add(name, "is not described by network scheme")
+		}
+	}
+
+	// link state is not stored into netplan config
+	for _, np := range actualStored.NP {
+		np.KeepOnline = true
+	}
+	rv.RuntimeStored.addDivergence(actualStored.Order, divergence(observed, actualStored))
+	return rv, nil
+}
+
+// parseNetConfig -- parse network config files back into network topology.
+// Returns nil topology if config plugin can't parse its config
+func parseNetConfig(configPlugin plugin.ConfigPlugin, files []plugin.ConfigFile) (rv *npstate.TopologyState, unsupported []string, err error) {
+	if _, ok := configPlugin.(*u1804.SavedConfig); !ok {
+		return nil, nil, nil
+	}
+	sc := u1804.NewSavedConfig(nil)
+	for _, file := range files {
+		parsed, u, err := u1804.ParseSavedConfig(file.Path, file.Content)
+		if err != nil {
+			return nil, nil, err
+		}
+		unsupported = append(unsupported, u...)
+		sc.Merge(parsed)
+	}
+	return netplanToScheme(sc).TopologyState(), unsupported, nil
+}
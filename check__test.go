@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/plugin"
+)
+
+const checkedScheme = `
+version: 1.2
+provider: lnx
+interfaces:
+  eth1: {}
+  eth2: {}
+transformations:
+  - name: br1
+    action: bridge
+  - name: eth1
+    action: port
+    bridge: br1
+endpoints:
+  br1:
+    IP: ['10.1.1.1/24']
+  eth2:
+    IP: ['10.2.2.1/24']
+`
+
+// checkedConfig -- returns netplan config plugin for network scheme and
+// path to network config, stored by it
+func checkedConfig(t *testing.T, dir string) (*NetworkScheme, plugin.ConfigPlugin, string) {
+	ns := new(NetworkScheme)
+	if err := ns.Load(strings.NewReader(checkedScheme)); err != nil {
+		t.FailNow()
+	}
+	configPlugin, err := plugin.NewConfigPlugin(plugin.DefaultConfigProvider)
+	if err != nil {
+		t.FailNow()
+	}
+	configPlugin.SetLogger(logger.New())
+	configPlugin.SetWantedState(&ns.TopologyState().NP)
+	if err = configPlugin.Generate(); err != nil {
+		t.FailNow()
+	}
+	path := filepath.Join(dir, "999-l23network.yaml")
+	for _, file := range configPlugin.Files(path) {
+		ioutil.WriteFile(file.Path, file.Content, 0644)
+	}
+	return ns, configPlugin, path
+}
+
+func TestCheck__NoDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-check")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ns, configPlugin, path := checkedConfig(t, dir)
+
+	report, err := checkDrift(ns.TopologyState(), ns.TopologyState(), configPlugin, path)
+	if err != nil || report.ExitCode() != CheckOK {
+		t.Logf("Unexpected differences found: %v", err)
+		report.Print(os.Stdout)
+		t.Fail()
+	}
+}
+
+func TestCheck__StoredDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-check")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ns, configPlugin, path := checkedConfig(t, dir)
+
+	// netplan config, edited by hand
+	data, _ := ioutil.ReadFile(path)
+	data = []byte(strings.Replace(string(data), "10.2.2.1/24", "10.2.2.2/24", 1))
+	ioutil.WriteFile(path, data, 0644)
+
+	report, err := checkDrift(ns.TopologyState(), ns.TopologyState(), configPlugin, path)
+	if err != nil {
+		t.FailNow()
+	}
+	if code := report.ExitCode(); code != CheckWarning {
+		t.Logf("Wrong exit code: %d, instead %d", code, CheckWarning)
+		t.Fail()
+	}
+	if wanted := []string{path, "eth2"}; !reflect.DeepEqual(report.SchemeStored.Names, wanted) {
+		t.Logf("Wrong differences between network scheme and stored config: %v, instead %v", report.SchemeStored.Names, wanted)
+		t.Fail()
+	}
+	if wanted := map[string][]string{"eth2": {"ipv4: [10.2.2.1/24] -> [10.2.2.2/24]"}}; !reflect.DeepEqual(report.RuntimeStored.Changes, wanted) {
+		t.Logf("Wrong differences between runtime and stored config: %v, instead %v", report.RuntimeStored.Changes, wanted)
+		t.Fail()
+	}
+
+	os.Remove(path)
+	if report, err = checkDrift(ns.TopologyState(), ns.TopologyState(), configPlugin, path); err != nil {
+		t.FailNow()
+	}
+	if report.ExitCode() != CheckWarning || report.RuntimeStored.Skipped == "" {
+		t.Logf("Absent stored config is not reported")
+		t.Fail()
+	}
+}
+
+func TestCheck__RuntimeDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-check")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ns, configPlugin, path := checkedConfig(t, dir)
+
+	// change, implemented at runtime only
+	observed := ns.TopologyState()
+	observed.NP["br1"].L3.IPv4 = append(observed.NP["br1"].L3.IPv4, "10.3.3.1/24")
+	wanted := ns.TopologyState()
+	wanted.NP["br1"].L3.IPv4 = append(wanted.NP["br1"].L3.IPv4, "10.3.3.1/24")
+
+	report, err := checkDrift(observed, ns.TopologyState(), configPlugin, path)
+	if err != nil {
+		t.FailNow()
+	}
+	if code := report.ExitCode(); code != CheckCritical {
+		t.Logf("Wrong exit code: %d, instead %d", code, CheckCritical)
+		t.Fail()
+	}
+	if !report.SchemeStored.IsEqual() {
+		t.Logf("Unexpected differences between network scheme and stored config: %v", report.SchemeStored.Changes)
+		t.Fail()
+	}
+
+	// change, implemented at runtime and described by network scheme, but
+	// never stored
+	configPlugin.SetWantedState(&wanted.NP)
+	configPlugin.Generate()
+	if report, err = checkDrift(observed, wanted, configPlugin, path); err != nil {
+		t.FailNow()
+	}
+	if code := report.ExitCode(); code != CheckWarning {
+		t.Logf("Wrong exit code: %d, instead %d", code, CheckWarning)
+		report.Print(os.Stdout)
+		t.Fail()
+	}
+}
+
+func TestCheck__NotComparedIsUnknown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-check")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ns := new(NetworkScheme)
+	if err := ns.Load(strings.NewReader(checkedScheme)); err != nil {
+		t.FailNow()
+	}
+	// ifcfg config can't be parsed back to be compared with runtime
+	configPlugin, err := plugin.NewConfigPlugin("ifcfg")
+	if err != nil {
+		t.FailNow()
+	}
+	configPlugin.SetLogger(logger.New())
+	configPlugin.SetWantedState(&ns.TopologyState().NP)
+	if err = configPlugin.Generate(); err != nil {
+		t.FailNow()
+	}
+	for _, file := range configPlugin.Files(dir) {
+		ioutil.WriteFile(file.Path, file.Content, 0644)
+	}
+
+	report, err := checkDrift(ns.TopologyState(), ns.TopologyState(), configPlugin, dir)
+	if err != nil {
+		t.FailNow()
+	}
+	if code := report.ExitCode(); code != CheckUnknown || report.RuntimeStored.Skipped == "" {
+		t.Logf("Wrong exit code: %d, instead %d", code, CheckUnknown)
+		report.Print(os.Stdout)
+		t.Fail()
+	}
+
+	// differences, which were found, are more important
+	observed := ns.TopologyState()
+	observed.NP["eth2"].L3.IPv4 = []string{"10.2.2.2/24"}
+	if report, err = checkDrift(observed, ns.TopologyState(), configPlugin, dir); err != nil {
+		t.FailNow()
+	}
+	if code := report.ExitCode(); code != CheckCritical {
+		t.Logf("Wrong exit code: %d, instead %d", code, CheckCritical)
+		t.Fail()
+	}
+}
@@ -0,0 +1,364 @@
+// Package ext implements runtime plugins, which are running out of process.
+//
+// Provider binary should be named 'l23-provider-<name>' and be placed into
+// the plugin directory. It will be registered as provider '<name>'. Built-in
+// providers can't be overridden. Provider binary and plugin directory should
+// be owned by root or by the user, running l23, and should not be writable
+// by group or others, otherwise they are refused.
+//
+// Wire protocol: provider binary is executed once for each request. Request
+// is written to stdin as one JSON object, response should be written to
+// stdout as one JSON object, stderr is logged. Request:
+//
+//	{
+//	  "protocol": 1,
+//	  "method":   "version" | "observe" | "create" | "modify" | "remove",
+//	  "dryrun":   false,
+//	  "np":       { network primitive wanted state, for create/modify/remove }
+//	}
+//
+// Response:
+//
+//	{
+//	  "error":    "error message, if request failed",
+//	  "version":  "provider version, for 'version' method",
+//	  "actions":  ["actions, supported by provider, for 'version' method"],
+//	  "topology": { observed topology, for 'observe' method },
+//	  "steps":    ["changes, which would be made, for dry-run create/modify/remove"]
+//	}
+//
+// Network primitive and topology are encoded as npstate.NPState and
+// npstate.TopologyState with their JSON field names. Non-zero exit code
+// of provider binary means failed request.
+package ext
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	MsgPrefix = "External plugin"
+
+	// ProtocolVersion -- version of wire protocol
+	ProtocolVersion = 1
+
+	// BinaryPrefix -- prefix of provider binary name
+	BinaryPrefix = "l23-provider-"
+)
+
+// CallTimeout -- maximum time of one request processing by provider binary
+var CallTimeout = 60 * time.Second
+
+// Request -- request to provider binary
+type Request struct {
+	Protocol int              `json:"protocol"`
+	Method   string           `json:"method"`
+	DryRun   bool             `json:"dryrun,omitempty"`
+	NP       *npstate.NPState `json:"np,omitempty"`
+}
+
+// Response -- response of provider binary
+type Response struct {
+	Error    string                 `json:"error,omitempty"`
+	Version  string                 `json:"version,omitempty"`
+	Actions  []string               `json:"actions,omitempty"`
+	Topology *npstate.TopologyState `json:"topology,omitempty"`
+	Steps    []string               `json:"steps,omitempty"`
+}
+
+// ExtRtPlugin -- runtime plugin, implemented by provider binary. Requests
+// to provider binary are serialized per instance
+type ExtRtPlugin struct {
+	mutex   sync.Mutex
+	name    string
+	path    string
+	log     *logger.Logger
+	version string
+	actions []string
+	// observed topology is read by operators, while requests are processed
+	topologyMutex sync.RWMutex
+	topology      *npstate.TopologyState
+	planned       []string // steps, reported in the dry-run mode until next observing
+}
+
+// call -- execute provider binary and process request
+func (s *ExtRtPlugin) call(req *Request) (*Response, error) {
+	req.Protocol = ProtocolVersion
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CallTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if stderr.Len() > 0 {
+		s.log.Debug("%s '%s': %s", MsgPrefix, s.name, strings.TrimSpace(stderr.String()))
+	}
+
+	rv := new(Response)
+	if err = json.Unmarshal(stdout.Bytes(), rv); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("provider '%s' failed to process '%s': %v", s.name, req.Method, runErr)
+		}
+		return nil, fmt.Errorf("provider '%s' returned wrong response to '%s': %v", s.name, req.Method, err)
+	}
+	if rv.Error != "" {
+		return nil, fmt.Errorf("provider '%s' failed to process '%s': %s", s.name, req.Method, rv.Error)
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("provider '%s' failed to process '%s': %v", s.name, req.Method, runErr)
+	}
+	return rv, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// ExtOperator -- operator, which delegates changes to provider binary
+type ExtOperator struct {
+	plugin      *ExtRtPlugin
+	log         *logger.Logger
+	wantedState *npstate.NPState
+}
+
+func (s *ExtOperator) Init(wantedState *npstate.NPState) error {
+	s.wantedState = wantedState
+	return nil
+}
+
+func (s *ExtOperator) Name() string {
+	return s.wantedState.Name
+}
+
+func (s *ExtOperator) apply(method string, dryrun bool) error {
+	if dryrun {
+		s.log.Info("%s dryrun: %s '%s' by provider '%s'.", MsgPrefix, method, s.Name(), s.plugin.name)
+	} else {
+		s.log.Info("%s: %s '%s' by provider '%s'", MsgPrefix, method, s.Name(), s.plugin.name)
+	}
+	rv, err := s.plugin.call(&Request{Method: method, DryRun: dryrun, NP: s.wantedState})
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	if dryrun {
+		if len(rv.Steps) == 0 {
+			s.log.Info("%s dryrun: nothing to change for '%s'", MsgPrefix, s.Name())
+		}
+		for _, step := range rv.Steps {
+			s.log.Info("%s dryrun: %s", MsgPrefix, step)
+		}
+		s.plugin.planned = append(s.plugin.planned, rv.Steps...)
+	}
+	return nil
+}
+
+func (s *ExtOperator) Create(dryrun bool) error {
+	return s.apply("create", dryrun)
+}
+
+func (s *ExtOperator) Remove(dryrun bool) error {
+	return s.apply("remove", dryrun)
+}
+
+func (s *ExtOperator) Modify(dryrun bool) error {
+	return s.apply("modify", dryrun)
+}
+
+// IPv4addrList -- returns addresses, observed by provider
+func (s *ExtOperator) IPv4addrList() []string {
+	if np := s.plugin.observedNp(s.Name()); np != nil {
+		return np.L3.IPv4
+	}
+	return []string{}
+}
+
+// -----------------------------------------------------------------------------
+
+// Init -- fetch version and supported actions from provider binary
+func (s *ExtRtPlugin) Init(log *logger.Logger, hh *netlink.Handle) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.log = log
+	rv, err := s.call(&Request{Method: "version"})
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	if len(rv.Actions) == 0 {
+		err = fmt.Errorf("provider '%s' supports no one action", s.name)
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	s.version = rv.Version
+	s.actions = rv.Actions
+	return nil
+}
+
+// Operators -- returns operator constructors for actions, supported by
+// provider binary
+func (s *ExtRtPlugin) Operators() NpOperators {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rv := NpOperators{}
+	for _, action := range s.actions {
+		rv[action] = func() NpOperator {
+			return &LockedOperator{NpOperator: &ExtOperator{plugin: s, log: s.log}, Locker: &s.mutex}
+		}
+	}
+	return rv
+}
+
+func (s *ExtRtPlugin) Version() string {
+	return s.version
+}
+
+func (s *ExtRtPlugin) Observe() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.log.Info("%s: Gathering current topology by provider '%s'", MsgPrefix, s.name)
+	s.planned = nil
+	rv, err := s.call(&Request{Method: "observe"})
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	topology := rv.Topology
+	if topology == nil {
+		topology = npstate.NewTopologyState()
+	}
+	if topology.NP == nil {
+		topology.NP = make(npstate.NPStates)
+	}
+	for name, np := range topology.NP {
+		np.Name = name
+		if IndexString(topology.Order, name) < 0 {
+			topology.Order = append(topology.Order, name)
+		}
+	}
+	s.topologyMutex.Lock()
+	defer s.topologyMutex.Unlock()
+	s.topology = topology
+	return nil
+}
+
+func (s *ExtRtPlugin) Topology() *npstate.TopologyState {
+	s.topologyMutex.RLock()
+	defer s.topologyMutex.RUnlock()
+	return s.topology
+}
+
+// observedNp -- returns observed network primitive, or nil if it is not
+// observed. Operators can't take the plugin lock, because it is held by
+// LockedOperator already
+func (s *ExtRtPlugin) observedNp(name string) *npstate.NPState {
+	s.topologyMutex.RLock()
+	defer s.topologyMutex.RUnlock()
+	if s.topology == nil {
+		return nil
+	}
+	return s.topology.NP[name]
+}
+
+func (s *ExtRtPlugin) GetLogger() *logger.Logger {
+	return s.log
+}
+
+// NewExtRtPlugin -- create runtime plugin for provider binary
+func NewExtRtPlugin(name, path string) RtPlugin {
+	return &ExtRtPlugin{
+		name: name,
+		path: path,
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// trusted -- check that provider binary or plugin directory can't be
+// replaced by other users: it should be owned by root or by the current
+// user and should not be writable by group or others
+func trusted(path string, info os.FileInfo) error {
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("'%s' is writable by group or others", path)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("'%s' is owned by uid %d, neither by root, nor by current user", path, st.Uid)
+	}
+	return nil
+}
+
+// Discover -- find provider binaries into given directory and register
+// them. Returns names of registered providers. Directory and binaries,
+// which can be changed by other users, are refused
+func Discover(dir string) (rv []string, err error) {
+	var (
+		files []os.FileInfo
+		info  os.FileInfo
+	)
+	if info, err = os.Stat(dir); err != nil {
+		return nil, err
+	}
+	if err = trusted(dir, info); err != nil {
+		return nil, fmt.Errorf("plugin directory refused: %v", err)
+	}
+	if files, err = ioutil.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	known := RtPluginNames()
+	errs := []string{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), BinaryPrefix) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		// symlinks are followed, like on execution
+		if file, err = os.Stat(path); err != nil || file.IsDir() || file.Mode()&0111 == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(file.Name(), BinaryPrefix)
+		if name == "" {
+			continue
+		}
+		if IndexString(known, name) >= 0 {
+			errs = append(errs, fmt.Sprintf("provider '%s' already registered, '%s' skipped", name, file.Name()))
+			continue
+		}
+		if err = trusted(path, file); err != nil {
+			errs = append(errs, fmt.Sprintf("provider '%s' skipped: %v", name, err))
+			continue
+		}
+		RegisterRtPlugin(name, func() RtPlugin {
+			return NewExtRtPlugin(name, path)
+		})
+		known = append(known, name)
+		rv = append(rv, name)
+	}
+	err = nil
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, "; "))
+	}
+	return rv, err
+}
@@ -0,0 +1,237 @@
+package ext
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	. "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+)
+
+// TestExt__HelperProvider -- is not a real test, it is a provider binary,
+// used by tests below
+func TestExt__HelperProvider(t *testing.T) {
+	if os.Getenv("L23_TEST_PROVIDER") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	req := new(Request)
+	rv := new(Response)
+	if err := json.NewDecoder(os.Stdin).Decode(req); err != nil {
+		rv.Error = err.Error()
+	}
+	if f, err := os.OpenFile(os.Getenv("L23_TEST_PROVIDER_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		name := ""
+		if req.NP != nil {
+			name = req.NP.Name
+		}
+		fmt.Fprintf(f, "%d %s %s %v\n", req.Protocol, req.Method, name, req.DryRun)
+		f.Close()
+	}
+	switch req.Method {
+	case "version":
+		rv.Version = "smartnic v1"
+		rv.Actions = []string{"vf", "representor"}
+	case "observe":
+		rv.Topology = &TopologyState{NP: NPStates{
+			"vf0": &NPState{Action: "vf", Online: true, L2: L2State{Mtu: 9000}, L3: L3State{IPv4: []string{"10.0.0.1/24"}}},
+		}}
+	case "create", "modify":
+		if req.NP.Name == "broken" {
+			rv.Error = "device is broken"
+		} else if req.DryRun {
+			rv.Steps = []string{fmt.Sprintf("devlink port add %s  # '%s' is absent", req.NP.Name, req.NP.Name)}
+		}
+	default:
+		rv.Error = fmt.Sprintf("unsupported method '%s'", req.Method)
+	}
+	json.NewEncoder(os.Stdout).Encode(rv)
+}
+
+func helperProviderDir(t *testing.T) (dir, log string) {
+	dir, err := ioutil.TempDir("", "l23-plugins")
+	if err != nil {
+		t.FailNow()
+	}
+	log = filepath.Join(dir, "calls.log")
+	script := fmt.Sprintf("#!/bin/sh\nL23_TEST_PROVIDER=1 L23_TEST_PROVIDER_LOG=%s exec %s -test.run=TestExt__HelperProvider\n", log, os.Args[0])
+	ioutil.WriteFile(filepath.Join(dir, BinaryPrefix+"smartnic"), []byte(script), 0755)
+	// not executable and wrong named files should be skipped
+	ioutil.WriteFile(filepath.Join(dir, BinaryPrefix+"noexec"), []byte(script), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "smartnic-tool"), []byte(script), 0755)
+	return dir, log
+}
+
+func TestExt__Discover(t *testing.T) {
+	dir, _ := helperProviderDir(t)
+	defer os.RemoveAll(dir)
+
+	providers, err := Discover(dir)
+	if err != nil || !reflect.DeepEqual(providers, []string{"smartnic"}) {
+		t.Logf("Wrong discovered providers: %v, %v", providers, err)
+		t.FailNow()
+	}
+	// already registered provider should not be registered twice
+	if providers, err = Discover(dir); err == nil || len(providers) != 0 {
+		t.Logf("Provider should not be registered twice: %v, %v", providers, err)
+		t.Fail()
+	}
+	if _, err := NewRtPlugin("smartnic"); err != nil {
+		t.Logf("Provider 'smartnic' is not registered: %v", err)
+		t.Fail()
+	}
+}
+
+func TestExt__DiscoverUntrusted(t *testing.T) {
+	dir, _ := helperProviderDir(t)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, BinaryPrefix+"smartnic")
+	for _, name := range []string{"groupwritable", "foreign"} {
+		data, _ := ioutil.ReadFile(script)
+		ioutil.WriteFile(filepath.Join(dir, BinaryPrefix+name), data, 0755)
+	}
+	os.Chmod(filepath.Join(dir, BinaryPrefix+"groupwritable"), 0775)
+	os.Remove(script)
+	skipped, registered := []string{"groupwritable"}, []string{"foreign"}
+	if os.Geteuid() == 0 {
+		// only root is able to give file to another user
+		os.Chown(filepath.Join(dir, BinaryPrefix+"foreign"), 65534, 65534)
+		skipped, registered = append(skipped, "foreign"), nil
+	}
+
+	providers, err := Discover(dir)
+	if !reflect.DeepEqual(providers, registered) {
+		t.Logf("Untrusted providers should be skipped: %v, %v", providers, err)
+		t.Fail()
+	}
+	for _, name := range skipped {
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("provider '%s' skipped", name)) {
+			t.Logf("Provider '%s' should be reported as skipped: %v", name, err)
+			t.Fail()
+		}
+	}
+
+	// plugin directory, writable by others, is refused at all
+	os.Chmod(dir, 0777)
+	if providers, err = Discover(dir); err == nil || len(providers) != 0 {
+		t.Logf("Plugin directory, writable by others, should be refused: %v, %v", providers, err)
+		t.Fail()
+	}
+}
+
+func TestExt__Protocol(t *testing.T) {
+	dir, log := helperProviderDir(t)
+	defer os.RemoveAll(dir)
+
+	rtPlugin := NewExtRtPlugin("smartnic", filepath.Join(dir, BinaryPrefix+"smartnic"))
+	if err := rtPlugin.Init(logger.New(), nil); err != nil {
+		t.Logf("Init failed: %v", err)
+		t.FailNow()
+	}
+	if rtPlugin.Version() != "smartnic v1" {
+		t.Logf("Wrong version: %s", rtPlugin.Version())
+		t.Fail()
+	}
+	actions := []string{}
+	for action := range rtPlugin.Operators() {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	if !reflect.DeepEqual(actions, []string{"representor", "vf"}) {
+		t.Logf("Wrong actions: %v", actions)
+		t.Fail()
+	}
+
+	if err := rtPlugin.Observe(); err != nil {
+		t.Logf("Observe failed: %v", err)
+		t.FailNow()
+	}
+	np := rtPlugin.Topology().NP["vf0"]
+	if np == nil || np.Name != "vf0" || np.L2.Mtu != 9000 || !np.Online {
+		t.Logf("Wrong observed topology: %v", rtPlugin.Topology())
+		t.FailNow()
+	}
+
+	oper := rtPlugin.Operators()["vf"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "vf1", Action: "vf"})
+	if err := oper.Create(true); err != nil {
+		t.Logf("Create failed: %v", err)
+		t.Fail()
+	}
+	if steps := []string{"devlink port add vf1  # 'vf1' is absent"}; !reflect.DeepEqual(rtPlugin.(*ExtRtPlugin).planned, steps) {
+		t.Logf("Wrong steps, planned by provider in the dry-run mode: %v, instead %v", rtPlugin.(*ExtRtPlugin).planned, steps)
+		t.Fail()
+	}
+	oper.Init(&NPState{Name: "broken", Action: "vf"})
+	if err := oper.Modify(false); err == nil || !strings.Contains(err.Error(), "device is broken") {
+		t.Logf("Error of provider should be returned: %v", err)
+		t.Fail()
+	}
+	if err := oper.Remove(false); err == nil {
+		t.Logf("Unsupported method should fail")
+		t.Fail()
+	}
+
+	calls, _ := ioutil.ReadFile(log)
+	wanted := "1 version  false\n1 observe  false\n1 create vf1 true\n1 modify broken false\n1 remove broken false\n"
+	if string(calls) != wanted {
+		t.Logf("Wrong calls of provider:\n%s\ninstead:\n%s", calls, wanted)
+		t.Fail()
+	}
+}
+
+func TestExt__ConcurrentObserve(t *testing.T) {
+	dir, _ := helperProviderDir(t)
+	defer os.RemoveAll(dir)
+
+	rtPlugin := NewExtRtPlugin("smartnic", filepath.Join(dir, BinaryPrefix+"smartnic"))
+	if err := rtPlugin.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+	oper := rtPlugin.Operators()["vf"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "vf0", Action: "vf"})
+	if addrs := oper.IPv4addrList(); len(addrs) != 0 {
+		t.Logf("Addresses should be empty before observing: %v", addrs)
+		t.Fail()
+	}
+
+	// addresses are read, while topology is observed again
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if err := rtPlugin.Observe(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Logf("Observe failed: %v", err)
+				t.FailNow()
+			}
+			running = false
+		default:
+			if addrs := oper.IPv4addrList(); len(addrs) != 0 && !reflect.DeepEqual(addrs, []string{"10.0.0.1/24"}) {
+				t.Logf("Wrong addresses: %v", addrs)
+				t.Fail()
+			}
+		}
+	}
+	if addrs := oper.IPv4addrList(); !reflect.DeepEqual(addrs, []string{"10.0.0.1/24"}) {
+		t.Logf("Wrong observed addresses: %v", addrs)
+		t.Fail()
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/xenolog/l23
 
+go 1.12
+
 require (
 	github.com/maxatome/go-testdeep v1.0.8
 	github.com/urfave/cli v1.20.0
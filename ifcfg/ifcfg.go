@@ -0,0 +1,352 @@
+package ifcfg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	MsgPrefix         = "Ifcfg plugin"
+	DefaultConfigPath = "/etc/sysconfig/network-scripts"
+)
+
+// -----------------------------------------------------------------------------
+
+// Ifcfg -- ifcfg-<name> and route-<name> files of network primitive
+type Ifcfg struct {
+	Name   string
+	Vars   []string // "KEY=value" in order of definition
+	Routes []string // in the 'ip route' notation
+}
+
+// set -- add variable, value is quoted if required
+func (s *Ifcfg) set(key string, value interface{}) {
+	v := fmt.Sprintf("%v", value)
+	if strings.ContainsAny(v, " ;\t") {
+		v = fmt.Sprintf("\"%s\"", v)
+	}
+	s.Vars = append(s.Vars, fmt.Sprintf("%s=%s", key, v))
+}
+
+func (s *Ifcfg) String() string {
+	return GeneratedHeader + strings.Join(s.Vars, "\n") + "\n"
+}
+
+func (s *Ifcfg) RoutesString() string {
+	return GeneratedHeader + strings.Join(s.Routes, "\n") + "\n"
+}
+
+// -----------------------------------------------------------------------------
+
+type SavedConfig struct {
+	log         *logger.Logger
+	wantedState *npstate.NPStates
+	Ifaces      map[string]*Ifcfg
+}
+
+func (s *SavedConfig) CheckWS() (rv error) {
+	if s.wantedState == nil {
+		errMsg := "Wanted states of Network primitives are not set."
+		s.log.Error("%s: %s", MsgPrefix, errMsg)
+		rv = errors.New(errMsg)
+	}
+	return rv
+}
+
+// storable -- whether network primitive can be described by ifcfg files
+func (s *SavedConfig) storable(np *npstate.NPState) bool {
+	return (np.Provider == "" || np.Provider == "lnx") && np.LinkType != "dummy"
+}
+
+// masters -- returns bonds for slaves
+func (s *SavedConfig) masters() map[string]string {
+	rv := make(map[string]string)
+	for _, np := range *s.wantedState {
+		if np.Action == "bond" {
+			for _, slave := range np.L2.Slaves {
+				rv[slave] = np.Name
+			}
+		}
+	}
+	return rv
+}
+
+// setupMatch -- initscripts are able to find interface by MAC address only
+// and rename it to DEVICE
+func (s *SavedConfig) setupMatch(cfg *Ifcfg, np *npstate.NPState) {
+	for _, key := range SortedKeys(np.Match) {
+		if key != "macaddress" {
+			s.log.Warn("%s: initscripts have no ability to find interface by '%s', rule for '%s' skipped.", MsgPrefix, key, np.Name)
+			continue
+		}
+		cfg.set("HWADDR", np.Match[key])
+	}
+}
+
+// setupL2Properties -- describe L2 properties of network primitive.
+// Txqueuelen and alias are not supported by initscripts and can't be stored
+func (s *SavedConfig) setupL2Properties(cfg *Ifcfg, np *npstate.NPState) {
+	if np.L2.Mtu > 0 {
+		cfg.set("MTU", np.L2.Mtu)
+	}
+	if np.L2.HwAddr != "" {
+		cfg.set("MACADDR", np.L2.HwAddr)
+	}
+	if np.L2.Bridge != "" {
+		cfg.set("BRIDGE", np.L2.Bridge)
+	}
+	ethtool := []string{}
+	if len(np.L2.Ethtool.Offload) > 0 {
+		opts := []string{"-K", np.Name}
+		for _, key := range npstate.EthtoolOffloads {
+			if value, ok := np.L2.Ethtool.Offload[key]; ok {
+				opts = append(opts, key, onOff(value))
+			}
+		}
+		ethtool = append(ethtool, strings.Join(opts, " "))
+	}
+	if len(np.L2.Ethtool.Ring) > 0 {
+		opts := []string{"-G", np.Name}
+		for _, key := range npstate.EthtoolRings {
+			if value, ok := np.L2.Ethtool.Ring[key]; ok {
+				opts = append(opts, key, fmt.Sprintf("%d", value))
+			}
+		}
+		ethtool = append(ethtool, strings.Join(opts, " "))
+	}
+	if len(np.L2.Ethtool.Coalesce) > 0 {
+		opts := []string{"-C", np.Name}
+		for _, key := range npstate.EthtoolCoalesces {
+			if value, ok := np.L2.Ethtool.Coalesce[key]; ok {
+				opts = append(opts, key, value)
+			}
+		}
+		ethtool = append(ethtool, strings.Join(opts, " "))
+	}
+	if len(ethtool) > 0 {
+		cfg.set("ETHTOOL_OPTS", strings.Join(ethtool, "; "))
+	}
+}
+
+// setupL3Properties -- describe addresses, default gateway and DNS
+// settings. Static routes and default gateway with metric are described
+// by route-<name> file
+func (s *SavedConfig) setupL3Properties(cfg *Ifcfg, np *npstate.NPState) {
+	for i, addr := range np.L3.IPv4 {
+		ip := strings.Split(addr, "/")
+		cfg.set(fmt.Sprintf("IPADDR%d", i), ip[0])
+		if len(ip) > 1 {
+			cfg.set(fmt.Sprintf("PREFIX%d", i), ip[1])
+		}
+	}
+	if np.L3.Gateway != "" {
+		if np.L3.GatewayMetric > 0 {
+			cfg.Routes = append(cfg.Routes, fmt.Sprintf("default via %s metric %d", np.L3.Gateway, np.L3.GatewayMetric))
+		} else {
+			cfg.set("GATEWAY", np.L3.Gateway)
+		}
+	}
+	for i, ns := range np.L3.Nameservers {
+		cfg.set(fmt.Sprintf("DNS%d", i+1), ns)
+	}
+	if len(np.L3.Search) > 0 {
+		cfg.set("DOMAIN", strings.Join(np.L3.Search, " "))
+	}
+	for _, route := range np.L3.Routes {
+		r := fmt.Sprintf("%s via %s", route.To, route.Via)
+		if route.Metric > 0 {
+			r += fmt.Sprintf(" metric %d", route.Metric)
+		}
+		cfg.Routes = append(cfg.Routes, r)
+	}
+}
+
+// bondingOpts -- bonding options are named like sysfs attributes of bond
+func (s *SavedConfig) bondingOpts(np *npstate.NPState) string {
+	rv := []string{}
+	for _, key := range npstate.BondProperties {
+		if value, ok := np.L2.BondProperties[key]; ok {
+			rv = append(rv, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return strings.Join(rv, " ")
+}
+
+// newIfcfg -- returns ifcfg with common variables of network primitive
+func newIfcfg(name string, onboot bool) *Ifcfg {
+	rv := &Ifcfg{Name: name}
+	rv.set("DEVICE", name)
+	rv.set("ONBOOT", yesNo(onboot))
+	rv.set("BOOTPROTO", "none")
+	rv.set("NM_CONTROLLED", "no")
+	return rv
+}
+
+func (s *SavedConfig) Generate() error {
+	if err := s.CheckWS(); err != nil {
+		return err
+	}
+	masters := s.masters()
+	for _, np := range *s.wantedState {
+		if !s.storable(np) {
+			if np.LinkType == "dummy" {
+				s.log.Warn("%s: dummy interface '%s' can't be stored, skipped.", MsgPrefix, np.Name)
+			} else {
+				s.log.Warn("%s: '%s' is managed by provider '%s' and can't be stored, skipped.", MsgPrefix, np.Name, np.Provider)
+			}
+			continue
+		}
+		cfg := newIfcfg(np.Name, np.Online && !np.KeepOnline)
+		switch np.Action {
+		case "port":
+			if np.Name == "lo" {
+				// loopback is described by ifcfg-lo of initscripts itself
+				continue
+			}
+			if np.L2.Vlan_id != 0 {
+				cfg.set("VLAN", "yes")
+				cfg.set("PHYSDEV", np.L2.Parent)
+				cfg.set("VLAN_ID", np.L2.Vlan_id)
+			} else {
+				cfg.set("TYPE", "Ethernet")
+				s.setupMatch(cfg, np)
+			}
+			if bond, ok := masters[np.Name]; ok {
+				cfg.set("MASTER", bond)
+				cfg.set("SLAVE", "yes")
+			}
+		case "bridge":
+			cfg.set("TYPE", "Bridge")
+			cfg.set("STP", yesNo(np.L2.Stp))
+		case "bond":
+			cfg.set("TYPE", "Bond")
+			cfg.set("BONDING_MASTER", "yes")
+			if opts := s.bondingOpts(np); opts != "" {
+				cfg.set("BONDING_OPTS", opts)
+			}
+		default:
+			errMsg := fmt.Sprintf("Unsupported 'action' for '%s'.", np.Name)
+			s.log.Error("%s: %s", MsgPrefix, errMsg)
+			return errors.New(errMsg)
+		}
+		s.setupL2Properties(cfg, np)
+		s.setupL3Properties(cfg, np)
+		s.Ifaces[np.Name] = cfg
+	}
+
+	// slaves of bond, which are not described, should be described to be
+	// enslaved while booting
+	for slave, bond := range masters {
+		if _, ok := s.Ifaces[slave]; !ok {
+			cfg := newIfcfg(slave, true)
+			cfg.set("TYPE", "Ethernet")
+			cfg.set("MASTER", bond)
+			cfg.set("SLAVE", "yes")
+			s.Ifaces[slave] = cfg
+		}
+	}
+	return nil
+}
+
+func (s *SavedConfig) String() string {
+	rv := []string{}
+	for _, file := range s.Files(DefaultConfigPath) {
+		rv = append(rv, fmt.Sprintf("# ==> %s <==\n%s", file.Path, file.Content))
+	}
+	return strings.Join(rv, "\n")
+}
+
+func (s *SavedConfig) names() []string {
+	rv := []string{}
+	for name := range s.Ifaces {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func (s *SavedConfig) DefaultPath() string {
+	return DefaultConfigPath
+}
+
+// Files -- each network primitive is described by ifcfg-<name> file and
+// route-<name> file, if it has static routes
+func (s *SavedConfig) Files(path string) []ConfigFile {
+	rv := []ConfigFile{}
+	for _, name := range s.names() {
+		cfg := s.Ifaces[name]
+		rv = append(rv, ConfigFile{
+			Path:    filepath.Join(path, "ifcfg-"+name),
+			Content: []byte(cfg.String()),
+			Mode:    0644,
+		})
+		if len(cfg.Routes) > 0 {
+			rv = append(rv, ConfigFile{
+				Path:    filepath.Join(path, "route-"+name),
+				Content: []byte(cfg.RoutesString()),
+				Mode:    0644,
+			})
+		}
+	}
+	return rv
+}
+
+// GeneratedFiles -- returns ifcfg-* and route-* files into given
+// directory, which were generated by l23network
+func (s *SavedConfig) GeneratedFiles(path string) ([]string, error) {
+	// directory contains files, which are not generated by l23network, so
+	// generated ones are found by header
+	return GeneratedFiles(path, "ifcfg-*", "route-*")
+}
+
+func (s *SavedConfig) SetWantedState(wantedState *npstate.NPStates) {
+	s.wantedState = wantedState
+}
+
+func (s *SavedConfig) SetLogger(log *logger.Logger) {
+	if log != nil {
+		s.log = log
+	} else {
+		s.log = new(logger.Logger)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+func NewSavedConfig(log *logger.Logger) *SavedConfig {
+	rv := &SavedConfig{
+		Ifaces: make(map[string]*Ifcfg),
+	}
+	rv.SetLogger(log)
+	return rv
+}
+
+func NewIfcfgConfigPlugin() ConfigPlugin {
+	return NewSavedConfig(nil)
+}
+
+func init() {
+	RegisterConfigPlugin("ifcfg", NewIfcfgConfigPlugin)
+}
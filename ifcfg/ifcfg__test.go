@@ -0,0 +1,167 @@
+package ifcfg
+
+import (
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+	"github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/utils"
+	"github.com/xenolog/l23/utils/configtest"
+)
+
+func Test__L3_without_addresses(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				Gateway:       "10.10.10.1",
+				GatewayMetric: 100,
+				Routes:        []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.20.20.254", Metric: 10}},
+				Nameservers:   []string{"8.8.8.8", "1.1.1.1"},
+				Search:        []string{"example.com", "example.org"},
+			},
+		},
+		"eth2": &npstate.NPState{
+			Name:   "eth2",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				Gateway: "10.2.2.254",
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	files := savedConfig.Files("/tmp/network-scripts")
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	// route file is created only for interfaces with routes, gateway with
+	// metric is described by it
+	td.CmpDeeply(t, paths, []string{
+		"/tmp/network-scripts/ifcfg-eth1", "/tmp/network-scripts/route-eth1",
+		"/tmp/network-scripts/ifcfg-eth2",
+	})
+	td.CmpDeeply(t, string(files[0].Content), `# Generated by l23network, don't edit manually
+DEVICE=eth1
+ONBOOT=yes
+BOOTPROTO=none
+NM_CONTROLLED=no
+TYPE=Ethernet
+DNS1=8.8.8.8
+DNS2=1.1.1.1
+DOMAIN="example.com example.org"
+`)
+	td.CmpDeeply(t, string(files[1].Content), `# Generated by l23network, don't edit manually
+default via 10.10.10.1 metric 100
+192.168.0.0/16 via 10.20.20.254 metric 10
+`)
+	td.CmpDeeply(t, savedConfig.Ifaces["eth2"].Vars[4:], []string{"TYPE=Ethernet", "GATEWAY=10.2.2.254"})
+}
+
+func Test__Bond_slaves_without_primitives(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2:     npstate.L2State{Slaves: []string{"eth2", "eth3"}},
+		},
+		"eth2": &npstate.NPState{
+			Name:   "eth2",
+			Action: "port",
+			Online: true,
+			Match:  map[string]string{"macaddress": "52:54:00:12:34:56"},
+			L2: npstate.L2State{
+				Mtu: 9000,
+				Ethtool: npstate.EthtoolState{
+					Offload: map[string]bool{"gro": false, "tso": true},
+					Ring:    map[string]int{"rx": 4096},
+				},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Ifaces["eth2"].Vars, []string{
+		"DEVICE=eth2", "ONBOOT=yes", "BOOTPROTO=none", "NM_CONTROLLED=no",
+		"TYPE=Ethernet", "HWADDR=52:54:00:12:34:56", "MASTER=bond0", "SLAVE=yes",
+		"MTU=9000", `ETHTOOL_OPTS="-K eth2 tso on gro off; -G eth2 rx 4096"`,
+	})
+	// slave, which is not described in the network scheme, should be
+	// described to be enslaved while booting
+	td.CmpDeeply(t, savedConfig.Ifaces["eth3"].Vars, []string{
+		"DEVICE=eth3", "ONBOOT=yes", "BOOTPROTO=none", "NM_CONTROLLED=no",
+		"TYPE=Ethernet", "MASTER=bond0", "SLAVE=yes",
+	})
+}
+
+func Test__Vlan_on_bond_into_bridge(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2: npstate.L2State{
+				Slaves:         []string{"eth2", "eth3"},
+				BondProperties: map[string]string{"xmit_hash_policy": "layer3+4", "mode": "802.3ad"},
+			},
+		},
+		"bond0.101": &npstate.NPState{
+			Name:   "bond0.101",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Parent:  "bond0",
+				Vlan_id: 101,
+				Bridge:  "br1",
+			},
+		},
+		"br1": &npstate.NPState{
+			Name:   "br1",
+			Action: "bridge",
+			Online: false,
+			L3: npstate.L3State{
+				IPv4:    []string{"10.1.1.1/24"},
+				Gateway: "10.1.1.254",
+			},
+		},
+		"dummy0": &npstate.NPState{
+			Name:     "dummy0",
+			Action:   "port",
+			LinkType: "dummy",
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.names(), []string{"bond0", "bond0.101", "br1", "eth2", "eth3"})
+	td.CmpDeeply(t, savedConfig.Ifaces["bond0"].Vars, []string{
+		"DEVICE=bond0", "ONBOOT=yes", "BOOTPROTO=none", "NM_CONTROLLED=no",
+		"TYPE=Bond", "BONDING_MASTER=yes", `BONDING_OPTS="mode=802.3ad xmit_hash_policy=layer3+4"`,
+	})
+	td.CmpDeeply(t, savedConfig.Ifaces["bond0.101"].Vars, []string{
+		"DEVICE=bond0.101", "ONBOOT=yes", "BOOTPROTO=none", "NM_CONTROLLED=no",
+		"VLAN=yes", "PHYSDEV=bond0", "VLAN_ID=101", "BRIDGE=br1",
+	})
+	td.CmpDeeply(t, savedConfig.Ifaces["br1"].Vars, []string{
+		"DEVICE=br1", "ONBOOT=no", "BOOTPROTO=none", "NM_CONTROLLED=no",
+		"TYPE=Bridge", "STP=no", "IPADDR0=10.1.1.1", "PREFIX0=24", "GATEWAY=10.1.1.254",
+	})
+	td.CmpDeeply(t, savedConfig.Ifaces["eth3"].Vars, []string{
+		"DEVICE=eth3", "ONBOOT=yes", "BOOTPROTO=none", "NM_CONTROLLED=no",
+		"TYPE=Ethernet", "MASTER=bond0", "SLAVE=yes",
+	})
+}
+
+func Test__Hand_written_files_are_kept(t *testing.T) {
+	files := configtest.GeneratedFiles(t, NewSavedConfig(nil), map[string]string{
+		"ifcfg-eth1": utils.GeneratedHeader + "DEVICE=eth1\n",
+		"route-eth1": utils.GeneratedHeader + "default via 10.1.1.1\n",
+		"ifcfg-lo":   "DEVICE=lo\n",
+		"ifup-eth":   utils.GeneratedHeader,
+	})
+	td.CmpDeeply(t, files, []string{"ifcfg-eth1", "route-eth1"})
+}
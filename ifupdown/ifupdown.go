@@ -0,0 +1,394 @@
+package ifupdown
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	MsgPrefix = "Ifupdown plugin"
+	// DefaultConfigPath -- directory, which should be sourced from
+	// /etc/network/interfaces by 'source /etc/network/interfaces.d/*' or
+	// 'source-directory /etc/network/interfaces.d'
+	DefaultConfigPath = "/etc/network/interfaces.d"
+	// FilePrefix -- prefix of files, generated by l23network
+	FilePrefix = "l23-"
+)
+
+// option prefixes of ethtool hook of ifupdown
+const (
+	offloadOptionPrefix  = "offload-"
+	ringOptionPrefix     = "hardware-dma-ring-"
+	coalesceOptionPrefix = "hardware-irq-coalesce-"
+)
+
+// -----------------------------------------------------------------------------
+
+// Iface -- 'iface' stanza of interfaces(5)
+type Iface struct {
+	Name    string
+	Method  string   // loopback, manual, static
+	Options []string // "option value" in order of definition
+}
+
+func (s *Iface) addOption(name string, value interface{}) {
+	s.Options = append(s.Options, fmt.Sprintf("%s %v", name, value))
+}
+
+func (s *Iface) String() string {
+	rv := fmt.Sprintf("iface %s inet %s\n", s.Name, s.Method)
+	for _, option := range s.Options {
+		rv += fmt.Sprintf("    %s\n", option)
+	}
+	return rv
+}
+
+// IfaceConfig -- configuration of one network primitive, it is stored into
+// own file. Additional addresses are described by additional stanzas
+type IfaceConfig struct {
+	Name    string
+	Auto    bool
+	Stanzas []*Iface
+}
+
+func (s *IfaceConfig) String() string {
+	rv := GeneratedHeader
+	if s.Auto {
+		rv += fmt.Sprintf("auto %s\n", s.Name)
+	}
+	for _, stanza := range s.Stanzas {
+		rv += stanza.String()
+	}
+	return rv
+}
+
+// -----------------------------------------------------------------------------
+
+type SavedConfig struct {
+	log         *logger.Logger
+	wantedState *npstate.NPStates
+	Ifaces      map[string]*IfaceConfig
+}
+
+func (s *SavedConfig) CheckWS() (rv error) {
+	if s.wantedState == nil {
+		errMsg := "Wanted states of Network primitives are not set."
+		s.log.Error("%s: %s", MsgPrefix, errMsg)
+		rv = errors.New(errMsg)
+	}
+	return rv
+}
+
+// masters -- returns bonds for slaves
+func (s *SavedConfig) masters() map[string]string {
+	rv := make(map[string]string)
+	for _, np := range *s.wantedState {
+		if np.Action == "bond" {
+			for _, slave := range np.L2.Slaves {
+				rv[slave] = np.Name
+			}
+		}
+	}
+	return rv
+}
+
+// bridgePorts -- returns members of bridge
+func (s *SavedConfig) bridgePorts(brName string) (rv []string) {
+	for _, member := range *s.wantedState {
+		if member.L2.Bridge == brName && (member.Provider == "" || member.Provider == "lnx") {
+			rv = append(rv, member.Name)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// setupL2Properties -- describe L2 properties of network primitive
+func (s *SavedConfig) setupL2Properties(iface *Iface, np *npstate.NPState) {
+	if np.L2.Mtu > 0 {
+		iface.addOption("mtu", np.L2.Mtu)
+	}
+	if np.L2.HwAddr != "" {
+		iface.addOption("hwaddress ether", np.L2.HwAddr)
+	}
+	if np.L2.TxQLen > 0 {
+		iface.addOption("post-up", fmt.Sprintf("ip link set dev $IFACE txqueuelen %d", np.L2.TxQLen))
+	}
+	if np.L2.Alias != "" {
+		iface.addOption("post-up", "ip link set dev $IFACE alias "+shellQuote(np.L2.Alias))
+	}
+	for _, key := range SortedKeys(np.L2.Ethtool.Offload) {
+		value := "off"
+		if np.L2.Ethtool.Offload[key] {
+			value = "on"
+		}
+		iface.addOption(offloadOptionPrefix+key, value)
+	}
+	for _, key := range SortedKeys(np.L2.Ethtool.Ring) {
+		iface.addOption(ringOptionPrefix+key, np.L2.Ethtool.Ring[key])
+	}
+	for _, key := range SortedKeys(np.L2.Ethtool.Coalesce) {
+		iface.addOption(coalesceOptionPrefix+key, np.L2.Ethtool.Coalesce[key])
+	}
+}
+
+// setupL3Properties -- describe addresses, default gateway, routes and
+// DNS settings. First address is described by main stanza, another ones
+// by additional stanzas. Gateway, routes and DNS settings are described
+// even if primitive has no addresses
+func (s *SavedConfig) setupL3Properties(cfg *IfaceConfig, np *npstate.NPState) {
+	main := cfg.Stanzas[0]
+	addrs := []string{}
+	for _, addr := range np.L3.IPv4 {
+		if main.Method == "loopback" && addr == "127.0.0.1/8" {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) > 0 && main.Method != "loopback" {
+		main.Method = "static"
+		main.Options = append([]string{"address " + addrs[0]}, main.Options...)
+		addrs = addrs[1:]
+	}
+	for _, addr := range addrs {
+		cfg.Stanzas = append(cfg.Stanzas, &Iface{
+			Name:    np.Name,
+			Method:  "static",
+			Options: []string{"address " + addr},
+		})
+	}
+
+	if np.L3.Gateway == "" && len(np.L3.Routes) == 0 && len(np.L3.Nameservers) == 0 && len(np.L3.Search) == 0 {
+		return
+	}
+	iface := main
+	if main.Method == "loopback" {
+		// loopback stanza is described by /etc/network/interfaces itself,
+		// so options are described by additional stanza
+		if len(cfg.Stanzas) == 1 {
+			cfg.Stanzas = append(cfg.Stanzas, &Iface{Name: np.Name, Method: "manual"})
+		}
+		iface = cfg.Stanzas[1]
+	}
+	if np.L3.Gateway != "" {
+		if iface.Method == "static" {
+			iface.addOption("gateway", np.L3.Gateway)
+			if np.L3.GatewayMetric > 0 {
+				iface.addOption("metric", np.L3.GatewayMetric)
+			}
+		} else {
+			// 'gateway' option is supported only by static method
+			addRouteOptions(iface, npstate.RouteState{To: "default", Via: np.L3.Gateway, Metric: np.L3.GatewayMetric})
+		}
+	}
+	for _, route := range np.L3.Routes {
+		addRouteOptions(iface, route)
+	}
+	if len(np.L3.Nameservers) > 0 {
+		iface.addOption("dns-nameservers", strings.Join(np.L3.Nameservers, " "))
+	}
+	if len(np.L3.Search) > 0 {
+		iface.addOption("dns-search", strings.Join(np.L3.Search, " "))
+	}
+}
+
+func (s *SavedConfig) Generate() error {
+	if err := s.CheckWS(); err != nil {
+		return err
+	}
+	masters := s.masters()
+	for _, np := range *s.wantedState {
+		if np.Provider != "" && np.Provider != "lnx" {
+			// ifupdown can describe only linux kernel network primitives
+			s.log.Warn("%s: '%s' is managed by provider '%s' and can't be stored, skipped.", MsgPrefix, np.Name, np.Provider)
+			continue
+		}
+		if len(np.Match) > 0 {
+			s.log.Warn("%s: ifupdown has no ability to find interface by match rules, '%s' is stored by name.", MsgPrefix, np.Name)
+		}
+		cfg := &IfaceConfig{
+			Name: np.Name,
+			Auto: np.Online && !np.KeepOnline,
+		}
+		main := &Iface{Name: np.Name, Method: "manual"}
+		cfg.Stanzas = []*Iface{main}
+		switch np.Action {
+		case "port":
+			switch {
+			case np.Name == "lo":
+				main.Method = "loopback"
+			case np.L2.Vlan_id != 0:
+				main.addOption("vlan-raw-device", np.L2.Parent)
+			case np.LinkType == "dummy":
+				main.addOption("pre-up", "ip link add $IFACE type dummy")
+				main.addOption("post-down", "ip link del $IFACE")
+			}
+			if bond, ok := masters[np.Name]; ok {
+				main.addOption("bond-master", bond)
+			}
+		case "bridge":
+			ports := s.bridgePorts(np.Name)
+			if len(ports) == 0 {
+				ports = []string{"none"}
+			}
+			main.addOption("bridge_ports", strings.Join(ports, " "))
+			stp := "off"
+			if np.L2.Stp {
+				stp = "on"
+			}
+			main.addOption("bridge_stp", stp)
+		case "bond":
+			slaves := append([]string{}, np.L2.Slaves...)
+			if len(slaves) == 0 {
+				slaves = []string{"none"}
+			}
+			main.addOption("bond-slaves", strings.Join(slaves, " "))
+			for _, key := range SortedKeys(np.L2.BondProperties) {
+				main.addOption("bond-"+strings.Replace(key, "_", "-", -1), np.L2.BondProperties[key])
+			}
+		default:
+			errMsg := fmt.Sprintf("Unsupported 'action' for '%s'.", np.Name)
+			s.log.Error("%s: %s", MsgPrefix, errMsg)
+			return errors.New(errMsg)
+		}
+		if strings.ContainsAny(np.L2.Alias, "\r\n") {
+			errMsg := fmt.Sprintf("Alias of '%s' can't be stored, it contains line breaks.", np.Name)
+			s.log.Error("%s: %s", MsgPrefix, errMsg)
+			return errors.New(errMsg)
+		}
+		s.setupL2Properties(main, np)
+		s.setupL3Properties(cfg, np)
+		if main.Method == "loopback" {
+			// loopback is described by /etc/network/interfaces itself, only
+			// additional addresses should be stored
+			if len(cfg.Stanzas) == 1 {
+				continue
+			}
+			cfg.Auto = false
+			cfg.Stanzas = cfg.Stanzas[1:]
+		}
+		s.Ifaces[np.Name] = cfg
+	}
+
+	// slaves of bond, which are not described, should be described to be
+	// enslaved while booting
+	for slave, bond := range masters {
+		if _, ok := s.Ifaces[slave]; !ok {
+			s.Ifaces[slave] = &IfaceConfig{
+				Name:    slave,
+				Auto:    true,
+				Stanzas: []*Iface{{Name: slave, Method: "manual", Options: []string{"bond-master " + bond}}},
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SavedConfig) String() string {
+	rv := []string{}
+	for _, name := range s.names() {
+		rv = append(rv, s.Ifaces[name].String())
+	}
+	return strings.Join(rv, "\n")
+}
+
+func (s *SavedConfig) names() []string {
+	rv := []string{}
+	for name := range s.Ifaces {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func (s *SavedConfig) DefaultPath() string {
+	return DefaultConfigPath
+}
+
+// fileName -- returns name of file for network primitive. 'source-directory'
+// reads only files, which names consist of letters, digits, '_' and '-', so
+// other characters ('.' of vlan, for example) are replaced by '_'
+func fileName(name string) string {
+	return FilePrefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Files -- each network primitive is stored into own file into given
+// directory
+func (s *SavedConfig) Files(path string) []ConfigFile {
+	rv := []ConfigFile{}
+	for _, name := range s.names() {
+		rv = append(rv, ConfigFile{
+			Path:    filepath.Join(path, fileName(name)),
+			Content: []byte(s.Ifaces[name].String()),
+			Mode:    0644,
+		})
+	}
+	return rv
+}
+
+// GeneratedFiles -- returns files into given directory, which were
+// generated by l23network. Hand-written files with the same prefix are
+// kept, they have no header
+func (s *SavedConfig) GeneratedFiles(path string) ([]string, error) {
+	return GeneratedFiles(path, FilePrefix+"*")
+}
+
+func (s *SavedConfig) SetWantedState(wantedState *npstate.NPStates) {
+	s.wantedState = wantedState
+}
+
+func (s *SavedConfig) SetLogger(log *logger.Logger) {
+	if log != nil {
+		s.log = log
+	} else {
+		s.log = new(logger.Logger)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// addRouteOptions -- describe route by commands, which add it while
+// interface is brought up and remove while it is brought down
+func addRouteOptions(iface *Iface, route npstate.RouteState) {
+	cmd := fmt.Sprintf("ip route add %s via %s", route.To, route.Via)
+	if route.Metric > 0 {
+		cmd += fmt.Sprintf(" metric %d", route.Metric)
+	}
+	iface.addOption("up", cmd)
+	iface.addOption("down", strings.Replace(cmd, " add ", " del ", 1))
+}
+
+// shellQuote -- quote string to be passed to shell as one word
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
+func NewSavedConfig(log *logger.Logger) *SavedConfig {
+	rv := &SavedConfig{
+		Ifaces: make(map[string]*IfaceConfig),
+	}
+	rv.SetLogger(log)
+	return rv
+}
+
+func NewIfupdownConfigPlugin() ConfigPlugin {
+	return NewSavedConfig(nil)
+}
+
+func init() {
+	RegisterConfigPlugin("ifupdown", NewIfupdownConfigPlugin)
+}
@@ -0,0 +1,237 @@
+package ifupdown
+
+import (
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/utils"
+	"github.com/xenolog/l23/utils/configtest"
+)
+
+func Test__L3_without_addresses(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				Gateway:       "10.10.10.1",
+				GatewayMetric: 100,
+				Routes:        []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.20.20.254", Metric: 10}},
+				Nameservers:   []string{"8.8.8.8", "1.1.1.1"},
+				Search:        []string{"example.com"},
+			},
+		},
+		"eth2": &npstate.NPState{
+			Name:   "eth2",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				IPv4:    []string{"10.30.30.1/24", "10.40.40.1/24"},
+				Gateway: "10.30.30.254",
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	// 'gateway' option is not supported by manual method
+	td.CmpDeeply(t, savedConfig.Ifaces["eth1"].String(), `# Generated by l23network, don't edit manually
+auto eth1
+iface eth1 inet manual
+    up ip route add default via 10.10.10.1 metric 100
+    down ip route del default via 10.10.10.1 metric 100
+    up ip route add 192.168.0.0/16 via 10.20.20.254 metric 10
+    down ip route del 192.168.0.0/16 via 10.20.20.254 metric 10
+    dns-nameservers 8.8.8.8 1.1.1.1
+    dns-search example.com
+`)
+	td.CmpDeeply(t, savedConfig.Ifaces["eth2"].String(), `# Generated by l23network, don't edit manually
+auto eth2
+iface eth2 inet static
+    address 10.30.30.1/24
+    gateway 10.30.30.254
+iface eth2 inet static
+    address 10.40.40.1/24
+`)
+}
+
+func Test__Loopback(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"lo": &npstate.NPState{
+			Name:   "lo",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				IPv4: []string{"127.0.0.1/8"},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	if len(savedConfig.Ifaces) != 0 {
+		t.Logf("Loopback without additional addresses should not be stored:\n%s", savedConfig)
+		t.Fail()
+	}
+
+	// loopback stanza is described by /etc/network/interfaces, DNS
+	// settings should not be lost
+	wantedState["lo"].L3.Nameservers = []string{"127.0.0.53"}
+	savedConfig = NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.String(), `# Generated by l23network, don't edit manually
+iface lo inet manual
+    dns-nameservers 127.0.0.53
+`)
+
+	wantedState["lo"].L3.IPv4 = append(wantedState["lo"].L3.IPv4, "10.255.0.1/32")
+	savedConfig = NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.String(), `# Generated by l23network, don't edit manually
+iface lo inet static
+    address 10.255.0.1/32
+    dns-nameservers 127.0.0.53
+`)
+}
+
+func Test__Bond_slaves_without_primitives(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth2": &npstate.NPState{
+			Name:   "eth2",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Mtu: 9000,
+				Ethtool: npstate.EthtoolState{
+					Offload: map[string]bool{"gro": false},
+					Ring:    map[string]int{"rx": 4096},
+				},
+			},
+		},
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2: npstate.L2State{
+				Slaves:         []string{"eth2", "eth3"},
+				BondProperties: map[string]string{"mode": "802.3ad", "xmit_hash_policy": "layer3+4"},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Ifaces["bond0"].Stanzas[0].Options, []string{
+		"bond-slaves eth2 eth3",
+		"bond-mode 802.3ad",
+		"bond-xmit-hash-policy layer3+4",
+	})
+	td.CmpDeeply(t, savedConfig.Ifaces["eth2"].Stanzas[0].Options, []string{
+		"bond-master bond0",
+		"mtu 9000",
+		"offload-gro off",
+		"hardware-dma-ring-rx 4096",
+	})
+	// slave, which is not described in the network scheme, should be
+	// described to be enslaved while booting
+	td.CmpDeeply(t, savedConfig.Ifaces["eth3"].String(), `# Generated by l23network, don't edit manually
+auto eth3
+iface eth3 inet manual
+    bond-master bond0
+`)
+}
+
+func Test__Vlan_on_bond_into_bridge(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2:     npstate.L2State{Slaves: []string{"eth2", "eth3"}},
+		},
+		"bond0.100": &npstate.NPState{
+			Name:   "bond0.100",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Parent:  "bond0",
+				Vlan_id: 100,
+				Bridge:  "br1",
+			},
+		},
+		"br1": &npstate.NPState{
+			Name:   "br1",
+			Action: "bridge",
+			Online: true,
+			L3:     npstate.L3State{IPv4: []string{"10.1.1.1/24"}},
+		},
+		"br-ovs": &npstate.NPState{
+			Name:     "br-ovs",
+			Action:   "bridge",
+			Provider: "ovs",
+			Online:   true,
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Ifaces["br1"].String(), `# Generated by l23network, don't edit manually
+auto br1
+iface br1 inet static
+    address 10.1.1.1/24
+    bridge_ports bond0.100
+    bridge_stp off
+`)
+	td.CmpDeeply(t, savedConfig.Ifaces["bond0.100"].String(), `# Generated by l23network, don't edit manually
+auto bond0.100
+iface bond0.100 inet manual
+    vlan-raw-device bond0
+`)
+
+	// 'source-directory' skips file names with dots
+	paths := []string{}
+	for _, file := range savedConfig.Files("/tmp/interfaces.d") {
+		paths = append(paths, file.Path)
+	}
+	td.CmpDeeply(t, paths, []string{
+		"/tmp/interfaces.d/l23-bond0",
+		"/tmp/interfaces.d/l23-bond0_100",
+		"/tmp/interfaces.d/l23-br1",
+		"/tmp/interfaces.d/l23-eth2",
+		"/tmp/interfaces.d/l23-eth3",
+	})
+}
+
+func Test__Alias(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+			L2:     npstate.L2State{Alias: "it's uplink; reboot"},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Ifaces["eth1"].Stanzas[0].Options, []string{
+		`post-up ip link set dev $IFACE alias 'it'\''s uplink; reboot'`,
+	})
+
+	// line break would add options to the stanza
+	wantedState["eth1"].L2.Alias = "uplink\n    pre-up reboot"
+	savedConfig = NewSavedConfig(logger.New())
+	savedConfig.SetWantedState(&wantedState)
+	if err := savedConfig.Generate(); err == nil {
+		t.Logf("Alias with line breaks should not be stored:\n%s", savedConfig)
+		t.Fail()
+	}
+}
+
+func Test__Hand_written_files_are_kept(t *testing.T) {
+	files := configtest.GeneratedFiles(t, NewSavedConfig(nil), map[string]string{
+		"l23-eth1":     utils.GeneratedHeader + "iface eth1 inet manual\n",
+		"l23-eth1_101": utils.GeneratedHeader,
+		"l23-tun0":     "iface tun0 inet manual\n",
+		"eth0":         utils.GeneratedHeader,
+	})
+	td.CmpDeeply(t, files, []string{"l23-eth1", "l23-eth1_101"})
+}
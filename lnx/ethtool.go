@@ -0,0 +1,226 @@
+package lnx
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/utils"
+)
+
+// EthtoolCmd -- ethtool binary, used for get and set NIC settings
+var EthtoolCmd = "ethtool"
+
+// ethtool short offload names and corresponded names from 'ethtool -k' output
+var ethtoolOffloadNames = map[string]string{
+	"rx":     "rx-checksumming",
+	"tx":     "tx-checksumming",
+	"sg":     "scatter-gather",
+	"tso":    "tcp-segmentation-offload",
+	"gso":    "generic-segmentation-offload",
+	"gro":    "generic-receive-offload",
+	"lro":    "large-receive-offload",
+	"rxvlan": "rx-vlan-offload",
+	"txvlan": "tx-vlan-offload",
+	"ntuple": "ntuple-filters",
+	"rxhash": "receive-hashing",
+}
+
+// 'ethtool -g' output names and corresponded 'ethtool -G' parameters
+var ethtoolRingNames = map[string]string{
+	"RX":       "rx",
+	"RX Mini":  "rx-mini",
+	"RX Jumbo": "rx-jumbo",
+	"TX":       "tx",
+}
+
+func ethtoolOnOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+func ethtoolRun(args ...string) (string, error) {
+	out, err := exec.Command(EthtoolCmd, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("'%s %s' failed: %v: %s", EthtoolCmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// parseEthtoolOffload -- parse 'ethtool -k' output
+func parseEthtoolOffload(out string) map[string]bool {
+	features := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+		features[kv[0]] = (fields[0] == "on")
+	}
+	rv := make(map[string]bool)
+	for short, long := range ethtoolOffloadNames {
+		if v, ok := features[long]; ok {
+			rv[short] = v
+		}
+	}
+	return rv
+}
+
+// parseEthtoolRing -- parse 'ethtool -g' output, only current settings
+// will be returned
+func parseEthtoolRing(out string) map[string]int {
+	rv := make(map[string]int)
+	current := false
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Current hardware settings") {
+			current = true
+			continue
+		}
+		if !current {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name, ok := ethtoolRingNames[strings.TrimSpace(kv[0])]
+		if !ok {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSpace(kv[1])); err == nil {
+			rv[name] = v
+		}
+	}
+	return rv
+}
+
+// parseEthtoolCoalesce -- parse 'ethtool -c' output
+func parseEthtoolCoalesce(out string) map[string]string {
+	rv := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Adaptive RX:") {
+			// Adaptive RX: off  TX: off
+			fields := strings.Fields(line)
+			if len(fields) >= 5 {
+				rv["adaptive-rx"] = fields[2]
+				rv["adaptive-tx"] = fields[4]
+			}
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		if IndexString(npstate.EthtoolCoalesces, key) < 0 || value == "" || value == "n/a" {
+			continue
+		}
+		rv[key] = value
+	}
+	return rv
+}
+
+// EthtoolGet -- fetch NIC settings, managed by ethtool
+func EthtoolGet(ifname string) (*npstate.EthtoolState, error) {
+	rv := &npstate.EthtoolState{}
+	out, err := ethtoolRun("-k", ifname)
+	if err != nil {
+		return nil, err
+	}
+	rv.Offload = parseEthtoolOffload(out)
+	// ring and coalesce settings are not supported by all drivers
+	if out, err = ethtoolRun("-g", ifname); err == nil {
+		rv.Ring = parseEthtoolRing(out)
+	}
+	if out, err = ethtoolRun("-c", ifname); err == nil {
+		rv.Coalesce = parseEthtoolCoalesce(out)
+	}
+	return rv, nil
+}
+
+// ethtoolArgs -- generate ethtool arguments for change NIC settings
+// from 'actual' to 'wanted'. Only settings, defined in the 'wanted' will
+// be changed. Returns list of argument lists, one for each ethtool call
+func ethtoolArgs(ifname string, wanted, actual *npstate.EthtoolState) (rv [][]string) {
+	var keys []string
+
+	args := []string{"-K", ifname}
+	keys = []string{}
+	for key, value := range wanted.Offload {
+		if v, ok := actual.Offload[key]; !ok || v != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, key, ethtoolOnOff(wanted.Offload[key]))
+	}
+	if len(keys) > 0 {
+		rv = append(rv, args)
+	}
+
+	args = []string{"-G", ifname}
+	keys = []string{}
+	for key, value := range wanted.Ring {
+		if v, ok := actual.Ring[key]; !ok || v != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, key, strconv.Itoa(wanted.Ring[key]))
+	}
+	if len(keys) > 0 {
+		rv = append(rv, args)
+	}
+
+	args = []string{"-C", ifname}
+	keys = []string{}
+	for key, value := range wanted.Coalesce {
+		if v, ok := actual.Coalesce[key]; !ok || v != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, key, wanted.Coalesce[key])
+	}
+	if len(keys) > 0 {
+		rv = append(rv, args)
+	}
+
+	return rv
+}
+
+// EthtoolSet -- apply wanted NIC settings, if they differ from the actual ones
+func EthtoolSet(ifname string, wanted *npstate.EthtoolState) error {
+	if wanted.IsEmpty() {
+		return nil
+	}
+	actual, err := EthtoolGet(ifname)
+	if err != nil {
+		return err
+	}
+	for _, args := range ethtoolArgs(ifname, wanted, actual) {
+		if _, err := ethtoolRun(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package lnx
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	npstate "github.com/xenolog/l23/npstate"
+)
+
+// Kernel -- access to network configuration of linux kernel. All kernel
+// operations of LnxRtPlugin and its operators are made through this
+// interface. Link and address methods have the same semantic as methods
+// of netlink.Handle
+type Kernel interface {
+	LinkList() ([]netlink.Link, error)
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	LinkAdd(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	LinkSetUp(link netlink.Link) error
+	LinkSetDown(link netlink.Link) error
+	LinkSetMTU(link netlink.Link, mtu int) error
+	LinkSetName(link netlink.Link, name string) error
+	LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error
+	LinkSetTxQLen(link netlink.Link, qlen int) error
+	LinkSetAlias(link netlink.Link, name string) error
+	LinkSetMasterByIndex(link netlink.Link, masterIndex int) error
+	LinkSetNoMaster(link netlink.Link) error
+
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+
+	// link properties, which are not available through netlink
+	// ("bridge/stp_state", "bonding/slaves", for example)
+	SysfsRead(ifname, file string) string
+	SysfsWrite(ifname, file, value string) error
+	// base name of symlink target ("device/driver", for example), or empty
+	// string if there is no such link
+	SysfsLink(ifname, file string) string
+
+	// NIC settings
+	EthtoolGet(ifname string) (*npstate.EthtoolState, error)
+	EthtoolSet(ifname string, wanted *npstate.EthtoolState) error
+}
+
+// NetlinkKernel -- Kernel implementation for the real kernel. Links and
+// addresses are managed through netlink handle, other properties through
+// sysfs and ethtool
+type NetlinkKernel struct {
+	*netlink.Handle
+}
+
+func (s *NetlinkKernel) SysfsRead(ifname, file string) string {
+	return sysfsRead(ifname, file)
+}
+
+func (s *NetlinkKernel) SysfsWrite(ifname, file, value string) error {
+	return sysfsWrite(ifname, file, value)
+}
+
+func (s *NetlinkKernel) SysfsLink(ifname, file string) string {
+	return sysfsLinkBase(ifname, file)
+}
+
+func (s *NetlinkKernel) EthtoolGet(ifname string) (*npstate.EthtoolState, error) {
+	return EthtoolGet(ifname)
+}
+
+func (s *NetlinkKernel) EthtoolSet(ifname string, wanted *npstate.EthtoolState) error {
+	return EthtoolSet(ifname, wanted)
+}
+
+// NewNetlinkKernel -- create Kernel for given netlink handle. If nil given,
+// new handle for current network namespace will be created
+func NewNetlinkKernel(hh *netlink.Handle) (rv *NetlinkKernel, err error) {
+	if hh == nil {
+		if hh, err = netlink.NewHandle(); err != nil {
+			return nil, err
+		}
+	}
+	return &NetlinkKernel{Handle: hh}, nil
+}
+
+// Explainer -- Kernel, which records reasons of operations (dry-run kernel,
+// for example). Given reason is applied to all following operations
+type Explainer interface {
+	Explain(reason string)
+}
@@ -2,11 +2,10 @@ package lnx
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/vishvananda/netlink"
 	logger "github.com/xenolog/go-tiny-logger"
@@ -21,12 +20,19 @@ const (
 	MsgPrefix = "LNX plugin"
 )
 
-var LnxRtPluginEntryPoint *LnxRtPlugin
-
+// LnxRtPlugin -- runtime plugin, which manages linux kernel network
+// primitives through Kernel. Each instance has own Kernel (i.e. may work
+// into own network namespace or with in-memory kernel), operators are bound
+// to the instance, which creates them. Changes and observing are serialized
+// per instance, because netlink handle can't be used concurrently
 type LnxRtPlugin struct {
-	log      *logger.Logger
-	handle   *netlink.Handle
-	topology *npstate.TopologyState
+	mutex     sync.Mutex
+	log       *logger.Logger
+	kernel    Kernel
+	topology  *npstate.TopologyState
+	dryKernel *MemKernel        // copy of observed topology for dry-run changes
+	ethtool   map[string]bool   // interfaces to observe NIC settings, nil for all
+	renames   map[string]string // interfaces, renamed in the dry-run mode
 }
 
 type BondSlavesDiffType struct {
@@ -39,9 +45,10 @@ type BondSlavesDiffType struct {
 type OpBase struct {
 	plugin      *LnxRtPlugin
 	log         *logger.Logger
-	handle      *netlink.Handle
+	kernel      Kernel
 	wantedState *npstate.NPState
 	rtState     *npstate.NPState
+	errs        []string // errors, which don't break the change
 }
 
 func (s *OpBase) Init(wantedState *npstate.NPState) error {
@@ -49,10 +56,61 @@ func (s *OpBase) Init(wantedState *npstate.NPState) error {
 	s.rtState = nil
 	return nil
 }
-func (s *OpBase) setupGlobals() {
-	s.plugin = LnxRtPluginEntryPoint
-	s.handle = s.plugin.handle
-	s.log = s.plugin.log
+func (s *OpBase) setupPlugin(plugin *LnxRtPlugin) {
+	s.plugin = plugin
+	s.kernel = plugin.kernel
+	s.log = plugin.log
+}
+
+// explain -- tell kernel the reason of following operations. Reasons are
+// reported in the dry-run mode
+func (s *OpBase) explain(format string, args ...interface{}) {
+	if e, ok := s.kernel.(Explainer); ok {
+		e.Explain(fmt.Sprintf(format, args...))
+	}
+}
+
+// fail -- log error, which doesn't break the change, and keep it to be
+// returned as result of the change
+func (s *OpBase) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	s.log.Error("%s: %s", MsgPrefix, msg)
+	s.errs = append(s.errs, msg)
+}
+
+// result -- returns result of the change: given error or errors, kept
+// while changing
+func (s *OpBase) result(err error) error {
+	if err == nil && len(s.errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(s.errs, "; "))
+	}
+	s.errs = nil
+	return err
+}
+
+// dryRun -- make changes into the in-memory copy of kernel instead of the
+// real one and report kernel operations, which would be made, in the order
+// of execution
+func (s *OpBase) dryRun(apply func() error) error {
+	kernel := s.kernel
+	dryKernel := s.plugin.dryRunKernel()
+	s.kernel = dryKernel
+	defer func() { s.kernel = kernel }()
+
+	done := len(dryKernel.Steps())
+	dryKernel.Explain("")
+	err := apply()
+	steps := dryKernel.Steps()[done:]
+	if len(steps) == 0 {
+		s.log.Info("%s dryrun: nothing to change for '%s'", MsgPrefix, s.Name())
+	}
+	for _, step := range steps {
+		s.log.Info("%s dryrun: %s", MsgPrefix, step)
+	}
+	if err != nil {
+		s.log.Info("%s dryrun: changing of '%s' would fail: %v", MsgPrefix, s.Name(), err)
+	}
+	return err
 }
 
 func (s *OpBase) Name() string {
@@ -61,7 +119,7 @@ func (s *OpBase) Name() string {
 
 func (s *OpBase) Link() netlink.Link {
 	linkName := s.Name()
-	link, err := netlink.LinkByName(linkName)
+	link, err := s.kernel.LinkByName(linkName)
 	if err != nil {
 		s.log.Error("%s Can't get attributes for interface '%s' : %v", MsgPrefix, linkName, err)
 		return nil
@@ -80,25 +138,45 @@ func (s *OpBase) IfIndex() int {
 
 func (s *OpBase) AddToBridge(brName string) error {
 	// attach to bridge
-	br, err := netlink.LinkByName(brName)
+	br, err := s.kernel.LinkByName(brName)
 	if br == nil || err != nil {
 		s.log.Debug("%s: bridge '%s' can't be located: %v", MsgPrefix, brName, err)
 		return err
 	}
 
-	if err := s.handle.LinkSetMasterByIndex(s.Link(), br.Attrs().Index); err != nil {
+	s.explain("'%s' should be a member of bridge '%s'", s.Name(), brName)
+	if err := s.kernel.LinkSetMasterByIndex(s.Link(), br.Attrs().Index); err != nil {
 		s.log.Debug("%s: '%s' can't be became a member of bridge '%s': %v", MsgPrefix, s.Name(), brName, err)
 		return err
 	}
 	return nil
 }
 
+// bridgePort -- check whether link should be released by removing from
+// bridge. Bond slaves have master too, but should not be touched here.
+// Master, which can't be found, is considered as a bridge
+func bridgePort(link, master netlink.Link) bool {
+	if link.Attrs().MasterIndex == 0 {
+		return false
+	}
+	return master == nil || master.Type() == "bridge"
+}
+
 func (s *OpBase) RemoveFromBridge() error {
 	// remove from bridge
-	// todo(sv): Check if master is bridge, not bond !!!
 	link := s.Link()
+	if link == nil {
+		return fmt.Errorf("interface '%s' not found", s.Name())
+	}
+	var master netlink.Link
 	if link.Attrs().MasterIndex != 0 {
-		if err := s.handle.LinkSetNoMaster(link); err != nil {
+		master, _ = s.kernel.LinkByIndex(link.Attrs().MasterIndex)
+	}
+	if bridgePort(link, master) {
+		if master != nil {
+			s.explain("'%s' should not be a member of bridge '%s'", s.Name(), master.Attrs().Name)
+		}
+		if err := s.kernel.LinkSetNoMaster(link); err != nil {
 			s.log.Debug("%s: '%s' can't be removed from bridge: %v", MsgPrefix, s.Name(), err)
 			return err
 		}
@@ -106,11 +184,77 @@ func (s *OpBase) RemoveFromBridge() error {
 	return nil
 }
 
+// setupLinkState -- setup administrative state of the link to the wanted one
+func (s *OpBase) setupLinkState(link netlink.Link) (err error) {
+	switch {
+	case s.wantedState.KeepOnline:
+		s.log.Debug("%s: state of '%s' is unmanaged, left as is", MsgPrefix, s.Name())
+	case s.wantedState.Online:
+		s.log.Debug("%s: setting to UP state", MsgPrefix)
+		s.explain("'%s' should be online", s.Name())
+		if err = s.kernel.LinkSetUp(link); err != nil {
+			s.fail("error while '%s' set to UP state: %v", s.Name(), err)
+		}
+	default:
+		s.log.Debug("%s: setting to DOWN state", MsgPrefix)
+		s.explain("'%s' should be offline", s.Name())
+		if err = s.kernel.LinkSetDown(link); err != nil {
+			s.fail("error while '%s' set to DOWN state: %v", s.Name(), err)
+		}
+	}
+	return err
+}
+
+// setupL2Properties -- setup optional L2 properties (MAC address, txqueuelen,
+// alias, ethtool settings) if they defined into wanted state
+func (s *OpBase) setupL2Properties(link netlink.Link) (err error) {
+	attrs := link.Attrs()
+	l2 := &s.wantedState.L2
+
+	if l2.HwAddr != "" && !strings.EqualFold(l2.HwAddr, attrs.HardwareAddr.String()) {
+		s.log.Debug("%s: setting MAC address to: %v", MsgPrefix, l2.HwAddr)
+		var hwaddr net.HardwareAddr
+		s.explain("MAC address of '%s' differs: %s -> %s", s.Name(), attrs.HardwareAddr, l2.HwAddr)
+		if hwaddr, err = net.ParseMAC(l2.HwAddr); err == nil {
+			err = s.kernel.LinkSetHardwareAddr(link, hwaddr)
+		}
+		if err != nil {
+			s.fail("error while '%s' set MAC address: %v", s.Name(), err)
+		}
+	}
+
+	if l2.TxQLen > 0 && l2.TxQLen != attrs.TxQLen {
+		s.log.Debug("%s: setting txqueuelen to: %v", MsgPrefix, l2.TxQLen)
+		s.explain("txqueuelen of '%s' differs: %d -> %d", s.Name(), attrs.TxQLen, l2.TxQLen)
+		if err = s.kernel.LinkSetTxQLen(link, l2.TxQLen); err != nil {
+			s.fail("error while '%s' set txqueuelen: %v", s.Name(), err)
+		}
+	}
+
+	if l2.Alias != "" && l2.Alias != attrs.Alias {
+		s.log.Debug("%s: setting alias to: %v", MsgPrefix, l2.Alias)
+		s.explain("alias of '%s' differs: '%s' -> '%s'", s.Name(), attrs.Alias, l2.Alias)
+		if err = s.kernel.LinkSetAlias(link, l2.Alias); err != nil {
+			s.fail("error while '%s' set alias: %v", s.Name(), err)
+		}
+	}
+
+	if !l2.Ethtool.IsEmpty() {
+		s.log.Debug("%s: setting ethtool properties", MsgPrefix)
+		s.explain("ethtool settings of '%s' are defined", s.Name())
+		if err = s.kernel.EthtoolSet(s.Name(), &l2.Ethtool); err != nil {
+			s.fail("error while '%s' set ethtool properties: %v", s.Name(), err)
+		}
+	}
+
+	return err
+}
+
 // returns address list in the original order
 func (s *OpBase) IPv4addrList() []string {
 
 	rv := []string{}
-	if addrs, err := s.handle.AddrList(s.Link(), unix.AF_INET); err == nil { // unix.AF_INET === netlink.FAMILY_V4 , but operable under OSX
+	if addrs, err := s.kernel.AddrList(s.Link(), unix.AF_INET); err == nil { // unix.AF_INET === netlink.FAMILY_V4 , but operable under OSX
 		for _, addr := range addrs {
 			rv = append(rv, addr.IPNet.String())
 		}
@@ -148,11 +292,12 @@ func (s *OpBase) allignIPv4list() {
 	for _, addr := range toAdd {
 		s.log.Debug("%s %s: Adding IPv4 addr '%s'", MsgPrefix, s.Name(), addr)
 		if a, err := netlink.ParseAddr(addr); err == nil {
-			if err := s.handle.AddrAdd(s.Link(), a); err != nil {
-				s.log.Error("%s %sCan't add IPv4 addr '%s': %v", MsgPrefix, s.Name(), addr, err)
+			s.explain("address %s of '%s' is wanted", addr, s.Name())
+			if err := s.kernel.AddrAdd(s.Link(), a); err != nil {
+				s.fail("%s: can't add IPv4 addr '%s': %v", s.Name(), addr, err)
 			}
 		} else {
-			s.log.Error("%s Can't parse IPv4 addr '%s' while addition: %v", MsgPrefix, addr, err)
+			s.fail("can't parse IPv4 addr '%s' while addition: %v", addr, err)
 		}
 	}
 
@@ -160,11 +305,12 @@ func (s *OpBase) allignIPv4list() {
 	for _, addr := range toRemove {
 		s.log.Debug("%s %s Removing IPv4 addr '%s'", MsgPrefix, s.Name(), addr)
 		if a, err := netlink.ParseAddr(addr); err == nil {
-			if err := s.handle.AddrDel(s.Link(), a); err != nil {
-				s.log.Error("%s %s Can't remove IPv4 addr '%s': %v", MsgPrefix, s.Name(), addr, err)
+			s.explain("address %s of '%s' is not wanted", addr, s.Name())
+			if err := s.kernel.AddrDel(s.Link(), a); err != nil {
+				s.fail("%s: can't remove IPv4 addr '%s': %v", s.Name(), addr, err)
 			}
 		} else {
-			s.log.Error("%s Can't parse IPv4 addr '%s' while addition: %v", MsgPrefix, addr, err)
+			s.fail("can't parse IPv4 addr '%s' while removing: %v", addr, err)
 		}
 	}
 
@@ -176,16 +322,22 @@ type L2Port struct {
 	OpBase
 }
 
-func (s *L2Port) Create(dryrun bool) error {
+func (s *L2Port) Create(dryrun bool) (err error) {
+	if s.wantedState.L2.Vlan_id == 0 && (s.wantedState.LinkType == "" || s.wantedState.LinkType == "device") {
+		// physical interfaces can't be created, only configured
+		err = fmt.Errorf("interface '%s' not found, physical interfaces can't be created", s.Name())
+		s.log.Error("%s %v", MsgPrefix, err)
+		return err
+	}
+
 	if dryrun {
-		s.log.Info("%s dryrun: Port '%s' created.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Create(false) })
 	}
 
 	s.log.Info("%s Creating port '%s'", MsgPrefix, s.Name())
 
 	// check whether this port is HW device
-	link, err := netlink.LinkByName(s.Name())
+	link, err := s.kernel.LinkByName(s.Name())
 	if err == nil {
 		s.log.Error("%s found existed port", MsgPrefix)
 		report, _ := yaml.Marshal(link.Attrs())
@@ -193,10 +345,11 @@ func (s *L2Port) Create(dryrun bool) error {
 		return err
 	}
 
-	if s.wantedState.L2.Parent != "" && s.wantedState.L2.Vlan_id > 0 {
+	switch {
+	case s.wantedState.L2.Parent != "" && s.wantedState.L2.Vlan_id > 0:
 		// vlan over parent
 		parentID := 0
-		if parent, err := netlink.LinkByName(s.wantedState.L2.Parent); err != nil {
+		if parent, err := s.kernel.LinkByName(s.wantedState.L2.Parent); err != nil {
 			s.log.Error("%s Can't find interface '%s' as parent for '%s': %v", MsgPrefix, s.wantedState.L2.Parent, s.Name(), err)
 			return err
 		} else {
@@ -207,82 +360,94 @@ func (s *L2Port) Create(dryrun bool) error {
 		}
 		vlan.Name = s.Name()
 		vlan.ParentIndex = parentID
-		if err := s.handle.LinkAdd(&vlan); err != nil {
+		s.explain("vlan '%s' does not exist", s.Name())
+		if err := s.kernel.LinkAdd(&vlan); err != nil {
 			s.log.Error("%s Can't create vlan '%s': %v", MsgPrefix, s.Name(), err)
 			return err
 		}
-		err := s.Modify(false)
+	case s.wantedState.LinkType == "dummy":
+		dummy := netlink.Dummy{}
+		dummy.Name = s.Name()
+		s.explain("dummy interface '%s' does not exist", s.Name())
+		if err := s.kernel.LinkAdd(&dummy); err != nil {
+			s.log.Error("%s Can't create dummy interface '%s': %v", MsgPrefix, s.Name(), err)
+			return err
+		}
+	default:
+		err = fmt.Errorf("unsupported type '%s' for port '%s'", s.wantedState.LinkType, s.Name())
+		s.log.Error("%s %v", MsgPrefix, err)
 		return err
-	} else {
-		s.log.Error("%s Not implementing, because TBD", MsgPrefix)
 	}
 
-	return nil
+	return s.Modify(false)
 }
 
 func (s *L2Port) Remove(dryrun bool) error {
 	if dryrun {
-		s.log.Info("%s dryrun: Port '%s' removed.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Remove(false) })
 	}
 
 	s.log.Info("%s: Removing port '%s'", MsgPrefix, s.Name())
-	link, _ := netlink.LinkByName(s.Name())
-	if err := s.handle.LinkSetDown(link); err != nil {
+	s.explain("port '%s' is not wanted", s.Name())
+	link, _ := s.kernel.LinkByName(s.Name())
+	if err := s.kernel.LinkSetDown(link); err != nil {
 		s.log.Error("%s: error while port removing: %v", MsgPrefix, err)
 		return err
 	}
-	if err := s.handle.LinkDel(link); err != nil {
+	if err := s.kernel.LinkDel(link); err != nil {
 		s.log.Error("%s: error while port removing: %v", MsgPrefix, err)
-	} else {
-		s.log.Info("%s: port removed.", MsgPrefix)
+		return err
 	}
+	s.log.Info("%s: port removed.", MsgPrefix)
 	return nil
 }
 
 func (s *L2Port) Modify(dryrun bool) error {
 	if dryrun {
-		s.log.Info("%s dryrun: Port '%s' modifyed.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Modify(false) })
 	}
 
 	s.log.Info("%s: Modifying port '%s'", MsgPrefix, s.Name())
 	// needUp := false
-	link, _ := netlink.LinkByName(s.Name())
+	link, err := s.kernel.LinkByName(s.Name())
+	if err != nil {
+		s.log.Error("%s: '%s' can't be modified: %v", MsgPrefix, s.Name(), err)
+		return err
+	}
 	attrs := link.Attrs()
 
-	// if err := s.handle.LinkSetDown(link); err != nil {
+	// if err := s.kernel.LinkSetDown(link); err != nil {
 	// 	s.log.Error("%s: error while port set to DOWN state: %v", MsgPrefix, err)
 	// }
 
 	if s.wantedState.L2.Mtu > 0 && s.wantedState.L2.Mtu != attrs.MTU {
 		s.log.Debug("%s: setting MTU to: %v", MsgPrefix, s.wantedState.L2.Mtu)
-		if err := s.handle.LinkSetMTU(link, s.wantedState.L2.Mtu); err != nil {
-			s.log.Error("%s: error while port set MTU: %v", MsgPrefix, err)
+		s.explain("MTU of '%s' differs: %d -> %d", s.Name(), attrs.MTU, s.wantedState.L2.Mtu)
+		if err := s.kernel.LinkSetMTU(link, s.wantedState.L2.Mtu); err != nil {
+			s.fail("error while port '%s' set MTU: %v", s.Name(), err)
 		}
 	}
 
-	if s.wantedState.L2.Bridge != "" {
-		s.AddToBridge(s.wantedState.L2.Bridge)
-	} else {
-		s.RemoveFromBridge()
-	}
+	s.setupL2Properties(link)
 
-	if s.wantedState.Online {
-		s.log.Debug("%s: setting to UP state", MsgPrefix)
-		if err := s.handle.LinkSetUp(link); err != nil {
-			s.log.Error("%s: error while port set to UP state: %v", MsgPrefix, err)
+	if s.wantedState.L2.Bridge != "" {
+		if err := s.AddToBridge(s.wantedState.L2.Bridge); err != nil {
+			s.fail("'%s' can't be added to bridge '%s': %v", s.Name(), s.wantedState.L2.Bridge, err)
 		}
+	} else if err := s.RemoveFromBridge(); err != nil {
+		s.fail("'%s' can't be removed from bridge: %v", s.Name(), err)
 	}
 
+	s.setupLinkState(link)
+
 	s.allignIPv4list()
 
-	return nil
+	return s.result(nil)
 }
 
-func NewPort() NpOperator {
+func NewPort(plugin *LnxRtPlugin) NpOperator {
 	rv := new(L2Port)
-	rv.setupGlobals()
+	rv.setupPlugin(plugin)
 	return rv
 }
 
@@ -294,14 +459,14 @@ type L2Bridge struct {
 
 func (s *L2Bridge) Create(dryrun bool) (err error) {
 	if dryrun {
-		s.log.Info("%s dryrun: Bridge '%s' created.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Create(false) })
 	}
 
 	s.log.Info("%s Creating bridge '%s'", MsgPrefix, s.Name())
 	br := netlink.Bridge{}
 	br.Name = s.Name()
-	if err = s.handle.LinkAdd(&br); err != nil {
+	s.explain("bridge '%s' does not exist", s.Name())
+	if err = s.kernel.LinkAdd(&br); err != nil {
 		s.log.Error("%s: error while bridge creating: %v", MsgPrefix, err)
 		return err
 	} else {
@@ -315,16 +480,16 @@ func (s *L2Bridge) Create(dryrun bool) (err error) {
 
 func (s *L2Bridge) Remove(dryrun bool) (err error) {
 	if dryrun {
-		s.log.Info("%s: dryrun: Bridge '%s' removed.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Remove(false) })
 	}
 	s.log.Info("%s: Removing bridge '%s'", MsgPrefix, s.Name())
-	link, _ := netlink.LinkByName(s.Name())
-	if err = s.handle.LinkSetDown(link); err != nil {
+	s.explain("bridge '%s' is not wanted", s.Name())
+	link, _ := s.kernel.LinkByName(s.Name())
+	if err = s.kernel.LinkSetDown(link); err != nil {
 		s.log.Error("%s: error while bridge removing: %v", MsgPrefix, err)
 		return err
 	}
-	if err = s.handle.LinkDel(link); err != nil {
+	if err = s.kernel.LinkDel(link); err != nil {
 		s.log.Error("%s: error while bridge removing: %v", MsgPrefix, err)
 	} else {
 		s.log.Info("%s: bridge removed.", MsgPrefix)
@@ -334,35 +499,58 @@ func (s *L2Bridge) Remove(dryrun bool) (err error) {
 
 func (s *L2Bridge) Modify(dryrun bool) (err error) {
 	if dryrun {
-		s.log.Info("%s dryrun: Bridge '%s' modifyed.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Modify(false) })
 	}
 
 	s.log.Info("%s: Modifying bridge '%s'", MsgPrefix, s.Name())
-	link, _ := netlink.LinkByName(s.Name())
+	link, err := s.kernel.LinkByName(s.Name())
+	if err != nil {
+		s.log.Error("%s: '%s' can't be modified: %v", MsgPrefix, s.Name(), err)
+		return err
+	}
 	attrs := link.Attrs()
 
-	if err = s.handle.LinkSetDown(link); err != nil {
-		s.log.Error("%s: error while bridge set to DOWN state: %v", MsgPrefix, err)
+	if !s.wantedState.KeepOnline {
+		s.explain("bridge '%s' is re-configured in the DOWN state", s.Name())
+		if err = s.kernel.LinkSetDown(link); err != nil {
+			s.fail("error while bridge '%s' set to DOWN state: %v", s.Name(), err)
+		}
 	}
 
 	if s.wantedState.L2.Mtu > 0 && s.wantedState.L2.Mtu != attrs.MTU {
 		s.log.Debug("%s: setting MTU to: %v", MsgPrefix, s.wantedState.L2.Mtu)
-		if err = s.handle.LinkSetMTU(link, s.wantedState.L2.Mtu); err != nil {
-			s.log.Error("%s: error while bridge set MTU: %v", MsgPrefix, err)
+		s.explain("MTU of '%s' differs: %d -> %d", s.Name(), attrs.MTU, s.wantedState.L2.Mtu)
+		if err = s.kernel.LinkSetMTU(link, s.wantedState.L2.Mtu); err != nil {
+			s.fail("error while bridge '%s' set MTU: %v", s.Name(), err)
 		}
 	}
 
-	if s.wantedState.Online {
-		s.log.Debug("%s: setting to UP state", MsgPrefix)
-		if err = s.handle.LinkSetUp(link); err != nil {
-			s.log.Error("%s: error while bridge set to UP state: %v", MsgPrefix, err)
-		}
+	if err = s.setupStp(); err != nil {
+		s.fail("error while bridge '%s' set STP: %v", s.Name(), err)
 	}
 
+	s.setupL2Properties(link)
+
+	s.setupLinkState(link)
+
 	s.allignIPv4list()
 
-	return err
+	return s.result(nil)
+}
+
+// setupStp -- enable or disable STP for bridge, if need
+func (s *L2Bridge) setupStp() error {
+	actual := s.kernel.SysfsRead(s.Name(), "bridge/stp_state")
+	if (actual != "" && actual != "0") == s.wantedState.L2.Stp {
+		return nil
+	}
+	s.log.Debug("%s: setting STP to: %v", MsgPrefix, s.wantedState.L2.Stp)
+	value, state := "0", "disabled"
+	if s.wantedState.L2.Stp {
+		value, state = "1", "enabled"
+	}
+	s.explain("STP of bridge '%s' should be %s", s.Name(), state)
+	return s.kernel.SysfsWrite(s.Name(), "bridge/stp_state", value)
 }
 
 func (s *L2Bridge) AddToBridge(brName string) error {
@@ -374,9 +562,9 @@ func (s *L2Bridge) RemoveFromBridge() error {
 	return nil
 }
 
-func NewBridge() NpOperator {
+func NewBridge(plugin *LnxRtPlugin) NpOperator {
 	rv := new(L2Bridge)
-	rv.setupGlobals()
+	rv.setupPlugin(plugin)
 	return rv
 }
 
@@ -388,13 +576,13 @@ type L2Bond struct {
 
 func (s *L2Bond) Create(dryrun bool) (err error) {
 	if dryrun {
-		s.log.Info("%s dryrun: Bond '%s' created.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Create(false) })
 	}
 
 	s.log.Info("%s Creating bond '%s'", MsgPrefix, s.Name())
 	bnd := netlink.NewLinkBond(netlink.LinkAttrs{Name: s.Name()})
-	if err = s.handle.LinkAdd(bnd); err != nil {
+	s.explain("bond '%s' does not exist", s.Name())
+	if err = s.kernel.LinkAdd(bnd); err != nil {
 		s.log.Error("%s: error while bond creating: %v", MsgPrefix, err)
 		return err
 	} else {
@@ -408,16 +596,16 @@ func (s *L2Bond) Create(dryrun bool) (err error) {
 
 func (s *L2Bond) Remove(dryrun bool) (err error) {
 	if dryrun {
-		s.log.Info("%s: dryrun: Bond '%s' removed.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Remove(false) })
 	}
 	s.log.Info("%s: Removing Bond '%s'", MsgPrefix, s.Name())
-	link, _ := netlink.LinkByName(s.Name())
-	if err = s.handle.LinkSetDown(link); err != nil {
+	s.explain("bond '%s' is not wanted", s.Name())
+	link, _ := s.kernel.LinkByName(s.Name())
+	if err = s.kernel.LinkSetDown(link); err != nil {
 		s.log.Error("%s: error while Bond removing: %v", MsgPrefix, err)
 		return err
 	}
-	if err = s.handle.LinkDel(link); err != nil {
+	if err = s.kernel.LinkDel(link); err != nil {
 		s.log.Error("%s: error while Bond removing: %v", MsgPrefix, err)
 	} else {
 		s.log.Info("%s: Bond removed.", MsgPrefix)
@@ -425,13 +613,7 @@ func (s *L2Bond) Remove(dryrun bool) (err error) {
 	return err
 }
 
-func (s *L2Bond) getSlaves(dryrun bool) (rv []string) {
-	if dryrun {
-		rv = []string{"eth1", "eth2"}
-		s.log.Info("%s dryrun: slaves for Bond '%s' is %v", MsgPrefix, s.Name(), rv)
-		return
-	}
-
+func (s *L2Bond) getSlaves() (rv []string) {
 	// todo(sv): Research why this code does not work properly (race condition, may be)
 	// bondIfIndex := s.Link().Attrs().Index
 
@@ -448,31 +630,18 @@ func (s *L2Bond) getSlaves(dryrun bool) (rv []string) {
 	// 	}
 	// }
 
-	bondSlavesFileName := fmt.Sprintf("/sys/class/net/%s/bonding/slaves", s.Name())
-	var (
-		rr   *os.File
-		err  error
-		data []byte
-	)
-	// Log.Debug("Run NetworkConfig with network scheme: '%s'", c.GlobalString("ns"))
-	if rr, err = os.Open(bondSlavesFileName); err != nil {
-		s.log.Error("Can't open file '%s': %v", bondSlavesFileName, err)
-		return
-	}
-	if data, err = ioutil.ReadAll(rr); err != nil {
-		s.log.Error("Can't process file '%s': %v", bondSlavesFileName, err)
-		return
-	}
-	rv = strings.Fields(string(data))
+	bondSlaves := s.kernel.SysfsRead(s.Name(), "bonding/slaves")
+	s.log.Debug("%s: slaves of Bond '%s' from sysfs: '%s'", MsgPrefix, s.Name(), bondSlaves)
+	rv = strings.Fields(bondSlaves)
 	sort.Strings(rv)
 	return rv
 }
 
 // diffSlaves -- genrate diff between current and wanted Bond slaves list
 // returns (diff, ok), where ok==true if differnces found
-func (s *L2Bond) diffSlaves(dryrun bool, wantedSlaves []string) (*BondSlavesDiffType, bool) {
-	actualSlaves := s.getSlaves(dryrun) // already sorted by design
-	sort.Strings(wantedSlaves)          // should be sorted
+func (s *L2Bond) diffSlaves(wantedSlaves []string) (*BondSlavesDiffType, bool) {
+	actualSlaves := s.getSlaves() // already sorted by design
+	sort.Strings(wantedSlaves)    // should be sorted
 	s.log.Debug("%s: Bond's wanted slaves: %v", MsgPrefix, wantedSlaves)
 	s.log.Debug("%s: Bond's actual slaves: %v", MsgPrefix, actualSlaves)
 
@@ -499,77 +668,83 @@ func (s *L2Bond) diffSlaves(dryrun bool, wantedSlaves []string) (*BondSlavesDiff
 
 func (s *L2Bond) Modify(dryrun bool) (err error) {
 	if dryrun {
-		s.log.Info("%s dryrun: Bond '%s' modifyed.", MsgPrefix, s.Name())
-		return nil
+		return s.dryRun(func() error { return s.Modify(false) })
 	}
 
 	s.log.Info("%s: Modifying Bond '%s'", MsgPrefix, s.Name())
 	bondLink := s.Link()
+	if bondLink == nil {
+		return fmt.Errorf("bond '%s' not found", s.Name())
+	}
 	bondAttrs := bondLink.Attrs()
 
-	// if err = s.handle.LinkSetDown(bondLink); err != nil {
+	// if err = s.kernel.LinkSetDown(bondLink); err != nil {
 	// 	s.log.Error("%s: error while Bond set to DOWN state: %v", MsgPrefix, err)
 	// }
 
 	if s.wantedState.L2.Mtu > 0 && s.wantedState.L2.Mtu != bondAttrs.MTU {
 		s.log.Debug("%s: setting MTU to: %v", MsgPrefix, s.wantedState.L2.Mtu)
-		if err = s.handle.LinkSetMTU(bondLink, s.wantedState.L2.Mtu); err != nil {
-			s.log.Error("%s: error while Bond set MTU: %v", MsgPrefix, err)
+		s.explain("MTU of '%s' differs: %d -> %d", s.Name(), bondAttrs.MTU, s.wantedState.L2.Mtu)
+		if err = s.kernel.LinkSetMTU(bondLink, s.wantedState.L2.Mtu); err != nil {
+			s.fail("error while bond '%s' set MTU: %v", s.Name(), err)
 		}
 	}
 
-	diff, need := s.diffSlaves(dryrun, s.wantedState.L2.Slaves)
+	s.setupL2Properties(bondLink)
+
+	diff, need := s.diffSlaves(s.wantedState.L2.Slaves)
 	if need {
 		for _, slaveName := range diff.toRemove {
 			s.log.Debug("%s: Removing '%s' from bond", MsgPrefix, slaveName)
-			slaveLink, err := s.handle.LinkByName(slaveName)
+			s.explain("'%s' should not be a slave of bond '%s'", slaveName, s.Name())
+			slaveLink, err := s.kernel.LinkByName(slaveName)
 			if err == nil {
-				err = s.handle.LinkSetDown(slaveLink)
+				err = s.kernel.LinkSetNoMaster(slaveLink)
 			}
-			if err == nil {
-				err = s.handle.LinkSetNoMaster(slaveLink)
+			if err == nil && slaveLink.Attrs().Flags&net.FlagUp != 0 {
+				// released slave is closed by kernel, but its own state
+				// is not changed by bond
+				s.explain("'%s' is closed by releasing from bond '%s', should be online again", slaveName, s.Name())
+				err = s.kernel.LinkSetUp(slaveLink)
 			}
 			if err != nil {
-				s.log.Error("%s: error while Bond removing slave '%s': %v", MsgPrefix, slaveName, err)
+				s.fail("error while bond '%s' removing slave '%s': %v", s.Name(), slaveName, err)
 			}
 		}
 		for _, slaveName := range diff.toAdd {
 			s.log.Debug("%s: Enslaving '%s' to bond", MsgPrefix, slaveName)
-			slaveLink, err := s.handle.LinkByName(slaveName)
+			s.explain("'%s' should be a slave of bond '%s'", slaveName, s.Name())
+			slaveLink, err := s.kernel.LinkByName(slaveName)
 			if err == nil {
-				err = s.handle.LinkSetDown(slaveLink)
+				err = s.kernel.LinkSetDown(slaveLink)
 			}
 			if err == nil {
-				err = netlink.LinkSetBondSlave(slaveLink, &netlink.Bond{LinkAttrs: *bondAttrs})
-				// whi does not wokr??? err = s.handle.LinkSetMasterByIndex(slaveLink, bondAttrs.MasterIndex)
+				err = s.kernel.LinkSetMasterByIndex(slaveLink, bondAttrs.Index)
 			}
 			if err != nil {
-				s.log.Error("%s: error while Bond adding slave '%s': %v", MsgPrefix, slaveName, err)
+				s.fail("error while bond '%s' adding slave '%s': %v", s.Name(), slaveName, err)
 			}
 		}
 	}
 
 	if s.wantedState.L2.Bridge != "" {
-		s.AddToBridge(s.wantedState.L2.Bridge)
-	} else {
-		s.RemoveFromBridge()
-	}
-
-	if s.wantedState.Online {
-		s.log.Debug("%s: setting to UP state", MsgPrefix)
-		if err = s.handle.LinkSetUp(bondLink); err != nil {
-			s.log.Error("%s: error while Bond set to UP state: %v", MsgPrefix, err)
+		if err := s.AddToBridge(s.wantedState.L2.Bridge); err != nil {
+			s.fail("'%s' can't be added to bridge '%s': %v", s.Name(), s.wantedState.L2.Bridge, err)
 		}
+	} else if err := s.RemoveFromBridge(); err != nil {
+		s.fail("'%s' can't be removed from bridge: %v", s.Name(), err)
 	}
 
+	s.setupLinkState(bondLink)
+
 	s.allignIPv4list()
 
-	return err
+	return s.result(nil)
 }
 
-func NewBond() NpOperator {
+func NewBond(plugin *LnxRtPlugin) NpOperator {
 	rv := new(L2Bond)
-	rv.setupGlobals()
+	rv.setupPlugin(plugin)
 	return rv
 }
 
@@ -578,26 +753,21 @@ func NewBond() NpOperator {
 
 // Init -- Runtime linux plugin entry point
 func (s *LnxRtPlugin) Init(log *logger.Logger, hh *netlink.Handle) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.log = log
-	if s.handle == nil && hh == nil {
-		// generate new handle if need
-		if s.handle, err = netlink.NewHandle(); err != nil {
-			s.log.Error("%v", err)
-			return err
-		}
-	} else if hh != nil {
-		// setup handle
-		s.handle = hh
+	if err = s.setHandle(hh); err != nil {
+		return err
 	}
-	LnxRtPluginEntryPoint = s
 	return nil
 }
 
+// Operators -- returns operator constructors, bound to this plugin instance
 func (s *LnxRtPlugin) Operators() NpOperators {
 	return NpOperators{
-		"port":   NewPort,
-		"bridge": NewBridge,
-		"bond":   NewBond,
+		"port":   func() NpOperator { return &LockedOperator{NpOperator: NewPort(s), Locker: &s.mutex} },
+		"bridge": func() NpOperator { return &LockedOperator{NpOperator: NewBridge(s), Locker: &s.mutex} },
+		"bond":   func() NpOperator { return &LockedOperator{NpOperator: NewBond(s), Locker: &s.mutex} },
 		// "endpoint":   NewIPv4,
 	}
 }
@@ -607,22 +777,63 @@ func (s *LnxRtPlugin) Version() string {
 }
 
 // Setup netlink handler if need.
-// if nil given -- netlink handler will be created automatically
+// if nil given -- netlink handler will be created automatically,
+// if kernel is not defined yet
 func (s *LnxRtPlugin) setHandle(hh *netlink.Handle) (err error) {
-	if s.handle == nil && hh == nil {
-		// generate new handle if need
-		if s.handle, err = netlink.NewHandle(); err != nil {
+	if s.kernel == nil || hh != nil {
+		if s.kernel, err = NewNetlinkKernel(hh); err != nil {
+			s.kernel = nil
 			s.log.Error("%v", err)
 		}
-	} else if hh != nil {
-		// setup handle
-		s.handle = hh
 	}
 	return
 }
 
+// LimitEthtool -- observe NIC settings only for given interfaces
+func (s *LnxRtPlugin) LimitEthtool(names []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ethtool = make(map[string]bool)
+	for _, name := range names {
+		s.ethtool[name] = true
+	}
+}
+
 func (s *LnxRtPlugin) Observe() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.observe()
+}
+
+// LoadTopology -- use network topology, observed earlier, instead of
+// observing the live one. It is used as initial state for dry-run changes
+func (s *LnxRtPlugin) LoadTopology(topology *npstate.TopologyState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topology = topology
+	s.dryKernel = nil
+}
+
+// dryRunKernel -- in-memory kernel, made from the observed network topology.
+// Dry-run changes of all operators are made into it until next observing
+func (s *LnxRtPlugin) dryRunKernel() *MemKernel {
+	if s.dryKernel != nil {
+		return s.dryKernel
+	}
+	if s.topology == nil {
+		s.observe()
+	}
+	var err error
+	if s.dryKernel, err = NewSimKernel(s.topology); err != nil {
+		s.log.Error("%s: can't make copy of observed topology for dry-run: %v", MsgPrefix, err)
+		s.dryKernel = NewMemKernel()
+	}
+	return s.dryKernel
+}
+
+func (s *LnxRtPlugin) observe() error {
 	s.topology = npstate.NewTopologyState()
+	s.dryKernel = nil
 
 	var (
 		linkList []netlink.Link
@@ -635,7 +846,7 @@ func (s *LnxRtPlugin) Observe() error {
 	s.setHandle(nil)
 
 	s.log.Debug("%s: Fetching LinkList from netlink.", MsgPrefix)
-	if linkList, err = s.handle.LinkList(); err != nil {
+	if linkList, err = s.kernel.LinkList(); err != nil {
 		s.log.Error("%v", err)
 		return err
 	}
@@ -661,10 +872,21 @@ func (s *LnxRtPlugin) Observe() error {
 		}
 		s.topology.NP[linkName].L2 = npstate.L2State{
 			// bridge, vlan, bond information should be catched here
-			Mtu: mtu,
+			Mtu:    mtu,
+			HwAddr: attrs.HardwareAddr.String(),
+			TxQLen: attrs.TxQLen,
+			Alias:  attrs.Alias,
+		}
+		if link.Type() == "device" && (s.ethtool == nil || s.ethtool[linkName]) {
+			// NIC settings makes sense only for physical interfaces
+			if ethtool, err := s.kernel.EthtoolGet(linkName); err == nil {
+				s.topology.NP[linkName].L2.Ethtool = *ethtool
+			} else {
+				s.log.Debug("%s: Can't fetch ethtool info for '%s': %v", MsgPrefix, linkName, err)
+			}
 		}
 
-		if ipaddrs, err := s.handle.AddrList(link, unix.AF_INET); err == nil { // unix.AF_INET === netlink.FAMILY_V4 , but operable under OSX
+		if ipaddrs, err := s.kernel.AddrList(link, unix.AF_INET); err == nil { // unix.AF_INET === netlink.FAMILY_V4 , but operable under OSX
 			// s.topology.NP[linkName].FillByNetlinkAddrList(&ipaddrInfo)
 			tmpString := ""
 			for _, addr := range ipaddrs {
@@ -678,16 +900,109 @@ func (s *LnxRtPlugin) Observe() error {
 			s.log.Error("Error while fetch L3 info for '%s' %v", linkName, err)
 		}
 	}
+	observeRelations(s.kernel, s.topology, linkList)
+	// interfaces, renamed in the dry-run mode, are seen by new names, like
+	// after the real renaming
+	for name, newName := range s.renames {
+		s.topology.Rename(name, newName)
+	}
 	s.log.Debug("%s: gathering done.", MsgPrefix)
 	return nil
 }
 
+// observeRelations -- fill bridge, bond slaves, vlan id and parent and STP
+// state of observed network primitives from relationships between links
+func observeRelations(kernel Kernel, topology *npstate.TopologyState, linkList []netlink.Link) {
+	byIndex := make(map[int]netlink.Link)
+	for _, link := range linkList {
+		byIndex[link.Attrs().Index] = link
+	}
+	for _, link := range linkList {
+		attrs := link.Attrs()
+		np := topology.NP[attrs.Name]
+		if master, ok := byIndex[attrs.MasterIndex]; ok && attrs.MasterIndex != 0 {
+			switch master.Type() {
+			case "bridge":
+				np.L2.Bridge = master.Attrs().Name
+			case "bond":
+				bondNp := topology.NP[master.Attrs().Name]
+				bondNp.L2.Slaves = append(bondNp.L2.Slaves, attrs.Name)
+			}
+		}
+		if vlan, ok := link.(*netlink.Vlan); ok {
+			np.L2.Vlan_id = vlan.VlanId
+			if parent, ok := byIndex[attrs.ParentIndex]; ok {
+				np.L2.Parent = parent.Attrs().Name
+			}
+		}
+		if link.Type() == "bridge" {
+			stp := kernel.SysfsRead(attrs.Name, "bridge/stp_state")
+			np.L2.Stp = (stp != "" && stp != "0")
+		}
+		if link.Type() == "bond" {
+			np.L2.BondProperties = sysfsBondProperties(kernel, attrs.Name)
+		}
+	}
+}
+
+// ResolveInterface -- find physical interface by match rules and rename it
+// to 'setName' if need. Returns the runtime name of interface
+func (s *LnxRtPlugin) ResolveInterface(match map[string]string, setName string, dryrun bool) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.setHandle(nil)
+	name, err := MatchInterface(s.kernel, match)
+	if err != nil {
+		return "", err
+	}
+	if setName == "" || setName == name {
+		return name, nil
+	}
+	if dryrun {
+		s.log.Info("%s dryrun: interface '%s' renamed to '%s'.", MsgPrefix, name, setName)
+		if s.renames == nil {
+			s.renames = make(map[string]string)
+		}
+		s.renames[name] = setName
+		if s.topology != nil {
+			s.topology.Rename(name, setName)
+			s.dryKernel = nil
+		}
+		return setName, nil
+	}
+
+	s.log.Info("%s: Renaming interface '%s' to '%s'", MsgPrefix, name, setName)
+	link, err := s.kernel.LinkByName(name)
+	if err != nil {
+		return "", err
+	}
+	online := link.Attrs().Flags&net.FlagUp != 0
+	if online {
+		// interface can't be renamed while it is up
+		if err = s.kernel.LinkSetDown(link); err != nil {
+			return "", err
+		}
+	}
+	if err = s.kernel.LinkSetName(link, setName); err != nil {
+		s.log.Error("%s: error while renaming '%s': %v", MsgPrefix, name, err)
+		return "", err
+	}
+	if online {
+		if err = s.kernel.LinkSetUp(link); err != nil {
+			s.log.Error("%s: error while '%s' set to UP state: %v", MsgPrefix, setName, err)
+		}
+	}
+	return setName, nil
+}
+
 func (s *LnxRtPlugin) Topology() *npstate.TopologyState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.topology
 }
 
 func (s *LnxRtPlugin) GetNp(name string) *npstate.NPState {
-	rv, ok := s.topology.NP[name]
+	rv, ok := s.Topology().NP[name]
 	if !ok {
 		s.log.Error("Network primitive '%s' not found in the stored base", name)
 		return nil
@@ -699,11 +1014,28 @@ func (s *LnxRtPlugin) GetLogger() *logger.Logger {
 	return s.log
 }
 
+// GetHandle -- returns netlink handle, or nil if plugin works not with
+// the real kernel
 func (s *LnxRtPlugin) GetHandle() *netlink.Handle {
-	return s.handle
+	if k, ok := s.kernel.(*NetlinkKernel); ok {
+		return k.Handle
+	}
+	return nil
 }
 
 func NewLnxRtPlugin() RtPlugin {
 	rv := new(LnxRtPlugin)
 	return rv
 }
+
+// NewLnxRtPluginWithKernel -- create runtime plugin, which works with
+// given Kernel instead of the real one
+func NewLnxRtPluginWithKernel(kernel Kernel) *LnxRtPlugin {
+	rv := new(LnxRtPlugin)
+	rv.kernel = kernel
+	return rv
+}
+
+func init() {
+	RegisterRtPlugin("lnx", NewLnxRtPlugin)
+}
@@ -1,14 +1,22 @@
 package lnx
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/vishvananda/netlink"
 	logger "github.com/xenolog/go-tiny-logger"
 	. "github.com/xenolog/l23/npstate"
 	. "github.com/xenolog/l23/plugin"
 	. "github.com/xenolog/l23/utils"
+	"golang.org/x/sys/unix"
 )
 
 func TestLNX__OperatorList(t *testing.T) {
@@ -19,7 +27,9 @@ func TestLNX__OperatorList(t *testing.T) {
 		keys = append(keys, key.String())
 	}
 	sort.Strings(keys)
-	wantedKeys := []string{"bridge", "port"}
+	// "bond" operator is registered by LnxRtPlugin.Operators() since the
+	// very first bond support, the test just was not updated then
+	wantedKeys := []string{"bond", "bridge", "port"}
 
 	if !reflect.DeepEqual(keys, wantedKeys) {
 		t.Logf("Operator list from LnxRtPlugin broken, given %v, instead %v", keys, wantedKeys)
@@ -172,7 +182,7 @@ func TestLNX__1__MainRun(t *testing.T) {
 		oper := action.(func() NpOperator)()
 		oper.Init(wantedNps.NP[npName])
 
-		t.Logf(npName)
+		t.Logf("%s", npName)
 		if IndexString(diff.Waste, npName) >= 0 {
 			// this NP should be removed
 			oper.Remove(true)
@@ -204,3 +214,805 @@ func TestLNX__1__MainRun(t *testing.T) {
 	// 	t.Fail()
 	// }
 }
+
+// -----------------------------------------------------------------------------
+
+func TestLNX__EthtoolParse(t *testing.T) {
+	offload := parseEthtoolOffload(`Features for eth0:
+rx-checksumming: on
+tx-checksumming: on
+	tx-checksum-ipv4: off [fixed]
+scatter-gather: on
+tcp-segmentation-offload: off
+generic-segmentation-offload: on
+generic-receive-offload: on
+large-receive-offload: off [fixed]
+`)
+	wantedOffload := map[string]bool{"rx": true, "tx": true, "sg": true, "tso": false, "gso": true, "gro": true, "lro": false}
+	if !reflect.DeepEqual(offload, wantedOffload) {
+		t.Logf("Wrong offload: %v, instead %v", offload, wantedOffload)
+		t.Fail()
+	}
+
+	ring := parseEthtoolRing(`Ring parameters for eth0:
+Pre-set maximums:
+RX:		4096
+RX Mini:	0
+RX Jumbo:	0
+TX:		4096
+Current hardware settings:
+RX:		256
+RX Mini:	0
+RX Jumbo:	0
+TX:		512
+`)
+	wantedRing := map[string]int{"rx": 256, "rx-mini": 0, "rx-jumbo": 0, "tx": 512}
+	if !reflect.DeepEqual(ring, wantedRing) {
+		t.Logf("Wrong ring: %v, instead %v", ring, wantedRing)
+		t.Fail()
+	}
+
+	coalesce := parseEthtoolCoalesce(`Coalesce parameters for eth0:
+Adaptive RX: on  TX: off
+stats-block-usecs: 0
+rx-usecs: 50
+rx-frames: n/a
+tx-usecs: 0
+`)
+	wantedCoalesce := map[string]string{"adaptive-rx": "on", "adaptive-tx": "off", "stats-block-usecs": "0", "rx-usecs": "50", "tx-usecs": "0"}
+	if !reflect.DeepEqual(coalesce, wantedCoalesce) {
+		t.Logf("Wrong coalesce: %v, instead %v", coalesce, wantedCoalesce)
+		t.Fail()
+	}
+}
+
+func TestLNX__EthtoolArgs(t *testing.T) {
+	wanted := &EthtoolState{
+		Offload:  map[string]bool{"gro": false, "tso": false, "rx": true},
+		Ring:     map[string]int{"rx": 4096, "tx": 512},
+		Coalesce: map[string]string{"rx-usecs": "50"},
+	}
+	actual := &EthtoolState{
+		Offload:  map[string]bool{"gro": true, "tso": true, "rx": true, "tx": true},
+		Ring:     map[string]int{"rx": 256, "tx": 512},
+		Coalesce: map[string]string{"rx-usecs": "50"},
+	}
+	args := ethtoolArgs("eth0", wanted, actual)
+	wantedArgs := [][]string{
+		{"-K", "eth0", "gro", "off", "tso", "off"},
+		{"-G", "eth0", "rx", "4096"},
+	}
+	if !reflect.DeepEqual(args, wantedArgs) {
+		t.Logf("Wrong ethtool args: %v, instead %v", args, wantedArgs)
+		t.Fail()
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func fakeSysfs(t *testing.T) string {
+	root, err := ioutil.TempDir("", "l23-sysfs")
+	if err != nil {
+		t.FailNow()
+	}
+	// physical interfaces: name, MAC, driver, PCI address
+	for _, nic := range [][]string{
+		{"eth0", "52:54:00:00:00:01", "virtio_net", "0000:00:03.0"},
+		{"eth1", "52:54:00:00:00:02", "ixgbe", "0000:03:00.0"},
+		{"eth2", "52:54:00:00:00:02", "ixgbe", "0000:03:00.1"},
+	} {
+		dev := filepath.Join(root, "devices", nic[3])
+		os.MkdirAll(dev, 0755)
+		os.MkdirAll(filepath.Join(root, "drivers", nic[2]), 0755)
+		os.Symlink(filepath.Join(root, "drivers", nic[2]), filepath.Join(dev, "driver"))
+		os.MkdirAll(filepath.Join(root, "net", nic[0]), 0755)
+		os.Symlink(dev, filepath.Join(root, "net", nic[0], "device"))
+		ioutil.WriteFile(filepath.Join(root, "net", nic[0], "address"), []byte(nic[1]+"\n"), 0644)
+	}
+	// eth2 is a bond slave and has original MAC address into perm_hwaddr
+	os.MkdirAll(filepath.Join(root, "net", "eth2", "bonding_slave"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "net", "eth2", "bonding_slave", "perm_hwaddr"), []byte("52:54:00:00:00:03\n"), 0644)
+	// virtual interface with the same MAC address
+	os.MkdirAll(filepath.Join(root, "net", "bond0"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "net", "bond0", "address"), []byte("52:54:00:00:00:02\n"), 0644)
+	return root
+}
+
+func TestLNX__SysfsLinks(t *testing.T) {
+	root := fakeSysfs(t)
+	defer os.RemoveAll(root)
+	savedSysfsNetPath := SysfsNetPath
+	SysfsNetPath = filepath.Join(root, "net")
+	defer func() { SysfsNetPath = savedSysfsNetPath }()
+
+	kernel := &NetlinkKernel{}
+	for _, tc := range [][]string{
+		{kernel.SysfsLink("eth0", "device/driver"), "virtio_net"},
+		{kernel.SysfsLink("eth1", "device"), "0000:03:00.0"},
+		{kernel.SysfsLink("bond0", "device"), ""},
+		{sysfsPermAddr(kernel, "eth1"), "52:54:00:00:00:02"},
+		{sysfsPermAddr(kernel, "eth2"), "52:54:00:00:00:03"},
+	} {
+		if tc[0] != tc[1] {
+			t.Logf("Wrong sysfs value '%s', instead '%s'", tc[0], tc[1])
+			t.Fail()
+		}
+	}
+}
+
+// memSysfsKernel -- in-memory kernel with the same interfaces, as fakeSysfs()
+func memSysfsKernel() *MemKernel {
+	kernel := NewMemKernel()
+	for _, nic := range [][]string{
+		{"eth0", "52:54:00:00:00:01", "virtio_net", "0000:00:03.0"},
+		{"eth1", "52:54:00:00:00:02", "ixgbe", "0000:03:00.0"},
+		{"eth2", "52:54:00:00:00:02", "ixgbe", "0000:03:00.1"},
+	} {
+		eth := &netlink.Device{}
+		eth.Name = nic[0]
+		eth.HardwareAddr, _ = net.ParseMAC(nic[1])
+		kernel.AddLink(eth)
+		kernel.AddSysfs(nic[0], "device/driver", nic[2])
+		kernel.AddSysfs(nic[0], "device", nic[3])
+	}
+	kernel.AddSysfs("eth2", "bonding_slave/perm_hwaddr", "52:54:00:00:00:03")
+	bond := &netlink.Bond{}
+	bond.Name = "bond0"
+	bond.HardwareAddr, _ = net.ParseMAC("52:54:00:00:00:02")
+	kernel.AddLink(bond)
+	return kernel
+}
+
+func TestLNX__MatchInterface(t *testing.T) {
+	kernel := memSysfsKernel()
+	for _, tc := range []struct {
+		match  map[string]string
+		ifname string
+	}{
+		{map[string]string{"macaddress": "52:54:00:00:00:01"}, "eth0"},
+		{map[string]string{"macaddress": "52:54:00:00:00:02"}, "eth1"},
+		{map[string]string{"macaddress": "52:54:00:00:00:03"}, "eth2"},
+		{map[string]string{"driver": "virtio*"}, "eth0"},
+		{map[string]string{"driver": "ixgbe", "path": "pci-0000:03:00.1"}, "eth2"},
+		{map[string]string{"path": "0000:03:00.0"}, "eth1"},
+		{map[string]string{"driver": "ixgbe"}, ""},
+		{map[string]string{"driver": "e1000"}, ""},
+	} {
+		ifname, err := MatchInterface(kernel, tc.match)
+		if tc.ifname == "" && err == nil {
+			t.Logf("Interface '%s' found for %v, instead error", ifname, tc.match)
+			t.Fail()
+		} else if tc.ifname != "" && ifname != tc.ifname {
+			t.Logf("Interface '%s' found for %v, instead '%s': %v", ifname, tc.match, tc.ifname, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestLNX__ResolveInterfaceDryRun(t *testing.T) {
+	kernel := memSysfsKernel()
+	lnxRtPlugin := NewLnxRtPluginWithKernel(kernel)
+	if err := lnxRtPlugin.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+
+	// dry-run should resolve interface to the same name, as the real run
+	name, err := lnxRtPlugin.ResolveInterface(map[string]string{"path": "0000:03:00.0"}, "uplink0", true)
+	if err != nil || name != "uplink0" {
+		t.Logf("Interface resolved as '%s' in the dry-run mode, instead 'uplink0': %v", name, err)
+		t.FailNow()
+	}
+	if ops := kernel.Ops(); len(ops) > 0 {
+		t.Logf("Interface should not be renamed in the dry-run mode: %v", ops)
+		t.Fail()
+	}
+	// and observed topology sees it by new name
+	if err = lnxRtPlugin.Observe(); err != nil {
+		t.FailNow()
+	}
+	topology := lnxRtPlugin.Topology()
+	if _, ok := topology.NP["eth1"]; ok || topology.NP["uplink0"] == nil || topology.NP["uplink0"].Name != "uplink0" {
+		t.Logf("Interface should be observed by new name in the dry-run mode")
+		t.Fail()
+	}
+
+	// dry-run changes are made to the renamed interface
+	oper := lnxRtPlugin.Operators()["port"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "uplink0", Online: true, L2: L2State{Mtu: 9000}})
+	if err = oper.Modify(true); err != nil {
+		t.Logf("Renamed interface can't be modified in the dry-run mode: %v", err)
+		t.Fail()
+	}
+}
+
+func TestLNX__ObserveRelations(t *testing.T) {
+	kernel := NewMemKernel()
+	kernel.AddSysfs("br1", "bridge/stp_state", "1")
+	kernel.AddSysfs("br2", "bridge/stp_state", "0")
+
+	br1 := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br1", Index: 2}}
+	br2 := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br2", Index: 3}}
+	bond0 := &netlink.Bond{LinkAttrs: netlink.LinkAttrs{Name: "bond0", Index: 4, MasterIndex: 2}}
+	eth1 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 5, MasterIndex: 4}}
+	eth2 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth2", Index: 6, MasterIndex: 4}}
+	eth3 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth3", Index: 7}}
+	vlan := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "eth3.100", Index: 8, ParentIndex: 7, MasterIndex: 3}, VlanId: 100}
+	linkList := []netlink.Link{br1, br2, bond0, eth1, eth2, eth3, vlan}
+	topology := NewTopologyState()
+	for _, link := range linkList {
+		topology.NP[link.Attrs().Name] = &NPState{Name: link.Attrs().Name}
+	}
+
+	observeRelations(kernel, topology, linkList)
+
+	wanted := map[string]L2State{
+		"br1":      {Stp: true},
+		"br2":      {},
+		"bond0":    {Bridge: "br1", Slaves: []string{"eth1", "eth2"}},
+		"eth1":     {},
+		"eth2":     {},
+		"eth3":     {},
+		"eth3.100": {Bridge: "br2", Parent: "eth3", Vlan_id: 100},
+	}
+	for name, l2 := range wanted {
+		if !reflect.DeepEqual(topology.NP[name].L2, l2) {
+			t.Logf("Wrong observed L2 properties of '%s': %v, instead %v", name, topology.NP[name].L2, l2)
+			t.Fail()
+		}
+	}
+}
+
+func TestLNX__BridgePort(t *testing.T) {
+	br1 := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br1", Index: 2}}
+	bond0 := &netlink.Bond{LinkAttrs: netlink.LinkAttrs{Name: "bond0", Index: 3}}
+	for _, m := range []struct {
+		link   netlink.Link
+		master netlink.Link
+		wanted bool
+	}{
+		{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1"}}, nil, false},
+		{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", MasterIndex: 2}}, br1, true},
+		{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", MasterIndex: 3}}, bond0, false},
+		{&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", MasterIndex: 4}}, nil, true},
+	} {
+		if rv := bridgePort(m.link, m.master); rv != m.wanted {
+			t.Logf("'%s' with master %v should be released from bridge: %v, instead %v", m.link.Attrs().Name, m.master, m.wanted, rv)
+			t.Fail()
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func TestLNX__OperatorsBoundToPlugin(t *testing.T) {
+	log := logger.New()
+	plugins := []*LnxRtPlugin{}
+	for i := 0; i < 2; i++ {
+		handle, err := netlink.NewHandle()
+		if err != nil {
+			t.Skipf("Netlink is not available: %v", err)
+		}
+		rtPlugin := NewLnxRtPlugin().(*LnxRtPlugin)
+		rtPlugin.Init(log, handle)
+		plugins = append(plugins, rtPlugin)
+	}
+
+	var wg sync.WaitGroup
+	for _, rtPlugin := range plugins {
+		wg.Add(1)
+		go func(rtPlugin *LnxRtPlugin) {
+			defer wg.Done()
+			for action, constructor := range rtPlugin.Operators() {
+				oper := constructor.(func() NpOperator)()
+				locked, ok := oper.(*LockedOperator)
+				if !ok {
+					t.Errorf("Operator '%s' is not serialized by plugin", action)
+					continue
+				}
+				var base *OpBase
+				switch op := locked.NpOperator.(type) {
+				case *L2Port:
+					base = &op.OpBase
+				case *L2Bridge:
+					base = &op.OpBase
+				case *L2Bond:
+					base = &op.OpBase
+				}
+				if base == nil || base.plugin != rtPlugin || base.kernel != rtPlugin.kernel {
+					t.Errorf("Operator '%s' is not bound to plugin, which creates it", action)
+				}
+			}
+		}(rtPlugin)
+	}
+	wg.Wait()
+}
+
+// -----------------------------------------------------------------------------
+
+func newMemLnxRtPlugin(t *testing.T) (*LnxRtPlugin, *MemKernel) {
+	kernel := NewMemKernel()
+	for _, name := range []string{"eth1", "eth2", "eth3"} {
+		eth := &netlink.Device{}
+		eth.Name = name
+		kernel.AddLink(eth)
+	}
+	rv := NewLnxRtPluginWithKernel(kernel)
+	if err := rv.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+	return rv, kernel
+}
+
+func TestLNX__OperationSequence(t *testing.T) {
+	lnxRtPlugin, kernel := newMemLnxRtPlugin(t)
+	operators := lnxRtPlugin.Operators()
+
+	for _, m := range []struct {
+		action string
+		method string
+		np     *NPState
+		ops    []string
+	}{
+		{"port", "create", &NPState{Name: "eth1.100", Online: true, L2: L2State{Parent: "eth1", Vlan_id: 100, Mtu: 1400}, L3: L3State{IPv4: []string{"10.1.1.1/24"}}}, []string{
+			"link add link eth1 name eth1.100 type vlan id 100",
+			"link set eth1.100 mtu 1400",
+			"link set eth1.100 up",
+			"addr add 10.1.1.1/24 dev eth1.100",
+		}},
+		{"bridge", "create", &NPState{Name: "br1", Online: true, L2: L2State{Stp: true}, L3: L3State{IPv4: []string{"10.2.2.2/24"}}}, []string{
+			"link add br1 type bridge",
+			"link set br1 down",
+			"sysfs br1/bridge/stp_state 1",
+			"link set br1 up",
+			"addr add 10.2.2.2/24 dev br1",
+		}},
+		{"port", "modify", &NPState{Name: "eth3", Online: true, L2: L2State{Bridge: "br1", TxQLen: 2000}}, []string{
+			"link set eth3 txqueuelen 2000",
+			"link set eth3 master br1",
+			"link set eth3 up",
+		}},
+		{"bond", "create", &NPState{Name: "bond0", Online: true, L2: L2State{Slaves: []string{"eth2"}, Mtu: 9000}}, []string{
+			"link add bond0 type bond",
+			"link set bond0 mtu 9000",
+			"link set eth2 down",
+			"link set eth2 master bond0",
+			"link set bond0 up",
+		}},
+		{"port", "remove", &NPState{Name: "eth1.100"}, []string{
+			"link set eth1.100 down",
+			"link del eth1.100",
+		}},
+		{"bridge", "remove", &NPState{Name: "br1"}, []string{
+			"link set br1 down",
+			"link del br1",
+		}},
+		{"bond", "modify", &NPState{Name: "bond0", Online: true, L2: L2State{Mtu: 9000}}, []string{
+			"link set eth2 nomaster",
+			"link set eth2 up", // released slave is closed by kernel
+			"link set bond0 up",
+		}},
+	} {
+		kernel.ResetOps()
+		oper := operators[m.action].(func() NpOperator)()
+		oper.Init(m.np)
+		switch m.method {
+		case "create":
+			oper.Create(false)
+		case "modify":
+			oper.Modify(false)
+		case "remove":
+			oper.Remove(false)
+		}
+		if ops := kernel.Ops(); !reflect.DeepEqual(ops, m.ops) {
+			t.Logf("Wrong operation sequence for %s '%s':\n%v\ninstead:\n%v", m.method, m.np.Name, ops, m.ops)
+			t.Fail()
+		}
+	}
+
+	// kernel-like side effects
+	if eth3, _ := kernel.LinkByName("eth3"); eth3.Attrs().MasterIndex != 0 {
+		t.Logf("Port of removed bridge should be released")
+		t.Fail()
+	}
+	if eth2, _ := kernel.LinkByName("eth2"); eth2.Attrs().MTU != 9000 {
+		t.Logf("Bond slave should inherit MTU of bond, given %d", eth2.Attrs().MTU)
+		t.Fail()
+	}
+}
+
+func TestLNX__ObserveMemKernel(t *testing.T) {
+	lnxRtPlugin, _ := newMemLnxRtPlugin(t)
+	operators := lnxRtPlugin.Operators()
+	for _, m := range []struct {
+		action string
+		np     *NPState
+	}{
+		{"bridge", &NPState{Name: "br1", Online: true, L2: L2State{Stp: true}}},
+		{"port", &NPState{Name: "eth1.100", Online: true, L2: L2State{Parent: "eth1", Vlan_id: 100, Mtu: 1400, Bridge: "br1"}}},
+		{"bond", &NPState{Name: "bond0", Online: true, L2: L2State{Slaves: []string{"eth2", "eth3"}}, L3: L3State{IPv4: []string{"10.3.3.3/24"}}}},
+	} {
+		oper := operators[m.action].(func() NpOperator)()
+		oper.Init(m.np)
+		if err := oper.Create(false); err != nil {
+			t.Logf("Can't create '%s': %v", m.np.Name, err)
+			t.FailNow()
+		}
+	}
+
+	if err := lnxRtPlugin.Observe(); err != nil {
+		t.Logf("Observe failed: %v", err)
+		t.FailNow()
+	}
+	topology := lnxRtPlugin.Topology()
+	for _, m := range []struct {
+		name   string
+		online bool
+		l2     L2State
+		ipv4   []string
+	}{
+		{"br1", true, L2State{Stp: true, Mtu: 1400}, []string{}},
+		{"eth1.100", true, L2State{Parent: "eth1", Vlan_id: 100, Mtu: 1400, Bridge: "br1"}, []string{}},
+		{"bond0", true, L2State{Slaves: []string{"eth2", "eth3"}}, []string{"10.3.3.3/24"}},
+		{"eth2", true, L2State{}, []string{}}, // slave is opened by bond
+	} {
+		np := topology.NP[m.name]
+		if np == nil {
+			t.Logf("Network primitive '%s' is not observed", m.name)
+			t.Fail()
+			continue
+		}
+		l2 := np.L2
+		l2.HwAddr, l2.TxQLen = "", 0
+		if np.Online != m.online || !reflect.DeepEqual(l2, m.l2) || !reflect.DeepEqual(np.L3.IPv4, m.ipv4) {
+			t.Logf("Wrong observed state of '%s': %v %+v %v, instead %v %+v %v", m.name, np.Online, l2, np.L3.IPv4, m.online, m.l2, m.ipv4)
+			t.Fail()
+		}
+	}
+}
+
+func TestLNX__BridgeStp(t *testing.T) {
+	lnxRtPlugin, kernel := newMemLnxRtPlugin(t)
+	oper := lnxRtPlugin.Operators()["bridge"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "br1", Online: true, L2: L2State{Stp: true}})
+	if err := oper.Create(false); err != nil {
+		t.FailNow()
+	}
+
+	for _, m := range []struct {
+		stp bool
+		ops []string
+	}{
+		{false, []string{"link set br1 down", "sysfs br1/bridge/stp_state 0", "link set br1 up"}},
+		{false, []string{"link set br1 down", "link set br1 up"}}, // STP is already disabled
+		{true, []string{"link set br1 down", "sysfs br1/bridge/stp_state 1", "link set br1 up"}},
+	} {
+		kernel.ResetOps()
+		oper.Init(&NPState{Name: "br1", Online: true, L2: L2State{Stp: m.stp}})
+		if err := oper.Modify(false); err != nil {
+			t.Logf("Can't modify bridge: %v", err)
+			t.Fail()
+		}
+		if ops := kernel.Ops(); !reflect.DeepEqual(ops, m.ops) {
+			t.Logf("Wrong operations while STP set to %v:\n%v\ninstead:\n%v", m.stp, ops, m.ops)
+			t.Fail()
+		}
+	}
+}
+
+func TestLNX__BondSlaveNotRemovedFromBond(t *testing.T) {
+	lnxRtPlugin, kernel := newMemLnxRtPlugin(t)
+	operators := lnxRtPlugin.Operators()
+	oper := operators["bond"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "bond0", Online: true, L2: L2State{Slaves: []string{"eth2"}}})
+	if err := oper.Create(false); err != nil {
+		t.FailNow()
+	}
+
+	// port, which is not wanted into bridge, is removed from bridge only
+	oper = operators["port"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "eth2", Online: true})
+	if err := oper.Modify(false); err != nil {
+		t.Logf("Can't modify bond slave: %v", err)
+		t.Fail()
+	}
+	bond, _ := kernel.LinkByName("bond0")
+	if eth, _ := kernel.LinkByName("eth2"); eth.Attrs().MasterIndex != bond.Attrs().Index {
+		t.Logf("Slave is removed from bond while removing from bridge")
+		t.Fail()
+	}
+}
+
+// Observed topology should be equal to wanted one after changes, otherwise
+// watch mode re-converges the same network primitives endlessly
+func TestLNX__ObservedEqualsWanted(t *testing.T) {
+	lnxRtPlugin, _ := newMemLnxRtPlugin(t)
+	operators := lnxRtPlugin.Operators()
+	wanted := NewTopologyState()
+	for _, m := range []struct {
+		action string
+		np     *NPState
+	}{
+		{"bridge", &NPState{Name: "br1", Online: true, L2: L2State{Stp: true}}},
+		{"port", &NPState{Name: "eth1.100", Online: true, L2: L2State{Parent: "eth1", Vlan_id: 100, Bridge: "br1"}}},
+		{"bond", &NPState{Name: "bond0", Online: true, L2: L2State{Slaves: []string{"eth3", "eth2"}}}},
+	} {
+		oper := operators[m.action].(func() NpOperator)()
+		oper.Init(m.np)
+		if err := oper.Create(false); err != nil {
+			t.Logf("Can't create '%s': %v", m.np.Name, err)
+			t.FailNow()
+		}
+		np := *m.np
+		np.Action = m.action
+		wanted.NP[np.Name] = &np
+	}
+	if err := lnxRtPlugin.Observe(); err != nil {
+		t.FailNow()
+	}
+	observed := lnxRtPlugin.Topology()
+	for name := range observed.NP {
+		if wanted.NP[name] == nil {
+			// not managed
+			wanted.NP[name] = observed.NP[name]
+		}
+	}
+	if diff := observed.Compare(wanted); !diff.IsEqual() {
+		t.Logf("Observed topology differs from wanted one:\n%v", diff)
+		t.Fail()
+	}
+}
+
+// ethtoolCountingKernel -- in-memory kernel, which records interfaces, NIC
+// settings of which are requested
+type ethtoolCountingKernel struct {
+	*MemKernel
+	requested []string
+}
+
+func (s *ethtoolCountingKernel) EthtoolGet(ifname string) (*EthtoolState, error) {
+	s.requested = append(s.requested, ifname)
+	return s.MemKernel.EthtoolGet(ifname)
+}
+
+func TestLNX__ObserveEthtoolLimited(t *testing.T) {
+	kernel := &ethtoolCountingKernel{MemKernel: NewMemKernel()}
+	for _, name := range []string{"eth1", "eth2", "eth3"} {
+		eth := &netlink.Device{}
+		eth.Name = name
+		kernel.AddLink(eth)
+	}
+	lnxRtPlugin := NewLnxRtPluginWithKernel(kernel)
+	if err := lnxRtPlugin.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+
+	for _, m := range []struct {
+		limit  []string
+		wanted []string
+	}{
+		{nil, []string{"eth1", "eth2", "eth3", "lo"}}, // all by default
+		{[]string{"eth2", "br1"}, []string{"eth2"}},
+		{[]string{}, []string{}},
+	} {
+		if m.limit != nil {
+			lnxRtPlugin.LimitEthtool(m.limit)
+		}
+		kernel.requested = []string{}
+		if err := lnxRtPlugin.Observe(); err != nil {
+			t.FailNow()
+		}
+		sort.Strings(kernel.requested)
+		if !reflect.DeepEqual(kernel.requested, m.wanted) {
+			t.Logf("NIC settings are requested for %v, instead %v", kernel.requested, m.wanted)
+			t.Fail()
+		}
+	}
+}
+
+func TestLNX__ObserveBondProperties(t *testing.T) {
+	kernel := memSysfsKernel()
+	for file, value := range map[string]string{
+		"bonding/mode":             "802.3ad 4",
+		"bonding/miimon":           "100",
+		"bonding/updelay":          "0",
+		"bonding/lacp_rate":        "fast 1",
+		"bonding/xmit_hash_policy": "layer3+4 1",
+		"bonding/ad_select":        "stable 0",
+		"bonding/primary":          "",
+	} {
+		kernel.AddSysfs("bond0", file, value)
+	}
+	bond := &netlink.Bond{}
+	bond.Name = "bond1"
+	kernel.AddLink(bond)
+	kernel.AddSysfs("bond1", "bonding/mode", "active-backup 1")
+	kernel.AddSysfs("bond1", "bonding/lacp_rate", "slow 0")
+	kernel.AddSysfs("bond1", "bonding/primary", "eth2")
+
+	lnxRtPlugin := NewLnxRtPluginWithKernel(kernel)
+	if err := lnxRtPlugin.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+	if err := lnxRtPlugin.Observe(); err != nil {
+		t.FailNow()
+	}
+	topology := lnxRtPlugin.Topology()
+	for name, wanted := range map[string]map[string]string{
+		"bond0": {"mode": "802.3ad", "miimon": "100", "lacp_rate": "fast", "xmit_hash_policy": "layer3+4", "ad_select": "stable"},
+		// LACP rate makes no sense for active-backup bond
+		"bond1": {"mode": "active-backup", "primary": "eth2"},
+	} {
+		if properties := topology.NP[name].L2.BondProperties; !reflect.DeepEqual(properties, wanted) {
+			t.Logf("Wrong bonding parameters of '%s': %v, instead %v", name, properties, wanted)
+			t.Fail()
+		}
+	}
+}
+
+func TestLNX__ReleasedBondSlaveOnline(t *testing.T) {
+	lnxRtPlugin, kernel := newMemLnxRtPlugin(t)
+	oper := lnxRtPlugin.Operators()["bond"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "bond0", Online: true, L2: L2State{Slaves: []string{"eth2", "eth3"}}})
+	if err := oper.Create(false); err != nil {
+		t.FailNow()
+	}
+
+	oper.Init(&NPState{Name: "bond0", Online: true, L2: L2State{Slaves: []string{"eth3"}}})
+	if err := oper.Modify(false); err != nil {
+		t.Logf("Can't modify bond: %v", err)
+		t.Fail()
+	}
+	// kernel closes released slave, it should stay online like before
+	if eth2, _ := kernel.LinkByName("eth2"); eth2.Attrs().MasterIndex != 0 || eth2.Attrs().Flags&net.FlagUp == 0 {
+		t.Logf("Slave, released from bond, should be online")
+		t.Fail()
+	}
+}
+
+func TestLNX__SimState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-sim")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { SimStatePath = path }(SimStatePath)
+
+	SimStatePath = ""
+	if err = NewSimRtPlugin().Init(logger.New(), nil); err == nil {
+		t.Logf("Simulated host should not be used without state path")
+		t.Fail()
+	}
+
+	// directory of state is created, state is not readable by other users
+	SimStatePath = filepath.Join(dir, "l23", "sim-state.yaml")
+	sim := NewSimRtPlugin()
+	if err = sim.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+	oper := sim.Operators()["bridge"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "br1", Online: true})
+	if err = oper.Create(false); err != nil {
+		t.FailNow()
+	}
+	if info, err := os.Stat(SimStatePath); err != nil || info.Mode().Perm() != 0600 {
+		t.Logf("Wrong state of simulated host: %v", err)
+		t.Fail()
+	}
+}
+
+func TestLNX__DryRunSteps(t *testing.T) {
+	lnxRtPlugin, kernel := newMemLnxRtPlugin(t)
+	if err := lnxRtPlugin.Observe(); err != nil {
+		t.Logf("Observe failed: %v", err)
+		t.FailNow()
+	}
+	operators := lnxRtPlugin.Operators()
+	kernel.ResetOps()
+
+	for _, m := range []struct {
+		action string
+		np     *NPState
+	}{
+		{"bridge", &NPState{Name: "br1", Online: true}},
+		{"port", &NPState{Name: "eth1.100", Online: true, L2: L2State{Parent: "eth1", Vlan_id: 100, Bridge: "br1"}}},
+	} {
+		oper := operators[m.action].(func() NpOperator)()
+		oper.Init(m.np)
+		if err := oper.Create(true); err != nil {
+			t.Logf("Dry-run creating of '%s' failed: %v", m.np.Name, err)
+			t.Fail()
+		}
+	}
+
+	if ops := kernel.Ops(); len(ops) != 0 {
+		t.Logf("Kernel should not be changed in the dry-run mode, given: %v", ops)
+		t.Fail()
+	}
+	// vlan is planned over bridge, created by previous dry-run operator
+	steps := lnxRtPlugin.dryRunKernel().Steps()
+	ops := []string{}
+	for _, step := range steps {
+		ops = append(ops, step.Op)
+		if step.Reason == "" {
+			t.Logf("Operation '%s' has no reason", step.Op)
+			t.Fail()
+		}
+	}
+	wanted := []string{
+		"link add br1 type bridge",
+		"link set br1 down",
+		"link set br1 up",
+		"link add link eth1 name eth1.100 type vlan id 100",
+		"link set eth1.100 master br1",
+		"link set eth1.100 up",
+	}
+	if !reflect.DeepEqual(ops, wanted) {
+		t.Logf("Wrong dry-run operation sequence:\n%v\ninstead:\n%v", ops, wanted)
+		t.Fail()
+	}
+}
+
+func TestLNX__ErrorsReturned(t *testing.T) {
+	lnxRtPlugin, kernel := newMemLnxRtPlugin(t)
+	operators := lnxRtPlugin.Operators()
+
+	// MTU of vlan can't exceed MTU of parent, rest of changes should be
+	// made anyway
+	oper := operators["port"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "eth1.100", Online: true, L2: L2State{Parent: "eth1", Vlan_id: 100, Mtu: 9000}})
+	if err := oper.Create(false); err == nil || !strings.Contains(err.Error(), "set MTU") {
+		t.Logf("Error of setting MTU should be returned, given: %v", err)
+		t.Fail()
+	}
+	if vlan, err := kernel.LinkByName("eth1.100"); err != nil || vlan.Attrs().Flags&net.FlagUp == 0 {
+		t.Logf("Vlan should be created and online in spite of MTU error")
+		t.Fail()
+	}
+
+	// errors should not be kept between changes
+	oper.Init(&NPState{Name: "eth1.100", Online: true, L2: L2State{Parent: "eth1", Vlan_id: 100}})
+	if err := oper.Modify(false); err != nil {
+		t.Logf("Unexpected error: %v", err)
+		t.Fail()
+	}
+}
+
+// failingKernel -- in-memory kernel, which can't set links up and write to
+// sysfs
+type failingKernel struct {
+	*MemKernel
+}
+
+func (s *failingKernel) LinkSetUp(link netlink.Link) error {
+	return unix.EPERM
+}
+
+func (s *failingKernel) SysfsWrite(ifname, file, value string) error {
+	return unix.EPERM
+}
+
+func TestLNX__BridgeErrorsCombined(t *testing.T) {
+	kernel := &failingKernel{NewMemKernel()}
+	lnxRtPlugin := NewLnxRtPluginWithKernel(kernel)
+	if err := lnxRtPlugin.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+	br := &netlink.Bridge{}
+	br.Name = "br1"
+	kernel.AddLink(br)
+
+	// every failure should be reported, not only the last one
+	oper := lnxRtPlugin.Operators()["bridge"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "br1", Online: true, L2: L2State{Mtu: 10, Stp: true}, L3: L3State{IPv4: []string{"10.1.1.1/24"}}})
+	err := oper.Modify(false)
+	for _, msg := range []string{"set MTU", "set STP", "UP state"} {
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Logf("Error '%s' should be returned, given: %v", msg, err)
+			t.Fail()
+		}
+	}
+	if addrs, _ := kernel.AddrList(br, 0); len(addrs) != 1 {
+		t.Logf("IP address should be assigned in spite of errors, given: %v", addrs)
+		t.Fail()
+	}
+}
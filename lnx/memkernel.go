@@ -0,0 +1,663 @@
+package lnx
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	npstate "github.com/xenolog/l23/npstate"
+	"golang.org/x/sys/unix"
+)
+
+// MemKernel -- in-memory Kernel implementation. It keeps links, addresses
+// and link properties in memory, changes them the way the kernel would and
+// records all changing operations in the 'ip' command like notation
+type MemKernel struct {
+	mutex     sync.Mutex
+	links     map[int]netlink.Link
+	addrs     map[int][]netlink.Addr
+	sysfs     map[string]string // "ifname/file" to value
+	ethtool   map[string]*npstate.EthtoolState
+	mtuSet    map[int]bool // bridges with explicitly defined MTU
+	nextIndex int
+	ops       []string
+	reasons   []string
+	reason    string
+}
+
+// KernelStep -- recorded kernel operation with its reason
+type KernelStep struct {
+	Op     string
+	Reason string
+}
+
+func (s KernelStep) String() string {
+	if s.Reason == "" {
+		return s.Op
+	}
+	return fmt.Sprintf("%s  # %s", s.Op, s.Reason)
+}
+
+// NewMemKernel -- create empty in-memory kernel, only loopback exists
+func NewMemKernel() *MemKernel {
+	rv := &MemKernel{
+		links:     make(map[int]netlink.Link),
+		addrs:     make(map[int][]netlink.Addr),
+		sysfs:     make(map[string]string),
+		ethtool:   make(map[string]*npstate.EthtoolState),
+		mtuSet:    make(map[int]bool),
+		nextIndex: 1,
+	}
+	lo := &netlink.Device{}
+	lo.Name = "lo"
+	lo.MTU = 65536
+	lo.Flags = net.FlagUp | net.FlagLoopback
+	index := rv.addLink(lo).Attrs().Index
+	ip, _ := netlink.ParseAddr("127.0.0.1/8")
+	rv.addrs[index] = []netlink.Addr{*ip}
+	return rv
+}
+
+// Ops -- returns changing operations, made since creation or last reset
+func (s *MemKernel) Ops() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rv := make([]string, len(s.ops))
+	copy(rv, s.ops)
+	return rv
+}
+
+// Steps -- returns changing operations with their reasons
+func (s *MemKernel) Steps() []KernelStep {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rv := make([]KernelStep, len(s.ops))
+	for i := range s.ops {
+		rv[i] = KernelStep{Op: s.ops[i], Reason: s.reasons[i]}
+	}
+	return rv
+}
+
+// ResetOps -- forget recorded operations
+func (s *MemKernel) ResetOps() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ops = nil
+	s.reasons = nil
+}
+
+// Explain -- set reason of following operations
+func (s *MemKernel) Explain(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reason = reason
+}
+
+func (s *MemKernel) record(format string, args ...interface{}) {
+	s.ops = append(s.ops, fmt.Sprintf(format, args...))
+	s.reasons = append(s.reasons, s.reason)
+}
+
+// copyLink -- links are returned by copy, like they are fetched from kernel
+func copyLink(link netlink.Link) netlink.Link {
+	switch l := link.(type) {
+	case *netlink.Device:
+		rv := *l
+		return &rv
+	case *netlink.Dummy:
+		rv := *l
+		return &rv
+	case *netlink.Bridge:
+		rv := *l
+		return &rv
+	case *netlink.Vlan:
+		rv := *l
+		return &rv
+	case *netlink.Bond:
+		rv := *l
+		return &rv
+	case *netlink.Veth:
+		rv := *l
+		return &rv
+	}
+	rv := &netlink.GenericLink{LinkType: link.Type()}
+	rv.LinkAttrs = *link.Attrs()
+	return rv
+}
+
+// addLink -- add link without any checks and recording
+func (s *MemKernel) addLink(link netlink.Link) netlink.Link {
+	link = copyLink(link)
+	attrs := link.Attrs()
+	if attrs.Index == 0 {
+		attrs.Index = s.nextIndex
+	}
+	if attrs.Index >= s.nextIndex {
+		s.nextIndex = attrs.Index + 1
+	}
+	if attrs.MTU == 0 {
+		attrs.MTU = 1500
+	}
+	if attrs.TxQLen == 0 && attrs.Name != "lo" {
+		attrs.TxQLen = 1000
+	}
+	if len(attrs.HardwareAddr) == 0 && attrs.Name != "lo" {
+		attrs.HardwareAddr = net.HardwareAddr{0x02, 0, 0, 0, byte(attrs.Index >> 8), byte(attrs.Index)}
+	}
+	s.links[attrs.Index] = link
+	return link
+}
+
+// AddLink -- add link as it exists into the kernel before any changes (i.e.
+// physical interface). Operation is not recorded
+func (s *MemKernel) AddLink(link netlink.Link) netlink.Link {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return copyLink(s.addLink(link))
+}
+
+// AddSysfs -- define sysfs property of link, as it exists into the kernel
+// before any changes (driver or PCI address of physical interface, for
+// example). Symlinks are defined by base name of their targets. Operation is
+// not recorded
+func (s *MemKernel) AddSysfs(ifname, file, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sysfs[ifname+"/"+file] = value
+}
+
+func (s *MemKernel) byName(name string) netlink.Link {
+	for _, link := range s.links {
+		if link.Attrs().Name == name {
+			return link
+		}
+	}
+	return nil
+}
+
+// get -- find stored link, corresponded to given one
+func (s *MemKernel) get(link netlink.Link) (netlink.Link, error) {
+	if link == nil {
+		return nil, unix.ENODEV
+	}
+	attrs := link.Attrs()
+	if attrs.Index != 0 {
+		if rv, ok := s.links[attrs.Index]; ok {
+			return rv, nil
+		}
+	}
+	if rv := s.byName(attrs.Name); rv != nil {
+		return rv, nil
+	}
+	return nil, unix.ENODEV
+}
+
+func (s *MemKernel) sortedIndexes() []int {
+	rv := []int{}
+	for index := range s.links {
+		rv = append(rv, index)
+	}
+	sort.Ints(rv)
+	return rv
+}
+
+func (s *MemKernel) LinkList() ([]netlink.Link, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rv := []netlink.Link{}
+	for _, index := range s.sortedIndexes() {
+		rv = append(rv, copyLink(s.links[index]))
+	}
+	return rv, nil
+}
+
+func (s *MemKernel) LinkByName(name string) (netlink.Link, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if link := s.byName(name); link != nil {
+		return copyLink(link), nil
+	}
+	return nil, fmt.Errorf("Link %s not found", name)
+}
+
+func (s *MemKernel) LinkByIndex(index int) (netlink.Link, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if link, ok := s.links[index]; ok {
+		return copyLink(link), nil
+	}
+	return nil, fmt.Errorf("Link not found")
+}
+
+func (s *MemKernel) LinkAdd(link netlink.Link) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	attrs := link.Attrs()
+	if attrs.Name == "" {
+		return unix.EINVAL
+	}
+	if s.byName(attrs.Name) != nil {
+		return unix.EEXIST
+	}
+	op := fmt.Sprintf("link add %s type %s", attrs.Name, link.Type())
+	tmp := copyLink(link)
+	tmp.Attrs().Index = 0
+	tmp.Attrs().Flags = 0
+	tmp.Attrs().MasterIndex = 0
+	if vlan, ok := tmp.(*netlink.Vlan); ok {
+		parent, ok := s.links[vlan.ParentIndex]
+		if !ok {
+			return unix.ENODEV
+		}
+		// vlan inherits MTU of parent
+		vlan.MTU = parent.Attrs().MTU
+		op = fmt.Sprintf("link add link %s name %s type vlan id %d", parent.Attrs().Name, attrs.Name, vlan.VlanId)
+	}
+	s.addLink(tmp)
+	s.record("%s", op)
+	return nil
+}
+
+func (s *MemKernel) LinkDel(link netlink.Link) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	if stored.Type() == "device" {
+		return unix.EOPNOTSUPP
+	}
+	s.record("link del %s", stored.Attrs().Name)
+	s.delLink(stored)
+	return nil
+}
+
+// delLink -- remove link with addresses, vlans over it and release slaves
+func (s *MemKernel) delLink(link netlink.Link) {
+	attrs := link.Attrs()
+	delete(s.links, attrs.Index)
+	delete(s.addrs, attrs.Index)
+	delete(s.mtuSet, attrs.Index)
+	for key := range s.sysfs {
+		if strings.HasPrefix(key, attrs.Name+"/") {
+			delete(s.sysfs, key)
+		}
+	}
+	if master, ok := s.links[attrs.MasterIndex]; ok && attrs.MasterIndex != 0 {
+		s.releaseSlave(master, attrs.Name)
+	}
+	for _, index := range s.sortedIndexes() {
+		other, ok := s.links[index]
+		if !ok {
+			continue
+		}
+		if vlan, ok := other.(*netlink.Vlan); ok && vlan.ParentIndex == attrs.Index {
+			s.delLink(vlan)
+			continue
+		}
+		if other.Attrs().MasterIndex == attrs.Index {
+			other.Attrs().MasterIndex = 0
+			if link.Type() == "bond" {
+				// released bond slave is closed
+				other.Attrs().Flags &^= net.FlagUp
+			}
+		}
+	}
+}
+
+func (s *MemKernel) LinkSetUp(link netlink.Link) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	s.record("link set %s up", stored.Attrs().Name)
+	stored.Attrs().Flags |= net.FlagUp
+	return nil
+}
+
+func (s *MemKernel) LinkSetDown(link netlink.Link) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	s.record("link set %s down", stored.Attrs().Name)
+	stored.Attrs().Flags &^= net.FlagUp
+	return nil
+}
+
+func (s *MemKernel) LinkSetMTU(link netlink.Link, mtu int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	if mtu < 68 {
+		return unix.EINVAL
+	}
+	if vlan, ok := stored.(*netlink.Vlan); ok {
+		if parent, ok := s.links[vlan.ParentIndex]; ok && mtu > parent.Attrs().MTU {
+			// vlan MTU can't exceed MTU of parent
+			return unix.ERANGE
+		}
+	}
+	s.record("link set %s mtu %d", stored.Attrs().Name, mtu)
+	stored.Attrs().MTU = mtu
+	switch stored.Type() {
+	case "bond":
+		// bond propagates MTU to slaves
+		for _, other := range s.links {
+			if other.Attrs().MasterIndex == stored.Attrs().Index {
+				other.Attrs().MTU = mtu
+			}
+		}
+	case "bridge":
+		s.mtuSet[stored.Attrs().Index] = true
+	}
+	return nil
+}
+
+func (s *MemKernel) LinkSetName(link netlink.Link, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	if stored.Attrs().Flags&net.FlagUp != 0 {
+		// kernel does not allow to rename active interface
+		return unix.EBUSY
+	}
+	if s.byName(name) != nil {
+		return unix.EEXIST
+	}
+	oldName := stored.Attrs().Name
+	s.record("link set %s name %s", oldName, name)
+	for key, value := range s.sysfs {
+		if strings.HasPrefix(key, oldName+"/") {
+			delete(s.sysfs, key)
+			s.sysfs[name+strings.TrimPrefix(key, oldName)] = value
+		}
+	}
+	if ethtool, ok := s.ethtool[oldName]; ok {
+		delete(s.ethtool, oldName)
+		s.ethtool[name] = ethtool
+	}
+	stored.Attrs().Name = name
+	return nil
+}
+
+func (s *MemKernel) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	s.record("link set %s address %s", stored.Attrs().Name, hwaddr)
+	stored.Attrs().HardwareAddr = hwaddr
+	return nil
+}
+
+func (s *MemKernel) LinkSetTxQLen(link netlink.Link, qlen int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	s.record("link set %s txqueuelen %d", stored.Attrs().Name, qlen)
+	stored.Attrs().TxQLen = qlen
+	return nil
+}
+
+func (s *MemKernel) LinkSetAlias(link netlink.Link, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	s.record("link set %s alias %s", stored.Attrs().Name, name)
+	stored.Attrs().Alias = name
+	return nil
+}
+
+// bondSlaves -- returns names of bond slaves, in the enslaving order
+func (s *MemKernel) bondSlaves(bond netlink.Link) []string {
+	return strings.Fields(s.sysfs[bond.Attrs().Name+"/bonding/slaves"])
+}
+
+func (s *MemKernel) releaseSlave(master netlink.Link, name string) {
+	switch master.Type() {
+	case "bond":
+		slaves := []string{}
+		for _, slave := range s.bondSlaves(master) {
+			if slave != name {
+				slaves = append(slaves, slave)
+			}
+		}
+		s.sysfs[master.Attrs().Name+"/bonding/slaves"] = strings.Join(slaves, " ")
+	case "bridge":
+		s.updateBridgeMtu(master)
+	}
+}
+
+// updateBridgeMtu -- bridge without explicitly defined MTU has minimal MTU
+// of its ports
+func (s *MemKernel) updateBridgeMtu(bridge netlink.Link) {
+	if s.mtuSet[bridge.Attrs().Index] {
+		return
+	}
+	mtu := 0
+	for _, other := range s.links {
+		if other.Attrs().MasterIndex == bridge.Attrs().Index && (mtu == 0 || other.Attrs().MTU < mtu) {
+			mtu = other.Attrs().MTU
+		}
+	}
+	if mtu > 0 {
+		bridge.Attrs().MTU = mtu
+	}
+}
+
+func (s *MemKernel) LinkSetMasterByIndex(link netlink.Link, masterIndex int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	master, ok := s.links[masterIndex]
+	if !ok {
+		return unix.ENODEV
+	}
+	attrs := stored.Attrs()
+	if master.Type() != "bridge" && master.Type() != "bond" {
+		return unix.EOPNOTSUPP
+	}
+	if attrs.MasterIndex == masterIndex {
+		return nil
+	}
+	if attrs.MasterIndex != 0 {
+		// link should be released before enslaving to another master
+		return unix.EBUSY
+	}
+	s.record("link set %s master %s", attrs.Name, master.Attrs().Name)
+	attrs.MasterIndex = masterIndex
+	switch master.Type() {
+	case "bond":
+		// slave takes MTU of bond and is opened by bond
+		attrs.MTU = master.Attrs().MTU
+		attrs.Flags |= net.FlagUp
+		slaves := append(s.bondSlaves(master), attrs.Name)
+		s.sysfs[master.Attrs().Name+"/bonding/slaves"] = strings.Join(slaves, " ")
+	case "bridge":
+		s.updateBridgeMtu(master)
+	}
+	return nil
+}
+
+func (s *MemKernel) LinkSetNoMaster(link netlink.Link) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	attrs := stored.Attrs()
+	if attrs.MasterIndex == 0 {
+		return nil
+	}
+	s.record("link set %s nomaster", attrs.Name)
+	master := s.links[attrs.MasterIndex]
+	attrs.MasterIndex = 0
+	if master != nil {
+		s.releaseSlave(master, attrs.Name)
+		if master.Type() == "bond" {
+			// released bond slave is closed
+			attrs.Flags &^= net.FlagUp
+		}
+	}
+	return nil
+}
+
+func (s *MemKernel) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	indexes := s.sortedIndexes()
+	if link != nil {
+		stored, err := s.get(link)
+		if err != nil {
+			return nil, err
+		}
+		indexes = []int{stored.Attrs().Index}
+	}
+	rv := []netlink.Addr{}
+	for _, index := range indexes {
+		for _, addr := range s.addrs[index] {
+			if family == netlink.FAMILY_ALL || (family == netlink.FAMILY_V4) == (addr.IP.To4() != nil) {
+				rv = append(rv, addr)
+			}
+		}
+	}
+	return rv, nil
+}
+
+func (s *MemKernel) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	index := stored.Attrs().Index
+	for _, a := range s.addrs[index] {
+		if a.IPNet.String() == addr.IPNet.String() {
+			return unix.EEXIST
+		}
+	}
+	s.record("addr add %s dev %s", addr.IPNet, stored.Attrs().Name)
+	s.addrs[index] = append(s.addrs[index], *addr)
+	return nil
+}
+
+func (s *MemKernel) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, err := s.get(link)
+	if err != nil {
+		return err
+	}
+	index := stored.Attrs().Index
+	for i, a := range s.addrs[index] {
+		if a.IPNet.String() == addr.IPNet.String() {
+			s.record("addr del %s dev %s", addr.IPNet, stored.Attrs().Name)
+			s.addrs[index] = append(s.addrs[index][:i], s.addrs[index][i+1:]...)
+			return nil
+		}
+	}
+	return unix.EADDRNOTAVAIL
+}
+
+func (s *MemKernel) SysfsRead(ifname, file string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if value, ok := s.sysfs[ifname+"/"+file]; ok || file != "address" {
+		return value
+	}
+	if link := s.byName(ifname); link != nil {
+		return link.Attrs().HardwareAddr.String()
+	}
+	return ""
+}
+
+func (s *MemKernel) SysfsWrite(ifname, file, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.byName(ifname) == nil {
+		return unix.ENOENT
+	}
+	s.record("sysfs %s/%s %s", ifname, file, value)
+	s.sysfs[ifname+"/"+file] = value
+	return nil
+}
+
+func (s *MemKernel) SysfsLink(ifname, file string) string {
+	return s.SysfsRead(ifname, file)
+}
+
+func (s *MemKernel) EthtoolGet(ifname string) (*npstate.EthtoolState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.byName(ifname) == nil {
+		return nil, unix.ENODEV
+	}
+	rv := &npstate.EthtoolState{}
+	if ethtool, ok := s.ethtool[ifname]; ok {
+		*rv = ethtool.Masked(ethtool)
+	}
+	return rv, nil
+}
+
+func (s *MemKernel) EthtoolSet(ifname string, wanted *npstate.EthtoolState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.byName(ifname) == nil {
+		return unix.ENODEV
+	}
+	actual, ok := s.ethtool[ifname]
+	if !ok {
+		actual = &npstate.EthtoolState{}
+		s.ethtool[ifname] = actual
+	}
+	for _, args := range ethtoolArgs(ifname, wanted, actual) {
+		s.record("ethtool %s", strings.Join(args, " "))
+	}
+	for key, value := range wanted.Offload {
+		if actual.Offload == nil {
+			actual.Offload = make(map[string]bool)
+		}
+		actual.Offload[key] = value
+	}
+	for key, value := range wanted.Ring {
+		if actual.Ring == nil {
+			actual.Ring = make(map[string]int)
+		}
+		actual.Ring[key] = value
+	}
+	for key, value := range wanted.Coalesce {
+		if actual.Coalesce == nil {
+			actual.Coalesce = make(map[string]string)
+		}
+		actual.Coalesce[key] = value
+	}
+	return nil
+}
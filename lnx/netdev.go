@@ -0,0 +1,43 @@
+package lnx
+
+import (
+	"fmt"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+)
+
+// NetDev -- network device, created and enslaved by another provider (OVS
+// bridge or internal port, for example). Its administrative state and IPv4
+// addresses are managed the same way, as LNX operators do it
+type NetDev struct {
+	OpBase
+}
+
+// Setup -- setup administrative state and IPv4 addresses of network device
+func (s *NetDev) Setup() error {
+	link := s.Link()
+	if link == nil {
+		return fmt.Errorf("network device '%s' not found", s.Name())
+	}
+	err := s.setupLinkState(link)
+	s.allignIPv4list()
+	return err
+}
+
+// IPv4addrList -- returns address list in the original order, or empty
+// list if network device doesn't exist
+func (s *NetDev) IPv4addrList() []string {
+	if _, err := s.kernel.LinkByName(s.Name()); err != nil {
+		return []string{}
+	}
+	return s.OpBase.IPv4addrList()
+}
+
+func NewNetDev(log *logger.Logger, kernel Kernel, wantedState *npstate.NPState) *NetDev {
+	rv := new(NetDev)
+	rv.log = log
+	rv.kernel = kernel
+	rv.wantedState = wantedState
+	return rv
+}
@@ -0,0 +1,263 @@
+package lnx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+// SimStatePath -- file, where state of the simulated host is kept between
+// runs, in the topology snapshot format. If file does not exist, simulated
+// host has 'lo' and physical interfaces from SimDevices only. By default it
+// is placed into the cache directory of user, not shared with other users
+var SimStatePath = defaultSimStatePath()
+
+// SimDevices -- physical interfaces of the simulated host by default
+var SimDevices = []string{"eth0", "eth1", "eth2", "eth3"}
+
+// defaultSimStatePath -- returns path to state of the simulated host into
+// the cache directory of user, or empty string if there is no such directory
+func defaultSimStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "l23", "sim-state.yaml")
+}
+
+// SimRtPlugin -- runtime plugin, which simulates linux host. Network
+// primitives are managed by operators of LnxRtPlugin into the in-memory
+// kernel, state of kernel is stored after each change
+type SimRtPlugin struct {
+	*LnxRtPlugin
+	memKernel *MemKernel
+	path      string
+}
+
+// simOperator -- operator, which stores state of simulated host after
+// each change
+type simOperator struct {
+	NpOperator
+	plugin *SimRtPlugin
+}
+
+func (s *simOperator) Create(dryrun bool) error {
+	err := s.NpOperator.Create(dryrun)
+	s.plugin.store(dryrun)
+	return err
+}
+
+func (s *simOperator) Remove(dryrun bool) error {
+	err := s.NpOperator.Remove(dryrun)
+	s.plugin.store(dryrun)
+	return err
+}
+
+func (s *simOperator) Modify(dryrun bool) error {
+	err := s.NpOperator.Modify(dryrun)
+	s.plugin.store(dryrun)
+	return err
+}
+
+// Init -- load state of the simulated host
+func (s *SimRtPlugin) Init(log *logger.Logger, hh *netlink.Handle) (err error) {
+	var topology *npstate.TopologyState
+	if s.path = SimStatePath; s.path == "" {
+		return fmt.Errorf("path to state of simulated host is not defined")
+	}
+	if data, err := ioutil.ReadFile(s.path); err == nil {
+		snapshot, err := npstate.LoadTopologySnapshot(data)
+		if err != nil {
+			return fmt.Errorf("can't process state of simulated host '%s': %v", s.path, err)
+		}
+		topology = snapshot.TopologyState()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if s.memKernel, err = NewSimKernel(topology); err != nil {
+		return fmt.Errorf("can't load state of simulated host '%s': %v", s.path, err)
+	}
+	s.LnxRtPlugin = NewLnxRtPluginWithKernel(s.memKernel)
+	return s.LnxRtPlugin.Init(log, nil)
+}
+
+// Operators -- returns operators of LnxRtPlugin, which store state of the
+// simulated host after each change
+func (s *SimRtPlugin) Operators() NpOperators {
+	rv := NpOperators{}
+	for action, constructor := range s.LnxRtPlugin.Operators() {
+		constructor := constructor.(func() NpOperator)
+		rv[action] = func() NpOperator {
+			return &simOperator{NpOperator: constructor(), plugin: s}
+		}
+	}
+	return rv
+}
+
+func (s *SimRtPlugin) Version() string {
+	return "SIM RUNTIME PLUGIN: v0.0.1"
+}
+
+// ResolveInterface -- find physical interface of the simulated host by
+// match rules and rename it to 'setName' if need
+func (s *SimRtPlugin) ResolveInterface(match map[string]string, setName string, dryrun bool) (string, error) {
+	name, err := s.LnxRtPlugin.ResolveInterface(match, setName, dryrun)
+	if err == nil {
+		s.store(dryrun)
+	}
+	return name, err
+}
+
+// Kernel -- returns in-memory kernel of the simulated host
+func (s *SimRtPlugin) Kernel() *MemKernel {
+	return s.memKernel
+}
+
+// store -- save state of the simulated host
+func (s *SimRtPlugin) store(dryrun bool) {
+	if dryrun || s.path == "" {
+		return
+	}
+	topology := NewLnxRtPluginWithKernel(s.memKernel)
+	topology.Init(s.log, nil)
+	if err := topology.Observe(); err != nil {
+		s.log.Error("%s: can't observe simulated host: %v", MsgPrefix, err)
+		return
+	}
+	data, err := npstate.NewTopologySnapshot(topology.Topology()).Marshal("yaml")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(s.path, data, 0600)
+	}
+	if err != nil {
+		s.log.Error("%s: can't store state of simulated host to '%s': %v", MsgPrefix, s.path, err)
+	}
+}
+
+func NewSimRtPlugin() RtPlugin {
+	return new(SimRtPlugin)
+}
+
+// -----------------------------------------------------------------------------
+
+// NewSimKernel -- create in-memory kernel, which contains network topology,
+// observed earlier. If nil given, kernel will contain 'lo' and physical
+// interfaces from SimDevices only
+func NewSimKernel(topology *npstate.TopologyState) (*MemKernel, error) {
+	rv := NewMemKernel()
+	if topology == nil {
+		for _, name := range SimDevices {
+			eth := &netlink.Device{}
+			eth.Name = name
+			eth.Flags = net.FlagUp
+			rv.AddLink(eth)
+		}
+		return rv, nil
+	}
+
+	// observed topology may have no order
+	names := append([]string{}, topology.Order...)
+	tail := []string{}
+	for name := range topology.NP {
+		if IndexString(names, name) < 0 {
+			tail = append(tail, name)
+		}
+	}
+	sort.Strings(tail)
+	names = append(names, tail...)
+
+	// links, vlans should be added after their parents
+	for _, vlans := range []bool{false, true} {
+		for _, npName := range names {
+			np := topology.NP[npName]
+			if np.Name == "lo" || (np.LinkType == "vlan") != vlans {
+				continue
+			}
+			attrs := netlink.LinkAttrs{Name: np.Name, MTU: np.L2.Mtu, TxQLen: np.L2.TxQLen, Alias: np.L2.Alias}
+			if cached := np.Attrs(); cached != nil {
+				attrs.Index = cached.Index
+				attrs.MTU = cached.MTU
+			}
+			attrs.HardwareAddr, _ = net.ParseMAC(np.L2.HwAddr)
+			var link netlink.Link
+			switch np.LinkType {
+			case "device", "":
+				link = &netlink.Device{LinkAttrs: attrs}
+			case "dummy":
+				link = &netlink.Dummy{LinkAttrs: attrs}
+			case "bridge":
+				link = &netlink.Bridge{LinkAttrs: attrs}
+			case "bond":
+				link = &netlink.Bond{LinkAttrs: attrs}
+			case "veth":
+				link = &netlink.Veth{LinkAttrs: attrs}
+			case "vlan":
+				parent, err := rv.LinkByName(np.L2.Parent)
+				if err != nil {
+					return nil, fmt.Errorf("parent '%s' of vlan '%s' not found", np.L2.Parent, np.Name)
+				}
+				attrs.ParentIndex = parent.Attrs().Index
+				link = &netlink.Vlan{LinkAttrs: attrs, VlanId: np.L2.Vlan_id}
+			default:
+				link = &netlink.GenericLink{LinkAttrs: attrs, LinkType: np.LinkType}
+			}
+			rv.AddLink(link)
+		}
+	}
+
+	// relationships, properties and addresses
+	for _, npName := range names {
+		np := topology.NP[npName]
+		link, err := rv.LinkByName(np.Name)
+		if err != nil {
+			continue
+		}
+		for _, slave := range np.L2.Slaves {
+			if slaveLink, err := rv.LinkByName(slave); err == nil {
+				rv.LinkSetMasterByIndex(slaveLink, link.Attrs().Index)
+			}
+		}
+		if br, err := rv.LinkByName(np.L2.Bridge); err == nil && np.L2.Bridge != "" {
+			rv.LinkSetMasterByIndex(link, br.Attrs().Index)
+		}
+		if np.LinkType == "bridge" {
+			if np.L2.Mtu > 0 {
+				rv.LinkSetMTU(link, np.L2.Mtu)
+			}
+			if np.L2.Stp {
+				rv.SysfsWrite(np.Name, "bridge/stp_state", "1")
+			}
+		}
+		for key, value := range np.L2.BondProperties {
+			rv.AddSysfs(np.Name, "bonding/"+key, value)
+		}
+		if !np.L2.Ethtool.IsEmpty() {
+			rv.EthtoolSet(np.Name, &np.L2.Ethtool)
+		}
+		for _, addr := range np.L3.IPv4 {
+			if a, err := netlink.ParseAddr(addr); err == nil {
+				rv.AddrAdd(link, a)
+			}
+		}
+		if np.Online {
+			rv.LinkSetUp(link)
+		}
+	}
+	rv.ResetOps()
+	return rv, nil
+}
+
+func init() {
+	RegisterRtPlugin("sim", NewSimRtPlugin)
+}
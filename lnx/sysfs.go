@@ -0,0 +1,131 @@
+package lnx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/utils"
+)
+
+// SysfsNetPath -- place, where kernel exposes network interfaces
+var SysfsNetPath = "/sys/class/net"
+
+func sysfsRead(ifname string, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join(SysfsNetPath, ifname, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func sysfsWrite(ifname string, file string, value string) error {
+	return ioutil.WriteFile(filepath.Join(SysfsNetPath, ifname, file), []byte(value), 0644)
+}
+
+func sysfsLinkBase(ifname string, file string) string {
+	target, err := os.Readlink(filepath.Join(SysfsNetPath, ifname, file))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// sysfsPermAddr -- returns permanent MAC address of physical interface.
+// Bond slaves has MAC address of bond, instead own.
+func sysfsPermAddr(kernel Kernel, ifname string) string {
+	if addr := kernel.SysfsRead(ifname, "bonding_slave/perm_hwaddr"); addr != "" {
+		return addr
+	}
+	return kernel.SysfsRead(ifname, "address")
+}
+
+// bondModeProperties -- bonding parameters, which make sense only for some
+// bonding modes
+var bondModeProperties = map[string][]string{
+	"lacp_rate":        {"802.3ad"},
+	"ad_select":        {"802.3ad"},
+	"xmit_hash_policy": {"balance-xor", "802.3ad", "balance-tlb"},
+	"primary":          {"active-backup", "balance-tlb", "balance-alb"},
+}
+
+// sysfsBondProperties -- returns bonding parameters of bond, supported by
+// l23network. Sysfs shows some of them as name and number, like
+// '802.3ad 4', only name is taken. Parameters, which make no sense for
+// bonding mode, and zero timings (defaults of kernel) are skipped
+func sysfsBondProperties(kernel Kernel, ifname string) map[string]string {
+	mode := strings.Fields(kernel.SysfsRead(ifname, "bonding/mode"))
+	if len(mode) == 0 {
+		return nil
+	}
+	rv := make(map[string]string)
+	for _, key := range npstate.BondProperties {
+		value := strings.Fields(kernel.SysfsRead(ifname, "bonding/"+key))
+		if len(value) == 0 || value[0] == "0" {
+			continue
+		}
+		if modes, ok := bondModeProperties[key]; ok && IndexString(modes, mode[0]) < 0 {
+			continue
+		}
+		rv[key] = value[0]
+	}
+	return rv
+}
+
+// sysfsMatchIface -- check whether physical interface corresponds to all match rules
+func sysfsMatchIface(kernel Kernel, ifname string, match map[string]string) bool {
+	for key, value := range match {
+		switch key {
+		case "macaddress":
+			if !strings.EqualFold(sysfsPermAddr(kernel, ifname), value) {
+				return false
+			}
+		case "driver":
+			if ok, _ := filepath.Match(value, kernel.SysfsLink(ifname, "device/driver")); !ok {
+				return false
+			}
+		case "path":
+			// PCI address, like '0000:00:03.0' or 'pci-0000:00:03.0'
+			if kernel.SysfsLink(ifname, "device") != strings.TrimPrefix(value, "pci-") {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// MatchInterface -- find physical interface, corresponded to match rules
+// (macaddress, driver, path) by reading sysfs through given kernel. Exactly
+// one interface should be found.
+func MatchInterface(kernel Kernel, match map[string]string) (string, error) {
+	links, err := kernel.LinkList()
+	if err != nil {
+		return "", err
+	}
+	found := []string{}
+	for _, link := range links {
+		ifname := link.Attrs().Name
+		if link.Type() != "device" || link.Attrs().Flags&net.FlagLoopback != 0 {
+			// non-physical interface
+			continue
+		}
+		if sysfsMatchIface(kernel, ifname, match) {
+			found = append(found, ifname)
+		}
+	}
+	sort.Strings(found)
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no one interface matches %v", match)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("more than one interface matches %v: %v", match, found)
+}
@@ -0,0 +1,134 @@
+package lnx
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	. "github.com/xenolog/l23/plugin"
+	"golang.org/x/sys/unix"
+)
+
+// WatchBufferSize -- number of runtime updates, which may be queued
+const WatchBufferSize = 1024
+
+// Watch -- subscribe to netlink link, address and route updates. Events with
+// names of affected links will be sent to the returned channel until 'done'
+// will be closed
+func (s *LnxRtPlugin) Watch(done <-chan struct{}) (<-chan RtEvent, error) {
+	s.mutex.Lock()
+	s.setHandle(nil)
+	_, ok := s.kernel.(*NetlinkKernel)
+	s.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("watching is supported only for the real kernel")
+	}
+
+	// netlink socket overflows (ENOBUFS) and subscription is closed, if
+	// updates are not read in time, so bursts of updates are buffered
+	linkUpdates := make(chan netlink.LinkUpdate, WatchBufferSize)
+	addrUpdates := make(chan netlink.AddrUpdate, WatchBufferSize)
+	routeUpdates := make(chan netlink.RouteUpdate, WatchBufferSize)
+
+	if err := netlink.LinkSubscribe(linkUpdates, done); err != nil {
+		return nil, fmt.Errorf("can't subscribe to link updates: %v", err)
+	}
+	if err := netlink.AddrSubscribe(addrUpdates, done); err != nil {
+		return nil, fmt.Errorf("can't subscribe to address updates: %v", err)
+	}
+	if err := netlink.RouteSubscribe(routeUpdates, done); err != nil {
+		return nil, fmt.Errorf("can't subscribe to route updates: %v", err)
+	}
+
+	// address and route updates contain only link index, names of
+	// deleted links can't be fetched from kernel, so cache is required
+	var mutex sync.Mutex
+	names := make(map[int]string)
+	s.mutex.Lock()
+	linkList, err := s.kernel.LinkList()
+	s.mutex.Unlock()
+	if err == nil {
+		for _, link := range linkList {
+			names[link.Attrs().Index] = link.Attrs().Name
+		}
+	}
+	linkName := func(index int) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if name, ok := names[index]; ok {
+			return name
+		}
+		s.mutex.Lock()
+		link, err := s.kernel.LinkByIndex(index)
+		s.mutex.Unlock()
+		if err == nil {
+			names[index] = link.Attrs().Name
+			return link.Attrs().Name
+		}
+		return ""
+	}
+
+	rv := make(chan RtEvent, WatchBufferSize)
+	send := func(ev RtEvent) bool {
+		select {
+		case rv <- ev:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for update := range linkUpdates {
+			attrs := update.Link.Attrs()
+			reason := "link changed"
+			if update.Header.Type == unix.RTM_DELLINK {
+				reason = "link removed"
+			}
+			mutex.Lock()
+			names[attrs.Index] = attrs.Name
+			mutex.Unlock()
+			if !send(RtEvent{Name: attrs.Name, Reason: reason}) {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for update := range addrUpdates {
+			reason := fmt.Sprintf("address %s removed", update.LinkAddress.String())
+			if update.NewAddr {
+				reason = fmt.Sprintf("address %s added", update.LinkAddress.String())
+			}
+			if !send(RtEvent{Name: linkName(update.LinkIndex), Reason: reason}) {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for update := range routeUpdates {
+			dst := "default"
+			if update.Route.Dst != nil {
+				dst = update.Route.Dst.String()
+			}
+			reason := fmt.Sprintf("route to %s removed", dst)
+			if update.Type == unix.RTM_NEWROUTE {
+				reason = fmt.Sprintf("route to %s added", dst)
+			}
+			if !send(RtEvent{Name: linkName(update.Route.LinkIndex), Reason: reason}) {
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(rv)
+	}()
+
+	s.log.Info("%s: Watching for netlink updates", MsgPrefix)
+	return rv, nil
+}
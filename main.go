@@ -2,14 +2,26 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	cli "github.com/urfave/cli"
 	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/ext"
+	_ "github.com/xenolog/l23/ifcfg"    // config plugin
+	_ "github.com/xenolog/l23/ifupdown" // config plugin
 	"github.com/xenolog/l23/lnx"
+	_ "github.com/xenolog/l23/networkd" // config plugin
+	_ "github.com/xenolog/l23/nm"       // config plugin
+	npstate "github.com/xenolog/l23/npstate"
+	_ "github.com/xenolog/l23/ovs" // runtime plugin
 	"github.com/xenolog/l23/plugin"
-	"github.com/xenolog/l23/u1804"
+	_ "github.com/xenolog/l23/u1804" // config plugin
 	. "github.com/xenolog/l23/utils"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const (
@@ -21,6 +33,9 @@ var (
 	App *cli.App
 	Cfg *AppConfig
 	err error
+
+	// provider, used instead of the default provider of network scheme
+	providerOverride string
 )
 
 func init() {
@@ -57,8 +72,40 @@ func init() {
 		cli.StringFlag{
 			Name:   "store-config",
 			EnvVar: "L23_STORE_CONFIG",
-			Value:  "/etc/netplan/999-l23network.yaml",
-			Usage:  "Specify path for generate network config file. (use 'stdout' if need)",
+			Usage:  "Specify path for generate network config file or directory, default depends on config provider. (use 'stdout' if need)",
+		},
+		cli.IntFlag{
+			Name:   "config-backups",
+			EnvVar: "L23_CONFIG_BACKUPS",
+			Value:  3,
+			Usage:  "Keep given number of timestamped backups of each stored network config file into hidden '.l23-backups' directory near it (0 to disable)",
+		},
+		cli.StringFlag{
+			Name:   "config-provider",
+			EnvVar: "L23_CONFIG_PROVIDER",
+			Usage:  fmt.Sprintf("Use given config provider instead of 'config-provider' of network scheme (default '%s')", plugin.DefaultConfigProvider),
+		},
+		cli.StringFlag{
+			Name:   "plugin-dir",
+			EnvVar: "L23_PLUGIN_DIR",
+			Value:  "/usr/lib/l23/plugins",
+			Usage:  "Specify directory with out-of-process provider plugins (l23-provider-<name> binaries)",
+		},
+		cli.StringFlag{
+			Name:   "provider",
+			EnvVar: "L23_PROVIDER",
+			Usage:  "Use given provider instead of the default provider of network scheme ('sim' for simulated host)",
+		},
+		cli.StringFlag{
+			Name:   "sim-state",
+			EnvVar: "L23_SIM_STATE",
+			Value:  lnx.SimStatePath,
+			Usage:  "Specify path to state of simulated host, used by 'sim' provider",
+		},
+		cli.StringFlag{
+			Name:   "observe-from",
+			EnvVar: "L23_OBSERVE_FROM",
+			Usage:  "Use network topology snapshot from file (see 'utility snapshot') instead of observing the live one",
 		},
 		cli.BoolFlag{
 			Name:   "generate",
@@ -78,12 +125,121 @@ func init() {
 			Name:   "list-np-old",
 			Usage:  "add a new template",
 			Action: UtilityListNetworkPrimitivesOld,
+		}, {
+			Name:   "import-netplan",
+			Usage:  "Convert netplan configuration of the host into network scheme",
+			Action: UtilityImportNetplan,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "netplan-dir",
+					Value: "/etc/netplan",
+					Usage: "Specify directory with netplan configuration",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "stdout",
+					Usage: "Specify path for network scheme file (use 'stdout' if need)",
+				},
+			},
+		}, {
+			Name:      "export-scheme",
+			Usage:     "Store network scheme, which describes observed network topology of the host",
+			ArgsUsage: "[interface ...]",
+			Action:    UtilityExportScheme,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "stdout",
+					Usage: "Specify path for network scheme file (use 'stdout' if need)",
+				},
+			},
+		}, {
+			Name:   "snapshot",
+			Usage:  "Store observed network topology, including cached link attributes, to file",
+			Action: UtilitySnapshot,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: "yaml",
+					Usage: "Snapshot format: 'yaml' or 'json'",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "stdout",
+					Usage: "Specify path for snapshot file (use 'stdout' if need)",
+				},
+			},
 		}},
 	}, {
 		Name:    "netconfig",
 		Aliases: []string{"nc", "run"},
 		Usage:   "Re-configure network, correspond to network scheme",
 		Action:  RunNetConfig,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "report",
+				EnvVar: "L23_REPORT",
+				Usage:  "Store results of changes to file in JSON format",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			Log.Debug("Check network scheme exists.")
+			_, err := os.Stat(Cfg.NsPath)
+			return err
+		},
+	}, {
+		Name:   "diff",
+		Usage:  "Show differences between current network topology and network scheme",
+		Action: RunDiff,
+		Before: func(c *cli.Context) error {
+			Log.Debug("Check network scheme exists.")
+			_, err := os.Stat(Cfg.NsPath)
+			return err
+		},
+	}, {
+		Name:   "plan",
+		Usage:  "Show changes, required to implement network scheme, in the order of implementation",
+		Action: RunPlan,
+		Before: func(c *cli.Context) error {
+			Log.Debug("Check network scheme exists.")
+			_, err := os.Stat(Cfg.NsPath)
+			return err
+		},
+	}, {
+		Name:   "check",
+		Usage:  "Compare network scheme, current network topology and stored network config with each other (exit codes: 0 - OK, 1 - stored config differs, 2 - runtime differs, 3 - unknown)",
+		Action: RunCheck,
+		Before: func(c *cli.Context) error {
+			Log.Debug("Check network scheme exists.")
+			if _, err := os.Stat(Cfg.NsPath); err != nil {
+				return cli.NewExitError(err.Error(), CheckUnknown)
+			}
+			return nil
+		},
+	}, {
+		Name:   "watch",
+		Usage:  "Re-configure network, correspond to network scheme, and keep it converged",
+		Action: RunWatch,
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:   "debounce",
+				EnvVar: "L23_DEBOUNCE",
+				Value:  2 * time.Second,
+				Usage:  "Wait for this time after the last runtime change before re-convergence",
+			},
+			cli.IntFlag{
+				Name:   "max-corrections",
+				EnvVar: "L23_MAX_CORRECTIONS",
+				Value:  3,
+				Usage:  "Stop correcting network primitive, which drifts again after this number of corrections in a row",
+			},
+			cli.DurationFlag{
+				Name:   "backoff",
+				EnvVar: "L23_BACKOFF",
+				Value:  5 * time.Minute,
+				Usage:  "Ignore runtime changes of network primitive, which doesn't converge, for this time",
+			},
+		},
 		Before: func(c *cli.Context) error {
 			Log.Debug("Check network scheme exists.")
 			_, err := os.Stat(Cfg.NsPath)
@@ -94,6 +250,12 @@ func init() {
 		Aliases: []string{"st"},
 		Usage:   "Re-write network config, correspond to network scheme",
 		Action:  StoreNetConfig,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "diff",
+				Usage: "Show changes of network config files before storing (use with --dry-run to show only)",
+			},
+		},
 		Before: func(c *cli.Context) error {
 			Log.Debug("Check network scheme exists.")
 			_, err := os.Stat(Cfg.NsPath)
@@ -111,6 +273,9 @@ func init() {
 			suffix = " (dry-run)"
 		}
 		Log.Debug("L23network started.%s", suffix)
+		discoverPlugins(c.GlobalString("plugin-dir"))
+		providerOverride = c.GlobalString("provider")
+		lnx.SimStatePath = c.GlobalString("sim-state")
 		return nil
 	}
 	App.CommandNotFound = func(c *cli.Context, cmd string) {
@@ -120,140 +285,445 @@ func init() {
 }
 
 func main() {
-	App.Run(os.Args)
+	if err := App.Run(os.Args); err != nil {
+		os.Exit(ExitFailure)
+	}
 }
 
 // -----------------------------------------------------------------------------
-func RunNetConfig(c *cli.Context) (err error) {
-	// Load and Process Network Scheme
+
+// discoverPlugins -- register out-of-process provider plugins, found into
+// plugin directory
+func discoverPlugins(dir string) {
+	if _, err := os.Stat(dir); err != nil {
+		Log.Debug("Plugin directory '%s' not found, out-of-process providers are not used", dir)
+		return
+	}
+	providers, err := ext.Discover(dir)
+	if err != nil {
+		Log.Warn("%v", err)
+	}
+	if len(providers) > 0 {
+		Log.Info("Out-of-process providers loaded from '%s': %v", dir, providers)
+	}
+}
+
+// loadNetworkScheme -- load network scheme from file and validate it
+func loadNetworkScheme(path string) (ns *NetworkScheme, err error) {
 	var rr *os.File
-	Log.Debug("Run NetworkConfig with network scheme: '%s'", c.GlobalString("ns"))
-	ns := new(NetworkScheme)
-	if rr, err = os.Open(c.GlobalString("ns")); err != nil {
-		Log.Error("Can't open file '%s'", c.GlobalString("ns"))
+	ns = new(NetworkScheme)
+	if rr, err = os.Open(path); err != nil {
+		Log.Error("Can't open file '%s'", path)
 		Log.Error("%v", err)
-		return err
+		return nil, err
 	}
+	defer rr.Close()
 	if err = ns.Load(rr); err != nil {
-		Log.Error("Can't process network scheme from '%s'", c.GlobalString("ns"))
+		Log.Error("Can't process network scheme from '%s'", path)
 		Log.Error("%v", err)
-		return err
+		return nil, err
+	}
+	if providerOverride != "" {
+		Log.Debug("Provider '%s' used instead of the default one", providerOverride)
+		ns.Provider = providerOverride
+	}
+	if err = ns.Validate(); err != nil {
+		Log.Error("Network scheme '%s' is invalid", path)
+		Log.Error("%v", err)
+		return nil, err
 	}
 	Log.Debug("NetworkScheme loaded")
+	return ns, nil
+}
+
+// resolveInterfaces -- find physical interfaces, described by match rules,
+// by the first provider, which able to do it
+func resolveInterfaces(ns *NetworkScheme, rtPlugins *RtPlugins, dryrun bool) (err error) {
+	if rtPlugins.snapshot != nil {
+		if err = ns.ResolveInterfaces(func(match map[string]string, setName string) (string, error) {
+			return rtPlugins.ResolveBySnapshot(match, setName)
+		}); err != nil {
+			Log.Error("%v", err)
+		}
+		return err
+	}
+	var resolver plugin.IfResolver
+	for _, name := range rtPlugins.Providers {
+		if r, ok := rtPlugins.Plugins[name].(plugin.IfResolver); ok {
+			resolver = r
+			break
+		}
+	}
+	if resolver == nil {
+		return nil
+	}
+	if err = ns.ResolveInterfaces(func(match map[string]string, setName string) (string, error) {
+		return resolver.ResolveInterface(match, setName, dryrun)
+	}); err != nil {
+		Log.Error("%v", err)
+	}
+	return err
+}
+
+// storeNetworkScheme -- store network scheme in YAML format, prefixed by
+// header, to file or stdout
+func storeNetworkScheme(ns *NetworkScheme, header, output string) error {
+	data, err := yaml.Marshal(ns)
+	if err != nil {
+		Log.Error("Can't serialize network scheme: %v", err)
+		return err
+	}
+	data = append([]byte(header), data...)
+	if output == "stdout" || output == "tty" {
+		fmt.Printf("%s", data)
+		return nil
+	}
+	if err = ioutil.WriteFile(output, data, 0644); err != nil {
+		Log.Error("Can't store network scheme to '%s': %v", output, err)
+		return err
+	}
+	Log.Info("Network scheme stored to '%s'", output)
+	return nil
+}
+
+// initRtPlugins -- initialize runtime plugins of all providers, used by
+// network scheme
+func initRtPlugins(ns *NetworkScheme) (rtPlugins *RtPlugins, err error) {
+	if rtPlugins, err = NewRtPlugins(ns.Providers()); err != nil {
+		Log.Error("%v", err)
+		return nil, err
+	}
+	Log.Debug("Runtime plugins initialized: %v", rtPlugins.Providers)
+	return rtPlugins, nil
+}
+
+// loadSnapshot -- load network topology snapshot from file
+func loadSnapshot(path string) (*npstate.TopologyState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		Log.Error("Can't read snapshot '%s': %v", path, err)
+		return nil, err
+	}
+	snapshot, err := npstate.LoadTopologySnapshot(data)
+	if err != nil {
+		Log.Error("Can't process snapshot '%s': %v", path, err)
+		return nil, err
+	}
+	Log.Debug("Network topology snapshot loaded from '%s'", path)
+	return snapshot.TopologyState(), nil
+}
+
+// changeSet -- current and wanted network topology and differences
+// between them
+type changeSet struct {
+	rtPlugins *RtPlugins
+	observed  *npstate.TopologyState
+	wanted    *npstate.TopologyState
+	diff      *npstate.DiffTopologyStatees
+}
+
+// observeChanges -- load network scheme, observe current network topology
+// (or take it from snapshot) and compare them
+func observeChanges(c *cli.Context) (rv *changeSet, err error) {
+	var ns *NetworkScheme
+	rv = new(changeSet)
+
+	Log.Debug("Run NetworkConfig with network scheme: '%s'", c.GlobalString("ns"))
+	if ns, err = loadNetworkScheme(c.GlobalString("ns")); err != nil {
+		return nil, err
+	}
+
+	// initialize and configure runtime plugins
+	if rv.rtPlugins, err = initRtPlugins(ns); err != nil {
+		return nil, err
+	}
+	if path := c.GlobalString("observe-from"); path != "" {
+		snapshot, err := loadSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		rv.rtPlugins.UseSnapshot(snapshot)
+	}
+
+	// find physical interfaces, described by match rules
+	if err = resolveInterfaces(ns, rv.rtPlugins, c.GlobalBool("dry-run")); err != nil {
+		return nil, err
+	}
 
 	// generate wanted network topology
-	wantedNetState := ns.TopologyState()
+	rv.wanted = ns.TopologyState()
 	Log.Debug("NetworkScheme processed")
-	Log.Debug("Planned resources ordering is: %s", wantedNetState.Order)
+	Log.Debug("Planned resources ordering is: %s", rv.wanted.Order)
 
-	// initialize and configure LnxRtPlugin
-	lnxRtPlugin := lnx.NewLnxRtPlugin()
-	lnxRtPlugin.Init(Log, nil)
-	lnxRtPlugin.Observe()
-	Log.Debug("LnxRtPlugin initialized")
+	rv.rtPlugins.LimitEthtool(rv.wanted)
+	if err = rv.rtPlugins.Observe(); err != nil {
+		Log.Error("%v", err)
+		return nil, err
+	}
+	Log.Debug("Current network topology observed")
 
 	// generate diff betwen current and wanted network topology
-	diffNetState := lnxRtPlugin.Topology().Compare(wantedNetState)
-	Log.Debug("NetworkState DIFF ready: \n%v", diffNetState)
+	rv.observed = rv.rtPlugins.Topology(rv.wanted)
+	rv.diff = rv.observed.Compare(rv.wanted)
+	Log.Debug("NetworkState DIFF ready: \n%v", rv.diff)
+	return rv, nil
+}
+
+func RunNetConfig(c *cli.Context) (err error) {
+	var changes *changeSet
+	if c.GlobalString("observe-from") != "" && !c.GlobalBool("dry-run") {
+		err = fmt.Errorf("snapshot can be used only in the dry-run mode")
+		Log.Error("%v", err)
+		return err
+	}
+
+	// Load and Process Network Scheme
+	if changes, err = observeChanges(c); err != nil {
+		return err
+	}
+
+	report := applyChanges(changes.rtPlugins, changes.wanted, changes.diff, c.GlobalBool("dry-run"))
+
+	if c.GlobalBool("generate") {
+		err = StoreNetConfig(c)
+	}
+
+	if !c.GlobalBool("dry-run") {
+		// operators may only log errors, so result of changes should be
+		// checked by the network topology itself
+		var verr error
+		if report.Diverged, verr = verifyConvergence(changes.rtPlugins, changes.wanted); verr != nil {
+			Log.Error("Can't verify network topology after changes: %v", verr)
+			return cli.NewExitError("", ExitFailure)
+		}
+		for _, npName := range changes.wanted.Order {
+			if fields, ok := report.Diverged[npName]; ok {
+				Log.Error("'%s' differs from network scheme after changes: %s", npName, strings.Join(fields, ", "))
+			}
+		}
+		if len(report.Diverged) == 0 {
+			Log.Info("Network topology is converged with network scheme")
+		}
+	}
 
-	NSoperators := lnxRtPlugin.Operators()
+	report.Print(os.Stdout)
+	if path := c.String("report"); path != "" {
+		if err := report.Store(path); err != nil {
+			Log.Error("Can't store report to '%s': %v", path, err)
+			return cli.NewExitError("", ExitFailure)
+		}
+	}
+	if err != nil {
+		return cli.NewExitError("", ExitFailure)
+	}
+	if code := report.ExitCode(); code != ExitSuccess {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
 
-	// // report
-	// npCreated := []string{}
-	// npRemoved := []string{}
-	// npModifyed := []string{}
+// applyChanges -- walk through wanted network primitives and implement
+// changes, found into diff, into network configuration. Each network
+// primitive is processed by operators of its provider.
+// returns results of changes of network primitives
+func applyChanges(rtPlugins *RtPlugins, wantedNetState *npstate.TopologyState, diffNetState *npstate.DiffTopologyStatees, dryrun bool) (report *ApplyReport) {
+	report = NewApplyReport(dryrun)
+	planned := make(map[string]PlanStep)
+	for _, step := range planChanges(wantedNetState, diffNetState) {
+		planned[step.Name] = step
+	}
+	results := map[string]string{MethodCreate: ResultCreated, MethodModify: ResultModified, MethodRemove: ResultRemoved}
 
-	// walk through Wanted.NetState network primitives and implement changes
-	// into network configuration
+	// walk through planned changes of network primitives and implement
+	// them into network configuration
 	for _, npName := range wantedNetState.Order {
-		Log.Debug("Processing '%v'", npName)
-		action, ok := NSoperators[wantedNetState.NP[npName].Action]
+		step, ok := planned[npName]
 		if !ok {
-			Log.Warn("Unsupported action '%s' for '%s', skipped", action, npName)
+			np := wantedNetState.NP[npName]
+			report.add(npName, np.Action, np.Provider, ResultUnchanged, 0, nil)
 			continue
 		}
-		oper := action.(func() plugin.NpOperator)()
-		oper.Init(wantedNetState.NP[npName])
-
-		if IndexString(diffNetState.Waste, npName) >= 0 {
-			// this NP should be removed
-			oper.Remove(c.GlobalBool("dry-run"))
-			// npRemoved = append(npRemoved, npName)
-		} else if IndexString(diffNetState.New, npName) >= 0 {
-			// this NP shoujld be created
-			oper.Create(c.GlobalBool("dry-run"))
-			// npCreated = append(npCreated, npName)
-		} else if IndexString(diffNetState.Different, npName) >= 0 {
-			oper.Modify(c.GlobalBool("dry-run"))
-			// npModifyed = append(npModifyed, npName)
-		}
+		Log.Debug("Processing '%v'", npName)
+		started := time.Now()
+		err := applyStep(rtPlugins, wantedNetState, step, dryrun)
+		report.add(npName, step.NP.Action, step.NP.Provider, results[step.Method], time.Since(started), err)
 	}
+	return report
+}
 
-	// // evaluate report
-	// if !reflect.DeepEqual(npCreated, []string{"br4", "eth1.101"}) {
-	//     t.Logf("Problen while creating resources: %v", npCreated)
-	//     t.Fail()
-	// }
-	// if !reflect.DeepEqual(npModifyed, []string{"eth0", "eth1"}) {
-	//     t.Logf("Problen while modifying resources: %v", npModifyed)
-	//     t.Fail()
-	// }
-
-	if c.GlobalBool("gnerate") {
-		err = StoreNetConfig(c)
+// applyStep -- implement planned change of one network primitive by
+// operator of its provider
+func applyStep(rtPlugins *RtPlugins, wantedNetState *npstate.TopologyState, step PlanStep, dryrun bool) (err error) {
+	NSoperators, err := rtPlugins.Operators(step.NP)
+	if err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	action, ok := NSoperators[step.NP.Action]
+	if !ok {
+		err = fmt.Errorf("unsupported action '%s' of provider '%s' for '%s'", step.NP.Action, step.NP.Provider, step.Name)
+		Log.Warn("%v, skipped", err)
+		return err
+	}
+	oper := action.(func() plugin.NpOperator)()
+	if rtPlugins.ForeignBridge(wantedNetState, step.NP) {
+		// membership into bridge of another provider is managed
+		// by provider of bridge
+		np := *step.NP
+		np.L2.Bridge = ""
+		oper.Init(&np)
+	} else {
+		oper.Init(step.NP)
 	}
 
+	switch step.Method {
+	case MethodRemove:
+		// this NP should be removed
+		err = oper.Remove(dryrun)
+	case MethodCreate:
+		// this NP shoujld be created
+		err = oper.Create(dryrun)
+	case MethodModify:
+		err = oper.Modify(dryrun)
+	}
+	if err == nil && step.Method != MethodRemove {
+		err = rtPlugins.SyncMembership(wantedNetState, step.NP, dryrun)
+	}
 	return err
 }
 
 func StoreNetConfig(c *cli.Context) (err error) {
 	// Load and Process Network Scheme
 	var (
-		rr *os.File
-		ww *os.File
+		ns           *NetworkScheme
+		configPlugin plugin.ConfigPlugin
 	)
 	Log.Debug("Run StoreNetConfig with network scheme: '%s'", c.GlobalString("ns"))
-	ns := new(NetworkScheme)
-	if rr, err = os.Open(c.GlobalString("ns")); err != nil {
-		Log.Error("Can't open file '%s'", c.GlobalString("ns"))
-		Log.Error("%v", err)
-		return err
-	}
-	// defer rr.Close()
-	if err = ns.Load(rr); err != nil {
-		Log.Error("Can't process network scheme from '%s'", c.GlobalString("ns"))
-		Log.Error("%v", err)
+	if ns, err = loadNetworkScheme(c.GlobalString("ns")); err != nil {
 		return err
 	}
-	Log.Debug("NetworkScheme loaded")
-
-	// generate wanted network topology
-	wantedNetState := ns.TopologyState()
-	Log.Debug("NetworkScheme processed")
 
-	// Generate Netplan YAML and store it
-	savedConfig := u1804.NewSavedConfig(Log)
-	savedConfig.SetWantedState(&wantedNetState.NP)
-	if err = savedConfig.Generate(); err != nil {
-		Log.Error("Error while Netplan YAML generation: '%s'", err)
+	// Generate network config and store it
+	if configPlugin, err = generateNetConfig(c, ns); err != nil {
 		return err
 	}
-	actualYaml := savedConfig.String()
 
 	configFileName := c.GlobalString("store-config")
 	if configFileName == "stdout" || configFileName == "tty" {
-		fmt.Printf("---\n%s", actualYaml)
-	} else {
-		ww, err = os.Create(configFileName)
+		for _, file := range configPlugin.Files(configPlugin.DefaultPath()) {
+			if file.Path == configPlugin.DefaultPath() {
+				fmt.Printf("---\n%s", file.Content)
+			} else {
+				// config, stored as several files into directory
+				fmt.Printf("# ==> %s <==\n%s\n", file.Path, file.Content)
+			}
+		}
+		return nil
+	}
+	if configFileName == "" {
+		configFileName = configPlugin.DefaultPath()
+	}
+	files := configPlugin.Files(configFileName)
+	stale := []string{}
+	if cleaner, ok := configPlugin.(plugin.ConfigCleaner); ok {
+		if stale, err = staleConfigs(cleaner, configFileName, files); err != nil {
+			Log.Error("Can't find network config files, stored before into '%s': %v", configFileName, err)
+			return err
+		}
+	}
+	if c.Bool("diff") {
+		if err = printConfigDiff(files, stale); err != nil {
+			Log.Error("Can't compare network config with stored one: %v", err)
+			return err
+		}
+	}
+	if c.GlobalBool("dry-run") {
+		Log.Info("Network config is not stored in the dry-run mode")
+		return nil
+	}
+
+	backups := c.GlobalInt("config-backups")
+	for _, file := range files {
+		if file.Mode == 0 {
+			file.Mode = 0644
+		}
+		if err = os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			Log.Error("Can't create directory for network config '%s': %v", file.Path, err)
+			return err
+		}
+		changed, err := storeConfigFile(file, backups)
 		if err != nil {
-			Log.Error("Can't create file '%s'", configFileName)
-			Log.Error("%v", err)
+			Log.Error("Can't store network config to '%s': %v", file.Path, err)
 			return err
 		}
-		defer ww.Close()
-		ww.WriteString(actualYaml)
+		if changed {
+			Log.Debug("Network config stored to '%s'", file.Path)
+		} else {
+			Log.Debug("Network config '%s' is not changed", file.Path)
+		}
 	}
-	return err
+	for _, fileName := range stale {
+		if err = removeConfigFile(fileName, backups); err != nil {
+			Log.Error("Can't remove stale network config '%s': %v", fileName, err)
+			return err
+		}
+		Log.Info("Stale network config '%s' removed", fileName)
+	}
+	return nil
+}
+
+// staleConfigs -- returns files, generated before for network primitives,
+// which are absent into the network scheme now
+func staleConfigs(cleaner plugin.ConfigCleaner, path string, files []plugin.ConfigFile) (rv []string, err error) {
+	generated, err := cleaner.GeneratedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]bool)
+	for _, file := range files {
+		actual[file.Path] = true
+	}
+	rv = []string{}
+	for _, fileName := range generated {
+		if !actual[fileName] {
+			rv = append(rv, fileName)
+		}
+	}
+	return rv, nil
+}
+
+// generateNetConfig -- generate network config, correspond to network
+// scheme, by config plugin, given by CLI flag or by network scheme
+func generateNetConfig(c *cli.Context, ns *NetworkScheme) (rv plugin.ConfigPlugin, err error) {
+	// generate wanted network topology
+	wantedNetState := ns.TopologyState()
+	Log.Debug("NetworkScheme processed")
+
+	if rv, err = newConfigPlugin(c, ns); err != nil {
+		return nil, err
+	}
+	rv.SetWantedState(&wantedNetState.NP)
+	if err = rv.Generate(); err != nil {
+		Log.Error("Error while network config generation: '%s'", err)
+		return nil, err
+	}
+	return rv, nil
+}
+
+// newConfigPlugin -- create config plugin, given by CLI flag or by
+// network scheme
+func newConfigPlugin(c *cli.Context, ns *NetworkScheme) (rv plugin.ConfigPlugin, err error) {
+	name := ns.ConfigProviderName()
+	if c.GlobalString("config-provider") != "" {
+		name = c.GlobalString("config-provider")
+	}
+	if rv, err = plugin.NewConfigPlugin(name); err != nil {
+		Log.Error("%v, known config providers are: %v", err, plugin.ConfigPluginNames())
+		return nil, err
+	}
+	rv.SetLogger(Log)
+	Log.Debug("Config provider '%s' is used", name)
+	return rv, nil
 }
 
 // -----------------------------------------------------------------------------
@@ -1,41 +1,68 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"reflect"
 	"sort"
 
 	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
 
 	. "github.com/xenolog/l23/utils"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type NsPrimitive struct {
-	Action       string   `yaml:"action"`
-	Name         string   `yaml:"name"`
-	Mtu          int      `yaml:"mtu,omitempty"`
-	Bridge       string   `yaml:"bridge,omitempty"`
-	Parent       string   `yaml:"parent,omitempty"`
-	Slaves       []string `yaml:"slaves,omitempty"`
-	Vlan_id      int      `yaml:"vlan_id,omitempty"`
-	Stp          bool     `yaml:"stp,omitempty"`
-	Bpdu_forward bool     `yaml:"bpdu_forward,omitempty"`
-	Type         string   `yaml:"Type,omitempty"`
-	Provider     string   `yaml:"provider"`
+	Action       string               `yaml:"action,omitempty"`
+	Name         string               `yaml:"name,omitempty"`
+	Mtu          int                  `yaml:"mtu,omitempty"`
+	Bridge       string               `yaml:"bridge,omitempty"`
+	Parent       string               `yaml:"parent,omitempty"`
+	Slaves       []string             `yaml:"slaves,omitempty"`
+	Vlan_id      int                  `yaml:"vlan_id,omitempty"`
+	Stp          bool                 `yaml:"stp,omitempty"`
+	Bpdu_forward bool                 `yaml:"bpdu_forward,omitempty"`
+	Type         string               `yaml:"type,omitempty"`
+	TypeOld      string               `yaml:"Type,omitempty"` // deprecated spelling of 'type'
+	Provider     string               `yaml:"provider,omitempty"`
+	State        string               `yaml:"state,omitempty"`
+	Macaddress   string               `yaml:"macaddress,omitempty"`
+	Txqueuelen   int                  `yaml:"txqueuelen,omitempty"`
+	Alias        string               `yaml:"alias,omitempty"`
+	Ethtool      npstate.EthtoolState `yaml:"ethtool,omitempty"`
+	Match        map[string]string    `yaml:"match,omitempty"`
+	SetName      string               `yaml:"set-name,omitempty"`
+	Trunks       []int                `yaml:"trunks,omitempty"`
+	Peer         string               `yaml:"peer,omitempty"`
 	// Vendor_specific string   `yaml:"vendor_specific,omitempty"`
-	// Ethtool
 	// External_ids
-	// Bond_properties
+	BondProperties map[string]string `yaml:"bond_properties,omitempty"`
 	// Interface_properties
 }
 
+type NsRoute struct {
+	Net    string `yaml:"net"` // in the CIDR notation
+	Via    string `yaml:"via"`
+	Metric int    `yaml:"metric,omitempty"`
+}
+
+type NsNameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// NsEp -- endpoint. Gateway, routes and nameservers are stored into
+// network configuration only, runtime providers do not apply them
 type NsEp struct {
-	Gateway       string   `yaml:"gateway,omitempty"`
-	GatewayMetric int      `yamk:"gateway_metric,omitempty"`
-	IP            []string `yaml:"IP"`
+	Gateway       string        `yaml:"gateway,omitempty"`
+	GatewayMetric int           `yaml:"gateway_metric,omitempty"`
+	IP            []string      `yaml:"IP"`
+	Routes        []NsRoute     `yaml:"routes,omitempty"`
+	Nameservers   NsNameservers `yaml:"nameservers,omitempty"`
 }
 
 type NsTransformations []NsPrimitive
@@ -48,6 +75,7 @@ type NetworkScheme struct {
 	Transformations NsTransformations `yaml:"transformations"`
 	Endpoints       NsEps             `yaml:"endpoints"`
 	Provider        string            `yaml:"provider"`
+	ConfigProvider  string            `yaml:"config-provider,omitempty"`
 }
 
 // func (s *NetworkScheme) setLogger(log *logger.Logger) {
@@ -72,9 +100,311 @@ func (s *NetworkScheme) Load(r io.Reader) (err error) {
 		log.Printf("NetworkScheme YAML parsing error: %v", err)
 		return
 	}
+	for name, iface := range s.Interfaces {
+		if iface.migrateType(name) {
+			s.Interfaces[name] = iface
+		}
+	}
+	for i := range s.Transformations {
+		s.Transformations[i].migrateType(s.Transformations[i].Name)
+	}
 	return
 }
 
+// migrateType -- take link type from deprecated 'Type' key, if 'type' is
+// not given. Returns true if network primitive is changed
+func (s *NsPrimitive) migrateType(name string) bool {
+	if s.TypeOld == "" {
+		return false
+	}
+	log.Printf("NetworkScheme: 'Type' key of '%s' is deprecated, use 'type' instead", name)
+	if s.Type == "" {
+		s.Type = s.TypeOld
+	}
+	s.TypeOld = ""
+	return true
+}
+
+// setupLinkState -- setup administrative link state of network primitive
+// if it defined
+func (s *NsPrimitive) setupLinkState(np *npstate.NPState) {
+	switch s.State {
+	case npstate.LinkStateUp:
+		np.Online = true
+		np.KeepOnline = false
+	case npstate.LinkStateDown:
+		np.Online = false
+		np.KeepOnline = false
+	case npstate.LinkStateUnmanaged:
+		np.KeepOnline = true
+	}
+}
+
+// setupL2Properties -- setup optional L2 properties of network primitive.
+// If 'force' is false, properties, already defined for network primitive
+// will not be overridden.
+func (s *NsPrimitive) setupL2Properties(np *npstate.NPState, force bool) {
+	if s.Macaddress != "" && (force || np.L2.HwAddr == "") {
+		np.L2.HwAddr = s.Macaddress
+	}
+	if s.Txqueuelen != 0 && (force || np.L2.TxQLen == 0) {
+		np.L2.TxQLen = s.Txqueuelen
+	}
+	if s.Alias != "" && (force || np.L2.Alias == "") {
+		np.L2.Alias = s.Alias
+	}
+	for key, value := range s.Ethtool.Offload {
+		if _, ok := np.L2.Ethtool.Offload[key]; force || !ok {
+			if np.L2.Ethtool.Offload == nil {
+				np.L2.Ethtool.Offload = make(map[string]bool)
+			}
+			np.L2.Ethtool.Offload[key] = value
+		}
+	}
+	for key, value := range s.Ethtool.Ring {
+		if _, ok := np.L2.Ethtool.Ring[key]; force || !ok {
+			if np.L2.Ethtool.Ring == nil {
+				np.L2.Ethtool.Ring = make(map[string]int)
+			}
+			np.L2.Ethtool.Ring[key] = value
+		}
+	}
+	for key, value := range s.Ethtool.Coalesce {
+		if _, ok := np.L2.Ethtool.Coalesce[key]; force || !ok {
+			if np.L2.Ethtool.Coalesce == nil {
+				np.L2.Ethtool.Coalesce = make(map[string]string)
+			}
+			np.L2.Ethtool.Coalesce[key] = value
+		}
+	}
+}
+
+func validateL2Properties(name string, np *NsPrimitive) error {
+	if np.Macaddress != "" {
+		if _, err := net.ParseMAC(np.Macaddress); err != nil {
+			return fmt.Errorf("wrong macaddress '%s' for '%s': %v", np.Macaddress, name, err)
+		}
+	}
+	if np.Txqueuelen < 0 {
+		return fmt.Errorf("wrong txqueuelen '%d' for '%s'", np.Txqueuelen, name)
+	}
+	for key := range np.Ethtool.Offload {
+		if IndexString(npstate.EthtoolOffloads, key) < 0 {
+			return fmt.Errorf("unsupported ethtool offload '%s' for '%s'", key, name)
+		}
+	}
+	for key := range np.Ethtool.Ring {
+		if IndexString(npstate.EthtoolRings, key) < 0 {
+			return fmt.Errorf("unsupported ethtool ring parameter '%s' for '%s'", key, name)
+		}
+	}
+	for key := range np.Ethtool.Coalesce {
+		if IndexString(npstate.EthtoolCoalesces, key) < 0 {
+			return fmt.Errorf("unsupported ethtool coalesce parameter '%s' for '%s'", key, name)
+		}
+	}
+	return nil
+}
+
+func validateBondProperties(name string, np *NsPrimitive) error {
+	if len(np.BondProperties) > 0 && np.Action != "bond" {
+		return fmt.Errorf("'bond_properties' are allowed only for bond, but '%s' is '%s'", name, np.Action)
+	}
+	for key := range np.BondProperties {
+		if IndexString(npstate.BondProperties, key) < 0 {
+			return fmt.Errorf("unsupported bond property '%s' for '%s'", key, name)
+		}
+	}
+	return nil
+}
+
+func validateEndpoint(name string, ep *NsEp) error {
+	if ep.Gateway != "" && net.ParseIP(ep.Gateway) == nil {
+		return fmt.Errorf("wrong gateway '%s' for '%s'", ep.Gateway, name)
+	}
+	for _, route := range ep.Routes {
+		if _, _, err := net.ParseCIDR(route.Net); err != nil {
+			return fmt.Errorf("wrong route network '%s' for '%s': %v", route.Net, name, err)
+		}
+		if net.ParseIP(route.Via) == nil {
+			return fmt.Errorf("wrong route gateway '%s' for '%s'", route.Via, name)
+		}
+	}
+	for _, addr := range ep.Nameservers.Addresses {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("wrong nameserver '%s' for '%s'", addr, name)
+		}
+	}
+	return nil
+}
+
+func validateLinkState(name, state string) error {
+	switch state {
+	case "", npstate.LinkStateUp, npstate.LinkStateDown, npstate.LinkStateUnmanaged:
+		return nil
+	}
+	return fmt.Errorf("wrong state '%s' for '%s', should be one of: %s, %s, %s", state, name,
+		npstate.LinkStateUp, npstate.LinkStateDown, npstate.LinkStateUnmanaged)
+}
+
+// Keys, allowed into 'match' section of interface
+var NsMatchKeys = []string{"macaddress", "driver", "path"}
+
+func validateMatch(name string, np *NsPrimitive) error {
+	if np.SetName != "" && len(np.Match) == 0 {
+		return fmt.Errorf("'set-name' for '%s' requires 'match' section", name)
+	}
+	for key, value := range np.Match {
+		if IndexString(NsMatchKeys, key) < 0 {
+			return fmt.Errorf("unsupported match key '%s' for '%s'", key, name)
+		}
+		if value == "" {
+			return fmt.Errorf("empty match value '%s' for '%s'", key, name)
+		}
+	}
+	if mac, ok := np.Match["macaddress"]; ok {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return fmt.Errorf("wrong match macaddress '%s' for '%s': %v", mac, name, err)
+		}
+	}
+	return nil
+}
+
+// ResolveInterfaces -- find real names of interfaces, described by 'match'
+// section, and rename them into the network scheme. 'resolve' should return
+// the runtime name of interface
+func (s *NetworkScheme) ResolveInterfaces(resolve func(match map[string]string, setName string) (string, error)) error {
+	renames := make(map[string]string)
+	for key, iface := range s.Interfaces {
+		if len(iface.Match) == 0 {
+			continue
+		}
+		name, err := resolve(iface.Match, iface.SetName)
+		if err != nil {
+			return fmt.Errorf("can't resolve interface '%s': %v", key, err)
+		}
+		log.Printf("Interface '%s' resolved as '%s'", key, name)
+		if name != key {
+			renames[key] = name
+		}
+	}
+	if len(renames) == 0 {
+		return nil
+	}
+
+	rename := func(name string) string {
+		if newName, ok := renames[name]; ok {
+			return newName
+		}
+		return name
+	}
+	ifaces := make(NsIfaces)
+	for key, iface := range s.Interfaces {
+		ifaces[rename(key)] = iface
+	}
+	s.Interfaces = ifaces
+	for i := range s.Transformations {
+		tr := &s.Transformations[i]
+		tr.Name = rename(tr.Name)
+		tr.Bridge = rename(tr.Bridge)
+		tr.Parent = rename(tr.Parent)
+		for j := range tr.Slaves {
+			tr.Slaves[j] = rename(tr.Slaves[j])
+		}
+	}
+	eps := make(NsEps)
+	for key, ep := range s.Endpoints {
+		eps[rename(key)] = ep
+	}
+	s.Endpoints = eps
+	return nil
+}
+
+// Providers -- returns list of providers, used by network scheme.
+// Default provider is the first
+func (s *NetworkScheme) Providers() []string {
+	defaultProvider := "lnx"
+	if s.Provider != "" {
+		defaultProvider = s.Provider
+	}
+	rv := []string{}
+	for _, tr := range s.Transformations {
+		if tr.Provider != "" && tr.Provider != defaultProvider && IndexString(rv, tr.Provider) < 0 {
+			rv = append(rv, tr.Provider)
+		}
+	}
+	for _, iface := range s.Interfaces {
+		if iface.Provider != "" && iface.Provider != defaultProvider && IndexString(rv, iface.Provider) < 0 {
+			rv = append(rv, iface.Provider)
+		}
+	}
+	sort.Strings(rv)
+	return PrependString(rv, defaultProvider)
+}
+
+// ConfigProviderName -- returns name of config plugin, which stores network
+// configuration for this network scheme
+func (s *NetworkScheme) ConfigProviderName() string {
+	if s.ConfigProvider != "" {
+		return s.ConfigProvider
+	}
+	return plugin.DefaultConfigProvider
+}
+
+func validateProvider(name, provider string) error {
+	if provider == "" || IndexString(plugin.RtPluginNames(), provider) >= 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown provider '%s' for '%s', known providers are: %v", provider, name, plugin.RtPluginNames())
+}
+
+// Validate -- check network scheme for semantic errors
+func (s *NetworkScheme) Validate() error {
+	if s.Provider != "" && IndexString(plugin.RtPluginNames(), s.Provider) < 0 {
+		return fmt.Errorf("unknown default provider '%s', known providers are: %v", s.Provider, plugin.RtPluginNames())
+	}
+	if s.ConfigProvider != "" && IndexString(plugin.ConfigPluginNames(), s.ConfigProvider) < 0 {
+		return fmt.Errorf("unknown config provider '%s', known config providers are: %v", s.ConfigProvider, plugin.ConfigPluginNames())
+	}
+	for _, tr := range s.Transformations {
+		if err := validateProvider(tr.Name, tr.Provider); err != nil {
+			return err
+		}
+		if err := validateLinkState(tr.Name, tr.State); err != nil {
+			return err
+		}
+		if err := validateL2Properties(tr.Name, &tr); err != nil {
+			return err
+		}
+		if err := validateBondProperties(tr.Name, &tr); err != nil {
+			return err
+		}
+		if len(tr.Match) > 0 || tr.SetName != "" {
+			return fmt.Errorf("'match' and 'set-name' for '%s' are allowed only into 'interfaces' section", tr.Name)
+		}
+	}
+	for name, iface := range s.Interfaces {
+		if err := validateProvider(name, iface.Provider); err != nil {
+			return err
+		}
+		if err := validateLinkState(name, iface.State); err != nil {
+			return err
+		}
+		if err := validateL2Properties(name, &iface); err != nil {
+			return err
+		}
+		if err := validateMatch(name, &iface); err != nil {
+			return err
+		}
+	}
+	for name, ep := range s.Endpoints {
+		if err := validateEndpoint(name, &ep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *NetworkScheme) TopologyState() *npstate.TopologyState {
 
 	rv := &npstate.TopologyState{
@@ -107,8 +437,16 @@ func (s *NetworkScheme) TopologyState() *npstate.TopologyState {
 		rv.NP[tr.Name].L2.Parent = tr.Parent
 		rv.NP[tr.Name].L2.Slaves = tr.Slaves
 		rv.NP[tr.Name].L2.Vlan_id = tr.Vlan_id
+		rv.NP[tr.Name].L2.Trunks = tr.Trunks
+		rv.NP[tr.Name].L2.Peer = tr.Peer
 		rv.NP[tr.Name].L2.Stp = tr.Stp                   // todo(sv): move to vendor_specific
 		rv.NP[tr.Name].L2.Bpdu_forward = tr.Bpdu_forward // todo(sv): move to vendor_specific
+		rv.NP[tr.Name].L2.BondProperties = tr.BondProperties
+		if tr.Type != "" {
+			rv.NP[tr.Name].LinkType = tr.Type
+		}
+		tr.setupLinkState(rv.NP[tr.Name])
+		tr.setupL2Properties(rv.NP[tr.Name], true)
 		if tr.Provider != "" {
 			rv.NP[tr.Name].Provider = tr.Provider
 		} else if rv.NP[tr.Name].Provider == "" {
@@ -137,6 +475,11 @@ func (s *NetworkScheme) TopologyState() *npstate.TopologyState {
 		rv.NP[key].Action = "port"
 		//todo(sv): call corresponded interface for resource
 		rv.NP[key].L2.Mtu = s.Interfaces[key].Mtu
+		if s.Interfaces[key].Type != "" {
+			rv.NP[key].LinkType = s.Interfaces[key].Type
+		}
+		iface := s.Interfaces[key]
+		iface.setupLinkState(rv.NP[key])
 		if s.Interfaces[key].Provider != "" {
 			rv.NP[key].Provider = s.Interfaces[key].Provider
 		} else {
@@ -144,6 +487,16 @@ func (s *NetworkScheme) TopologyState() *npstate.TopologyState {
 		}
 	}
 
+	// L2 properties from interfaces section should be applied to all
+	// interfaces, but properties, defined into transformations, have priority
+	for key, iface := range s.Interfaces {
+		iface.setupL2Properties(rv.NP[key], false)
+		if len(iface.Match) > 0 {
+			rv.NP[key].Match = iface.Match
+			rv.NP[key].SetName = iface.SetName
+		}
+	}
+
 	// endpoints should be processed last
 	for key, endpoint := range s.Endpoints {
 		if IndexString(rv.Order, key) < 0 {
@@ -159,6 +512,13 @@ func (s *NetworkScheme) TopologyState() *npstate.TopologyState {
 			rv.NP[key].L3.IPv4 = make([]string, len(endpoint.IP))
 			copy(rv.NP[key].L3.IPv4, endpoint.IP)
 		}
+		rv.NP[key].L3.Gateway = endpoint.Gateway
+		rv.NP[key].L3.GatewayMetric = endpoint.GatewayMetric
+		for _, route := range endpoint.Routes {
+			rv.NP[key].L3.Routes = append(rv.NP[key].L3.Routes, npstate.RouteState{To: route.Net, Via: route.Via, Metric: route.Metric})
+		}
+		rv.NP[key].L3.Nameservers = endpoint.Nameservers.Addresses
+		rv.NP[key].L3.Search = endpoint.Nameservers.Search
 	}
 
 	return rv
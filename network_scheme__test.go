@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -442,8 +443,574 @@ transformations:
 	}
 	if t.Failed() {
 		txt, _ := yaml.Marshal(nps.NP["xxx"])
-		t.Logf(string(txt))
+		t.Logf("%s", txt)
 	}
 }
 
 // -----------------------------------------------------------------------------
+
+func TestNS__Transformations__Type(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    eth0: {}
+    dummy1:
+      type: dummy
+transformations:
+  - name: dummy0
+    action: port
+    type: dummy
+  - name: eth0.101
+    action: port
+    parent: eth0
+    vlan_id: 101
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	nps := ns.TopologyState()
+	for _, m := range [][]string{
+		{"eth0", ""},
+		{"dummy0", "dummy"},
+		{"dummy1", "dummy"},
+		{"eth0.101", ""},
+	} {
+		if nps.NP[m[0]].LinkType != m[1] {
+			t.Logf("Wrong type for %s: '%v', instead '%v'", m[0], nps.NP[m[0]].LinkType, m[1])
+			t.Fail()
+		}
+	}
+}
+
+// TestNS__Transformations__Type__Deprecated -- network schemes, written
+// with 'Type' key, should keep working
+func TestNS__Transformations__Type__Deprecated(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    dummy1:
+      Type: dummy
+transformations:
+  - name: dummy0
+    action: port
+    Type: dummy
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	nps := ns.TopologyState()
+	for _, name := range []string{"dummy0", "dummy1"} {
+		if nps.NP[name].LinkType != "dummy" {
+			t.Logf("Wrong type for %s: '%v', instead 'dummy'", name, nps.NP[name].LinkType)
+			t.Fail()
+		}
+	}
+	// scheme is stored with the new key only
+	data, _ := yaml.Marshal(ns)
+	if strings.Contains(string(data), "Type:") {
+		t.Logf("Deprecated key is stored:\n%s", data)
+		t.Fail()
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func TestNS__LinkState(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    eth0: {}
+    eth1:
+      state: down
+    eth2:
+      state: unmanaged
+transformations:
+  - name: br0
+    action: bridge
+    state: down
+  - name: br1
+    action: bridge
+    state: up
+  - name: br2
+    action: bridge
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	if err := ns.Validate(); err != nil {
+		t.Logf("Unexpected validation error: %v", err)
+		t.FailNow()
+	}
+	nps := ns.TopologyState()
+	for _, m := range []struct {
+		name       string
+		online     bool
+		keepOnline bool
+	}{
+		{"eth0", true, false},
+		{"eth1", false, false},
+		{"eth2", true, true},
+		{"br0", false, false},
+		{"br1", true, false},
+		{"br2", true, false},
+	} {
+		if nps.NP[m.name].Online != m.online || nps.NP[m.name].KeepOnline != m.keepOnline {
+			t.Logf("Wrong state for %s: online=%v keep=%v, instead online=%v keep=%v", m.name,
+				nps.NP[m.name].Online, nps.NP[m.name].KeepOnline, m.online, m.keepOnline)
+			t.Fail()
+		}
+	}
+}
+
+func TestNS__LinkState__Wrong(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+transformations:
+  - name: br0
+    action: bridge
+    state: sleeping
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	if err := ns.Validate(); err == nil {
+		t.Logf("Wrong state should not pass validation")
+		t.Fail()
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func TestNS__Interfaces__L2Properties(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    eth0:
+      macaddress: 52:54:00:aa:bb:cc
+      txqueuelen: 10000
+      alias: uplink
+      ethtool:
+        offload:
+          gro: off
+          tso: on
+        ring:
+          rx: 4096
+        coalesce:
+          rx-usecs: 50
+          adaptive-rx: on
+    eth1:
+      alias: wrong
+transformations:
+  - name: eth1
+    action: port
+    alias: storage
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	if err := ns.Validate(); err != nil {
+		t.Logf("Unexpected validation error: %v", err)
+		t.FailNow()
+	}
+	nps := ns.TopologyState()
+	wantedL2 := npstate.L2State{
+		HwAddr: "52:54:00:aa:bb:cc",
+		TxQLen: 10000,
+		Alias:  "uplink",
+		Ethtool: npstate.EthtoolState{
+			Offload:  map[string]bool{"gro": false, "tso": true},
+			Ring:     map[string]int{"rx": 4096},
+			Coalesce: map[string]string{"rx-usecs": "50", "adaptive-rx": "on"},
+		},
+	}
+	if !reflect.DeepEqual(nps.NP["eth0"].L2, wantedL2) {
+		t.Logf("Wrong L2 properties for eth0: %v, instead %v", nps.NP["eth0"].L2, wantedL2)
+		t.Fail()
+	}
+	if nps.NP["eth1"].L2.Alias != "storage" {
+		t.Logf("Wrong alias for eth1: '%s', instead 'storage'", nps.NP["eth1"].L2.Alias)
+		t.Fail()
+	}
+}
+
+func TestNS__Interfaces__L2Properties__Wrong(t *testing.T) {
+	for _, nsText := range []string{`
+interfaces:
+    eth0:
+      macaddress: 52:54:00:aa:bb
+`, `
+interfaces:
+    eth0:
+      ethtool:
+        offload:
+          xxx: on
+`, `
+interfaces:
+    eth0:
+      ethtool:
+        ring:
+          rx-mega: 100
+`} {
+		ns := new(NetworkScheme)
+		if err := ns.Load(strings.NewReader(nsText)); err != nil {
+			t.FailNow()
+		}
+		if err := ns.Validate(); err == nil {
+			t.Logf("Wrong network scheme should not pass validation:\n%s", nsText)
+			t.Fail()
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func TestNS__Interfaces__Match(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    uplink:
+      match:
+        macaddress: 52:54:00:aa:bb:cc
+    storage0:
+      match:
+        driver: ixgbe
+        path: pci-0000:03:00.0
+      set-name: storage0
+    eth2: {}
+transformations:
+  - name: br0
+    action: bridge
+  - name: uplink
+    action: port
+    bridge: br0
+  - name: uplink.101
+    action: port
+    parent: uplink
+    vlan_id: 101
+  - name: bond0
+    action: bond
+    slaves:
+      - storage0
+      - eth2
+endpoints:
+    uplink.101:
+      IP:
+        - '10.1.3.11/24'
+    uplink:
+      IP:
+        - '10.1.4.11/24'
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	if err := ns.Validate(); err != nil {
+		t.Logf("Unexpected validation error: %v", err)
+		t.FailNow()
+	}
+	err := ns.ResolveInterfaces(func(match map[string]string, setName string) (string, error) {
+		if match["macaddress"] == "52:54:00:aa:bb:cc" {
+			return "enp1s0", nil
+		}
+		return setName, nil
+	})
+	if err != nil {
+		t.Logf("Unexpected resolving error: %v", err)
+		t.FailNow()
+	}
+	nps := ns.TopologyState()
+	wantedOrder := []string{"eth2", "storage0", "br0", "enp1s0", "uplink.101", "bond0"}
+	if !reflect.DeepEqual(nps.Order, wantedOrder) {
+		t.Logf("Wrong ordering: %v, instead %v", nps.Order, wantedOrder)
+		t.Fail()
+	}
+	if nps.NP["enp1s0"].L2.Bridge != "br0" || nps.NP["enp1s0"].Match["macaddress"] != "52:54:00:aa:bb:cc" {
+		t.Logf("Wrong resolved interface:\n%s", nps.NP["enp1s0"])
+		t.Fail()
+	}
+	if nps.NP["uplink.101"].L2.Parent != "enp1s0" {
+		t.Logf("Wrong parent for 'uplink.101': %s", nps.NP["uplink.101"].L2.Parent)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(nps.NP["enp1s0"].L3.IPv4, []string{"10.1.4.11/24"}) {
+		t.Logf("Wrong IP addresses for 'enp1s0': %v", nps.NP["enp1s0"].L3.IPv4)
+		t.Fail()
+	}
+	if nps.NP["storage0"].SetName != "storage0" {
+		t.Logf("Wrong set-name for 'storage0': %s", nps.NP["storage0"].SetName)
+		t.Fail()
+	}
+}
+
+func TestNS__Interfaces__Match__Wrong(t *testing.T) {
+	for _, nsText := range []string{`
+interfaces:
+    eth0:
+      match:
+        name: eth*
+`, `
+interfaces:
+    eth0:
+      set-name: eth0
+`, `
+transformations:
+  - name: eth0
+    action: port
+    match:
+      driver: e1000
+`} {
+		ns := new(NetworkScheme)
+		if err := ns.Load(strings.NewReader(nsText)); err != nil {
+			t.FailNow()
+		}
+		if err := ns.Validate(); err == nil {
+			t.Logf("Wrong network scheme should not pass validation:\n%s", nsText)
+			t.Fail()
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func TestNS__Providers(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    eth0: {}
+    eth1:
+      provider: lnx
+transformations:
+  - name: br-ex
+    action: bridge
+    provider: ovs
+  - name: br1
+    action: bridge
+    provider: aaa
+  - name: bond0
+    action: bond
+    provider: ovs
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	providers := ns.Providers()
+	wanted := []string{"lnx", "aaa", "ovs"}
+	if !reflect.DeepEqual(providers, wanted) {
+		t.Logf("Wrong providers list: %v, instead %v", providers, wanted)
+		t.Fail()
+	}
+}
+
+func TestNS__Providers__Unknown(t *testing.T) {
+	for _, nsText := range []string{`
+provider: unknown
+interfaces:
+    eth0: {}
+`, `
+interfaces:
+    eth0:
+      provider: unknown
+`, `
+transformations:
+  - name: br0
+    action: bridge
+    provider: unknown
+`} {
+		ns := new(NetworkScheme)
+		if err := ns.Load(strings.NewReader(nsText)); err != nil {
+			t.FailNow()
+		}
+		if err := ns.Validate(); err == nil {
+			t.Logf("Unknown provider should not pass validation:\n%s", nsText)
+			t.Fail()
+		}
+	}
+}
+
+func TestNS__OvsProperties(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    eth1: {}
+transformations:
+  - name: br-int
+    action: bridge
+    provider: ovs
+  - name: patch-int
+    action: patch
+    bridge: br-int
+    peer: patch-ex
+    provider: ovs
+  - name: vm1
+    action: port
+    type: internal
+    bridge: br-int
+    vlan_id: 101
+    provider: ovs
+  - name: trunk1
+    action: port
+    type: internal
+    bridge: br-int
+    trunks: [101, 102]
+    provider: ovs
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	if err := ns.Validate(); err != nil {
+		t.Logf("Network scheme with OVS provider should pass validation: %v", err)
+		t.FailNow()
+	}
+	nps := ns.TopologyState()
+	if nps.NP["patch-int"].L2.Peer != "patch-ex" || nps.NP["patch-int"].Provider != "ovs" {
+		t.Logf("Wrong patch: %v", nps.NP["patch-int"])
+		t.Fail()
+	}
+	if nps.NP["vm1"].L2.Vlan_id != 101 || nps.NP["vm1"].LinkType != "internal" {
+		t.Logf("Wrong internal port: %v", nps.NP["vm1"])
+		t.Fail()
+	}
+	if !reflect.DeepEqual(nps.NP["trunk1"].L2.Trunks, []int{101, 102}) {
+		t.Logf("Wrong trunks: %v", nps.NP["trunk1"].L2.Trunks)
+		t.Fail()
+	}
+	if nps.NP["eth1"].Provider != "lnx" {
+		t.Logf("Wrong provider for eth1: %s", nps.NP["eth1"].Provider)
+		t.Fail()
+	}
+}
+
+func TestNS__BondProperties__Endpoints(t *testing.T) {
+	ns := new(NetworkScheme)
+	ns_data := strings.NewReader(`
+version: 1.1
+provider: lnx
+interfaces:
+    eth1: {}
+    eth2: {}
+transformations:
+  - name: bond0
+    action: bond
+    slaves: [eth1, eth2]
+    bond_properties:
+      mode: 802.3ad
+      miimon: 100
+endpoints:
+    bond0:
+      IP: [10.1.1.2/24]
+      gateway: 10.1.1.1
+      gateway_metric: 10
+      routes:
+        - net: 192.168.0.0/16
+          via: 10.1.1.254
+          metric: 100
+      nameservers:
+        addresses: [10.1.1.1]
+        search: [example.com]
+`)
+	if err := ns.Load(ns_data); err != nil {
+		t.FailNow()
+	}
+	if err := ns.Validate(); err != nil {
+		t.Logf("Unexpected validation error: %v", err)
+		t.FailNow()
+	}
+	bond := ns.TopologyState().NP["bond0"]
+	if !reflect.DeepEqual(bond.L2.BondProperties, map[string]string{"mode": "802.3ad", "miimon": "100"}) {
+		t.Logf("Wrong bond properties: %v", bond.L2.BondProperties)
+		t.Fail()
+	}
+	wantedL3 := npstate.L3State{
+		IPv4:          []string{"10.1.1.2/24"},
+		Gateway:       "10.1.1.1",
+		GatewayMetric: 10,
+		Routes:        []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.1.1.254", Metric: 100}},
+		Nameservers:   []string{"10.1.1.1"},
+		Search:        []string{"example.com"},
+	}
+	if !reflect.DeepEqual(bond.L3, wantedL3) {
+		t.Logf("Wrong L3 properties for bond0: %v, instead %v", bond.L3, wantedL3)
+		t.Fail()
+	}
+}
+
+func TestNS__BondProperties__Endpoints__Wrong(t *testing.T) {
+	for _, nsText := range []string{`
+transformations:
+  - name: bond0
+    action: bond
+    bond_properties:
+      xxx: 1
+`, `
+transformations:
+  - name: br0
+    action: bridge
+    bond_properties:
+      mode: active-backup
+`, `
+interfaces:
+    eth0: {}
+endpoints:
+    eth0:
+      IP: [10.1.1.2/24]
+      gateway: 10.1.1
+`, `
+interfaces:
+    eth0: {}
+endpoints:
+    eth0:
+      IP: [10.1.1.2/24]
+      routes:
+        - net: 192.168.0.0
+          via: 10.1.1.254
+`} {
+		ns := new(NetworkScheme)
+		if err := ns.Load(strings.NewReader(nsText)); err != nil {
+			t.FailNow()
+		}
+		if err := ns.Validate(); err == nil {
+			t.Logf("Wrong network scheme should not pass validation:\n%s", nsText)
+			t.Fail()
+		}
+	}
+}
+
+func TestNS__ConfigProvider(t *testing.T) {
+	for _, m := range []struct {
+		nsText string
+		name   string
+		valid  bool
+	}{
+		{"interfaces:\n    eth0: {}\n", plugin.DefaultConfigProvider, true},
+		{"config-provider: netplan\ninterfaces:\n    eth0: {}\n", "netplan", true},
+		{"config-provider: unknown\ninterfaces:\n    eth0: {}\n", "unknown", false},
+	} {
+		ns := new(NetworkScheme)
+		if err := ns.Load(strings.NewReader(m.nsText)); err != nil {
+			t.FailNow()
+		}
+		if err := ns.Validate(); (err == nil) != m.valid {
+			t.Logf("Wrong validation result for:\n%s%v", m.nsText, err)
+			t.Fail()
+		}
+		if name := ns.ConfigProviderName(); name != m.name {
+			t.Logf("Wrong config provider '%s', instead '%s'", name, m.name)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package networkd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	MsgPrefix         = "Networkd plugin"
+	DefaultConfigPath = "/etc/systemd/network"
+	// FilePrefix -- prefix of files, generated by l23network. Files are
+	// processed by systemd in lexical order, so generated ones should win
+	FilePrefix = "10-l23-"
+)
+
+// bond parameters of systemd.netdev(5) for bonding properties of l23network
+var bondOptions = map[string]string{
+	"mode":             "Mode",
+	"miimon":           "MIIMonitorSec",
+	"lacp_rate":        "LACPTransmitRate",
+	"xmit_hash_policy": "TransmitHashPolicy",
+	"updelay":          "UpDelaySec",
+	"downdelay":        "DownDelaySec",
+	"ad_select":        "AdSelect",
+}
+
+// match keys of systemd.network(5) and systemd.link(5)
+var matchOptions = map[string]string{
+	"macaddress": "MACAddress",
+	"driver":     "Driver",
+	"path":       "Path",
+}
+
+// -----------------------------------------------------------------------------
+
+// Section -- section of systemd unit file
+type Section struct {
+	Name    string
+	Options []string // "Key=Value" in order of definition
+}
+
+func (s *Section) add(key string, value interface{}) {
+	s.Options = append(s.Options, fmt.Sprintf("%s=%v", key, value))
+}
+
+// UnitFile -- .netdev, .network or .link file
+type UnitFile struct {
+	Name     string
+	Sections []*Section
+}
+
+// section -- returns first section with given name, creates it if required
+func (s *UnitFile) section(name string) *Section {
+	for _, section := range s.Sections {
+		if section.Name == name {
+			return section
+		}
+	}
+	return s.addSection(name)
+}
+
+// addSection -- append new section. Required for sections, which can be
+// repeated, like [Route]
+func (s *UnitFile) addSection(name string) *Section {
+	rv := &Section{Name: name}
+	s.Sections = append(s.Sections, rv)
+	return rv
+}
+
+func (s *UnitFile) String() string {
+	rv := []string{GeneratedHeader}
+	for _, section := range s.Sections {
+		rv = append(rv, fmt.Sprintf("[%s]\n%s\n", section.Name, strings.Join(section.Options, "\n")))
+	}
+	return strings.Join(rv, "\n")
+}
+
+// -----------------------------------------------------------------------------
+
+type SavedConfig struct {
+	log         *logger.Logger
+	wantedState *npstate.NPStates
+	Units       map[string]*UnitFile
+}
+
+func (s *SavedConfig) CheckWS() (rv error) {
+	if s.wantedState == nil {
+		errMsg := "Wanted states of Network primitives are not set."
+		s.log.Error("%s: %s", MsgPrefix, errMsg)
+		rv = errors.New(errMsg)
+	}
+	return rv
+}
+
+// unit -- returns unit file for network primitive, creates it if required.
+// Kind is the file extension: netdev, network or link
+func (s *SavedConfig) unit(name, kind string) *UnitFile {
+	fileName := fmt.Sprintf("%s%s.%s", FilePrefix, name, kind)
+	if _, ok := s.Units[fileName]; !ok {
+		s.Units[fileName] = &UnitFile{Name: fileName}
+	}
+	return s.Units[fileName]
+}
+
+// network -- returns .network file for interface, which is matched by name
+func (s *SavedConfig) network(name string) *UnitFile {
+	rv := s.unit(name, "network")
+	if len(rv.Sections) == 0 {
+		rv.section("Match").add("Name", name)
+	}
+	return rv
+}
+
+// netdev -- describe virtual network device of given kind
+func (s *SavedConfig) netdev(np *npstate.NPState, kind string) *UnitFile {
+	rv := s.unit(np.Name, "netdev")
+	netdev := rv.section("NetDev")
+	netdev.add("Name", np.Name)
+	netdev.add("Kind", kind)
+	if np.L2.Mtu > 0 {
+		netdev.add("MTUBytes", np.L2.Mtu)
+	}
+	if np.L2.HwAddr != "" {
+		netdev.add("MACAddress", np.L2.HwAddr)
+	}
+	return rv
+}
+
+// setupMatch -- setup rules to find physical interface. Interface, found
+// by rules, is renamed by .link file if required
+func (s *SavedConfig) setupMatch(np *npstate.NPState) *UnitFile {
+	rv := s.unit(np.Name, "network")
+	match := rv.section("Match")
+	// interface can be referenced by name before, as vlan parent or bond slave
+	match.Options = []string{}
+	for _, key := range SortedKeys(np.Match) {
+		match.add(matchOptions[key], np.Match[key])
+	}
+	if np.SetName != "" {
+		link := s.unit(np.Name, "link")
+		link.Sections = []*Section{{Name: "Match", Options: append([]string{}, match.Options...)}}
+		link.section("Link").add("Name", np.SetName)
+	}
+	return rv
+}
+
+// setupL2Properties -- describe L2 properties of physical interface.
+// Txqueuelen, alias and ethtool settings are not stored
+func (s *SavedConfig) setupL2Properties(network *UnitFile, np *npstate.NPState) {
+	if np.L2.Mtu > 0 {
+		network.section("Link").add("MTUBytes", np.L2.Mtu)
+	}
+	if np.L2.HwAddr != "" {
+		network.section("Link").add("MACAddress", np.L2.HwAddr)
+	}
+}
+
+// setupL3Properties -- describe addresses, default gateway, routes and
+// DNS settings
+func (s *SavedConfig) setupL3Properties(network *UnitFile, np *npstate.NPState) {
+	section := network.section("Network")
+	section.add("LinkLocalAddressing", "no")
+	section.add("IPv6AcceptRA", "no")
+	for _, addr := range np.L3.IPv4 {
+		section.add("Address", addr)
+	}
+	if np.L3.Gateway != "" && np.L3.GatewayMetric == 0 {
+		section.add("Gateway", np.L3.Gateway)
+	}
+	for _, ns := range np.L3.Nameservers {
+		section.add("DNS", ns)
+	}
+	if len(np.L3.Search) > 0 {
+		section.add("Domains", strings.Join(np.L3.Search, " "))
+	}
+	routes := np.L3.Routes
+	if np.L3.Gateway != "" && np.L3.GatewayMetric > 0 {
+		routes = append([]npstate.RouteState{{To: "0.0.0.0/0", Via: np.L3.Gateway, Metric: np.L3.GatewayMetric}}, routes...)
+	}
+	for _, r := range routes {
+		route := network.addSection("Route")
+		route.add("Destination", r.To)
+		route.add("Gateway", r.Via)
+		if r.Metric > 0 {
+			route.add("Metric", r.Metric)
+		}
+	}
+}
+
+// setupBondProperties -- bond properties, primary slave is described by
+// .network file of slave
+func (s *SavedConfig) setupBondProperties(netdev *UnitFile, np *npstate.NPState) {
+	if len(np.L2.BondProperties) == 0 {
+		return
+	}
+	bond := netdev.section("Bond")
+	for _, key := range SortedKeys(np.L2.BondProperties) {
+		value := np.L2.BondProperties[key]
+		switch key {
+		case "primary":
+			s.network(value).section("Network").add("PrimarySlave", "yes")
+			continue
+		case "miimon", "updelay", "downdelay":
+			// kernel uses milliseconds, systemd -- seconds by default
+			if _, err := strconv.Atoi(value); err == nil {
+				value += "ms"
+			}
+		}
+		bond.add(bondOptions[key], value)
+	}
+}
+
+func (s *SavedConfig) Generate() error {
+	if err := s.CheckWS(); err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range *s.wantedState {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		np := (*s.wantedState)[name]
+		if np.Provider != "" && np.Provider != "lnx" {
+			// networkd can describe only linux kernel network primitives
+			s.log.Warn("%s: '%s' is managed by provider '%s' and can't be stored, skipped.", MsgPrefix, np.Name, np.Provider)
+			continue
+		}
+		var network *UnitFile
+		switch np.Action {
+		case "port":
+			switch {
+			case np.L2.Vlan_id != 0:
+				netdev := s.netdev(np, "vlan")
+				netdev.section("VLAN").add("Id", np.L2.Vlan_id)
+				s.network(np.L2.Parent).section("Network").add("VLAN", np.Name)
+			case np.LinkType == "dummy":
+				s.netdev(np, "dummy")
+			case len(np.Match) > 0:
+				network = s.setupMatch(np)
+				s.setupL2Properties(network, np)
+			default:
+				network = s.network(np.Name)
+				s.setupL2Properties(network, np)
+			}
+		case "bridge":
+			netdev := s.netdev(np, "bridge")
+			netdev.section("Bridge").add("STP", boolValue(np.L2.Stp))
+		case "bond":
+			netdev := s.netdev(np, "bond")
+			s.setupBondProperties(netdev, np)
+			for _, slave := range np.L2.Slaves {
+				s.network(slave).section("Network").add("Bond", np.Name)
+			}
+		default:
+			errMsg := fmt.Sprintf("Unsupported 'action' for '%s'.", np.Name)
+			s.log.Error("%s: %s", MsgPrefix, errMsg)
+			return errors.New(errMsg)
+		}
+		if network == nil {
+			network = s.network(np.Name)
+		}
+		if !np.Online && !np.KeepOnline {
+			network.section("Link").add("ActivationPolicy", "down")
+		}
+		if np.L2.Bridge != "" {
+			network.section("Network").add("Bridge", np.L2.Bridge)
+		}
+		s.setupL3Properties(network, np)
+	}
+	return nil
+}
+
+func (s *SavedConfig) String() string {
+	rv := []string{}
+	for _, name := range s.names() {
+		rv = append(rv, fmt.Sprintf("# ==> %s <==\n%s", name, s.Units[name]))
+	}
+	return strings.Join(rv, "\n")
+}
+
+func (s *SavedConfig) names() []string {
+	rv := []string{}
+	for name := range s.Units {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func (s *SavedConfig) DefaultPath() string {
+	return DefaultConfigPath
+}
+
+// Files -- each network device is described by own .netdev, .network and
+// .link files into given directory
+func (s *SavedConfig) Files(path string) []ConfigFile {
+	rv := []ConfigFile{}
+	for _, name := range s.names() {
+		rv = append(rv, ConfigFile{
+			Path:    filepath.Join(path, name),
+			Content: []byte(s.Units[name].String()),
+			Mode:    0644,
+		})
+	}
+	return rv
+}
+
+// GeneratedFiles -- returns files into given directory, which were
+// generated by l23network. Hand-written files with the same prefix are
+// kept, they have no header
+func (s *SavedConfig) GeneratedFiles(path string) ([]string, error) {
+	return GeneratedFiles(path, FilePrefix+"*")
+}
+
+func (s *SavedConfig) SetWantedState(wantedState *npstate.NPStates) {
+	s.wantedState = wantedState
+}
+
+func (s *SavedConfig) SetLogger(log *logger.Logger) {
+	if log != nil {
+		s.log = log
+	} else {
+		s.log = new(logger.Logger)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+func boolValue(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+func NewSavedConfig(log *logger.Logger) *SavedConfig {
+	rv := &SavedConfig{
+		Units: make(map[string]*UnitFile),
+	}
+	rv.SetLogger(log)
+	return rv
+}
+
+func NewNetworkdConfigPlugin() ConfigPlugin {
+	return NewSavedConfig(nil)
+}
+
+func init() {
+	RegisterConfigPlugin("networkd", NewNetworkdConfigPlugin)
+}
@@ -0,0 +1,165 @@
+package networkd
+
+import (
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+	"github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/utils"
+	"github.com/xenolog/l23/utils/configtest"
+)
+
+func Test__L3_without_addresses(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				Gateway:       "10.10.10.1",
+				GatewayMetric: 100,
+				Routes:        []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.20.20.254", Metric: 10}},
+				Nameservers:   []string{"8.8.8.8", "1.1.1.1"},
+				Search:        []string{"example.com"},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	// networkd has no metric for 'Gateway', so gateway with metric is
+	// described as default route
+	td.CmpDeeply(t, savedConfig.Units["10-l23-eth1.network"].String(), `# Generated by l23network, don't edit manually
+
+[Match]
+Name=eth1
+
+[Network]
+LinkLocalAddressing=no
+IPv6AcceptRA=no
+DNS=8.8.8.8
+DNS=1.1.1.1
+Domains=example.com
+
+[Route]
+Destination=0.0.0.0/0
+Gateway=10.10.10.1
+Metric=100
+
+[Route]
+Destination=192.168.0.0/16
+Gateway=10.20.20.254
+Metric=10
+`)
+}
+
+func Test__Vlan_on_bond_into_bridge(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2:     npstate.L2State{Slaves: []string{"eth2", "eth3"}},
+		},
+		"bond0.101": &npstate.NPState{
+			Name:   "bond0.101",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Parent:  "bond0",
+				Vlan_id: 101,
+				Bridge:  "br1",
+			},
+		},
+		"br1": &npstate.NPState{
+			Name:   "br1",
+			Action: "bridge",
+			Online: true,
+			L2: npstate.L2State{
+				Mtu: 1500,
+				Stp: true,
+			},
+			L3: npstate.L3State{
+				IPv4: []string{"10.1.1.1/24"},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.names(), []string{
+		"10-l23-bond0.101.netdev", "10-l23-bond0.101.network",
+		"10-l23-bond0.netdev", "10-l23-bond0.network",
+		"10-l23-br1.netdev", "10-l23-br1.network",
+		"10-l23-eth2.network", "10-l23-eth3.network",
+	})
+	td.CmpDeeply(t, savedConfig.Units["10-l23-br1.netdev"].Sections, []*Section{
+		{Name: "NetDev", Options: []string{"Name=br1", "Kind=bridge", "MTUBytes=1500"}},
+		{Name: "Bridge", Options: []string{"STP=yes"}},
+	})
+	td.CmpDeeply(t, savedConfig.Units["10-l23-bond0.101.netdev"].Sections, []*Section{
+		{Name: "NetDev", Options: []string{"Name=bond0.101", "Kind=vlan"}},
+		{Name: "VLAN", Options: []string{"Id=101"}},
+	})
+	td.CmpDeeply(t, savedConfig.Units["10-l23-bond0.101.network"].section("Network").Options, []string{
+		"Bridge=br1", "LinkLocalAddressing=no", "IPv6AcceptRA=no",
+	})
+	// vlan is attached by .network file of bond, L3 settings of bond
+	// should not be lost
+	td.CmpDeeply(t, savedConfig.Units["10-l23-bond0.network"].section("Network").Options, []string{
+		"LinkLocalAddressing=no", "IPv6AcceptRA=no", "VLAN=bond0.101",
+	})
+}
+
+func Test__Bond_slaves_without_primitives(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2: npstate.L2State{
+				Slaves: []string{"eth2", "eth3"},
+				BondProperties: map[string]string{
+					"mode": "active-backup", "miimon": "100", "primary": "eth3",
+				},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Units["10-l23-bond0.netdev"].section("Bond").Options, []string{
+		"MIIMonitorSec=100ms", "Mode=active-backup",
+	})
+	td.CmpDeeply(t, savedConfig.Units["10-l23-eth2.network"].section("Network").Options, []string{"Bond=bond0"})
+	td.CmpDeeply(t, savedConfig.Units["10-l23-eth3.network"].section("Network").Options, []string{"PrimarySlave=yes", "Bond=bond0"})
+}
+
+func Test__Match_and_rename(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:    "eth1",
+			Action:  "port",
+			Online:  false,
+			Match:   map[string]string{"macaddress": "52:54:00:12:34:56"},
+			SetName: "lan0",
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Units["10-l23-eth1.link"].Sections, []*Section{
+		{Name: "Match", Options: []string{"MACAddress=52:54:00:12:34:56"}},
+		{Name: "Link", Options: []string{"Name=lan0"}},
+	})
+	td.CmpDeeply(t, savedConfig.Units["10-l23-eth1.network"].Sections[:2], []*Section{
+		{Name: "Match", Options: []string{"MACAddress=52:54:00:12:34:56"}},
+		{Name: "Link", Options: []string{"ActivationPolicy=down"}},
+	})
+}
+
+func Test__Hand_written_files_are_kept(t *testing.T) {
+	files := configtest.GeneratedFiles(t, NewSavedConfig(nil), map[string]string{
+		"10-l23-eth1.network": utils.GeneratedHeader + "[Match]\nName=eth1\n",
+		"10-l23-br1.netdev":   utils.GeneratedHeader,
+		"10-l23-manual.link":  "[Match]\nName=eth9\n",
+		"20-foreign.network":  utils.GeneratedHeader,
+	})
+	td.CmpDeeply(t, files, []string{"10-l23-br1.netdev", "10-l23-eth1.network"})
+}
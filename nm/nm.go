@@ -0,0 +1,361 @@
+package nm
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	MsgPrefix         = "NetworkManager plugin"
+	DefaultConfigPath = "/etc/NetworkManager/system-connections"
+	// FilePrefix -- prefix of files and connection IDs, generated by l23network
+	FilePrefix = "l23-"
+	FileSuffix = ".nmconnection"
+)
+
+// -----------------------------------------------------------------------------
+
+// Section -- section of keyfile
+type Section struct {
+	Name    string
+	Options []string // "key=value" in order of definition
+}
+
+func (s *Section) add(key string, value interface{}) {
+	s.Options = append(s.Options, fmt.Sprintf("%s=%v", key, value))
+}
+
+// Keyfile -- NetworkManager connection, described by keyfile
+type Keyfile struct {
+	Name     string
+	Sections []*Section
+}
+
+// section -- returns section with given name, creates it if required
+func (s *Keyfile) section(name string) *Section {
+	for _, section := range s.Sections {
+		if section.Name == name {
+			return section
+		}
+	}
+	rv := &Section{Name: name}
+	s.Sections = append(s.Sections, rv)
+	return rv
+}
+
+func (s *Keyfile) String() string {
+	rv := []string{GeneratedHeader}
+	for _, section := range s.Sections {
+		rv = append(rv, fmt.Sprintf("[%s]\n%s\n", section.Name, strings.Join(section.Options, "\n")))
+	}
+	return strings.Join(rv, "\n")
+}
+
+// -----------------------------------------------------------------------------
+
+type SavedConfig struct {
+	log         *logger.Logger
+	wantedState *npstate.NPStates
+	Connections map[string]*Keyfile
+}
+
+func (s *SavedConfig) CheckWS() (rv error) {
+	if s.wantedState == nil {
+		errMsg := "Wanted states of Network primitives are not set."
+		s.log.Error("%s: %s", MsgPrefix, errMsg)
+		rv = errors.New(errMsg)
+	}
+	return rv
+}
+
+// masters -- returns bonds for slaves
+func (s *SavedConfig) masters() map[string]string {
+	rv := make(map[string]string)
+	for _, np := range *s.wantedState {
+		if np.Action == "bond" {
+			for _, slave := range np.L2.Slaves {
+				rv[slave] = np.Name
+			}
+		}
+	}
+	return rv
+}
+
+// connection -- returns connection for interface, creates it if required
+func (s *SavedConfig) connection(name, connType string, autoconnect bool) *Keyfile {
+	if rv, ok := s.Connections[name]; ok {
+		return rv
+	}
+	rv := &Keyfile{Name: name}
+	connection := rv.section("connection")
+	connection.add("id", FilePrefix+name)
+	connection.add("uuid", uuid(name))
+	connection.add("type", connType)
+	connection.add("interface-name", name)
+	connection.add("autoconnect", autoconnect)
+	s.Connections[name] = rv
+	return rv
+}
+
+// setupMatch -- setup rules to find physical interface. NetworkManager has
+// no ability to rename interface
+func (s *SavedConfig) setupMatch(conn *Keyfile, np *npstate.NPState) {
+	if len(np.Match) == 0 {
+		return
+	}
+	// interface is found by rules, not by name
+	options := conn.section("connection").Options
+	for i, option := range options {
+		if strings.HasPrefix(option, "interface-name=") {
+			conn.section("connection").Options = append(options[:i], options[i+1:]...)
+			break
+		}
+	}
+	for _, key := range SortedKeys(np.Match) {
+		switch key {
+		case "macaddress":
+			conn.section("ethernet").add("mac-address", np.Match[key])
+		default:
+			conn.section("match").add(key, np.Match[key])
+		}
+	}
+	if np.SetName != "" {
+		s.log.Warn("%s: NetworkManager has no ability to rename interface, 'set-name' for '%s' skipped.", MsgPrefix, np.Name)
+	}
+}
+
+// setupL2Properties -- describe L2 properties of network primitive.
+// Txqueuelen and alias are not supported by NetworkManager and can't be stored
+func (s *SavedConfig) setupL2Properties(conn *Keyfile, np *npstate.NPState) {
+	if np.L2.Mtu > 0 {
+		conn.section("ethernet").add("mtu", np.L2.Mtu)
+	}
+	if np.L2.HwAddr != "" {
+		conn.section("ethernet").add("cloned-mac-address", np.L2.HwAddr)
+	}
+	for _, key := range npstate.EthtoolOffloads {
+		if value, ok := np.L2.Ethtool.Offload[key]; ok {
+			conn.section("ethtool").add("feature-"+key, value)
+		}
+	}
+	for _, key := range npstate.EthtoolRings {
+		if value, ok := np.L2.Ethtool.Ring[key]; ok {
+			conn.section("ethtool").add("ring-"+key, value)
+		}
+	}
+	for _, key := range npstate.EthtoolCoalesces {
+		if value, ok := np.L2.Ethtool.Coalesce[key]; ok {
+			// NetworkManager accepts numbers only
+			switch value {
+			case "on":
+				value = "1"
+			case "off":
+				value = "0"
+			}
+			conn.section("ethtool").add("coalesce-"+key, value)
+		}
+	}
+}
+
+// setupSlave -- connection of bridge member or bond slave has no IP settings
+func (s *SavedConfig) setupSlave(conn *Keyfile, master, slaveType string) {
+	connection := conn.section("connection")
+	connection.add("master", master)
+	connection.add("slave-type", slaveType)
+}
+
+// setupL3Properties -- describe addresses, default gateway, routes and
+// DNS settings. NetworkManager rejects connections with gateway, routes or
+// DNS settings for disabled IPv4, so they are skipped for primitive
+// without addresses
+func (s *SavedConfig) setupL3Properties(conn *Keyfile, np *npstate.NPState) {
+	ipv4 := conn.section("ipv4")
+	conn.section("ipv6").add("method", "disabled")
+	if len(np.L3.IPv4) == 0 {
+		ipv4.add("method", "disabled")
+		if np.L3.Gateway != "" || len(np.L3.Routes) > 0 || len(np.L3.Nameservers) > 0 || len(np.L3.Search) > 0 {
+			s.log.Warn("%s: '%s' has no addresses, its gateway, routes and DNS settings can't be stored, skipped.", MsgPrefix, np.Name)
+		}
+		return
+	}
+	ipv4.add("method", "manual")
+	for i, addr := range np.L3.IPv4 {
+		ipv4.add(fmt.Sprintf("address%d", i+1), addr)
+	}
+	if np.L3.Gateway != "" {
+		ipv4.add("gateway", np.L3.Gateway)
+		if np.L3.GatewayMetric > 0 {
+			ipv4.add("route-metric", np.L3.GatewayMetric)
+		}
+	}
+	for i, route := range np.L3.Routes {
+		r := fmt.Sprintf("%s,%s", route.To, route.Via)
+		if route.Metric > 0 {
+			r += fmt.Sprintf(",%d", route.Metric)
+		}
+		ipv4.add(fmt.Sprintf("route%d", i+1), r)
+	}
+	if len(np.L3.Nameservers) > 0 {
+		ipv4.add("dns", strings.Join(np.L3.Nameservers, ";")+";")
+	}
+	if len(np.L3.Search) > 0 {
+		ipv4.add("dns-search", strings.Join(np.L3.Search, ";")+";")
+	}
+}
+
+func (s *SavedConfig) Generate() error {
+	if err := s.CheckWS(); err != nil {
+		return err
+	}
+	masters := s.masters()
+	for _, np := range *s.wantedState {
+		if np.Provider != "" && np.Provider != "lnx" {
+			// NetworkManager can describe only linux kernel network primitives
+			s.log.Warn("%s: '%s' is managed by provider '%s' and can't be stored, skipped.", MsgPrefix, np.Name, np.Provider)
+			continue
+		}
+		autoconnect := np.Online && !np.KeepOnline
+		var conn *Keyfile
+		switch np.Action {
+		case "port":
+			switch {
+			case np.Name == "lo":
+				// loopback is not managed by NetworkManager
+				continue
+			case np.L2.Vlan_id != 0:
+				conn = s.connection(np.Name, "vlan", autoconnect)
+				vlan := conn.section("vlan")
+				vlan.add("id", np.L2.Vlan_id)
+				vlan.add("parent", np.L2.Parent)
+			case np.LinkType == "dummy":
+				conn = s.connection(np.Name, "dummy", autoconnect)
+			default:
+				conn = s.connection(np.Name, "ethernet", autoconnect)
+				s.setupMatch(conn, np)
+			}
+		case "bridge":
+			conn = s.connection(np.Name, "bridge", autoconnect)
+			conn.section("bridge").add("stp", np.L2.Stp)
+		case "bond":
+			conn = s.connection(np.Name, "bond", autoconnect)
+			bond := conn.section("bond")
+			for _, key := range npstate.BondProperties {
+				if value, ok := np.L2.BondProperties[key]; ok {
+					bond.add(key, value)
+				}
+			}
+		default:
+			errMsg := fmt.Sprintf("Unsupported 'action' for '%s'.", np.Name)
+			s.log.Error("%s: %s", MsgPrefix, errMsg)
+			return errors.New(errMsg)
+		}
+		s.setupL2Properties(conn, np)
+		switch bond, ok := masters[np.Name]; {
+		case ok:
+			s.setupSlave(conn, bond, "bond")
+		case np.L2.Bridge != "":
+			s.setupSlave(conn, np.L2.Bridge, "bridge")
+		default:
+			s.setupL3Properties(conn, np)
+		}
+	}
+
+	// slaves of bond, which are not described, should be described to be
+	// enslaved while booting
+	for slave, bond := range masters {
+		if _, ok := s.Connections[slave]; !ok {
+			s.setupSlave(s.connection(slave, "ethernet", true), bond, "bond")
+		}
+	}
+	return nil
+}
+
+func (s *SavedConfig) String() string {
+	rv := []string{}
+	for _, file := range s.Files(DefaultConfigPath) {
+		rv = append(rv, fmt.Sprintf("# ==> %s <==\n%s", file.Path, file.Content))
+	}
+	return strings.Join(rv, "\n")
+}
+
+func (s *SavedConfig) names() []string {
+	rv := []string{}
+	for name := range s.Connections {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func (s *SavedConfig) DefaultPath() string {
+	return DefaultConfigPath
+}
+
+// Files -- each connection is stored into own keyfile. NetworkManager
+// ignores keyfiles, which are readable by other users
+func (s *SavedConfig) Files(path string) []ConfigFile {
+	rv := []ConfigFile{}
+	for _, name := range s.names() {
+		rv = append(rv, ConfigFile{
+			Path:    filepath.Join(path, FilePrefix+name+FileSuffix),
+			Content: []byte(s.Connections[name].String()),
+			Mode:    0600,
+		})
+	}
+	return rv
+}
+
+// GeneratedFiles -- returns keyfiles into given directory, which were
+// generated by l23network. Hand-written keyfiles with the same name are
+// kept, they have no header
+func (s *SavedConfig) GeneratedFiles(path string) ([]string, error) {
+	return GeneratedFiles(path, FilePrefix+"*"+FileSuffix)
+}
+
+func (s *SavedConfig) SetWantedState(wantedState *npstate.NPStates) {
+	s.wantedState = wantedState
+}
+
+func (s *SavedConfig) SetLogger(log *logger.Logger) {
+	if log != nil {
+		s.log = log
+	} else {
+		s.log = new(logger.Logger)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// uuid -- returns name-based UUID of connection, so UUID is not changed
+// while config regeneration
+func uuid(name string) string {
+	h := md5.Sum([]byte("l23network:" + name))
+	h[6] = (h[6] & 0x0f) | 0x30 // version 3
+	h[8] = (h[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func NewSavedConfig(log *logger.Logger) *SavedConfig {
+	rv := &SavedConfig{
+		Connections: make(map[string]*Keyfile),
+	}
+	rv.SetLogger(log)
+	return rv
+}
+
+func NewNetworkManagerConfigPlugin() ConfigPlugin {
+	return NewSavedConfig(nil)
+}
+
+func init() {
+	RegisterConfigPlugin("networkmanager", NewNetworkManagerConfigPlugin)
+}
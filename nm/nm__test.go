@@ -0,0 +1,220 @@
+package nm
+
+import (
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+	"github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/utils"
+	"github.com/xenolog/l23/utils/configtest"
+)
+
+func Test__L3_without_addresses(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Mtu: 9000,
+				Ethtool: npstate.EthtoolState{
+					Offload:  map[string]bool{"gro": false},
+					Coalesce: map[string]string{"adaptive-rx": "on"},
+				},
+			},
+			L3: npstate.L3State{
+				IPv4:          []string{"10.10.10.131/25", "10.20.20.1/24"},
+				Gateway:       "10.10.10.1",
+				GatewayMetric: 100,
+				Routes:        []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.20.20.254", Metric: 10}},
+				Nameservers:   []string{"8.8.8.8", "1.1.1.1"},
+				Search:        []string{"example.com"},
+			},
+		},
+		"eth2": &npstate.NPState{
+			Name:   "eth2",
+			Action: "port",
+			Online: true,
+			L3: npstate.L3State{
+				Gateway:     "10.30.30.1",
+				Routes:      []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.30.30.254"}},
+				Nameservers: []string{"8.8.8.8"},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Connections["eth1"].String(), `# Generated by l23network, don't edit manually
+
+[connection]
+id=l23-eth1
+uuid=0a188531-c142-360c-a07b-4f006b021962
+type=ethernet
+interface-name=eth1
+autoconnect=true
+
+[ethernet]
+mtu=9000
+
+[ethtool]
+feature-gro=false
+coalesce-adaptive-rx=1
+
+[ipv4]
+method=manual
+address1=10.10.10.131/25
+address2=10.20.20.1/24
+gateway=10.10.10.1
+route-metric=100
+route1=192.168.0.0/16,10.20.20.254,10
+dns=8.8.8.8;1.1.1.1;
+dns-search=example.com;
+
+[ipv6]
+method=disabled
+`)
+	// NetworkManager refuses to load connection with gateway, routes or
+	// DNS settings for disabled IPv4
+	td.CmpDeeply(t, savedConfig.Connections["eth2"].section("ipv4").Options, []string{"method=disabled"})
+	td.CmpDeeply(t, savedConfig.Connections["eth2"].section("ipv6").Options, []string{"method=disabled"})
+}
+
+func Test__Vlan_on_bond_into_bridge(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth2": &npstate.NPState{
+			Name:   "eth2",
+			Action: "port",
+			Online: true,
+			Match:  map[string]string{"macaddress": "52:54:00:12:34:56", "driver": "e1000"},
+		},
+		"bond0": &npstate.NPState{
+			Name:   "bond0",
+			Action: "bond",
+			Online: true,
+			L2: npstate.L2State{
+				Slaves:         []string{"eth2", "eth3"},
+				BondProperties: map[string]string{"xmit_hash_policy": "layer3+4", "mode": "802.3ad"},
+			},
+		},
+		"bond0.101": &npstate.NPState{
+			Name:   "bond0.101",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Parent:  "bond0",
+				Vlan_id: 101,
+				Bridge:  "br1",
+			},
+		},
+		"br1": &npstate.NPState{
+			Name:   "br1",
+			Action: "bridge",
+			Online: false,
+			L3: npstate.L3State{
+				IPv4: []string{"10.1.1.1/24"},
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.names(), []string{"bond0", "bond0.101", "br1", "eth2", "eth3"})
+	td.CmpDeeply(t, savedConfig.Connections["bond0"].section("bond").Options, []string{
+		"mode=802.3ad", "xmit_hash_policy=layer3+4",
+	})
+	td.CmpDeeply(t, savedConfig.Connections["bond0"].section("ipv4").Options, []string{"method=disabled"})
+	td.CmpDeeply(t, savedConfig.Connections["bond0.101"].Sections, []*Section{
+		{Name: "connection", Options: []string{
+			"id=l23-bond0.101", "uuid=" + uuid("bond0.101"), "type=vlan", "interface-name=bond0.101",
+			"autoconnect=true", "master=br1", "slave-type=bridge",
+		}},
+		{Name: "vlan", Options: []string{"id=101", "parent=bond0"}},
+	})
+	td.CmpDeeply(t, savedConfig.Connections["br1"].section("connection").Options[4], "autoconnect=false")
+	td.CmpDeeply(t, savedConfig.Connections["br1"].section("bridge").Options, []string{"stp=false"})
+	td.CmpDeeply(t, savedConfig.Connections["eth2"].Sections, []*Section{
+		{Name: "connection", Options: []string{
+			"id=l23-eth2", "uuid=" + uuid("eth2"), "type=ethernet",
+			"autoconnect=true", "master=bond0", "slave-type=bond",
+		}},
+		{Name: "match", Options: []string{"driver=e1000"}},
+		{Name: "ethernet", Options: []string{"mac-address=52:54:00:12:34:56"}},
+	})
+	td.CmpDeeply(t, savedConfig.Connections["eth3"].section("connection").Options[5:], []string{"master=bond0", "slave-type=bond"})
+}
+
+func Test__UUID_stability(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	before := savedConfig.Connections["eth1"].section("connection").Options[1]
+
+	// NetworkManager identifies connection by UUID, so it should not be
+	// changed by changes of connection or network scheme
+	wantedState["eth1"].L3.IPv4 = []string{"10.1.1.1/24"}
+	wantedState["eth0"] = &npstate.NPState{
+		Name:   "eth0",
+		Action: "port",
+		Online: true,
+	}
+	savedConfig = NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	td.CmpDeeply(t, savedConfig.Connections["eth1"].section("connection").Options[1], before)
+	// UUID should not be changed by upgrade of l23network too
+	td.CmpDeeply(t, before, "uuid=0a188531-c142-360c-a07b-4f006b021962")
+	td.CmpRe(t, uuid("eth1"), `^[0-9a-f]{8}-[0-9a-f]{4}-3[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, nil)
+	if uuid("eth0") == uuid("eth1") {
+		t.Logf("UUIDs of different connections are equal")
+		t.Fail()
+	}
+}
+
+func Test__Keyfiles_are_private(t *testing.T) {
+	wantedState := npstate.NPStates{
+		"eth1": &npstate.NPState{
+			Name:   "eth1",
+			Action: "port",
+			Online: true,
+		},
+		"eth1.101": &npstate.NPState{
+			Name:   "eth1.101",
+			Action: "port",
+			Online: true,
+			L2: npstate.L2State{
+				Parent:  "eth1",
+				Vlan_id: 101,
+			},
+		},
+	}
+	savedConfig := NewSavedConfig(nil)
+	configtest.Generate(t, savedConfig, wantedState)
+	paths := []string{}
+	for _, file := range savedConfig.Files("/tmp/system-connections") {
+		paths = append(paths, file.Path)
+		// NetworkManager ignores keyfiles, which are readable by others
+		if file.Mode != 0600 {
+			t.Logf("Wrong mode of '%s': %v", file.Path, file.Mode)
+			t.Fail()
+		}
+	}
+	td.CmpDeeply(t, paths, []string{
+		"/tmp/system-connections/l23-eth1.nmconnection",
+		"/tmp/system-connections/l23-eth1.101.nmconnection",
+	})
+}
+
+func Test__Hand_written_files_are_kept(t *testing.T) {
+	files := configtest.GeneratedFiles(t, NewSavedConfig(nil), map[string]string{
+		"l23-eth1.nmconnection":  utils.GeneratedHeader + "[connection]\nid=l23-eth1\n",
+		"l23-br1.nmconnection":   utils.GeneratedHeader,
+		"l23-eth1.nmconnection~": utils.GeneratedHeader,
+		"l23-vpn.nmconnection":   "[connection]\nid=l23-vpn\n",
+		"Wired connection 1":     "",
+	})
+	td.CmpDeeply(t, files, []string{"l23-br1.nmconnection", "l23-eth1.nmconnection"})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/vishvananda/netlink"
 	logger "github.com/xenolog/go-tiny-logger"
@@ -12,33 +13,134 @@ import (
 
 var Log *logger.Logger
 
+// Administrative link states, allowed into network scheme
+const (
+	LinkStateUp        = "up"
+	LinkStateDown      = "down"
+	LinkStateUnmanaged = "unmanaged"
+)
+
+// Ethtool parameters, supported by l23network
+var (
+	EthtoolOffloads = []string{
+		"rx", "tx", "sg", "tso", "gso", "gro", "lro", "rxvlan", "txvlan", "ntuple", "rxhash",
+	}
+	EthtoolRings = []string{
+		"rx", "rx-mini", "rx-jumbo", "tx",
+	}
+	EthtoolCoalesces = []string{
+		"adaptive-rx", "adaptive-tx", "sample-interval", "stats-block-usecs",
+		"pkt-rate-low", "pkt-rate-high",
+		"rx-usecs", "rx-frames", "rx-usecs-irq", "rx-frames-irq",
+		"tx-usecs", "tx-frames", "tx-usecs-irq", "tx-frames-irq",
+		"rx-usecs-low", "rx-frames-low", "tx-usecs-low", "tx-frames-low",
+		"rx-usecs-high", "rx-frames-high", "tx-usecs-high", "tx-frames-high",
+	}
+)
+
+// BondProperties -- bonding parameters, supported by l23network, named
+// like sysfs attributes of bond
+var BondProperties = []string{
+	"mode", "miimon", "lacp_rate", "xmit_hash_policy", "updelay", "downdelay", "primary", "ad_select",
+}
+
+// EthtoolState -- NIC settings, managed by ethtool. Keys are the ethtool
+// parameter names ("gro", "tso" for offloads, "rx", "tx" for rings,
+// "rx-usecs", "adaptive-rx" for coalesce)
+type EthtoolState struct {
+	Offload  map[string]bool   `yaml:"offload,omitempty" json:"offload,omitempty"`
+	Ring     map[string]int    `yaml:"ring,omitempty" json:"ring,omitempty"`
+	Coalesce map[string]string `yaml:"coalesce,omitempty" json:"coalesce,omitempty"`
+}
+
+// IsEmpty -- returns true if no one ethtool setting defined
+func (s *EthtoolState) IsEmpty() bool {
+	return len(s.Offload) == 0 && len(s.Ring) == 0 && len(s.Coalesce) == 0
+}
+
+// Masked -- returns copy of EthtoolState, which contains only settings,
+// defined into the given mask
+func (s *EthtoolState) Masked(mask *EthtoolState) EthtoolState {
+	rv := EthtoolState{}
+	for key := range mask.Offload {
+		if v, ok := s.Offload[key]; ok {
+			if rv.Offload == nil {
+				rv.Offload = make(map[string]bool)
+			}
+			rv.Offload[key] = v
+		}
+	}
+	for key := range mask.Ring {
+		if v, ok := s.Ring[key]; ok {
+			if rv.Ring == nil {
+				rv.Ring = make(map[string]int)
+			}
+			rv.Ring[key] = v
+		}
+	}
+	for key := range mask.Coalesce {
+		if v, ok := s.Coalesce[key]; ok {
+			if rv.Coalesce == nil {
+				rv.Coalesce = make(map[string]string)
+			}
+			rv.Coalesce[key] = v
+		}
+	}
+	return rv
+}
+
 type L2State struct {
-	Mtu          int
-	Bridge       string
-	Parent       string
-	Slaves       []string
-	Vlan_id      int
-	Stp          bool
-	Bpdu_forward bool
+	Mtu          int          `json:"mtu,omitempty"`
+	Bridge       string       `json:"bridge,omitempty"`
+	Parent       string       `json:"parent,omitempty"`
+	Slaves       []string     `json:"slaves,omitempty"`
+	Vlan_id      int          `json:"vlan_id,omitempty"`
+	Stp          bool         `json:"stp,omitempty"`
+	Bpdu_forward bool         `json:"bpdu_forward,omitempty"`
+	HwAddr       string       `json:"hwaddr,omitempty"`
+	TxQLen       int          `json:"txqlen,omitempty"`
+	Alias        string       `json:"alias,omitempty"`
+	Ethtool      EthtoolState `json:"ethtool"`
+	Trunks       []int        `json:"trunks,omitempty"` // VLANs, allowed on the trunk port (ovs)
+	Peer         string       `json:"peer,omitempty"`   // peer of the patch port (ovs)
+	// bonding parameters (see BondProperties), are not managed into runtime
+	BondProperties map[string]string `json:"bond_properties,omitempty" yaml:"bond_properties,omitempty"`
 	// Type         string
 }
 
+// RouteState -- static route via network primitive
+type RouteState struct {
+	To     string `json:"to" yaml:"to"` // in the CIDR notation
+	Via    string `json:"via" yaml:"via"`
+	Metric int    `json:"metric,omitempty" yaml:"metric,omitempty"`
+}
+
 type L3State struct {
-	IPv4 []string // in the CIDR notation
+	IPv4 []string `json:"ipv4"` // in the CIDR notation
 	// IPv6 []IpAddr6
+	// default gateway, static routes and DNS settings are not managed into
+	// runtime, they are stored into network configuration only
+	Gateway       string       `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	GatewayMetric int          `json:"gateway_metric,omitempty" yaml:"gateway_metric,omitempty"`
+	Routes        []RouteState `json:"routes,omitempty" yaml:"routes,omitempty"`
+	Nameservers   []string     `json:"nameservers,omitempty" yaml:"nameservers,omitempty"`
+	Search        []string     `json:"search,omitempty" yaml:"search,omitempty"`
 }
 
 // Np -- is a acronym for Network Primitive
 type NPState struct {
-	Name     string
-	Action   string
-	IfIndex  int
-	attrs    *netlink.LinkAttrs
-	LinkType string
-	Provider string
-	Online   bool
-	L2       L2State
-	L3       L3State
+	Name       string `json:"name"`
+	Action     string `json:"action"`
+	IfIndex    int    `json:"ifindex,omitempty"`
+	attrs      *netlink.LinkAttrs
+	LinkType   string            `json:"linktype,omitempty"`
+	Provider   string            `json:"provider,omitempty"`
+	Online     bool              `json:"online"`
+	KeepOnline bool              `json:"keeponline,omitempty"` // administrative state of link should not be touched
+	Match      map[string]string `json:"match,omitempty"`      // rules to find physical interface (macaddress, driver, path)
+	SetName    string            `json:"setname,omitempty"`    // interface, found by Match rules should be renamed to
+	L2         L2State           `json:"l2"`
+	L3         L3State           `json:"l3"`
 }
 
 // // Next methods implements netlink.NP interface
@@ -62,15 +164,65 @@ func (s *NPState) CacheAttrs(a *netlink.LinkAttrs) {
 	s.attrs = a
 }
 
+func sortedIntCopy(a []int) []int {
+	if len(a) == 0 {
+		return nil
+	}
+	rv := make([]int, len(a))
+	copy(rv, a)
+	sort.Ints(rv)
+	return rv
+}
+
+func sortedCopy(a []string) []string {
+	if len(a) == 0 {
+		return nil
+	}
+	rv := make([]string, len(a))
+	copy(rv, a)
+	sort.Strings(rv)
+	return rv
+}
+
+// normalizedL2 -- returns copies of L2 properties of 's' and 'n' (wanted
+// state), prepared for comparing. Optional properties (MTU, MAC address,
+// txqueuelen, alias, ethtool settings), which are not defined into 'n' are
+// cleared into both
+func (s *NPState) normalizedL2(n *NPState) (sl2, nl2 L2State) {
+	sl2 = s.L2
+	nl2 = n.L2
+	if nl2.Mtu == 0 {
+		// MTU is not defined, i.e. default or inherited from master
+		sl2.Mtu = 0
+	}
+	sl2.Slaves = sortedCopy(s.L2.Slaves)
+	nl2.Slaves = sortedCopy(n.L2.Slaves)
+	sl2.Trunks = sortedIntCopy(s.L2.Trunks)
+	nl2.Trunks = sortedIntCopy(n.L2.Trunks)
+	if nl2.HwAddr == "" {
+		sl2.HwAddr = ""
+	}
+	sl2.HwAddr = strings.ToLower(sl2.HwAddr)
+	nl2.HwAddr = strings.ToLower(nl2.HwAddr)
+	if nl2.TxQLen == 0 {
+		sl2.TxQLen = 0
+	}
+	if nl2.Alias == "" {
+		sl2.Alias = ""
+	}
+	sl2.Ethtool = s.L2.Ethtool.Masked(&nl2.Ethtool)
+	nl2.Ethtool = n.L2.Ethtool.Masked(&nl2.Ethtool)
+	sl2.BondProperties = nil
+	nl2.BondProperties = nil
+	return sl2, nl2
+}
+
 // CompareL2 -- A method, allows to compare L2 properties of NetworkPrimitive
+// Optional properties (MTU, MAC address, txqueuelen, alias, ethtool settings)
+// are compared only if they are defined into 'n' (wanted state)
 func (s *NPState) CompareL2(n *NPState) bool {
-	// fmt.Printf("*** Comparing L2 '%s' and '%s':\n", s.Name, n.Name)
-	// sl2, _ := yaml.Marshal(s.L2)
-	// sn2, _ := yaml.Marshal(n.L2)
-	// fmt.Printf("*** L2:\n%s\n%s\n", sl2, sn2)
-	rv := reflect.DeepEqual(s.L2, n.L2)
-	// fmt.Printf(">>> %v\n", rv)
-	return rv
+	sl2, nl2 := s.normalizedL2(n)
+	return reflect.DeepEqual(sl2, nl2)
 }
 
 // CompareL3 -- A method, allows to compare L3 properties of NetworkPrimitive
@@ -97,11 +249,34 @@ func (s *NPState) CompareL3(n *NPState) bool {
 func (s *NPState) CompareL23(n *NPState) bool {
 	l2 := s.CompareL2(n)
 	l3 := s.CompareL3(n)
-	oo := (s.Online == n.Online)
-	fmt.Printf("*** '%s-%s': %v %v %v\n", s.Name, n.Name, l2, l3, oo)
+	oo := (s.Online == n.Online) || s.KeepOnline || n.KeepOnline
+	// fmt.Printf("*** '%s-%s': %v %v %v\n", s.Name, n.Name, l2, l3, oo)
 	return l2 && l3 && oo
 }
 
+// Changes -- returns human readable list of differences between network
+// primitive and 'n' (wanted state), like "mtu: 1500 -> 9000". Properties
+// are compared the same way as by CompareL23
+func (s *NPState) Changes(n *NPState) (rv []string) {
+	sl2, nl2 := s.normalizedL2(n)
+	sv := reflect.ValueOf(sl2)
+	nv := reflect.ValueOf(nl2)
+	for i := 0; i < sv.NumField(); i++ {
+		if reflect.DeepEqual(sv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(sv.Type().Field(i).Tag.Get("json"), ",")[0]
+		rv = append(rv, fmt.Sprintf("%s: %v -> %v", name, sv.Field(i).Interface(), nv.Field(i).Interface()))
+	}
+	if !s.CompareL3(n) {
+		rv = append(rv, fmt.Sprintf("ipv4: %v -> %v", s.L3.IPv4, n.L3.IPv4))
+	}
+	if s.Online != n.Online && !s.KeepOnline && !n.KeepOnline {
+		rv = append(rv, fmt.Sprintf("online: %v -> %v", s.Online, n.Online))
+	}
+	return rv
+}
+
 func (s *NPState) String() string {
 	rv, _ := yaml.Marshal(s)
 	return string(rv)
@@ -127,9 +302,9 @@ func (s *DiffTopologyStatees) String() string {
 
 type NPStates map[string]*NPState
 type TopologyState struct {
-	NP              NPStates
-	Order           []string
-	DefaultProvider string
+	NP              NPStates `json:"np"`
+	Order           []string `json:"order"`
+	DefaultProvider string   `json:"defaultprovider,omitempty"`
 }
 
 // Compare -- compare TopologyState with another
@@ -162,6 +337,49 @@ func (s *TopologyState) Compare(n *TopologyState) *DiffTopologyStatees {
 	return rv
 }
 
+// Rename -- rename network primitive and references to it (bond slaves,
+// vlan parent, bridge). Nothing is done, if network primitive with the new
+// name exists already
+func (s *TopologyState) Rename(name, newName string) {
+	np, ok := s.NP[name]
+	if _, exists := s.NP[newName]; !ok || exists {
+		return
+	}
+	delete(s.NP, name)
+	np.Name = newName
+	s.NP[newName] = np
+	for i := range s.Order {
+		if s.Order[i] == name {
+			s.Order[i] = newName
+		}
+	}
+	for _, np := range s.NP {
+		for i := range np.L2.Slaves {
+			if np.L2.Slaves[i] == name {
+				np.L2.Slaves[i] = newName
+			}
+		}
+		if np.L2.Parent == name {
+			np.L2.Parent = newName
+		}
+		if np.L2.Bridge == name {
+			np.L2.Bridge = newName
+		}
+	}
+}
+
+// EthtoolDefined -- returns names of network primitives, which have NIC
+// settings defined, in order of processing
+func (s *TopologyState) EthtoolDefined() []string {
+	rv := []string{}
+	for _, npName := range s.Order {
+		if np, ok := s.NP[npName]; ok && !np.L2.Ethtool.IsEmpty() {
+			rv = append(rv, npName)
+		}
+	}
+	return rv
+}
+
 func (s *TopologyState) String() string {
 	rv, _ := yaml.Marshal(s)
 	return string(rv)
@@ -68,7 +68,7 @@ func RuntimeNpStatusesForRemove() *TopologyState {
 	return rv
 }
 
-func Testnpstate__EqualNpStatuses(t *testing.T) {
+func TestNpstate__EqualNpStatuses(t *testing.T) {
 	runtimeNps := RuntimeNpStatuses()
 	wantedNps := RuntimeNpStatuses()
 	diff := runtimeNps.Compare(wantedNps)
@@ -81,7 +81,7 @@ func Testnpstate__EqualNpStatuses(t *testing.T) {
 	}
 }
 
-func Testnpstate__ReducedIface(t *testing.T) {
+func TestNpstate__ReducedIface(t *testing.T) {
 	linkName := "eth1"
 	runtimeNps := RuntimeNpStatuses()
 	wantedNps := RuntimeNpStatuses()
@@ -99,7 +99,7 @@ func Testnpstate__ReducedIface(t *testing.T) {
 
 }
 
-func Testnpstate__ReducedIface__by_remove_action(t *testing.T) {
+func TestNpstate__ReducedIface__by_remove_action(t *testing.T) {
 	linkName := "eth0"
 	runtimeNps := RuntimeNpStatusesForRemove()
 	wantedNps := RuntimeNpStatusesForRemove()
@@ -122,7 +122,7 @@ func Testnpstate__ReducedIface__by_remove_action(t *testing.T) {
 
 }
 
-func Testnpstate__AddedIface(t *testing.T) {
+func TestNpstate__AddedIface(t *testing.T) {
 	runtimeNps := RuntimeNpStatuses()
 	wantedNps := RuntimeNpStatuses()
 	linkName := "eth2"
@@ -146,7 +146,7 @@ func Testnpstate__AddedIface(t *testing.T) {
 	}
 
 }
-func Testnpstate__DifferentIface(t *testing.T) {
+func TestNpstate__DifferentIface(t *testing.T) {
 	runtimeNps := RuntimeNpStatuses()
 	wantedNps := RuntimeNpStatuses()
 	linkName := "eth1"
@@ -163,3 +163,159 @@ func Testnpstate__DifferentIface(t *testing.T) {
 	}
 
 }
+
+func TestNpstate__DownIface(t *testing.T) {
+	runtimeNps := RuntimeNpStatuses()
+	wantedNps := RuntimeNpStatuses()
+	linkName := "eth1"
+	wantedNps.NP[linkName].Online = false
+
+	diff := runtimeNps.Compare(wantedNps)
+
+	if len(diff.Waste) != 0 || len(diff.New) != 0 || !reflect.DeepEqual(diff.Different, []string{linkName}) {
+		t.Fail()
+	}
+}
+
+func TestNpstate__UnmanagedIface(t *testing.T) {
+	runtimeNps := RuntimeNpStatuses()
+	wantedNps := RuntimeNpStatuses()
+	linkName := "eth1"
+	runtimeNps.NP[linkName].Online = false
+	wantedNps.NP[linkName].KeepOnline = true
+
+	diff := runtimeNps.Compare(wantedNps)
+
+	if !diff.IsEqual() {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+}
+
+func TestNpstate__OptionalL2Properties(t *testing.T) {
+	runtimeNps := RuntimeNpStatuses()
+	wantedNps := RuntimeNpStatuses()
+	linkName := "eth1"
+	runtimeNps.NP[linkName].L2.HwAddr = "52:54:00:AA:BB:CC"
+	runtimeNps.NP[linkName].L2.TxQLen = 1000
+	runtimeNps.NP[linkName].L2.Ethtool.Offload = map[string]bool{"gro": true, "tso": true}
+
+	// properties, not defined into wanted state should be ignored
+	if diff := runtimeNps.Compare(wantedNps); !diff.IsEqual() {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+
+	// properties, defined into wanted state and equal to runtime ones
+	wantedNps.NP[linkName].L2.HwAddr = "52:54:00:aa:bb:cc"
+	wantedNps.NP[linkName].L2.Ethtool.Offload = map[string]bool{"gro": true}
+	if diff := runtimeNps.Compare(wantedNps); !diff.IsEqual() {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+
+	// properties, defined into wanted state and differ from runtime ones
+	wantedNps.NP[linkName].L2.Ethtool.Offload = map[string]bool{"gro": false}
+	if diff := runtimeNps.Compare(wantedNps); !reflect.DeepEqual(diff.Different, []string{linkName}) {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+	wantedNps.NP[linkName].L2.Ethtool.Offload = nil
+	wantedNps.NP[linkName].L2.Alias = "uplink"
+	if diff := runtimeNps.Compare(wantedNps); !reflect.DeepEqual(diff.Different, []string{linkName}) {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+}
+
+func TestNpstate__EthtoolDefined(t *testing.T) {
+	wantedNps := RuntimeNpStatuses()
+	wantedNps.Order = []string{"eth0", "eth1"}
+	if rv := wantedNps.EthtoolDefined(); len(rv) != 0 {
+		t.Logf("No one NIC settings defined, but got %v", rv)
+		t.Fail()
+	}
+	wantedNps.NP["eth1"].L2.Ethtool.Offload = map[string]bool{"gro": false}
+	if rv := wantedNps.EthtoolDefined(); !reflect.DeepEqual(rv, []string{"eth1"}) {
+		t.Logf("NIC settings defined for 'eth1' only, but got %v", rv)
+		t.Fail()
+	}
+}
+
+func TestNpstate__Rename(t *testing.T) {
+	topology := &TopologyState{
+		NP: NPStates{
+			"eth1":     &NPState{Name: "eth1", L2: L2State{Bridge: "br1"}},
+			"eth2":     &NPState{Name: "eth2"},
+			"bond0":    &NPState{Name: "bond0", L2: L2State{Slaves: []string{"eth1", "eth2"}}},
+			"eth1.100": &NPState{Name: "eth1.100", L2: L2State{Parent: "eth1"}},
+		},
+		Order: []string{"eth1", "eth2", "bond0", "eth1.100"},
+	}
+	topology.Rename("eth1", "uplink0")
+	// existing network primitive is not overwritten
+	topology.Rename("eth2", "bond0")
+
+	if _, ok := topology.NP["eth1"]; ok || topology.NP["uplink0"] == nil || topology.NP["uplink0"].Name != "uplink0" ||
+		topology.NP["eth2"] == nil || topology.NP["bond0"].Name != "bond0" {
+		t.Logf("Wrong renamed network primitives: %v", topology.NP)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(topology.Order, []string{"uplink0", "eth2", "bond0", "eth1.100"}) ||
+		!reflect.DeepEqual(topology.NP["bond0"].L2.Slaves, []string{"uplink0", "eth2"}) ||
+		topology.NP["eth1.100"].L2.Parent != "uplink0" || topology.NP["uplink0"].L2.Bridge != "br1" {
+		t.Logf("References to renamed network primitive are not updated: %v", topology)
+		t.Fail()
+	}
+}
+
+func TestNpstate__RuntimeDefinedL2Properties(t *testing.T) {
+	runtimeNps := RuntimeNpStatuses()
+	wantedNps := RuntimeNpStatuses()
+	linkName := "bond0"
+	runtimeNps.NP[linkName] = &NPState{
+		Name:   linkName,
+		Action: "bond",
+		Online: true,
+		L2: L2State{
+			Mtu:    9000,
+			Slaves: []string{"eth1", "eth3"},
+		},
+	}
+	wantedNps.NP[linkName] = &NPState{
+		Name:   linkName,
+		Action: "bond",
+		Online: true,
+		L2: L2State{
+			Slaves: []string{"eth3", "eth1"},
+		},
+	}
+
+	// MTU is not defined into wanted state, slaves order is not important
+	if diff := runtimeNps.Compare(wantedNps); !diff.IsEqual() {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+
+	wantedNps.NP[linkName].L2.Mtu = 1500
+	if diff := runtimeNps.Compare(wantedNps); !reflect.DeepEqual(diff.Different, []string{linkName}) {
+		t.Logf("DIFF: \n%v", diff)
+		t.Fail()
+	}
+}
+
+func TestNpstate__Changes(t *testing.T) {
+	runtimeNps := RuntimeNpStatuses()
+	wantedNps := RuntimeNpStatuses()
+	linkName := "eth1"
+	runtimeNps.NP[linkName].L2.TxQLen = 1000
+	wantedNps.NP[linkName].L2.Mtu = 9000
+	wantedNps.NP[linkName].Online = false
+
+	changes := runtimeNps.NP[linkName].Changes(wantedNps.NP[linkName])
+	wanted := []string{"mtu: 0 -> 9000", "online: true -> false"}
+	if !reflect.DeepEqual(changes, wanted) {
+		t.Logf("Wrong changes: %v, instead %v", changes, wanted)
+		t.Fail()
+	}
+}
@@ -0,0 +1,135 @@
+package npstate
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	. "github.com/xenolog/l23/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CachedAttrs -- link attributes, cached from kernel while observing,
+// in the serializable form
+type CachedAttrs struct {
+	Index        int    `yaml:"index" json:"index"`
+	Name         string `yaml:"name" json:"name"`
+	Mtu          int    `yaml:"mtu" json:"mtu"`
+	TxQLen       int    `yaml:"txqlen,omitempty" json:"txqlen,omitempty"`
+	HardwareAddr string `yaml:"hwaddr,omitempty" json:"hwaddr,omitempty"`
+	Alias        string `yaml:"alias,omitempty" json:"alias,omitempty"`
+	Up           bool   `yaml:"up" json:"up"`
+	MasterIndex  int    `yaml:"master,omitempty" json:"master,omitempty"`
+	ParentIndex  int    `yaml:"parent,omitempty" json:"parent,omitempty"`
+}
+
+func newCachedAttrs(a *netlink.LinkAttrs) *CachedAttrs {
+	if a == nil {
+		return nil
+	}
+	return &CachedAttrs{
+		Index:        a.Index,
+		Name:         a.Name,
+		Mtu:          a.MTU,
+		TxQLen:       a.TxQLen,
+		HardwareAddr: a.HardwareAddr.String(),
+		Alias:        a.Alias,
+		Up:           a.Flags&net.FlagUp != 0,
+		MasterIndex:  a.MasterIndex,
+		ParentIndex:  a.ParentIndex,
+	}
+}
+
+// LinkAttrs -- restore link attributes from the serializable form
+func (s *CachedAttrs) LinkAttrs() *netlink.LinkAttrs {
+	rv := &netlink.LinkAttrs{
+		Index:       s.Index,
+		Name:        s.Name,
+		MTU:         s.Mtu,
+		TxQLen:      s.TxQLen,
+		Alias:       s.Alias,
+		MasterIndex: s.MasterIndex,
+		ParentIndex: s.ParentIndex,
+	}
+	rv.HardwareAddr, _ = net.ParseMAC(s.HardwareAddr)
+	if s.Up {
+		rv.Flags |= net.FlagUp
+	}
+	return rv
+}
+
+// NPSnapshot -- observed network primitive with cached link attributes
+type NPSnapshot struct {
+	NPState `yaml:",inline"`
+	Attrs   *CachedAttrs `yaml:"attrs,omitempty" json:"attrs,omitempty"`
+}
+
+// TopologySnapshot -- serializable form of the observed network topology.
+// It may be stored and used instead of observing the live kernel
+type TopologySnapshot struct {
+	NP    map[string]*NPSnapshot `yaml:"np" json:"np"`
+	Order []string               `yaml:"order" json:"order"`
+}
+
+// NewTopologySnapshot -- create snapshot of observed network topology
+func NewTopologySnapshot(t *TopologyState) *TopologySnapshot {
+	rv := &TopologySnapshot{
+		NP:    make(map[string]*NPSnapshot),
+		Order: append([]string{}, t.Order...),
+	}
+	for name, np := range t.NP {
+		rv.NP[name] = &NPSnapshot{
+			NPState: *np,
+			Attrs:   newCachedAttrs(np.Attrs()),
+		}
+	}
+	return rv
+}
+
+// TopologyState -- restore network topology from snapshot. Network
+// primitives, absent into the Order, are placed to the end of it
+func (s *TopologySnapshot) TopologyState() *TopologyState {
+	rv := NewTopologyState()
+	for name, snp := range s.NP {
+		np := snp.NPState
+		np.Name = name
+		if snp.Attrs != nil {
+			np.CacheAttrs(snp.Attrs.LinkAttrs())
+		}
+		rv.NP[name] = &np
+	}
+	tail := []string{}
+	for name := range s.NP {
+		if IndexString(s.Order, name) < 0 {
+			tail = append(tail, name)
+		}
+	}
+	sort.Strings(tail)
+	for _, name := range append(append([]string{}, s.Order...), tail...) {
+		if _, ok := rv.NP[name]; ok && IndexString(rv.Order, name) < 0 {
+			rv.Order = append(rv.Order, name)
+		}
+	}
+	return rv
+}
+
+// Marshal -- serialize snapshot into given format ("yaml" or "json")
+func (s *TopologySnapshot) Marshal(format string) ([]byte, error) {
+	if format == "json" {
+		return json.MarshalIndent(s, "", "  ")
+	}
+	return yaml.Marshal(s)
+}
+
+// LoadTopologySnapshot -- de-serialize snapshot, stored in YAML or JSON
+func LoadTopologySnapshot(data []byte) (*TopologySnapshot, error) {
+	rv := new(TopologySnapshot)
+	if err := yaml.Unmarshal(data, rv); err != nil {
+		return nil, err
+	}
+	if rv.NP == nil {
+		rv.NP = make(map[string]*NPSnapshot)
+	}
+	return rv, nil
+}
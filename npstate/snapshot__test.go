@@ -0,0 +1,50 @@
+package npstate
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestSnapshot__RoundTrip(t *testing.T) {
+	topology := RuntimeNpStatuses()
+	topology.Order = []string{"lo", "eth1"}
+	hwaddr, _ := net.ParseMAC("52:54:00:aa:bb:cc")
+	topology.NP["eth1"].CacheAttrs(&netlink.LinkAttrs{
+		Index:        3,
+		Name:         "eth1",
+		MTU:          1500,
+		HardwareAddr: hwaddr,
+		Flags:        net.FlagUp,
+		MasterIndex:  5,
+	})
+
+	for _, format := range []string{"yaml", "json"} {
+		data, err := NewTopologySnapshot(topology).Marshal(format)
+		if err != nil {
+			t.Logf("Can't marshal snapshot to %s: %v", format, err)
+			t.FailNow()
+		}
+		snapshot, err := LoadTopologySnapshot(data)
+		if err != nil {
+			t.Logf("Can't load %s snapshot: %v", format, err)
+			t.FailNow()
+		}
+		restored := snapshot.TopologyState()
+		if diff := restored.Compare(topology); !diff.IsEqual() {
+			t.Logf("Topology, restored from %s snapshot, differs: %v", format, diff)
+			t.Fail()
+		}
+		if !reflect.DeepEqual(restored.Order, topology.Order) {
+			t.Logf("Order, restored from %s snapshot, differs: %v", format, restored.Order)
+			t.Fail()
+		}
+		attrs := restored.NP["eth1"].Attrs()
+		if attrs == nil || attrs.Index != 3 || attrs.Flags&net.FlagUp == 0 || restored.NP["eth1"].Master() != 5 || attrs.HardwareAddr.String() != "52:54:00:aa:bb:cc" {
+			t.Logf("Cached attributes, restored from %s snapshot, differ: %+v", format, attrs)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ovs
+
+import (
+	"net"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/lnx"
+	npstate "github.com/xenolog/l23/npstate"
+	"golang.org/x/sys/unix"
+)
+
+// NetDevs -- manages network devices of OVS bridges and internal ports,
+// which are seen by the kernel as usual links
+type NetDevs interface {
+	// fill network primitive by state of network device, if it exists
+	Observe(np *npstate.NPState)
+	// setup administrative state and IPv4 addresses of network device
+	Setup(wantedState *npstate.NPState) error
+	IPv4addrList(name string) []string
+}
+
+// lnxNetDevs -- network devices, managed by LNX plugin logic through kernel
+type lnxNetDevs struct {
+	log    *logger.Logger
+	kernel lnx.Kernel
+}
+
+// Observe -- fill network primitive by state of corresponded network device.
+// Absent device means offline network primitive
+func (s *lnxNetDevs) Observe(np *npstate.NPState) {
+	link, err := s.kernel.LinkByName(np.Name)
+	if err != nil {
+		s.log.Debug("%s: network device for '%s' not found: %v", MsgPrefix, np.Name, err)
+		return
+	}
+	attrs := link.Attrs()
+	np.IfIndex = attrs.Index
+	np.CacheAttrs(attrs)
+	np.Online = attrs.Flags&net.FlagUp != 0
+	if attrs.MTU != 1500 {
+		// workaround for default MTU value
+		np.L2.Mtu = attrs.MTU
+	}
+	np.L2.HwAddr = attrs.HardwareAddr.String()
+	np.L2.TxQLen = attrs.TxQLen
+	np.L2.Alias = attrs.Alias
+	if addrs, err := s.kernel.AddrList(link, unix.AF_INET); err == nil {
+		for _, addr := range addrs {
+			np.L3.IPv4 = append(np.L3.IPv4, addr.IPNet.String())
+		}
+	} else {
+		s.log.Error("Error while fetch L3 info for '%s' %v", np.Name, err)
+	}
+}
+
+func (s *lnxNetDevs) Setup(wantedState *npstate.NPState) error {
+	return lnx.NewNetDev(s.log, s.kernel, wantedState).Setup()
+}
+
+func (s *lnxNetDevs) IPv4addrList(name string) []string {
+	return lnx.NewNetDev(s.log, s.kernel, &npstate.NPState{Name: name}).IPv4addrList()
+}
@@ -0,0 +1,550 @@
+package ovs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/lnx"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	MsgPrefix = "OVS plugin"
+)
+
+// OvsRtPlugin -- runtime plugin, which manages Open vSwitch bridges, ports,
+// bonds and patch ports through ovs-vsctl. Administrative state and IP
+// addresses of bridges and internal ports are managed like LNX plugin does.
+// Changes and observing are serialized per instance
+type OvsRtPlugin struct {
+	mutex      sync.Mutex
+	log        *logger.Logger
+	vsctl      Vsctl
+	netdevs    NetDevs
+	topology   *npstate.TopologyState
+	membership map[string]string
+	dryVsctl   *dryVsctl // invocations, planned in the dry-run mode
+}
+
+// -----------------------------------------------------------------------------
+
+type OpBase struct {
+	plugin      *OvsRtPlugin
+	log         *logger.Logger
+	wantedState *npstate.NPState
+}
+
+func (s *OpBase) Init(wantedState *npstate.NPState) error {
+	s.wantedState = wantedState
+	return nil
+}
+
+func (s *OpBase) Name() string {
+	return s.wantedState.Name
+}
+
+// observed -- returns network primitive state, observed by plugin, or nil
+func (s *OpBase) observed() *npstate.NPState {
+	if s.plugin.topology == nil {
+		return nil
+	}
+	return s.plugin.topology.NP[s.Name()]
+}
+
+// run -- run given ovs-vsctl commands into one transaction. In the dry-run
+// mode the invocation is recorded with its reason and reported
+func (s *OpBase) run(dryrun bool, reason string, cmds ...[]string) error {
+	args := []string{}
+	for _, cmd := range cmds {
+		if len(cmd) == 0 {
+			continue
+		}
+		if len(args) > 0 {
+			args = append(args, "--")
+		}
+		args = append(args, cmd...)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	if dryrun {
+		vsctl := s.plugin.dryRunVsctl()
+		vsctl.Explain(reason)
+		_, err := vsctl.Run(args...)
+		s.log.Info("%s dryrun: %s", MsgPrefix, VsctlStep{Args: args, Reason: reason})
+		return err
+	}
+	s.log.Debug("%s: %s %s", MsgPrefix, VsctlCmd, strings.Join(args, " "))
+	_, err := s.plugin.vsctl.Run(args...)
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+	}
+	return err
+}
+
+func (s *OpBase) bridge() (string, error) {
+	if s.wantedState.L2.Bridge == "" {
+		err := fmt.Errorf("bridge for '%s' is not defined, OVS ports can't exist without bridge", s.Name())
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return "", err
+	}
+	return s.wantedState.L2.Bridge, nil
+}
+
+// bridgeChanged -- returns true if port exists, but into another bridge
+func (s *OpBase) bridgeChanged() bool {
+	np := s.observed()
+	return np != nil && np.L2.Bridge != s.wantedState.L2.Bridge
+}
+
+// portSettings -- ovs-vsctl commands to setup VLAN tag and trunks of port
+func (s *OpBase) portSettings() [][]string {
+	rv := [][]string{}
+	if s.wantedState.L2.Vlan_id > 0 {
+		rv = append(rv, []string{"set", "Port", s.Name(), fmt.Sprintf("tag=%d", s.wantedState.L2.Vlan_id)})
+	} else {
+		rv = append(rv, []string{"clear", "Port", s.Name(), "tag"})
+	}
+	if len(s.wantedState.L2.Trunks) > 0 {
+		trunks := []string{}
+		for _, vlan := range s.wantedState.L2.Trunks {
+			trunks = append(trunks, strconv.Itoa(vlan))
+		}
+		rv = append(rv, []string{"set", "Port", s.Name(), "trunks=" + strings.Join(trunks, ",")})
+	} else {
+		rv = append(rv, []string{"clear", "Port", s.Name(), "trunks"})
+	}
+	return rv
+}
+
+// mtuSettings -- ovs-vsctl command to setup MTU of interface
+func (s *OpBase) mtuSettings() []string {
+	if s.wantedState.L2.Mtu > 0 {
+		return []string{"set", "Interface", s.Name(), fmt.Sprintf("mtu_request=%d", s.wantedState.L2.Mtu)}
+	}
+	return nil
+}
+
+// setupLink -- setup administrative state and IP addresses of network device,
+// corresponded to bridge or port
+func (s *OpBase) setupLink(dryrun bool) error {
+	l2 := &s.wantedState.L2
+	if l2.HwAddr != "" || l2.TxQLen > 0 || l2.Alias != "" || !l2.Ethtool.IsEmpty() {
+		s.log.Warn("%s: MAC address, txqueuelen, alias and ethtool settings of '%s' are not supported, skipped", MsgPrefix, s.Name())
+	}
+	if dryrun {
+		s.log.Info("%s dryrun: state of '%s' set up, IPv4 addresses set to %v.", MsgPrefix, s.Name(), s.wantedState.L3.IPv4)
+		return nil
+	}
+	return s.plugin.netdevs.Setup(s.wantedState)
+}
+
+// returns address list in the original order
+func (s *OpBase) IPv4addrList() []string {
+	return s.plugin.netdevs.IPv4addrList(s.Name())
+}
+
+func (s *OpBase) Remove(dryrun bool) error {
+	s.log.Info("%s: Removing port '%s'", MsgPrefix, s.Name())
+	return s.run(dryrun, fmt.Sprintf("'%s' is not wanted", s.Name()),
+		[]string{"--if-exists", "del-port", s.Name()})
+}
+
+// -----------------------------------------------------------------------------
+
+type OvsBridge struct {
+	OpBase
+}
+
+func (s *OvsBridge) Create(dryrun bool) (err error) {
+	s.log.Info("%s: Creating bridge '%s'", MsgPrefix, s.Name())
+	if err = s.run(dryrun, fmt.Sprintf("bridge '%s' is absent", s.Name()),
+		[]string{"--may-exist", "add-br", s.Name()}); err != nil {
+		return err
+	}
+	return s.Modify(dryrun)
+}
+
+func (s *OvsBridge) Remove(dryrun bool) error {
+	s.log.Info("%s: Removing bridge '%s'", MsgPrefix, s.Name())
+	return s.run(dryrun, fmt.Sprintf("bridge '%s' is not wanted", s.Name()),
+		[]string{"--if-exists", "del-br", s.Name()})
+}
+
+func (s *OvsBridge) Modify(dryrun bool) (err error) {
+	s.log.Info("%s: Modifying bridge '%s'", MsgPrefix, s.Name())
+	if err = s.run(dryrun, fmt.Sprintf("STP and MTU of bridge '%s' should be as wanted", s.Name()),
+		[]string{"set", "Bridge", s.Name(), fmt.Sprintf("stp_enable=%v", s.wantedState.L2.Stp)},
+		s.mtuSettings(),
+	); err != nil {
+		return err
+	}
+	return s.setupLink(dryrun)
+}
+
+func NewBridge(plugin *OvsRtPlugin) NpOperator {
+	rv := new(OvsBridge)
+	rv.plugin = plugin
+	rv.log = plugin.log
+	return rv
+}
+
+// -----------------------------------------------------------------------------
+
+// OvsPort -- port with system (already existing) or internal interface
+type OvsPort struct {
+	OpBase
+}
+
+func (s *OvsPort) isInternal() bool {
+	return s.wantedState.LinkType == "internal"
+}
+
+func (s *OvsPort) Create(dryrun bool) error {
+	s.log.Info("%s: Creating port '%s'", MsgPrefix, s.Name())
+	return s.setup(dryrun, false)
+}
+
+func (s *OvsPort) Modify(dryrun bool) error {
+	s.log.Info("%s: Modifying port '%s'", MsgPrefix, s.Name())
+	return s.setup(dryrun, s.bridgeChanged())
+}
+
+// setup -- add port to bridge (re-add if 'move' is true) and setup it
+func (s *OvsPort) setup(dryrun, move bool) error {
+	br, err := s.bridge()
+	if err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("'%s' should be a port of bridge '%s' with wanted VLAN settings", s.Name(), br)
+	cmds := [][]string{}
+	if move {
+		reason = fmt.Sprintf("'%s' should be moved to bridge '%s'", s.Name(), br)
+		cmds = append(cmds, []string{"--if-exists", "del-port", s.Name()})
+	}
+	cmds = append(cmds, []string{"--may-exist", "add-port", br, s.Name()})
+	if s.isInternal() {
+		cmds = append(cmds, []string{"set", "Interface", s.Name(), "type=internal"})
+	}
+	cmds = append(cmds, s.portSettings()...)
+	cmds = append(cmds, s.mtuSettings())
+	if err = s.run(dryrun, reason, cmds...); err != nil {
+		return err
+	}
+	return s.setupLink(dryrun)
+}
+
+func NewPort(plugin *OvsRtPlugin) NpOperator {
+	rv := new(OvsPort)
+	rv.plugin = plugin
+	rv.log = plugin.log
+	return rv
+}
+
+// -----------------------------------------------------------------------------
+
+type OvsBond struct {
+	OpBase
+}
+
+func (s *OvsBond) Create(dryrun bool) error {
+	s.log.Info("%s: Creating bond '%s'", MsgPrefix, s.Name())
+	return s.setup(dryrun, false)
+}
+
+func (s *OvsBond) Modify(dryrun bool) error {
+	s.log.Info("%s: Modifying bond '%s'", MsgPrefix, s.Name())
+	recreate := s.bridgeChanged()
+	if np := s.observed(); np != nil && !sameSlaves(np.L2.Slaves, s.wantedState.L2.Slaves) {
+		// OVS can't change interfaces of existing bond
+		recreate = true
+	}
+	return s.setup(dryrun, recreate)
+}
+
+func sameSlaves(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, slave := range a {
+		if IndexString(b, slave) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setup -- add bond to bridge (re-create it if 'recreate' is true) and
+// setup it
+func (s *OvsBond) setup(dryrun, recreate bool) error {
+	br, err := s.bridge()
+	if err != nil {
+		return err
+	}
+	if len(s.wantedState.L2.Slaves) < 2 {
+		err = fmt.Errorf("bond '%s' should have at least two slaves", s.Name())
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	reason := fmt.Sprintf("'%s' should be a bond of bridge '%s' with wanted VLAN settings", s.Name(), br)
+	cmds := [][]string{}
+	if recreate {
+		reason = fmt.Sprintf("bridge or slaves of bond '%s' differ, it should be re-created", s.Name())
+		cmds = append(cmds, []string{"--if-exists", "del-port", s.Name()})
+	}
+	cmds = append(cmds, append([]string{"--may-exist", "add-bond", br, s.Name()}, s.wantedState.L2.Slaves...))
+	cmds = append(cmds, s.portSettings()...)
+	return s.run(dryrun, reason, cmds...)
+}
+
+func NewBond(plugin *OvsRtPlugin) NpOperator {
+	rv := new(OvsBond)
+	rv.plugin = plugin
+	rv.log = plugin.log
+	return rv
+}
+
+// -----------------------------------------------------------------------------
+
+type OvsPatch struct {
+	OpBase
+}
+
+func (s *OvsPatch) Create(dryrun bool) error {
+	s.log.Info("%s: Creating patch '%s'", MsgPrefix, s.Name())
+	return s.setup(dryrun, false)
+}
+
+func (s *OvsPatch) Modify(dryrun bool) error {
+	s.log.Info("%s: Modifying patch '%s'", MsgPrefix, s.Name())
+	return s.setup(dryrun, s.bridgeChanged())
+}
+
+// setup -- add patch port to bridge (re-add if 'move' is true) and setup it
+func (s *OvsPatch) setup(dryrun, move bool) error {
+	br, err := s.bridge()
+	if err != nil {
+		return err
+	}
+	if s.wantedState.L2.Peer == "" {
+		err = fmt.Errorf("peer for patch '%s' is not defined", s.Name())
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	reason := fmt.Sprintf("'%s' should be a patch of bridge '%s' to '%s'", s.Name(), br, s.wantedState.L2.Peer)
+	cmds := [][]string{}
+	if move {
+		reason = fmt.Sprintf("'%s' should be moved to bridge '%s'", s.Name(), br)
+		cmds = append(cmds, []string{"--if-exists", "del-port", s.Name()})
+	}
+	cmds = append(cmds,
+		[]string{"--may-exist", "add-port", br, s.Name()},
+		[]string{"set", "Interface", s.Name(), "type=patch", "options:peer=" + s.wantedState.L2.Peer},
+	)
+	cmds = append(cmds, s.portSettings()...)
+	return s.run(dryrun, reason, cmds...)
+}
+
+func NewPatch(plugin *OvsRtPlugin) NpOperator {
+	rv := new(OvsPatch)
+	rv.plugin = plugin
+	rv.log = plugin.log
+	return rv
+}
+
+// -----------------------------------------------------------------------------
+// -----------------------------------------------------------------------------
+
+// Init -- Runtime OVS plugin entry point
+func (s *OvsRtPlugin) Init(log *logger.Logger, hh *netlink.Handle) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.log = log
+	if s.vsctl == nil {
+		s.vsctl = &execVsctl{}
+	}
+	if s.netdevs == nil {
+		var kernel *lnx.NetlinkKernel
+		if kernel, err = lnx.NewNetlinkKernel(hh); err != nil {
+			s.log.Error("%v", err)
+			return err
+		}
+		s.netdevs = &lnxNetDevs{log: log, kernel: kernel}
+	}
+	return nil
+}
+
+// Operators -- returns operator constructors, bound to this plugin instance
+func (s *OvsRtPlugin) Operators() NpOperators {
+	return NpOperators{
+		"bridge": func() NpOperator { return &LockedOperator{NpOperator: NewBridge(s), Locker: &s.mutex} },
+		"port":   func() NpOperator { return &LockedOperator{NpOperator: NewPort(s), Locker: &s.mutex} },
+		"bond":   func() NpOperator { return &LockedOperator{NpOperator: NewBond(s), Locker: &s.mutex} },
+		"patch":  func() NpOperator { return &LockedOperator{NpOperator: NewPatch(s), Locker: &s.mutex} },
+	}
+}
+
+// dryRunVsctl -- ovs-vsctl, which records invocations of all operators in
+// the dry-run mode until next observing
+func (s *OvsRtPlugin) dryRunVsctl() *dryVsctl {
+	if s.dryVsctl == nil {
+		s.dryVsctl = new(dryVsctl)
+	}
+	return s.dryVsctl
+}
+
+func (s *OvsRtPlugin) Version() string {
+	return "OVS RUNTIME PLUGIN: v0.0.1"
+}
+
+func (s *OvsRtPlugin) Observe() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topology = npstate.NewTopologyState()
+	s.membership = make(map[string]string)
+	s.dryVsctl = nil
+
+	s.log.Info("%s: Gathering current OVS topology", MsgPrefix)
+	bridges, err := ovsdbList(s.vsctl, "Bridge", "_uuid", "name", "ports", "stp_enable")
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	ports, err := ovsdbList(s.vsctl, "Port", "_uuid", "name", "interfaces", "tag", "trunks")
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	ifaces, err := ovsdbList(s.vsctl, "Interface", "_uuid", "name", "type", "options")
+	if err != nil {
+		s.log.Error("%s: %v", MsgPrefix, err)
+		return err
+	}
+	portByUUID := make(map[string]ovsdbRow)
+	for _, port := range ports {
+		portByUUID[port.String("_uuid")] = port
+	}
+	ifaceByUUID := make(map[string]ovsdbRow)
+	for _, iface := range ifaces {
+		ifaceByUUID[iface.String("_uuid")] = iface
+	}
+
+	for _, br := range bridges {
+		brName := br.String("name")
+		s.log.Debug("%s: Processing bridge '%s'", MsgPrefix, brName)
+		np := &npstate.NPState{
+			Name:     brName,
+			Action:   "bridge",
+			LinkType: "ovs-bridge",
+		}
+		np.L2.Stp = br.Bool("stp_enable")
+		s.netdevs.Observe(np)
+		s.topology.NP[brName] = np
+		s.topology.Order = append(s.topology.Order, brName)
+
+		for _, portUUID := range br.Strings("ports") {
+			port, ok := portByUUID[portUUID]
+			if !ok || port.String("name") == brName {
+				// bridge local port is a bridge itself
+				continue
+			}
+			np := &npstate.NPState{
+				Name:   port.String("name"),
+				Action: "port",
+			}
+			np.L2.Bridge = brName
+			np.L2.Vlan_id = port.Int("tag")
+			np.L2.Trunks = port.Ints("trunks")
+			portIfaces := []ovsdbRow{}
+			for _, ifaceUUID := range port.Strings("interfaces") {
+				if iface, ok := ifaceByUUID[ifaceUUID]; ok {
+					portIfaces = append(portIfaces, iface)
+				}
+			}
+			switch {
+			case len(portIfaces) > 1:
+				np.Action = "bond"
+				np.LinkType = "ovs-bond"
+				for _, iface := range portIfaces {
+					np.L2.Slaves = append(np.L2.Slaves, iface.String("name"))
+				}
+				// bond has no network device
+				np.Online = true
+				np.KeepOnline = true
+			case len(portIfaces) == 1 && portIfaces[0].String("type") == "patch":
+				np.Action = "patch"
+				np.LinkType = "ovs-patch"
+				np.L2.Peer = portIfaces[0].Map("options")["peer"]
+				// patch has no network device
+				np.Online = true
+				np.KeepOnline = true
+			case len(portIfaces) == 1 && portIfaces[0].String("type") == "internal":
+				np.LinkType = "internal"
+				s.netdevs.Observe(np)
+			default:
+				s.netdevs.Observe(np)
+			}
+			s.membership[np.Name] = brName
+			s.topology.NP[np.Name] = np
+			s.topology.Order = append(s.topology.Order, np.Name)
+		}
+	}
+	s.log.Debug("%s: gathering done.", MsgPrefix)
+	return nil
+}
+
+func (s *OvsRtPlugin) Topology() *npstate.TopologyState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.topology
+}
+
+func (s *OvsRtPlugin) GetLogger() *logger.Logger {
+	return s.log
+}
+
+// Membership -- returns OVS bridges of observed ports
+func (s *OvsRtPlugin) Membership() map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.membership
+}
+
+// AttachPort -- add network device, managed by another provider, to OVS
+// bridge
+func (s *OvsRtPlugin) AttachPort(port, bridge string, dryrun bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.log.Info("%s: Attaching '%s' to bridge '%s'", MsgPrefix, port, bridge)
+	op := &OpBase{plugin: s, log: s.log, wantedState: &npstate.NPState{Name: port}}
+	return op.run(dryrun, fmt.Sprintf("'%s' should be a member of bridge '%s'", port, bridge),
+		[]string{"--if-exists", "del-port", port},
+		[]string{"add-port", bridge, port},
+	)
+}
+
+// DetachPort -- remove network device, managed by another provider, from OVS
+// bridge
+func (s *OvsRtPlugin) DetachPort(port string, dryrun bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.log.Info("%s: Detaching '%s' from OVS bridge", MsgPrefix, port)
+	op := &OpBase{plugin: s, log: s.log, wantedState: &npstate.NPState{Name: port}}
+	return op.run(dryrun, fmt.Sprintf("'%s' should not be a member of OVS bridge", port),
+		[]string{"--if-exists", "del-port", port})
+}
+
+func NewOvsRtPlugin() RtPlugin {
+	rv := new(OvsRtPlugin)
+	return rv
+}
+
+func init() {
+	RegisterRtPlugin("ovs", NewOvsRtPlugin)
+}
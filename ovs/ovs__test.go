@@ -0,0 +1,305 @@
+package ovs
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/lnx"
+	. "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/plugin"
+)
+
+// fakeVsctl -- ovs-vsctl stand-in, records calls and serves canned
+// 'list' output for OVSDB tables
+type fakeVsctl struct {
+	tables map[string]string
+	calls  []string
+}
+
+func (s *fakeVsctl) Run(args ...string) (string, error) {
+	if len(args) > 1 && args[len(args)-2] == "list" {
+		return s.tables[args[len(args)-1]], nil
+	}
+	s.calls = append(s.calls, strings.Join(args, " "))
+	return "", nil
+}
+
+// fakeNetDevs -- network devices stand-in, records setups and serves IPv4
+// addresses of network devices
+type fakeNetDevs struct {
+	ipv4   map[string][]string
+	setups []string
+}
+
+func (s *fakeNetDevs) Observe(np *NPState) {
+	np.L3.IPv4 = s.ipv4[np.Name]
+}
+
+func (s *fakeNetDevs) Setup(wantedState *NPState) error {
+	s.setups = append(s.setups, wantedState.Name)
+	return nil
+}
+
+func (s *fakeNetDevs) IPv4addrList(name string) []string {
+	return s.ipv4[name]
+}
+
+func newFakeOvsRtPlugin(t *testing.T) (*OvsRtPlugin, *fakeVsctl) {
+	vsctl := &fakeVsctl{tables: map[string]string{
+		"Bridge": `{"data":[
+[["uuid","b1"],"br-ex",["set",[["uuid","p1"],["uuid","p2"],["uuid","p3"]]],false],
+[["uuid","b2"],"br-int",["set",[["uuid","p4"],["uuid","p5"],["uuid","p6"]]],true]],
+"headings":["_uuid","name","ports","stp_enable"]}`,
+		"Port": `{"data":[
+[["uuid","p1"],"br-ex",["uuid","i1"],["set",[]],["set",[]]],
+[["uuid","p2"],"bond0",["uuid","i2"],["set",[]],["set",[]]],
+[["uuid","p3"],"patch-ex",["uuid","i3"],["set",[]],["set",[]]],
+[["uuid","p4"],"br-int",["uuid","i4"],["set",[]],["set",[]]],
+[["uuid","p5"],"vm1",["uuid","i5"],101,["set",[]]],
+[["uuid","p6"],"bond1",["set",[["uuid","i6"],["uuid","i7"]]],["set",[]],["set",[101,102]]]],
+"headings":["_uuid","name","interfaces","tag","trunks"]}`,
+		"Interface": `{"data":[
+[["uuid","i1"],"br-ex","internal",["map",[]]],
+[["uuid","i2"],"bond0","",["map",[]]],
+[["uuid","i3"],"patch-ex","patch",["map",[["peer","patch-int"]]]],
+[["uuid","i4"],"br-int","internal",["map",[]]],
+[["uuid","i5"],"vm1","internal",["map",[]]],
+[["uuid","i6"],"eth2","",["map",[]]],
+[["uuid","i7"],"eth3","",["map",[]]]],
+"headings":["_uuid","name","type","options"]}`,
+	}}
+	rv := NewOvsRtPlugin().(*OvsRtPlugin)
+	rv.vsctl = vsctl
+	rv.netdevs = &fakeNetDevs{ipv4: map[string][]string{"br-ex": {"10.1.0.1/24"}}}
+	if err := rv.Init(logger.New(), nil); err != nil {
+		t.FailNow()
+	}
+	return rv, vsctl
+}
+
+func TestOVS__OperatorList(t *testing.T) {
+	keys := []string{}
+	for key := range NewOvsRtPlugin().Operators() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	wantedKeys := []string{"bond", "bridge", "patch", "port"}
+	if !reflect.DeepEqual(keys, wantedKeys) {
+		t.Logf("Operator list from OvsRtPlugin broken, given %v, instead %v", keys, wantedKeys)
+		t.Fail()
+	}
+}
+
+func TestOVS__Observe(t *testing.T) {
+	ovsRtPlugin, _ := newFakeOvsRtPlugin(t)
+	if err := ovsRtPlugin.Observe(); err != nil {
+		t.Logf("Observe failed: %v", err)
+		t.FailNow()
+	}
+	topology := ovsRtPlugin.Topology()
+	order := append([]string{}, topology.Order...)
+	sort.Strings(order)
+	if !reflect.DeepEqual(order, []string{"bond0", "bond1", "br-ex", "br-int", "patch-ex", "vm1"}) {
+		t.Logf("Wrong observed network primitives: %v", order)
+		t.FailNow()
+	}
+	for _, m := range []struct {
+		name   string
+		action string
+		l2     L2State
+	}{
+		{"br-int", "bridge", L2State{Stp: true}},
+		{"bond0", "port", L2State{Bridge: "br-ex"}},
+		{"patch-ex", "patch", L2State{Bridge: "br-ex", Peer: "patch-int"}},
+		{"vm1", "port", L2State{Bridge: "br-int", Vlan_id: 101}},
+		{"bond1", "bond", L2State{Bridge: "br-int", Slaves: []string{"eth2", "eth3"}, Trunks: []int{101, 102}}},
+	} {
+		np := topology.NP[m.name]
+		l2 := np.L2
+		l2.Mtu, l2.HwAddr, l2.TxQLen, l2.Alias = 0, "", 0, ""
+		if np.Action != m.action || !reflect.DeepEqual(l2, m.l2) {
+			t.Logf("Wrong observed state of '%s': %s %+v, instead %s %+v", m.name, np.Action, l2, m.action, m.l2)
+			t.Fail()
+		}
+	}
+	membership := ovsRtPlugin.Membership()
+	if membership["bond0"] != "br-ex" || membership["vm1"] != "br-int" {
+		t.Logf("Wrong membership: %v", membership)
+		t.Fail()
+	}
+	if _, ok := membership["br-ex"]; ok {
+		t.Logf("Bridge local port should not be reported as a member: %v", membership)
+		t.Fail()
+	}
+}
+
+func TestOVS__Operators(t *testing.T) {
+	ovsRtPlugin, vsctl := newFakeOvsRtPlugin(t)
+	ovsRtPlugin.Observe()
+	operators := ovsRtPlugin.Operators()
+
+	for _, m := range []struct {
+		action string
+		np     *NPState
+		modify bool
+		call   string
+	}{
+		{"bond", &NPState{Name: "bond1", L2: L2State{Bridge: "br-int", Slaves: []string{"eth2", "eth3"}, Trunks: []int{101}}}, true,
+			"--may-exist add-bond br-int bond1 eth2 eth3 -- clear Port bond1 tag -- set Port bond1 trunks=101"},
+		{"bond", &NPState{Name: "bond1", L2: L2State{Bridge: "br-int", Slaves: []string{"eth2", "eth4"}}}, true,
+			"--if-exists del-port bond1 -- --may-exist add-bond br-int bond1 eth2 eth4 -- clear Port bond1 tag -- clear Port bond1 trunks"},
+		{"patch", &NPState{Name: "patch-int", L2: L2State{Bridge: "br-int", Peer: "patch-ex"}}, false,
+			"--may-exist add-port br-int patch-int -- set Interface patch-int type=patch options:peer=patch-ex -- clear Port patch-int tag -- clear Port patch-int trunks"},
+		{"patch", &NPState{Name: "patch-ex", L2: L2State{Bridge: "br-int", Peer: "patch-int"}}, true,
+			"--if-exists del-port patch-ex -- --may-exist add-port br-int patch-ex -- set Interface patch-ex type=patch options:peer=patch-int -- clear Port patch-ex tag -- clear Port patch-ex trunks"},
+	} {
+		vsctl.calls = nil
+		oper := operators[m.action].(func() NpOperator)()
+		oper.Init(m.np)
+		if m.modify {
+			oper.Modify(false)
+		} else {
+			oper.Create(false)
+		}
+		if len(vsctl.calls) != 1 || vsctl.calls[0] != m.call {
+			t.Logf("Wrong ovs-vsctl calls for '%s':\n%v\ninstead:\n%v", m.np.Name, vsctl.calls, m.call)
+			t.Fail()
+		}
+	}
+
+	vsctl.calls = nil
+	oper := operators["bridge"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "br-ex"})
+	oper.Remove(false)
+	if !reflect.DeepEqual(vsctl.calls, []string{"--if-exists del-br br-ex"}) {
+		t.Logf("Wrong ovs-vsctl calls for bridge removing: %v", vsctl.calls)
+		t.Fail()
+	}
+
+	vsctl.calls = nil
+	ovsRtPlugin.AttachPort("bond0", "br-int", false)
+	ovsRtPlugin.AttachPort("bond2", "br-ex", true)
+	if !reflect.DeepEqual(vsctl.calls, []string{"--if-exists del-port bond0 -- add-port br-int bond0"}) {
+		t.Logf("Wrong ovs-vsctl calls for port attaching: %v", vsctl.calls)
+		t.Fail()
+	}
+}
+
+func TestOVS__BridgesAndInternalPorts(t *testing.T) {
+	ovsRtPlugin, vsctl := newFakeOvsRtPlugin(t)
+	netdevs := ovsRtPlugin.netdevs.(*fakeNetDevs)
+	ovsRtPlugin.Observe()
+	operators := ovsRtPlugin.Operators()
+
+	for _, m := range []struct {
+		action string
+		np     *NPState
+		modify bool
+		calls  []string
+	}{
+		{"bridge", &NPState{Name: "br-new", Online: true, L2: L2State{Stp: true, Mtu: 9000}, L3: L3State{IPv4: []string{"10.0.0.1/24"}}}, false, []string{
+			"--may-exist add-br br-new",
+			"set Bridge br-new stp_enable=true -- set Interface br-new mtu_request=9000",
+		}},
+		{"bridge", &NPState{Name: "br-int", Online: true}, true, []string{
+			"set Bridge br-int stp_enable=false",
+		}},
+		{"port", &NPState{Name: "vm2", LinkType: "internal", Online: true, L2: L2State{Bridge: "br-int", Vlan_id: 102}}, false, []string{
+			"--may-exist add-port br-int vm2 -- set Interface vm2 type=internal -- set Port vm2 tag=102 -- clear Port vm2 trunks",
+		}},
+		{"port", &NPState{Name: "vm3", LinkType: "internal", Online: true, L2: L2State{Bridge: "br-int", Trunks: []int{101, 102}, Mtu: 1450}}, false, []string{
+			"--may-exist add-port br-int vm3 -- set Interface vm3 type=internal -- clear Port vm3 tag -- set Port vm3 trunks=101,102 -- set Interface vm3 mtu_request=1450",
+		}},
+		// moved to another bridge
+		{"port", &NPState{Name: "vm1", LinkType: "internal", Online: true, L2: L2State{Bridge: "br-ex", Vlan_id: 103}}, true, []string{
+			"--if-exists del-port vm1 -- --may-exist add-port br-ex vm1 -- set Interface vm1 type=internal -- set Port vm1 tag=103 -- clear Port vm1 trunks",
+		}},
+	} {
+		oper := operators[m.action].(func() NpOperator)()
+		oper.Init(m.np)
+
+		// dry-run changes nothing, but records the same invocations
+		vsctl.calls, netdevs.setups = nil, nil
+		done := len(ovsRtPlugin.dryRunVsctl().Steps())
+		if m.modify {
+			oper.Modify(true)
+		} else {
+			oper.Create(true)
+		}
+		if len(vsctl.calls) != 0 || len(netdevs.setups) != 0 {
+			t.Logf("Changes of '%s' made in the dry-run mode: %v %v", m.np.Name, vsctl.calls, netdevs.setups)
+			t.Fail()
+		}
+		planned := []string{}
+		for _, step := range ovsRtPlugin.dryRunVsctl().Steps()[done:] {
+			planned = append(planned, strings.Join(step.Args, " "))
+			if step.Reason == "" {
+				t.Logf("Invocation '%s' has no reason", step)
+				t.Fail()
+			}
+		}
+		if !reflect.DeepEqual(planned, m.calls) {
+			t.Logf("Wrong ovs-vsctl invocations for '%s' in the dry-run mode:\n%v\ninstead:\n%v", m.np.Name, planned, m.calls)
+			t.Fail()
+		}
+
+		if m.modify {
+			oper.Modify(false)
+		} else {
+			oper.Create(false)
+		}
+		if !reflect.DeepEqual(vsctl.calls, m.calls) {
+			t.Logf("Wrong ovs-vsctl calls for '%s':\n%v\ninstead:\n%v", m.np.Name, vsctl.calls, m.calls)
+			t.Fail()
+		}
+		if !reflect.DeepEqual(netdevs.setups, []string{m.np.Name}) {
+			t.Logf("Network device of '%s' should be set up once: %v", m.np.Name, netdevs.setups)
+			t.Fail()
+		}
+	}
+
+	oper := operators["bridge"].(func() NpOperator)()
+	oper.Init(&NPState{Name: "br-ex"})
+	if addrs := oper.IPv4addrList(); !reflect.DeepEqual(addrs, []string{"10.1.0.1/24"}) {
+		t.Logf("Wrong IPv4 addresses of bridge: %v", addrs)
+		t.Fail()
+	}
+}
+
+func TestOVS__NetDevs(t *testing.T) {
+	kernel := lnx.NewMemKernel()
+	brEx := &netlink.Device{}
+	brEx.Name = "br-ex"
+	kernel.AddLink(brEx)
+	netdevs := &lnxNetDevs{log: logger.New(), kernel: kernel}
+
+	if err := netdevs.Setup(&NPState{Name: "br-ex", Online: true, L3: L3State{IPv4: []string{"10.1.0.1/24"}}}); err != nil {
+		t.Logf("Network device can't be set up: %v", err)
+		t.Fail()
+	}
+	wantedOps := []string{"link set br-ex up", "addr add 10.1.0.1/24 dev br-ex"}
+	if ops := kernel.Ops(); !reflect.DeepEqual(ops, wantedOps) {
+		t.Logf("Wrong operations while network device set up:\n%v\ninstead:\n%v", ops, wantedOps)
+		t.Fail()
+	}
+	np := &NPState{Name: "br-ex"}
+	netdevs.Observe(np)
+	if !np.Online || !reflect.DeepEqual(np.L3.IPv4, []string{"10.1.0.1/24"}) {
+		t.Logf("Wrong observed state of network device: %v %v", np.Online, np.L3.IPv4)
+		t.Fail()
+	}
+
+	// network device of bridge may be not created yet
+	if err := netdevs.Setup(&NPState{Name: "br-int", Online: true}); err == nil {
+		t.Logf("Absent network device can't be set up")
+		t.Fail()
+	}
+	if addrs := netdevs.IPv4addrList("br-int"); len(addrs) != 0 {
+		t.Logf("Absent network device has no addresses, given %v", addrs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,192 @@
+package ovs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// VsctlCmd -- ovs-vsctl binary, used for manage OVSDB
+var VsctlCmd = "ovs-vsctl"
+
+// Vsctl -- runs ovs-vsctl with given arguments and returns its output
+type Vsctl interface {
+	Run(args ...string) (string, error)
+}
+
+type execVsctl struct{}
+
+func (s *execVsctl) Run(args ...string) (string, error) {
+	out, err := exec.Command(VsctlCmd, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("'%s %s' failed: %v: %s", VsctlCmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// VsctlStep -- ovs-vsctl invocation with its reason
+type VsctlStep struct {
+	Args   []string
+	Reason string
+}
+
+func (s VsctlStep) String() string {
+	cmd := VsctlCmd + " " + strings.Join(s.Args, " ")
+	if s.Reason == "" {
+		return cmd
+	}
+	return fmt.Sprintf("%s  # %s", cmd, s.Reason)
+}
+
+// dryVsctl -- ovs-vsctl stand-in for the dry-run mode. Invocations are
+// recorded with their reasons in the order of execution instead of running
+type dryVsctl struct {
+	reason string
+	steps  []VsctlStep
+}
+
+func (s *dryVsctl) Run(args ...string) (string, error) {
+	s.steps = append(s.steps, VsctlStep{Args: args, Reason: s.reason})
+	return "", nil
+}
+
+// Explain -- set reason of following invocations
+func (s *dryVsctl) Explain(reason string) {
+	s.reason = reason
+}
+
+// Steps -- returns recorded invocations with their reasons
+func (s *dryVsctl) Steps() []VsctlStep {
+	return s.steps
+}
+
+// -----------------------------------------------------------------------------
+
+// ovsdbRow -- row of OVSDB table, column name to value
+type ovsdbRow map[string]interface{}
+
+// ovsdbList -- fetch given columns of all rows of OVSDB table
+func ovsdbList(vsctl Vsctl, table string, columns ...string) ([]ovsdbRow, error) {
+	out, err := vsctl.Run("--format=json", "--columns="+strings.Join(columns, ","), "list", table)
+	if err != nil {
+		return nil, err
+	}
+	return parseOvsdbList(out)
+}
+
+// parseOvsdbList -- parse 'ovs-vsctl --format=json list' output
+func parseOvsdbList(out string) ([]ovsdbRow, error) {
+	var data struct {
+		Data     [][]interface{} `json:"data"`
+		Headings []string        `json:"headings"`
+	}
+	if err := json.Unmarshal([]byte(out), &data); err != nil {
+		return nil, fmt.Errorf("can't parse ovs-vsctl output: %v", err)
+	}
+	rv := []ovsdbRow{}
+	for _, values := range data.Data {
+		row := make(ovsdbRow)
+		for i, heading := range data.Headings {
+			if i < len(values) {
+				row[heading] = values[i]
+			}
+		}
+		rv = append(rv, row)
+	}
+	return rv, nil
+}
+
+// ovsdbAtoms -- returns atoms of OVSDB value. Set is encoded as
+// ["set", [atom, ...]], but set with one element is encoded as the atom
+func ovsdbAtoms(v interface{}) []interface{} {
+	if pair, ok := v.([]interface{}); ok && len(pair) == 2 {
+		if kind, ok := pair[0].(string); ok && kind == "set" {
+			atoms, _ := pair[1].([]interface{})
+			return atoms
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	return []interface{}{v}
+}
+
+// atom ["uuid", "xxxx"] or "string" or number or bool
+func ovsdbAtomString(v interface{}) string {
+	switch atom := v.(type) {
+	case string:
+		return atom
+	case float64:
+		return fmt.Sprintf("%d", int(atom))
+	case bool:
+		return fmt.Sprintf("%v", atom)
+	case []interface{}:
+		if len(atom) == 2 {
+			if kind, ok := atom[0].(string); ok && (kind == "uuid" || kind == "named-uuid") {
+				return ovsdbAtomString(atom[1])
+			}
+		}
+	}
+	return ""
+}
+
+func (s ovsdbRow) String(column string) string {
+	atoms := ovsdbAtoms(s[column])
+	if len(atoms) == 0 {
+		return ""
+	}
+	return ovsdbAtomString(atoms[0])
+}
+
+func (s ovsdbRow) Strings(column string) []string {
+	rv := []string{}
+	for _, atom := range ovsdbAtoms(s[column]) {
+		rv = append(rv, ovsdbAtomString(atom))
+	}
+	return rv
+}
+
+func (s ovsdbRow) Ints(column string) (rv []int) {
+	for _, atom := range ovsdbAtoms(s[column]) {
+		if v, ok := atom.(float64); ok {
+			rv = append(rv, int(v))
+		}
+	}
+	return rv
+}
+
+func (s ovsdbRow) Int(column string) int {
+	if rv := s.Ints(column); len(rv) > 0 {
+		return rv[0]
+	}
+	return 0
+}
+
+func (s ovsdbRow) Bool(column string) bool {
+	atoms := ovsdbAtoms(s[column])
+	if len(atoms) == 0 {
+		return false
+	}
+	rv, _ := atoms[0].(bool)
+	return rv
+}
+
+// Map -- map is encoded as ["map", [[key, value], ...]]
+func (s ovsdbRow) Map(column string) map[string]string {
+	rv := make(map[string]string)
+	pair, ok := s[column].([]interface{})
+	if !ok || len(pair) != 2 {
+		return rv
+	}
+	if kind, ok := pair[0].(string); !ok || kind != "map" {
+		return rv
+	}
+	items, _ := pair[1].([]interface{})
+	for _, item := range items {
+		if kv, ok := item.([]interface{}); ok && len(kv) == 2 {
+			rv[ovsdbAtomString(kv[0])] = ovsdbAtomString(kv[1])
+		}
+	}
+	return rv
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	cli "github.com/urfave/cli"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/utils"
+)
+
+// Methods of network primitive change
+const (
+	MethodCreate = "create"
+	MethodModify = "modify"
+	MethodRemove = "remove"
+)
+
+// PlanStep -- planned change of one network primitive
+type PlanStep struct {
+	Name   string
+	Method string
+	NP     *npstate.NPState // wanted state
+}
+
+// planChanges -- returns changes of network primitives, found into diff,
+// in the order of implementation
+func planChanges(wantedNetState *npstate.TopologyState, diffNetState *npstate.DiffTopologyStatees) (rv []PlanStep) {
+	for _, npName := range wantedNetState.Order {
+		var method string
+		switch {
+		case IndexString(diffNetState.Waste, npName) >= 0:
+			method = MethodRemove
+		case IndexString(diffNetState.New, npName) >= 0:
+			method = MethodCreate
+		case IndexString(diffNetState.Different, npName) >= 0:
+			method = MethodModify
+		default:
+			continue
+		}
+		rv = append(rv, PlanStep{Name: npName, Method: method, NP: wantedNetState.NP[npName]})
+	}
+	return rv
+}
+
+// verifyConvergence -- observe network topology again after changes and
+// returns network primitives, which still differ from wanted state, with
+// the differences
+func verifyConvergence(rtPlugins *RtPlugins, wantedNetState *npstate.TopologyState) (rv map[string][]string, err error) {
+	if err = rtPlugins.Observe(); err != nil {
+		return nil, err
+	}
+	return divergence(rtPlugins.Topology(wantedNetState), wantedNetState), nil
+}
+
+// divergence -- returns network primitives of wanted network topology,
+// which differ from observed one, with the differences
+func divergence(observed, wantedNetState *npstate.TopologyState) (rv map[string][]string) {
+	rv = make(map[string][]string)
+	for _, step := range planChanges(wantedNetState, observed.Compare(wantedNetState)) {
+		switch step.Method {
+		case MethodCreate:
+			rv[step.Name] = []string{"does not exist"}
+		case MethodRemove:
+			rv[step.Name] = []string{"still exists"}
+		default:
+			rv[step.Name] = observed.NP[step.Name].Changes(step.NP)
+		}
+	}
+	return rv
+}
+
+// RunDiff -- show differences between current network topology and
+// network scheme
+func RunDiff(c *cli.Context) (err error) {
+	var changes *changeSet
+	if changes, err = observeChanges(c); err != nil {
+		return err
+	}
+
+	steps := planChanges(changes.wanted, changes.diff)
+	if len(steps) == 0 {
+		fmt.Printf("No differences found\n")
+		return nil
+	}
+	marks := map[string]string{MethodCreate: "+", MethodModify: "~", MethodRemove: "-"}
+	for _, step := range steps {
+		fmt.Printf("%s %s (%s, provider '%s')\n", marks[step.Method], step.Name, step.NP.Action, step.NP.Provider)
+		if step.Method != MethodModify {
+			continue
+		}
+		for _, change := range changes.observed.NP[step.Name].Changes(step.NP) {
+			fmt.Printf("    %s\n", change)
+		}
+	}
+	return nil
+}
+
+// RunPlan -- show changes, required to implement network scheme, in the
+// order of implementation
+func RunPlan(c *cli.Context) (err error) {
+	var changes *changeSet
+	if changes, err = observeChanges(c); err != nil {
+		return err
+	}
+
+	steps := planChanges(changes.wanted, changes.diff)
+	if len(steps) == 0 {
+		fmt.Printf("Nothing to do\n")
+		return nil
+	}
+	for i, step := range steps {
+		fmt.Printf("%d. %s %s '%s' by provider '%s'\n", i+1, step.Method, step.NP.Action, step.Name, step.NP.Provider)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+)
+
+// DefaultConfigProvider -- config plugin, used if network scheme has no
+// 'config-provider'
+const DefaultConfigProvider = "netplan"
+
+// ConfigFile -- one file of persistent network configuration
+type ConfigFile struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// ConfigPlugin -- generator of persistent network configuration (netplan,
+// ifupdown, etc.), which reproduces wanted network topology after reboot
+type ConfigPlugin interface {
+	SetLogger(*logger.Logger)
+	SetWantedState(*npstate.NPStates)
+	Generate() error
+	DefaultPath() string // file or directory, where configuration is stored by default
+	// Files -- returns files of generated configuration, which should be
+	// stored into given path (file or directory, depends on plugin)
+	Files(path string) []ConfigFile
+}
+
+// ConfigCleaner -- config plugin, which stores configuration as several
+// files into directory. Files, generated before for network primitives,
+// which are absent into the network scheme now, should be removed
+type ConfigCleaner interface {
+	// GeneratedFiles -- returns files into given directory, which were
+	// generated by plugin (now or before)
+	GeneratedFiles(path string) ([]string, error)
+}
+
+// ConfigPluginFactory -- function, which creates new instance of config plugin
+type ConfigPluginFactory func() ConfigPlugin
+
+var (
+	configPluginsMutex sync.RWMutex
+	configPlugins      = make(map[string]ConfigPluginFactory)
+)
+
+// RegisterConfigPlugin -- make config plugin available by config provider
+// name. Should be called from init() of plugin package
+func RegisterConfigPlugin(name string, factory ConfigPluginFactory) {
+	configPluginsMutex.Lock()
+	defer configPluginsMutex.Unlock()
+	if factory == nil {
+		panic("plugin: RegisterConfigPlugin factory is nil")
+	}
+	if _, dup := configPlugins[name]; dup {
+		panic(fmt.Sprintf("plugin: RegisterConfigPlugin called twice for config provider '%s'", name))
+	}
+	configPlugins[name] = factory
+}
+
+// NewConfigPlugin -- create new instance of config plugin for given config
+// provider
+func NewConfigPlugin(name string) (ConfigPlugin, error) {
+	configPluginsMutex.RLock()
+	factory, ok := configPlugins[name]
+	configPluginsMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown config provider '%s'", name)
+	}
+	return factory(), nil
+}
+
+// ConfigPluginNames -- returns sorted list of registered config providers
+func ConfigPluginNames() []string {
+	configPluginsMutex.RLock()
+	defer configPluginsMutex.RUnlock()
+	rv := []string{}
+	for name := range configPlugins {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
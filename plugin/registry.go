@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RtPluginFactory -- function, which creates new instance of runtime plugin
+type RtPluginFactory func() RtPlugin
+
+var (
+	rtPluginsMutex sync.RWMutex
+	rtPlugins      = make(map[string]RtPluginFactory)
+)
+
+// RegisterRtPlugin -- make runtime plugin available by provider name.
+// Should be called from init() of plugin package
+func RegisterRtPlugin(name string, factory RtPluginFactory) {
+	rtPluginsMutex.Lock()
+	defer rtPluginsMutex.Unlock()
+	if factory == nil {
+		panic("plugin: RegisterRtPlugin factory is nil")
+	}
+	if _, dup := rtPlugins[name]; dup {
+		panic(fmt.Sprintf("plugin: RegisterRtPlugin called twice for provider '%s'", name))
+	}
+	rtPlugins[name] = factory
+}
+
+// NewRtPlugin -- create new instance of runtime plugin for given provider
+func NewRtPlugin(name string) (RtPlugin, error) {
+	rtPluginsMutex.RLock()
+	factory, ok := rtPlugins[name]
+	rtPluginsMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider '%s'", name)
+	}
+	return factory(), nil
+}
+
+// RtPluginNames -- returns sorted list of registered providers
+func RtPluginNames() []string {
+	rtPluginsMutex.RLock()
+	defer rtPluginsMutex.RUnlock()
+	rv := []string{}
+	for name := range rtPlugins {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
@@ -1,6 +1,8 @@
 package plugin
 
 import (
+	"sync"
+
 	"github.com/vishvananda/netlink"
 	logger "github.com/xenolog/go-tiny-logger"
 	npstate "github.com/xenolog/l23/npstate"
@@ -23,6 +25,37 @@ type NpOperator interface {
 
 type NpOperators map[string]interface{}
 
+// LockedOperator -- NpOperator wrapper, which serializes changes, made by
+// operators of one runtime plugin, with given Locker
+type LockedOperator struct {
+	NpOperator
+	sync.Locker
+}
+
+func (s *LockedOperator) Create(dryrun bool) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.NpOperator.Create(dryrun)
+}
+
+func (s *LockedOperator) Remove(dryrun bool) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.NpOperator.Remove(dryrun)
+}
+
+func (s *LockedOperator) Modify(dryrun bool) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.NpOperator.Modify(dryrun)
+}
+
+func (s *LockedOperator) IPv4addrList() []string {
+	s.Lock()
+	defer s.Unlock()
+	return s.NpOperator.IPv4addrList()
+}
+
 type RtPlugin interface {
 	Init(*logger.Logger, *netlink.Handle) error
 	Version() string
@@ -34,4 +67,46 @@ type RtPlugin interface {
 	// GetHandle() *netlink.Handle
 }
 
+// IfResolver -- optional interface of RtPlugin, allows to find physical
+// interfaces by match rules and rename them if need.
+type IfResolver interface {
+	ResolveInterface(match map[string]string, setName string, dryrun bool) (string, error)
+}
+
+// RtEvent -- change of network primitive into runtime, reported by Watcher
+type RtEvent struct {
+	Name   string // network primitive name
+	Reason string // human readable description of change
+}
+
+// Watcher -- optional interface of RtPlugin, allows to subscribe to runtime
+// changes. Events will be sent to returned channel until 'done' will be closed
+type Watcher interface {
+	Watch(done <-chan struct{}) (<-chan RtEvent, error)
+}
+
+// PortAttacher -- optional interface of RtPlugin, allows to attach network
+// primitives, managed by another provider, to bridges of this provider
+// (linux bond into OVS bridge, for example)
+type PortAttacher interface {
+	Membership() map[string]string // observed port name to bridge name
+	AttachPort(port, bridge string, dryrun bool) error
+	DetachPort(port string, dryrun bool) error
+}
+
+// TopologyLoader -- optional interface of RtPlugin, allows to use network
+// topology, observed earlier (snapshot), instead of observing the live one
+type TopologyLoader interface {
+	LoadTopology(topology *npstate.TopologyState)
+}
+
+// -----------------------------------------------------------------------------
+
+// EthtoolLimiter -- optional interface of RtPlugin, allows to observe NIC
+// settings (several ethtool requests per interface) only for given network
+// primitives. NIC settings of all interfaces are observed by default
+type EthtoolLimiter interface {
+	LimitEthtool(names []string)
+}
+
 // -----------------------------------------------------------------------------
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
+)
+
+// RtPlugins -- initialized runtime plugins of providers, used by network
+// scheme. Providers list contains the default provider first
+type RtPlugins struct {
+	Providers []string
+	Plugins   map[string]plugin.RtPlugin
+	snapshot  *npstate.TopologyState // used instead of observing, if defined
+}
+
+// NewRtPlugins -- create and initialize runtime plugins for given providers
+func NewRtPlugins(providers []string) (rv *RtPlugins, err error) {
+	rv = &RtPlugins{
+		Providers: providers,
+		Plugins:   make(map[string]plugin.RtPlugin),
+	}
+	for _, name := range providers {
+		var rtPlugin plugin.RtPlugin
+		if rtPlugin, err = plugin.NewRtPlugin(name); err != nil {
+			return nil, err
+		}
+		if err = rtPlugin.Init(Log, nil); err != nil {
+			return nil, fmt.Errorf("can't initialize provider '%s': %v", name, err)
+		}
+		rv.Plugins[name] = rtPlugin
+	}
+	return rv, nil
+}
+
+// LimitEthtool -- observe NIC settings only for network primitives, which
+// have them defined into wanted network topology
+func (s *RtPlugins) LimitEthtool(wanted *npstate.TopologyState) {
+	names := wanted.EthtoolDefined()
+	for _, name := range s.Providers {
+		if limiter, ok := s.Plugins[name].(plugin.EthtoolLimiter); ok {
+			limiter.LimitEthtool(names)
+		}
+	}
+}
+
+// UseSnapshot -- use network topology from snapshot instead of observing
+// it by providers
+func (s *RtPlugins) UseSnapshot(snapshot *npstate.TopologyState) {
+	s.snapshot = snapshot
+	// providers, able to use snapshot, get their part of it
+	for _, name := range s.Providers {
+		loader, ok := s.Plugins[name].(plugin.TopologyLoader)
+		if !ok {
+			continue
+		}
+		topology := npstate.NewTopologyState()
+		for _, npName := range snapshot.Order {
+			np := snapshot.NP[npName]
+			if np.Provider == name || (np.Provider == "" && name == s.Providers[0]) {
+				topology.NP[npName] = np
+				topology.Order = append(topology.Order, npName)
+			}
+		}
+		loader.LoadTopology(topology)
+	}
+}
+
+// Observe -- gather current network topology by all providers
+func (s *RtPlugins) Observe() error {
+	if s.snapshot != nil {
+		return nil
+	}
+	for _, name := range s.Providers {
+		if err := s.Plugins[name].Observe(); err != nil {
+			return fmt.Errorf("provider '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Topology -- merge network topologies, observed by all providers.
+// Network primitive, which should be managed by a provider, is taken from
+// topology of this provider only. Other network primitives are taken from
+// topology of the first provider, which observes it
+func (s *RtPlugins) Topology(wanted *npstate.TopologyState) *npstate.TopologyState {
+	if s.snapshot != nil {
+		return s.snapshotTopology(wanted)
+	}
+	rv := npstate.NewTopologyState()
+	for _, name := range s.Providers {
+		topology := s.Plugins[name].Topology()
+		if topology == nil {
+			continue
+		}
+		for npName, np := range topology.NP {
+			if _, ok := rv.NP[npName]; ok {
+				continue
+			}
+			if wnp, ok := wanted.NP[npName]; ok && wnp.Provider != name {
+				// wanted from another provider. If that provider does not
+				// observe it, network primitive will be created
+				continue
+			}
+			np.Provider = name
+			rv.NP[npName] = np
+			rv.Order = append(rv.Order, npName)
+		}
+	}
+	sort.Strings(rv.Order)
+
+	// membership of network primitives into bridges of another providers
+	// is known by provider of bridge only
+	for _, name := range s.Providers {
+		attacher, ok := s.Plugins[name].(plugin.PortAttacher)
+		if !ok {
+			continue
+		}
+		membership := attacher.Membership()
+		for npName, np := range rv.NP {
+			if np.Provider == name {
+				continue
+			}
+			wnp, ok := wanted.NP[npName]
+			bridge, attached := membership[npName]
+			if !attached && (!ok || s.bridgeProvider(wanted, wnp) != name) {
+				continue
+			}
+			tmp := *np
+			tmp.L2.Bridge = bridge
+			rv.NP[npName] = &tmp
+		}
+	}
+	return rv
+}
+
+// snapshotTopology -- network topology from snapshot. Network primitives,
+// observed without provider, are considered as observed by the default one
+func (s *RtPlugins) snapshotTopology(wanted *npstate.TopologyState) *npstate.TopologyState {
+	rv := npstate.NewTopologyState()
+	for _, npName := range s.snapshot.Order {
+		np := *s.snapshot.NP[npName]
+		if np.Provider == "" {
+			np.Provider = s.Providers[0]
+		}
+		if wnp, ok := wanted.NP[npName]; ok && wnp.Provider != np.Provider {
+			continue
+		}
+		rv.NP[npName] = &np
+		rv.Order = append(rv.Order, npName)
+	}
+	return rv
+}
+
+// ResolveBySnapshot -- find physical interface into snapshot by match
+// rules and rename it into snapshot to 'setName' if need, like the real run
+// does. Only 'macaddress' rule can be resolved this way
+func (s *RtPlugins) ResolveBySnapshot(match map[string]string, setName string) (string, error) {
+	found := []string{}
+	for _, npName := range s.snapshot.Order {
+		np := s.snapshot.NP[npName]
+		if np.LinkType != "device" {
+			continue
+		}
+		matched := true
+		for key, value := range match {
+			if key != "macaddress" {
+				return "", fmt.Errorf("match rule '%s' can't be resolved by snapshot", key)
+			}
+			matched = matched && strings.EqualFold(np.L2.HwAddr, value)
+		}
+		if matched {
+			found = append(found, npName)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return "", fmt.Errorf("no one interface matches %v", match)
+	case len(found) > 1:
+		return "", fmt.Errorf("more than one interface matches %v: %v", match, found)
+	case setName == "" || setName == found[0]:
+		return found[0], nil
+	}
+	s.snapshot.Rename(found[0], setName)
+	// providers get renamed interface too
+	s.UseSnapshot(s.snapshot)
+	return setName, nil
+}
+
+// bridgeProvider -- returns provider of bridge, wanted for network primitive
+func (s *RtPlugins) bridgeProvider(wanted *npstate.TopologyState, np *npstate.NPState) string {
+	if br, ok := wanted.NP[np.L2.Bridge]; ok && np.L2.Bridge != "" {
+		return br.Provider
+	}
+	return ""
+}
+
+// ForeignBridge -- returns true if network primitive should be a member of
+// bridge, managed by another provider, able to attach it
+func (s *RtPlugins) ForeignBridge(wanted *npstate.TopologyState, np *npstate.NPState) bool {
+	provider := s.bridgeProvider(wanted, np)
+	if provider == "" || provider == np.Provider {
+		return false
+	}
+	_, ok := s.Plugins[provider].(plugin.PortAttacher)
+	return ok
+}
+
+// SyncMembership -- attach network primitive to bridges of another providers
+// or detach from them, correspond to wanted state
+func (s *RtPlugins) SyncMembership(wanted *npstate.TopologyState, np *npstate.NPState, dryrun bool) (err error) {
+	for _, name := range s.Providers {
+		attacher, ok := s.Plugins[name].(plugin.PortAttacher)
+		if !ok || name == np.Provider {
+			continue
+		}
+		actual := attacher.Membership()[np.Name]
+		bridge := ""
+		if s.bridgeProvider(wanted, np) == name {
+			bridge = np.L2.Bridge
+		}
+		switch {
+		case actual == bridge:
+			continue
+		case bridge != "":
+			err = attacher.AttachPort(np.Name, bridge, dryrun)
+		default:
+			err = attacher.DetachPort(np.Name, dryrun)
+		}
+		if err != nil {
+			return fmt.Errorf("provider '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Operators -- returns operators of provider, which should manage given
+// network primitive
+func (s *RtPlugins) Operators(np *npstate.NPState) (plugin.NpOperators, error) {
+	rtPlugin, ok := s.Plugins[np.Provider]
+	if !ok {
+		return nil, fmt.Errorf("provider '%s' for '%s' is not initialized", np.Provider, np.Name)
+	}
+	return rtPlugin.Operators(), nil
+}
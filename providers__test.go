@@ -0,0 +1,236 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	logger "github.com/xenolog/go-tiny-logger"
+	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
+)
+
+// fakeRtPlugin -- runtime plugin with predefined topology
+type fakeRtPlugin struct {
+	topology *npstate.TopologyState
+}
+
+func (s *fakeRtPlugin) Init(log *logger.Logger, hh *netlink.Handle) error { return nil }
+func (s *fakeRtPlugin) Version() string                                   { return "fake" }
+func (s *fakeRtPlugin) Operators() plugin.NpOperators                     { return plugin.NpOperators{} }
+func (s *fakeRtPlugin) Observe() error                                    { return nil }
+func (s *fakeRtPlugin) Topology() *npstate.TopologyState                  { return s.topology }
+func (s *fakeRtPlugin) GetLogger() *logger.Logger                         { return nil }
+
+func fakeTopology(names ...string) *npstate.TopologyState {
+	rv := npstate.NewTopologyState()
+	for _, name := range names {
+		rv.NP[name] = &npstate.NPState{Name: name, Action: "port", Online: true}
+		rv.Order = append(rv.Order, name)
+	}
+	return rv
+}
+
+func TestProviders__Topology(t *testing.T) {
+	rtPlugins := &RtPlugins{
+		Providers: []string{"lnx", "ovs"},
+		Plugins: map[string]plugin.RtPlugin{
+			"lnx": &fakeRtPlugin{topology: fakeTopology("lo", "eth0", "br-ex", "br-int", "eth1")},
+			"ovs": &fakeRtPlugin{topology: fakeTopology("br-ex", "br-eth1")},
+		},
+	}
+	wanted := npstate.NewTopologyState()
+	for name, provider := range map[string]string{
+		"eth0":    "lnx",
+		"eth1":    "lnx",
+		"br-ex":   "ovs",
+		"br-int":  "ovs",
+		"br-eth1": "ovs",
+	} {
+		wanted.NP[name] = &npstate.NPState{Name: name, Action: "bridge", Provider: provider}
+	}
+
+	topology := rtPlugins.Topology(wanted)
+	// br-int observed by 'lnx' only, but should be managed by 'ovs'
+	if !reflect.DeepEqual(topology.Order, []string{"br-eth1", "br-ex", "eth0", "eth1", "lo"}) {
+		t.Logf("Wrong merged topology: %v", topology.Order)
+		t.Fail()
+	}
+	for name, provider := range map[string]string{
+		"lo":      "lnx",
+		"eth0":    "lnx",
+		"br-ex":   "ovs",
+		"br-eth1": "ovs",
+	} {
+		if topology.NP[name].Provider != provider {
+			t.Logf("'%s' taken from provider '%s', instead '%s'", name, topology.NP[name].Provider, provider)
+			t.Fail()
+		}
+	}
+
+	diff := topology.Compare(wanted)
+	if !reflect.DeepEqual(diff.New, []string{"br-int"}) {
+		t.Logf("Wrong list of new network primitives: %v", diff.New)
+		t.Fail()
+	}
+}
+
+func TestProviders__Operators(t *testing.T) {
+	rtPlugins := &RtPlugins{
+		Providers: []string{"lnx"},
+		Plugins: map[string]plugin.RtPlugin{
+			"lnx": &fakeRtPlugin{},
+		},
+	}
+	if _, err := rtPlugins.Operators(&npstate.NPState{Name: "eth0", Provider: "lnx"}); err != nil {
+		t.Logf("Operators of 'lnx' should be found: %v", err)
+		t.Fail()
+	}
+	if _, err := rtPlugins.Operators(&npstate.NPState{Name: "br-ex", Provider: "ovs"}); err == nil {
+		t.Logf("Operators of not initialized provider should not be found")
+		t.Fail()
+	}
+}
+
+func TestProviders__Registry(t *testing.T) {
+	if _, err := NewRtPlugins([]string{"unknown"}); err == nil {
+		t.Logf("Unknown provider should not be initialized")
+		t.Fail()
+	}
+	rtPlugins, err := NewRtPlugins([]string{"lnx"})
+	if err != nil {
+		t.Logf("Provider 'lnx' should be registered: %v", err)
+		t.FailNow()
+	}
+	if _, ok := rtPlugins.Plugins["lnx"]; !ok {
+		t.Fail()
+	}
+}
+
+// fakeAttacher -- runtime plugin, which able to attach ports of another
+// providers to own bridges
+type fakeAttacher struct {
+	fakeRtPlugin
+	membership map[string]string
+	calls      []string
+}
+
+func (s *fakeAttacher) Membership() map[string]string { return s.membership }
+func (s *fakeAttacher) AttachPort(port, bridge string, dryrun bool) error {
+	s.calls = append(s.calls, "attach "+port+" "+bridge)
+	return nil
+}
+func (s *fakeAttacher) DetachPort(port string, dryrun bool) error {
+	s.calls = append(s.calls, "detach "+port)
+	return nil
+}
+
+func TestProviders__ForeignBridge(t *testing.T) {
+	ovs := &fakeAttacher{
+		fakeRtPlugin: fakeRtPlugin{topology: fakeTopology("br-ex", "br-int")},
+		membership:   map[string]string{"bond0": "br-int", "eth3": "br-ex"},
+	}
+	rtPlugins := &RtPlugins{
+		Providers: []string{"lnx", "ovs"},
+		Plugins: map[string]plugin.RtPlugin{
+			"lnx": &fakeRtPlugin{topology: fakeTopology("eth1", "eth2", "eth3", "bond0")},
+			"ovs": ovs,
+		},
+	}
+	wanted := npstate.NewTopologyState()
+	wanted.NP["br-ex"] = &npstate.NPState{Name: "br-ex", Action: "bridge", Provider: "ovs", Online: true}
+	wanted.NP["br-int"] = &npstate.NPState{Name: "br-int", Action: "bridge", Provider: "ovs", Online: true}
+	wanted.NP["bond0"] = &npstate.NPState{Name: "bond0", Action: "port", Provider: "lnx", Online: true, L2: npstate.L2State{Bridge: "br-ex"}}
+	wanted.NP["eth3"] = &npstate.NPState{Name: "eth3", Action: "port", Provider: "lnx", Online: true}
+	wanted.NP["eth2"] = &npstate.NPState{Name: "eth2", Action: "port", Provider: "lnx", Online: true, L2: npstate.L2State{Bridge: "br-ex"}}
+
+	topology := rtPlugins.Topology(wanted)
+	if topology.NP["bond0"].L2.Bridge != "br-int" || topology.NP["eth3"].L2.Bridge != "br-ex" || topology.NP["eth2"].L2.Bridge != "" {
+		t.Logf("Membership into OVS bridges is not merged: bond0=%s, eth2=%s, eth3=%s", topology.NP["bond0"].L2.Bridge,
+			topology.NP["eth2"].L2.Bridge, topology.NP["eth3"].L2.Bridge)
+		t.Fail()
+	}
+	diff := topology.Compare(wanted)
+	sort.Strings(diff.Different)
+	if !reflect.DeepEqual(diff.Different, []string{"bond0", "eth2", "eth3"}) {
+		t.Logf("Wrong list of different network primitives: %v", diff.Different)
+		t.Fail()
+	}
+
+	if !rtPlugins.ForeignBridge(wanted, wanted.NP["bond0"]) || rtPlugins.ForeignBridge(wanted, wanted.NP["eth3"]) {
+		t.Logf("Bridges of another provider are detected wrong")
+		t.Fail()
+	}
+	for _, npName := range diff.Different {
+		rtPlugins.SyncMembership(wanted, wanted.NP[npName], false)
+	}
+	if !reflect.DeepEqual(ovs.calls, []string{"attach bond0 br-ex", "attach eth2 br-ex", "detach eth3"}) {
+		t.Logf("Wrong attach/detach calls: %v", ovs.calls)
+		t.Fail()
+	}
+}
+
+func TestProviders__Snapshot(t *testing.T) {
+	rtPlugins := &RtPlugins{
+		Providers: []string{"lnx", "ovs"},
+		Plugins: map[string]plugin.RtPlugin{
+			"lnx": &fakeRtPlugin{topology: fakeTopology("lo")},
+			"ovs": &fakeRtPlugin{topology: fakeTopology()},
+		},
+	}
+	snapshot := fakeTopology("eth0", "eth1", "br-ex")
+	snapshot.NP["br-ex"].Provider = "ovs"
+	for _, name := range []string{"eth0", "eth1"} {
+		snapshot.NP[name].LinkType = "device"
+	}
+	snapshot.NP["eth0"].L2.HwAddr = "52:54:00:00:00:01"
+	snapshot.NP["eth1"].L2.HwAddr = "52:54:00:00:00:02"
+	rtPlugins.UseSnapshot(snapshot)
+
+	wanted := npstate.NewTopologyState()
+	wanted.NP["eth1"] = &npstate.NPState{Name: "eth1", Action: "port", Provider: "ovs"}
+	wanted.NP["br-ex"] = &npstate.NPState{Name: "br-ex", Action: "bridge", Provider: "ovs"}
+	topology := rtPlugins.Topology(wanted)
+	// eth1 is observed by 'lnx' (default), but wanted from 'ovs'
+	if !reflect.DeepEqual(topology.Order, []string{"eth0", "br-ex"}) || topology.NP["eth0"].Provider != "lnx" {
+		t.Logf("Wrong topology from snapshot: %v", topology.Order)
+		t.Fail()
+	}
+
+	if name, err := rtPlugins.ResolveBySnapshot(map[string]string{"macaddress": "52:54:00:00:00:02"}, ""); err != nil || name != "eth1" {
+		t.Logf("Interface resolved by snapshot as '%s', instead 'eth1': %v", name, err)
+		t.Fail()
+	}
+	if _, err := rtPlugins.ResolveBySnapshot(map[string]string{"driver": "virtio*"}, ""); err == nil {
+		t.Logf("Driver match rule can't be resolved by snapshot")
+		t.Fail()
+	}
+	// interface is renamed into snapshot, like by the real run
+	if name, err := rtPlugins.ResolveBySnapshot(map[string]string{"macaddress": "52:54:00:00:00:01"}, "uplink0"); err != nil || name != "uplink0" {
+		t.Logf("Interface resolved by snapshot as '%s', instead 'uplink0': %v", name, err)
+		t.Fail()
+	}
+	topology = rtPlugins.Topology(wanted)
+	if !reflect.DeepEqual(topology.Order, []string{"uplink0", "br-ex"}) || topology.NP["uplink0"].Name != "uplink0" {
+		t.Logf("Renamed interface is not found into topology from snapshot: %v", topology.Order)
+		t.Fail()
+	}
+}
+
+func TestProviders__PlanChanges(t *testing.T) {
+	wanted := fakeTopology("br1", "eth1", "eth2", "eth3")
+	diff := &npstate.DiffTopologyStatees{
+		New:       []string{"br1"},
+		Waste:     []string{"lo", "eth3"},
+		Different: []string{"eth1"},
+	}
+	steps := []string{}
+	for _, step := range planChanges(wanted, diff) {
+		steps = append(steps, step.Method+" "+step.Name)
+	}
+	if !reflect.DeepEqual(steps, []string{"create br1", "modify eth1", "remove eth3"}) {
+		t.Logf("Wrong plan: %v", steps)
+		t.Fail()
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+)
+
+// Results of network primitive change
+const (
+	ResultCreated   = "created"
+	ResultModified  = "modified"
+	ResultRemoved   = "removed"
+	ResultUnchanged = "unchanged"
+	ResultFailed    = "failed"
+)
+
+// Exit codes of network configuring
+const (
+	ExitSuccess        = 0 // changes implemented
+	ExitFailure        = 1 // network scheme can't be processed
+	ExitPartialFailure = 2 // some changes failed or are not converged
+	ExitNoChange       = 3 // network topology already corresponds to network scheme
+)
+
+// NpResult -- result of change of one network primitive
+type NpResult struct {
+	Name     string        `json:"name"`
+	Action   string        `json:"action,omitempty"`
+	Provider string        `json:"provider,omitempty"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"` // nanoseconds
+	Error    string        `json:"error,omitempty"`
+	err      error
+}
+
+// ApplyReport -- results of changes of network primitives in the order of
+// implementation
+type ApplyReport struct {
+	DryRun   bool                `json:"dryrun"`
+	NP       []*NpResult         `json:"np"`
+	Diverged map[string][]string `json:"diverged,omitempty"` // differences, found after changes
+}
+
+func NewApplyReport(dryrun bool) *ApplyReport {
+	return &ApplyReport{
+		DryRun: dryrun,
+		NP:     []*NpResult{},
+	}
+}
+
+// add -- add result of network primitive change. Result is 'failed' if
+// error given
+func (s *ApplyReport) add(name, action, provider, result string, duration time.Duration, err error) {
+	rv := &NpResult{
+		Name:     name,
+		Action:   action,
+		Provider: provider,
+		Result:   result,
+		Duration: duration,
+		err:      err,
+	}
+	if err != nil {
+		rv.Result = ResultFailed
+		rv.Error = err.Error()
+	}
+	s.NP = append(s.NP, rv)
+}
+
+// Failed -- returns errors of failed network primitives
+func (s *ApplyReport) Failed() map[string]error {
+	rv := make(map[string]error)
+	for _, np := range s.NP {
+		if np.err != nil {
+			rv[np.Name] = np.err
+		}
+	}
+	return rv
+}
+
+// ExitCode -- returns exit code, corresponded to results of changes
+func (s *ApplyReport) ExitCode() int {
+	if len(s.Failed()) > 0 || len(s.Diverged) > 0 {
+		return ExitPartialFailure
+	}
+	for _, np := range s.NP {
+		if np.Result != ResultUnchanged {
+			return ExitSuccess
+		}
+	}
+	return ExitNoChange
+}
+
+// Print -- print report as a summary table
+func (s *ApplyReport) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tACTION\tPROVIDER\tRESULT\tDURATION\tERROR\n")
+	for _, np := range s.NP {
+		result := np.Result
+		if s.DryRun && result != ResultUnchanged && result != ResultFailed {
+			result += " (dry-run)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\t%s\n", np.Name, np.Action, np.Provider, result, np.Duration, np.Error)
+	}
+	tw.Flush()
+	for _, np := range s.NP {
+		if fields, ok := s.Diverged[np.Name]; ok {
+			fmt.Fprintf(w, "Not converged '%s': %v\n", np.Name, fields)
+		}
+	}
+}
+
+// Store -- store report to file in JSON format
+func (s *ApplyReport) Store(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport__ExitCode(t *testing.T) {
+	report := NewApplyReport(false)
+	report.add("eth0", "port", "lnx", ResultUnchanged, 0, nil)
+	if code := report.ExitCode(); code != ExitNoChange {
+		t.Logf("Wrong exit code for unchanged topology: %d", code)
+		t.Fail()
+	}
+
+	report.add("br1", "bridge", "lnx", ResultCreated, time.Millisecond, nil)
+	if code := report.ExitCode(); code != ExitSuccess {
+		t.Logf("Wrong exit code for implemented changes: %d", code)
+		t.Fail()
+	}
+
+	report.Diverged = map[string][]string{"br1": {"online: false -> true"}}
+	if code := report.ExitCode(); code != ExitPartialFailure {
+		t.Logf("Wrong exit code for not converged topology: %d", code)
+		t.Fail()
+	}
+
+	report.Diverged = nil
+	report.add("eth1.101", "port", "lnx", ResultCreated, time.Millisecond, fmt.Errorf("test error"))
+	if code := report.ExitCode(); code != ExitPartialFailure {
+		t.Logf("Wrong exit code for failed changes: %d", code)
+		t.Fail()
+	}
+	if failed := report.Failed(); !reflect.DeepEqual(failed, map[string]error{"eth1.101": fmt.Errorf("test error")}) {
+		t.Logf("Wrong failed network primitives: %v", failed)
+		t.Fail()
+	}
+	if np := report.NP[2]; np.Result != ResultFailed || np.Error != "test error" {
+		t.Logf("Wrong result of failed network primitive: %+v", np)
+		t.Fail()
+	}
+}
+
+func TestReport__Print(t *testing.T) {
+	report := NewApplyReport(true)
+	report.add("eth0", "port", "lnx", ResultUnchanged, 0, nil)
+	report.add("br1", "bridge", "lnx", ResultCreated, time.Millisecond, nil)
+	buf := new(bytes.Buffer)
+	report.Print(buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") ||
+		!strings.Contains(lines[1], "unchanged") || !strings.Contains(lines[2], "created (dry-run)") {
+		t.Logf("Wrong report:\n%s", buf.String())
+		t.Fail()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xenolog/l23/lnx"
+)
+
+// TestSim__Migration -- implement network schemes of functional test one by
+// one on the simulated host
+func TestSim__Migration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-sim")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { lnx.SimStatePath = path }(lnx.SimStatePath)
+	defer func() { providerOverride = "" }()
+	lnx.SimStatePath = filepath.Join(dir, "state.yaml")
+	providerOverride = "sim"
+
+	testDir := "func_tests/bond/002_bond_interfaces_manipulation"
+	for i := 1; i <= 3; i++ {
+		ns, err := loadNetworkScheme(filepath.Join(testDir, fmt.Sprintf("network_scheme_%02d.yaml", i)))
+		if err != nil {
+			t.Logf("Can't load network scheme #%02d: %v", i, err)
+			t.FailNow()
+		}
+		rtPlugins, err := initRtPlugins(ns)
+		if err != nil {
+			t.FailNow()
+		}
+		wanted := ns.TopologyState()
+		rtPlugins.Observe()
+		diff := rtPlugins.Topology(wanted).Compare(wanted)
+		if failed := applyChanges(rtPlugins, wanted, diff, false).Failed(); len(failed) > 0 {
+			t.Logf("Network scheme #%02d not implemented: %v", i, failed)
+			t.Fail()
+		}
+		diverged, err := verifyConvergence(rtPlugins, wanted)
+		if err != nil {
+			t.Logf("Can't verify network scheme #%02d: %v", i, err)
+			t.FailNow()
+		}
+		if len(diverged) > 0 {
+			t.Logf("Network scheme #%02d not converged after changes: %v", i, diverged)
+			t.Fail()
+		}
+
+		// state is kept between runs, so the next run has nothing to do
+		if rtPlugins, err = initRtPlugins(ns); err != nil {
+			t.FailNow()
+		}
+		rtPlugins.Observe()
+		steps := []string{}
+		for _, step := range planChanges(wanted, rtPlugins.Topology(wanted).Compare(wanted)) {
+			steps = append(steps, step.Method+" "+step.Name)
+		}
+		if len(steps) > 0 {
+			t.Logf("Network scheme #%02d not converged: %v", i, steps)
+			t.Fail()
+		}
+	}
+
+	rtPlugins, _ := NewRtPlugins([]string{"sim"})
+	rtPlugins.Observe()
+	topology := rtPlugins.Plugins["sim"].Topology()
+	if bond := topology.NP["bond0"]; bond == nil || bond.L2.Bridge != "br1" || !reflect.DeepEqual(bond.L2.Slaves, []string{"eth1", "eth2"}) || len(bond.L3.IPv4) != 0 {
+		t.Logf("Wrong final state of 'bond0': %v", bond)
+		t.Fail()
+	}
+	if br := topology.NP["br1"]; br == nil || !reflect.DeepEqual(br.L3.IPv4, []string{"10.1.251.1/24"}) {
+		t.Logf("Wrong final state of 'br1': %v", br)
+		t.Fail()
+	}
+	if eth3 := topology.NP["eth3"]; eth3 == nil || eth3.Master() != 0 || !eth3.Online {
+		t.Logf("Wrong final state of 'eth3': %v", eth3)
+		t.Fail()
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+const (
+	// BackupDir -- directory of network config backups, created near
+	// network config. It is hidden, so it is not read by network
+	// configuration tools, even by 'source /etc/network/interfaces.d/*'
+	BackupDir = ".l23-backups"
+	// BackupSuffix -- suffix of network config backups
+	BackupSuffix = ".bak"
+	// BackupTimeFormat -- format of backup timestamp, sortable as string
+	BackupTimeFormat = "20060102-150405.000"
+)
+
+// storeConfigFile -- store network config file atomically. Previous
+// version of file is kept as timestamped backup, only 'backups' newest
+// backups are kept. Returns false if file is not changed
+func storeConfigFile(file plugin.ConfigFile, backups int) (changed bool, err error) {
+	current, err := ioutil.ReadFile(file.Path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return false, err
+	case bytes.Equal(current, file.Content):
+		// file could exist before with another permissions
+		return false, os.Chmod(file.Path, file.Mode)
+	default:
+		if err = backupConfigFile(file.Path, current, backups); err != nil {
+			return false, err
+		}
+	}
+	return true, writeFileAtomic(file.Path, file.Content, file.Mode)
+}
+
+// removeConfigFile -- remove network config file, keeping it as
+// timestamped backup
+func removeConfigFile(path string, backups int) error {
+	if backups > 0 {
+		name, err := backupName(path)
+		if err != nil {
+			return err
+		}
+		if err = os.Rename(path, name); err != nil {
+			return err
+		}
+		return pruneBackups(path, backups)
+	}
+	return os.Remove(path)
+}
+
+// writeFileAtomic -- write data to temporary file into the same directory
+// and rename it to given path, so file is never seen partially written
+func writeFileAtomic(path string, data []byte, mode os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	// hidden temporary file is ignored by network configuration tools
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// rename should survive a crash too
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// backupConfigFile -- store given content of network config file as
+// timestamped backup and remove old backups
+func backupConfigFile(path string, content []byte, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	name, err := backupName(path)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(name, content, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return pruneBackups(path, backups)
+}
+
+// backupPath -- returns path of backups of network config file, without
+// timestamp and suffix
+func backupPath(path string) string {
+	return filepath.Join(filepath.Dir(path), BackupDir, filepath.Base(path))
+}
+
+// backupName -- returns name of backup of network config file, created
+// now, and creates backup directory if need. Timestamp is shifted, if
+// backup already exists for the same time
+func backupName(path string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(backupPath(path)), 0700); err != nil {
+		return "", err
+	}
+	for now := time.Now(); ; now = now.Add(time.Millisecond) {
+		rv := fmt.Sprintf("%s.%s%s", backupPath(path), now.Format(BackupTimeFormat), BackupSuffix)
+		if _, err := os.Lstat(rv); os.IsNotExist(err) {
+			return rv, nil
+		}
+	}
+}
+
+// pruneBackups -- remove backups of network config file except 'backups'
+// newest ones
+func pruneBackups(path string, backups int) error {
+	path = backupPath(path)
+	matched, err := filepath.Glob(path + ".*" + BackupSuffix)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, name := range matched {
+		// backups of another files, like "l23-br1.100" for "l23-br1"
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), BackupSuffix)
+		if _, err := time.Parse(BackupTimeFormat, stamp); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for len(names) > backups {
+		if err = os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// printConfigDiff -- print changes of network config files, which will be
+// stored or removed, in unified diff format
+func printConfigDiff(files []plugin.ConfigFile, stale []string) error {
+	for _, file := range files {
+		from := file.Path
+		current, err := ioutil.ReadFile(file.Path)
+		switch {
+		case os.IsNotExist(err):
+			from = "/dev/null"
+		case err != nil:
+			return err
+		}
+		fmt.Print(UnifiedDiff(from, file.Path, current, file.Content))
+	}
+	for _, fileName := range stale {
+		current, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		fmt.Print(UnifiedDiff(fileName, "/dev/null", current, nil))
+	}
+	return nil
+}
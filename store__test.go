@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xenolog/l23/plugin"
+)
+
+func TestStore__Backups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-store")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "l23-br1")
+	// backup of another network config should not be pruned
+	os.Mkdir(filepath.Join(dir, BackupDir), 0700)
+	foreign := filepath.Join(dir, BackupDir, "l23-br1.100.20180101-000000.000"+BackupSuffix)
+	ioutil.WriteFile(foreign, []byte("foreign"), 0644)
+
+	for i, content := range []string{"v1", "v2", "v2", "v3", "v4"} {
+		changed, err := storeConfigFile(plugin.ConfigFile{Path: path, Content: []byte(content), Mode: 0600}, 2)
+		if err != nil {
+			t.Logf("Can't store network config: %v", err)
+			t.FailNow()
+		}
+		if wanted := i != 2; changed != wanted {
+			t.Logf("Wrong change of network config '%s': %v, instead %v", content, changed, wanted)
+			t.Fail()
+		}
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	info, _ := os.Stat(path)
+	if string(data) != "v4" || info.Mode().Perm() != 0600 {
+		t.Logf("Wrong network config: '%s', %v", data, info.Mode())
+		t.Fail()
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, BackupDir, "l23-br1.2*"+BackupSuffix))
+	contents := []string{}
+	for _, name := range backups {
+		data, _ := ioutil.ReadFile(name)
+		contents = append(contents, string(data))
+	}
+	if strings.Join(contents, ",") != "v2,v3" {
+		t.Logf("Wrong backups of network config: %v", contents)
+		t.Fail()
+	}
+	if _, err = os.Stat(foreign); err != nil {
+		t.Logf("Backup of another network config removed")
+		t.Fail()
+	}
+	// backups should not be read by network configuration tools, as
+	// files into the directory of network config
+	if names, _ := filepath.Glob(filepath.Join(dir, "l23-*")); len(names) != 1 {
+		t.Logf("Backups into the directory of network config: %v", names)
+		t.Fail()
+	}
+	// temporary files should not be left
+	for _, pattern := range []string{".l23-br1.*", BackupDir + "/.l23-br1.*"} {
+		if names, _ := filepath.Glob(filepath.Join(dir, pattern)); len(names) > 0 {
+			t.Logf("Temporary files left: %v", names)
+			t.Fail()
+		}
+	}
+}
+
+type testCleaner struct {
+	dir string
+}
+
+func (s *testCleaner) GeneratedFiles(path string) ([]string, error) {
+	return filepath.Glob(filepath.Join(s.dir, "l23-*"))
+}
+
+func TestStore__StaleConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-store")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"l23-br1", "l23-gone"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, BackupDir), 0700)
+	ioutil.WriteFile(filepath.Join(dir, BackupDir, "l23-gone.20180101-000000.000"+BackupSuffix), []byte("l23-gone"), 0644)
+
+	files := []plugin.ConfigFile{{Path: filepath.Join(dir, "l23-br1")}}
+	stale, err := staleConfigs(&testCleaner{dir}, dir, files)
+	if err != nil || len(stale) != 1 || stale[0] != filepath.Join(dir, "l23-gone") {
+		t.Logf("Wrong stale network configs: %v", stale)
+		t.FailNow()
+	}
+	if err = removeConfigFile(stale[0], 1); err != nil {
+		t.FailNow()
+	}
+	// the oldest backup is pruned, removed network config is kept as backup
+	backups, _ := filepath.Glob(filepath.Join(dir, BackupDir, "l23-gone.*"+BackupSuffix))
+	if len(backups) != 1 || strings.Contains(backups[0], "20180101") {
+		t.Logf("Wrong backups of removed network config: %v", backups)
+		t.Fail()
+	}
+	if _, err = os.Stat(stale[0]); !os.IsNotExist(err) {
+		t.Logf("Stale network config is not removed")
+		t.Fail()
+	}
+}
@@ -0,0 +1,166 @@
+package u1804
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	. "github.com/xenolog/l23/utils"
+)
+
+// Keys of netplan configuration, which have corresponding fields into
+// SavedConfig. Another ones can't be imported
+var (
+	knownNetworkKeys = []string{"version", "renderer", "ethernets", "bonds", "bridges", "vlans"}
+	knownBaseKeys    = []string{"addresses", "dhcp4", "dhcp6", "mtu", "gateway4", "routes", "nameservers"}
+	knownDeviceKeys  = map[string][]string{
+		"ethernets": {
+			"match", "set-name", "macaddress",
+			"receive-checksum-offload", "transmit-checksum-offload", "tcp-segmentation-offload",
+			"generic-segmentation-offload", "generic-receive-offload", "large-receive-offload",
+		},
+		"bonds":   {"interfaces", "parameters"},
+		"bridges": {"interfaces", "parameters"},
+		"vlans":   {"id", "link"},
+	}
+	knownParameterKeys = map[string][]string{
+		"bonds": {
+			"mode", "lacp-rate", "mii-monitor-interval", "transmit-hash-policy",
+			"up-delay", "down-delay", "ad-select", "primary",
+		},
+		"bridges": {"stp"},
+	}
+	knownMatchKeys = []string{"macaddress", "driver"}
+	knownRouteKeys = []string{"to", "via", "metric"}
+)
+
+// LoadSavedConfig -- load netplan configuration from given files in the
+// order of processing by netplan. Device, described into several files, is
+// taken from the last one. Constructs, which have no equivalent into
+// SavedConfig, are returned as unsupported
+func LoadSavedConfig(paths []string) (rv *SavedConfig, unsupported []string, err error) {
+	rv = NewSavedConfig(nil)
+	for _, path := range paths {
+		var (
+			data []byte
+			sc   *SavedConfig
+			u    []string
+		)
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, nil, err
+		}
+		if sc, u, err = ParseSavedConfig(path, data); err != nil {
+			return nil, nil, err
+		}
+		unsupported = append(unsupported, u...)
+		rv.Merge(sc)
+	}
+	return rv, unsupported, nil
+}
+
+// Merge -- add devices of another netplan configuration, replacing the
+// same named ones, like netplan does for several configuration files
+func (s *SavedConfig) Merge(sc *SavedConfig) {
+	for name, eth := range sc.Ethernets {
+		s.Ethernets[name] = eth
+	}
+	for name, bond := range sc.Bonds {
+		s.Bonds[name] = bond
+	}
+	for name, br := range sc.Bridges {
+		s.Bridges[name] = br
+	}
+	for name, vlan := range sc.Vlans {
+		s.Vlans[name] = vlan
+	}
+}
+
+// ParseSavedConfig -- parse netplan configuration, stored into given file.
+// Unsupported constructs are returned as "path: network.path.to.key"
+func ParseSavedConfig(path string, data []byte) (rv *SavedConfig, unsupported []string, err error) {
+	cfg := &netplanConfig{Network: NewSavedConfig(nil)}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, nil, fmt.Errorf("can't parse '%s': %v", path, err)
+	}
+	raw := make(map[string]map[string]interface{})
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("can't parse '%s': %v", path, err)
+	}
+	for _, u := range findUnsupported(raw["network"]) {
+		unsupported = append(unsupported, fmt.Sprintf("%s: %s", path, u))
+	}
+	return cfg.Network, unsupported, nil
+}
+
+// netplanConfig -- top level of netplan configuration
+type netplanConfig struct {
+	Network *SavedConfig
+}
+
+// findUnsupported -- returns unsupported constructs of 'network' section of
+// netplan configuration as "path.to.key: value"
+func findUnsupported(network map[string]interface{}) (rv []string) {
+	for _, key := range SortedKeys(network) {
+		if IndexString(knownNetworkKeys, key) < 0 {
+			rv = append(rv, fmt.Sprintf("network.%s", key))
+			continue
+		}
+		devices, ok := network[key].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		for _, name := range SortedKeys(devices) {
+			device, _ := devices[name].(map[interface{}]interface{})
+			rv = append(rv, findUnsupportedInDevice(key, fmt.Sprintf("network.%s.%s", key, name), device)...)
+		}
+	}
+	return rv
+}
+
+func findUnsupportedInDevice(section, path string, device map[interface{}]interface{}) (rv []string) {
+	for _, key := range SortedKeys(device) {
+		value := device[key]
+		keyPath := path + "." + key
+		if IndexString(knownBaseKeys, key) < 0 && IndexString(knownDeviceKeys[section], key) < 0 {
+			rv = append(rv, keyPath)
+			continue
+		}
+		switch key {
+		case "dhcp4", "dhcp6":
+			if enabled, _ := value.(bool); enabled {
+				rv = append(rv, fmt.Sprintf("%s: %v", keyPath, value))
+			}
+		case "addresses":
+			addrs, _ := value.([]interface{})
+			for _, addr := range addrs {
+				if strings.Contains(fmt.Sprintf("%v", addr), ":") {
+					rv = append(rv, fmt.Sprintf("%s: %v", keyPath, addr))
+				}
+			}
+		case "routes":
+			routes, _ := value.([]interface{})
+			for i, r := range routes {
+				route, _ := r.(map[interface{}]interface{})
+				rv = append(rv, findUnknownKeys(fmt.Sprintf("%s[%d]", keyPath, i), route, knownRouteKeys)...)
+			}
+		case "match":
+			match, _ := value.(map[interface{}]interface{})
+			rv = append(rv, findUnknownKeys(keyPath, match, knownMatchKeys)...)
+		case "parameters":
+			parameters, _ := value.(map[interface{}]interface{})
+			rv = append(rv, findUnknownKeys(keyPath, parameters, knownParameterKeys[section])...)
+		}
+	}
+	return rv
+}
+
+func findUnknownKeys(path string, m map[interface{}]interface{}, known []string) (rv []string) {
+	for _, key := range SortedKeys(m) {
+		if IndexString(known, key) < 0 {
+			rv = append(rv, path+"."+key)
+		}
+	}
+	return rv
+}
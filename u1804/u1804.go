@@ -4,26 +4,44 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 
 	logger "github.com/xenolog/go-tiny-logger"
 	npstate "github.com/xenolog/l23/npstate"
-	// . "github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/plugin"
 	// . "github.com/xenolog/l23/utils"
 	// "golang.org/x/sys/unix"
 )
 
 const (
 	MsgPrefix = "Netplan plugin"
+	// DefaultConfigPath -- netplan configuration file, generated by l23network
+	DefaultConfigPath = "/etc/netplan/999-l23network.yaml"
 )
 
 // -----------------------------------------------------------------------------
 
-type SCBase struct {
+type SCRoute struct {
+	To     string
+	Via    string
+	Metric int `yaml:",omitempty"`
+}
+
+type SCNameservers struct {
 	Addresses []string `yaml:",omitempty"`
-	Dhcp4     bool
-	Dhcp6     bool
+	Search    []string `yaml:",omitempty"`
+}
+
+type SCBase struct {
+	Addresses   []string `yaml:",omitempty"`
+	Dhcp4       bool
+	Dhcp6       bool
+	Mtu         int            `yaml:",omitempty"`
+	Gateway4    string         `yaml:",omitempty"`
+	Routes      []SCRoute      `yaml:",omitempty"`
+	Nameservers *SCNameservers `yaml:",omitempty"`
 }
 
 func (s *SCBase) AddAddresses(aa []string) {
@@ -32,6 +50,30 @@ func (s *SCBase) AddAddresses(aa []string) {
 	}
 }
 
+// SetupL3Properties -- setup addresses, default gateway, static routes and
+// DNS settings. Netplan has no metric for 'gateway4', so gateway with
+// metric is stored as default route. Gateway, routes and DNS settings are
+// not applied to runtime by lnx, they are stored only and take effect
+// after netplan apply or reboot
+func (s *SCBase) SetupL3Properties(l3 *npstate.L3State) {
+	s.AddAddresses(l3.IPv4)
+	switch {
+	case l3.Gateway != "" && l3.GatewayMetric > 0:
+		s.Routes = append(s.Routes, SCRoute{To: "0.0.0.0/0", Via: l3.Gateway, Metric: l3.GatewayMetric})
+	case l3.Gateway != "":
+		s.Gateway4 = l3.Gateway
+	}
+	for _, route := range l3.Routes {
+		s.Routes = append(s.Routes, SCRoute{To: route.To, Via: route.Via, Metric: route.Metric})
+	}
+	if len(l3.Nameservers) > 0 || len(l3.Search) > 0 {
+		s.Nameservers = &SCNameservers{
+			Addresses: l3.Nameservers,
+			Search:    l3.Search,
+		}
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 type SCVlan struct {
@@ -41,22 +83,104 @@ type SCVlan struct {
 }
 type SCVlans map[string]*SCVlan
 
+// SCBridgeParameters -- bridge parameters, stored only if they differ from
+// netplan defaults
+type SCBridgeParameters struct {
+	Stp bool
+}
+
+// NewSCBridgeParameters -- returns bridge parameters, corresponded to L2
+// properties of bridge, or nil if all of them are netplan defaults. STP is
+// enabled by netplan by default, but disabled for bridge, created by kernel
+func NewSCBridgeParameters(l2 *npstate.L2State) *SCBridgeParameters {
+	if l2.Stp {
+		return nil
+	}
+	return &SCBridgeParameters{Stp: l2.Stp}
+}
+
 type SCBridge struct {
 	SCBase     `yaml:",inline"`
-	Interfaces []string `yaml:",omitempty"`
+	Interfaces []string            `yaml:",omitempty"`
+	Parameters *SCBridgeParameters `yaml:",omitempty"`
 }
 type SCBridges map[string]*SCBridge
 
+type SCBondParameters struct {
+	Mode               string `yaml:",omitempty"`
+	LacpRate           string `yaml:"lacp-rate,omitempty"`
+	MiiMonitorInterval string `yaml:"mii-monitor-interval,omitempty"`
+	TransmitHashPolicy string `yaml:"transmit-hash-policy,omitempty"`
+	UpDelay            string `yaml:"up-delay,omitempty"`
+	DownDelay          string `yaml:"down-delay,omitempty"`
+	AdSelect           string `yaml:"ad-select,omitempty"`
+	Primary            string `yaml:",omitempty"`
+}
+
+// NewSCBondParameters -- returns bond parameters, corresponded to bond
+// properties of network scheme, or nil if no one property defined
+func NewSCBondParameters(properties map[string]string) *SCBondParameters {
+	if len(properties) == 0 {
+		return nil
+	}
+	return &SCBondParameters{
+		Mode:               properties["mode"],
+		LacpRate:           properties["lacp_rate"],
+		MiiMonitorInterval: properties["miimon"],
+		TransmitHashPolicy: properties["xmit_hash_policy"],
+		UpDelay:            properties["updelay"],
+		DownDelay:          properties["downdelay"],
+		AdSelect:           properties["ad_select"],
+		Primary:            properties["primary"],
+	}
+}
+
 type SCBond struct {
 	SCBase     `yaml:",inline"`
-	Interfaces []string `yaml:",omitempty"`
+	Interfaces []string          `yaml:",omitempty"`
+	Parameters *SCBondParameters `yaml:",omitempty"`
 }
 type SCBonds map[string]*SCBond
 
 type SCEthernet struct {
-	SCBase `yaml:",inline"`
-	Match  map[string]string `yaml:",omitempty"`
+	SCBase                     `yaml:",inline"`
+	Match                      map[string]string `yaml:",omitempty"`
+	SetName                    string            `yaml:"set-name,omitempty"`
+	Macaddress                 string            `yaml:",omitempty"`
+	ReceiveChecksumOffload     *bool             `yaml:"receive-checksum-offload,omitempty"`
+	TransmitChecksumOffload    *bool             `yaml:"transmit-checksum-offload,omitempty"`
+	TcpSegmentationOffload     *bool             `yaml:"tcp-segmentation-offload,omitempty"`
+	GenericSegmentationOffload *bool             `yaml:"generic-segmentation-offload,omitempty"`
+	GenericReceiveOffload      *bool             `yaml:"generic-receive-offload,omitempty"`
+	LargeReceiveOffload        *bool             `yaml:"large-receive-offload,omitempty"`
+}
+
+// SetupMatch -- setup rules to find physical interface. Netplan has no
+// ability to match interface by PCI path, such rule will be skipped
+func (s *SCEthernet) SetupMatch(match map[string]string, setName string) {
+	for key, value := range match {
+		if key == "path" {
+			continue
+		}
+		if s.Match == nil {
+			s.Match = make(map[string]string)
+		}
+		s.Match[key] = value
+	}
+	if len(s.Match) > 0 {
+		s.SetName = setName
+	}
+}
+
+// SetupL2Properties -- setup ethernet properties, which netplan able to
+// manage. Txqueuelen, alias and ethtool settings are not supported by
+// netplan of Ubuntu 18.04 and can't be stored, they are kept runtime only.
+// Offload fields of SCEthernet are used only to import configs, written for
+// newer netplan
+func (s *SCEthernet) SetupL2Properties(l2 *npstate.L2State) {
+	s.Macaddress = l2.HwAddr
 }
+
 type SCEthernets map[string]*SCEthernet
 
 type SavedConfig struct {
@@ -84,6 +208,80 @@ func (s *SavedConfig) addBrIfRequired(name string) {
 	}
 }
 
+// storable -- returns true if network primitive can be described by netplan
+func (s *SavedConfig) storable(np *npstate.NPState) bool {
+	// netplan from Ubuntu 18.04 can describe only linux kernel network
+	// primitives and has no way to describe dummy interfaces
+	return (np.Provider == "" || np.Provider == "lnx") && np.LinkType != "dummy"
+}
+
+// unstorable -- returns names of properties of network primitive, which
+// netplan of Ubuntu 18.04 can't describe. Such properties are kept runtime
+// only and will be lost after reboot
+func (s *SavedConfig) unstorable(np *npstate.NPState) (rv []string) {
+	if np.L2.TxQLen != 0 {
+		rv = append(rv, "txqueuelen")
+	}
+	if np.L2.Alias != "" {
+		rv = append(rv, "alias")
+	}
+	if len(np.L2.Ethtool.Offload) > 0 {
+		rv = append(rv, "ethtool offload")
+	}
+	if len(np.L2.Ethtool.Ring) > 0 {
+		rv = append(rv, "ethtool ring")
+	}
+	if len(np.L2.Ethtool.Coalesce) > 0 {
+		rv = append(rv, "ethtool coalesce")
+	}
+	return rv
+}
+
+// storedOnly -- returns names of L3 properties of network primitive, which
+// are stored into netplan, but not applied to runtime
+func (s *SavedConfig) storedOnly(np *npstate.NPState) (rv []string) {
+	if np.L3.Gateway != "" {
+		rv = append(rv, "gateway")
+	}
+	if len(np.L3.Routes) > 0 {
+		rv = append(rv, "routes")
+	}
+	if len(np.L3.Nameservers) > 0 || len(np.L3.Search) > 0 {
+		rv = append(rv, "nameservers")
+	}
+	return rv
+}
+
+// isDeclared -- returns true if interface is described into any section
+func (s *SavedConfig) isDeclared(name string) bool {
+	_, eth := s.Ethernets[name]
+	_, vlan := s.Vlans[name]
+	_, bond := s.Bonds[name]
+	_, br := s.Bridges[name]
+	return eth || vlan || bond || br
+}
+
+// declareReferenced -- netplan rejects configuration, which refers to
+// undescribed interfaces. Bond slaves, bridge members and vlan parents,
+// which are not described, are physical interfaces without addresses
+func (s *SavedConfig) declareReferenced() {
+	referenced := []string{}
+	for _, bond := range s.Bonds {
+		referenced = append(referenced, bond.Interfaces...)
+	}
+	for _, br := range s.Bridges {
+		referenced = append(referenced, br.Interfaces...)
+	}
+	for _, vlan := range s.Vlans {
+		referenced = append(referenced, vlan.Link)
+	}
+	for _, name := range referenced {
+		if name != "" && !s.isDeclared(name) {
+			s.addEthIfRequired(name)
+		}
+	}
+}
+
 func (s *SavedConfig) CheckWS() (rv error) {
 	if s.wantedState == nil {
 		errMsg := "Wanted states of Network primitives are not set."
@@ -98,26 +296,43 @@ func (s *SavedConfig) Generate() error {
 		return err
 	}
 	for _, np := range *s.wantedState {
+		if !s.storable(np) {
+			if np.LinkType == "dummy" {
+				s.log.Warn("%s: dummy interface '%s' can't be stored, skipped.", MsgPrefix, np.Name)
+			} else {
+				s.log.Warn("%s: '%s' is managed by provider '%s' and can't be stored, skipped.", MsgPrefix, np.Name, np.Provider)
+			}
+			continue
+		}
+		if props := s.unstorable(np); len(props) > 0 {
+			s.log.Warn("%s: %s of '%s' can't be stored by netplan, kept runtime only.", MsgPrefix, strings.Join(props, ", "), np.Name)
+		}
+		if props := s.storedOnly(np); len(props) > 0 {
+			s.log.Warn("%s: %s of '%s' are stored only and will not be applied until netplan apply or reboot.", MsgPrefix, strings.Join(props, ", "), np.Name)
+		}
 		switch np.Action {
 		case "port":
 			if np.L2.Vlan_id != 0 {
 				// vlan
-				//s.addEthIfRequired(np.L2.Parent)  // there are no such action here!!! Parent Interface always into NetworkState will be.
 				s.Vlans[np.Name] = &SCVlan{
 					Id:   np.L2.Vlan_id,
 					Link: np.L2.Parent,
 				}
-				s.Vlans[np.Name].AddAddresses(np.L3.IPv4)
+				s.Vlans[np.Name].Mtu = np.L2.Mtu
+				s.Vlans[np.Name].SetupL3Properties(&np.L3)
 			} else {
 				// just ethernet
 				s.addEthIfRequired(np.Name)
-				s.Ethernets[np.Name].AddAddresses(np.L3.IPv4)
+				s.Ethernets[np.Name].SetupMatch(np.Match, np.SetName)
+				s.Ethernets[np.Name].SetupL2Properties(&np.L2)
+				s.Ethernets[np.Name].Mtu = np.L2.Mtu
+				s.Ethernets[np.Name].SetupL3Properties(&np.L3)
 			}
 		case "bridge":
 			var ports []string
 			s.addBrIfRequired(np.Name)
 			for _, member := range *s.wantedState {
-				if member.L2.Bridge == np.Name {
+				if member.L2.Bridge == np.Name && s.storable(member) {
 					ports = append(ports, member.Name)
 				}
 			}
@@ -125,13 +340,17 @@ func (s *SavedConfig) Generate() error {
 				sort.Strings(ports)
 				s.Bridges[np.Name].Interfaces = append(s.Bridges[np.Name].Interfaces, ports...)
 			}
-			s.Bridges[np.Name].AddAddresses(np.L3.IPv4)
+			s.Bridges[np.Name].Parameters = NewSCBridgeParameters(&np.L2)
+			s.Bridges[np.Name].Mtu = np.L2.Mtu
+			s.Bridges[np.Name].SetupL3Properties(&np.L3)
 		case "bond":
 			if _, ok := s.Bonds[np.Name]; !ok {
 				s.Bonds[np.Name] = &SCBond{}
 			}
 			s.Bonds[np.Name].Interfaces = np.L2.Slaves
-			s.Bonds[np.Name].AddAddresses(np.L3.IPv4)
+			s.Bonds[np.Name].Parameters = NewSCBondParameters(np.L2.BondProperties)
+			s.Bonds[np.Name].Mtu = np.L2.Mtu
+			s.Bonds[np.Name].SetupL3Properties(&np.L3)
 		default:
 			errMsg := fmt.Sprintf("Unsupported 'action' for '%s'.", np.Name)
 			s.log.Error("%s: %s", MsgPrefix, errMsg)
@@ -139,6 +358,7 @@ func (s *SavedConfig) Generate() error {
 		}
 
 	}
+	s.declareReferenced()
 	return nil
 }
 
@@ -150,6 +370,19 @@ func (s *SavedConfig) String() string {
 	return string(rv[:])
 }
 
+func (s *SavedConfig) DefaultPath() string {
+	return DefaultConfigPath
+}
+
+// Files -- netplan configuration is stored into one file
+func (s *SavedConfig) Files(path string) []ConfigFile {
+	return []ConfigFile{{
+		Path:    path,
+		Content: []byte(s.String()),
+		Mode:    0644,
+	}}
+}
+
 func (s *SavedConfig) SetWantedState(wantedState *npstate.NPStates) {
 	s.wantedState = wantedState
 }
@@ -178,3 +411,11 @@ func NewSavedConfig(log *logger.Logger) *SavedConfig {
 
 	return rv
 }
+
+func NewNetplanConfigPlugin() ConfigPlugin {
+	return NewSavedConfig(nil)
+}
+
+func init() {
+	RegisterConfigPlugin(DefaultConfigProvider, NewNetplanConfigPlugin)
+}
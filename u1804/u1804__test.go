@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	td "github.com/maxatome/go-testdeep"
+	logger "github.com/xenolog/go-tiny-logger"
 	"github.com/xenolog/l23/npstate"
 	"gopkg.in/yaml.v2"
 )
@@ -35,12 +36,12 @@ func Test__Just_Ethernet(t *testing.T) {
           dhcp4: false
           dhcp6: false
 `
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
 	}
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -73,7 +74,7 @@ func Test__Just_Vlan(t *testing.T) {
 	savedConfig.SetWantedState(&wantedState)
 	savedConfig.Generate()
 	actualYaml := savedConfig.String()
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
@@ -95,7 +96,7 @@ func Test__Just_Vlan(t *testing.T) {
         dhcp4: false
         dhcp6: false
 `
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -132,7 +133,7 @@ func Test__Just_Bridge(t *testing.T) {
 	savedConfig.SetWantedState(&wantedState)
 	savedConfig.Generate()
 	actualYaml := savedConfig.String()
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
@@ -160,12 +161,14 @@ func Test__Just_Bridge(t *testing.T) {
     bridges:
       br1:
         interfaces: ["eth1","eth3","eth5"]
+        parameters:
+          stp: false
         addresses:
           - 10.10.10.131/25
         dhcp4: false
         dhcp6: false
 `
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -208,7 +211,7 @@ func Test__Bridge_with_vlans(t *testing.T) {
 	savedConfig.SetWantedState(&wantedState)
 	savedConfig.Generate()
 	actualYaml := savedConfig.String()
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
@@ -242,12 +245,14 @@ func Test__Bridge_with_vlans(t *testing.T) {
     bridges:
       br1:
         interfaces: ["eth1","eth3","eth3.103","eth5"]
+        parameters:
+          stp: false
         addresses:
           - 10.10.10.131/25
         dhcp4: false
         dhcp6: false
 `
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -282,7 +287,7 @@ func Test__Just_Bond(t *testing.T) {
 	savedConfig.SetWantedState(&wantedState)
 	savedConfig.Generate()
 	actualYaml := savedConfig.String()
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
@@ -306,7 +311,7 @@ func Test__Just_Bond(t *testing.T) {
         dhcp4: false
         dhcp6: false
 `
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -348,7 +353,7 @@ func Test__Bond_into_bridge(t *testing.T) {
 	savedConfig.SetWantedState(&wantedState)
 	savedConfig.Generate()
 	actualYaml := savedConfig.String()
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
@@ -367,6 +372,8 @@ func Test__Bond_into_bridge(t *testing.T) {
     bridges:
       br1:
         interfaces: ["bond1"]
+        parameters:
+          stp: false
         addresses:
           - 10.10.10.131/25
         dhcp4: false
@@ -377,7 +384,7 @@ func Test__Bond_into_bridge(t *testing.T) {
         dhcp4: false
         dhcp6: false
 `
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -437,7 +444,7 @@ func Test__Both_type_of_vlans_into_bridge(t *testing.T) {
 	savedConfig.SetWantedState(&wantedState)
 	savedConfig.Generate()
 	actualYaml := savedConfig.String()
-	actualSC := new(SavedConfig)
+	actualSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
 		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
 		t.FailNow()
@@ -459,6 +466,8 @@ func Test__Both_type_of_vlans_into_bridge(t *testing.T) {
     bridges:
       br1:
         interfaces: ["bond1.101", "eth1.111"]
+        parameters:
+          stp: false
         addresses:
           - 10.10.10.131/25
         dhcp4: false
@@ -480,7 +489,7 @@ func Test__Both_type_of_vlans_into_bridge(t *testing.T) {
         dhcp4: false
         dhcp6: false
 `
-	wantedSC := new(SavedConfig)
+	wantedSC := new(netplanConfig)
 	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
 		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
 		t.FailNow()
@@ -490,3 +499,276 @@ func Test__Both_type_of_vlans_into_bridge(t *testing.T) {
 }
 
 // -----------------------------------------------------------------------------
+
+func Test__Ethernet_L2_properties(t *testing.T) {
+	wantedState := make(npstate.NPStates)
+	linkName := "eth1"
+	wantedState[linkName] = &npstate.NPState{
+		Name:   linkName,
+		Action: "port",
+		Online: true,
+		L2: npstate.L2State{
+			HwAddr: "52:54:00:aa:bb:cc",
+			TxQLen: 10000,
+			Alias:  "uplink",
+			Ethtool: npstate.EthtoolState{
+				Offload: map[string]bool{"gro": false, "tso": true, "ntuple": true},
+				Ring:    map[string]int{"rx": 4096},
+			},
+		},
+	}
+
+	savedConfig := NewSavedConfig(logger.New())
+	savedConfig.SetWantedState(&wantedState)
+	savedConfig.Generate()
+	actualYaml := savedConfig.String()
+	wantedYaml := `
+    network:
+      version: "2"
+      renderer: networkd
+      ethernets:
+        eth1:
+          macaddress: 52:54:00:aa:bb:cc
+          dhcp4: false
+          dhcp6: false
+`
+	actualSC := new(netplanConfig)
+	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
+		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
+		t.FailNow()
+	}
+	wantedSC := new(netplanConfig)
+	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
+		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
+		t.FailNow()
+	}
+
+	td.CmpDeeply(t, actualSC, wantedSC, "ETH properties are not equal")
+}
+
+func Test__Ethernet_match(t *testing.T) {
+	wantedState := make(npstate.NPStates)
+	wantedState["uplink0"] = &npstate.NPState{
+		Name:    "uplink0",
+		Action:  "port",
+		Online:  true,
+		Match:   map[string]string{"macaddress": "52:54:00:aa:bb:cc", "path": "pci-0000:03:00.0"},
+		SetName: "uplink0",
+	}
+	wantedState["eth1"] = &npstate.NPState{
+		Name:   "eth1",
+		Action: "port",
+		Online: true,
+		Match:  map[string]string{"driver": "ixgbe"},
+	}
+
+	savedConfig := NewSavedConfig(nil)
+	savedConfig.SetWantedState(&wantedState)
+	savedConfig.Generate()
+	actualYaml := savedConfig.String()
+	wantedYaml := `
+    network:
+      version: "2"
+      renderer: networkd
+      ethernets:
+        uplink0:
+          match:
+            macaddress: 52:54:00:aa:bb:cc
+          set-name: uplink0
+          dhcp4: false
+          dhcp6: false
+        eth1:
+          match:
+            driver: ixgbe
+          dhcp4: false
+          dhcp6: false
+`
+	actualSC := new(netplanConfig)
+	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
+		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
+		t.FailNow()
+	}
+	wantedSC := new(netplanConfig)
+	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
+		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
+		t.FailNow()
+	}
+
+	td.CmpDeeply(t, actualSC, wantedSC, "ETH properties are not equal")
+}
+
+func Test__Bond_properties_and_L3(t *testing.T) {
+	wantedState := make(npstate.NPStates)
+	wantedState["bond0"] = &npstate.NPState{
+		Name:   "bond0",
+		Action: "bond",
+		Online: true,
+		L2: npstate.L2State{
+			Mtu:            9000,
+			Slaves:         []string{"eth2", "eth3"},
+			BondProperties: map[string]string{"mode": "802.3ad", "miimon": "100", "xmit_hash_policy": "layer3+4"},
+		},
+		L3: npstate.L3State{
+			IPv4:          []string{"10.10.10.131/25"},
+			Gateway:       "10.10.10.129",
+			GatewayMetric: 100,
+			Routes:        []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.10.10.130"}},
+			Nameservers:   []string{"10.10.10.1"},
+			Search:        []string{"example.com"},
+		},
+	}
+	wantedState["br1"] = &npstate.NPState{
+		Name:   "br1",
+		Action: "bridge",
+		Online: true,
+		L2: npstate.L2State{
+			Stp: true,
+		},
+		L3: npstate.L3State{
+			IPv4:    []string{"10.20.20.1/24"},
+			Gateway: "10.20.20.254",
+		},
+	}
+	wantedState["eth4.104"] = &npstate.NPState{
+		Name:   "eth4.104",
+		Action: "port",
+		Online: true,
+		L2: npstate.L2State{
+			Mtu:     1400,
+			Bridge:  "br1",
+			Parent:  "eth4",
+			Vlan_id: 104,
+		},
+	}
+
+	savedConfig := NewSavedConfig(logger.New())
+	savedConfig.SetWantedState(&wantedState)
+	savedConfig.Generate()
+	actualYaml := savedConfig.String()
+	actualSC := new(netplanConfig)
+	if err := yaml.Unmarshal([]byte(actualYaml), actualSC); err != nil {
+		t.Logf("Can't unmarshall the actual YAML: %s\n%s", err, actualYaml)
+		t.FailNow()
+	}
+	wantedYaml := `
+  network:
+    version: 2
+    renderer: networkd
+    ethernets:
+      eth2:
+        dhcp4: false
+        dhcp6: false
+      eth3:
+        dhcp4: false
+        dhcp6: false
+      eth4:
+        dhcp4: false
+        dhcp6: false
+    bonds:
+      bond0:
+        interfaces: ["eth2","eth3"]
+        parameters:
+          mode: 802.3ad
+          mii-monitor-interval: "100"
+          transmit-hash-policy: layer3+4
+        mtu: 9000
+        addresses:
+          - 10.10.10.131/25
+        routes:
+          - to: 0.0.0.0/0
+            via: 10.10.10.129
+            metric: 100
+          - to: 192.168.0.0/16
+            via: 10.10.10.130
+        nameservers:
+          addresses: ["10.10.10.1"]
+          search: ["example.com"]
+        dhcp4: false
+        dhcp6: false
+    bridges:
+      br1:
+        interfaces: ["eth4.104"]
+        addresses:
+          - 10.20.20.1/24
+        gateway4: 10.20.20.254
+        dhcp4: false
+        dhcp6: false
+    vlans:
+      eth4.104:
+        id: 104
+        link: eth4
+        mtu: 1400
+        dhcp4: false
+        dhcp6: false
+`
+	wantedSC := new(netplanConfig)
+	if err := yaml.Unmarshal([]byte(wantedYaml), wantedSC); err != nil {
+		t.Logf("Can't unmarshall the wanted YAML: %s\n%s", err, wantedYaml)
+		t.FailNow()
+	}
+	td.CmpDeeply(t, actualSC, wantedSC, "Bond, Bridge and L3 properties are not equal")
+}
+
+func Test__Foreign_members_are_not_referenced(t *testing.T) {
+	wantedState := make(npstate.NPStates)
+	wantedState["br1"] = &npstate.NPState{
+		Name:   "br1",
+		Action: "bridge",
+		Online: true,
+	}
+	wantedState["eth1"] = &npstate.NPState{
+		Name:   "eth1",
+		Action: "port",
+		Online: true,
+		L2:     npstate.L2State{Bridge: "br1"},
+	}
+	wantedState["patch1"] = &npstate.NPState{
+		Name:     "patch1",
+		Action:   "port",
+		Provider: "ovs",
+		Online:   true,
+		L2:       npstate.L2State{Bridge: "br1"},
+	}
+
+	savedConfig := NewSavedConfig(logger.New())
+	savedConfig.SetWantedState(&wantedState)
+	savedConfig.Generate()
+	if ifaces := savedConfig.Bridges["br1"].Interfaces; len(ifaces) != 1 || ifaces[0] != "eth1" {
+		t.Logf("Only members, which can be stored, should be referenced, given: %v", ifaces)
+		t.Fail()
+	}
+	if _, ok := savedConfig.Ethernets["patch1"]; ok {
+		t.Logf("Network primitive of another provider should not be stored")
+		t.Fail()
+	}
+}
+
+func Test__Properties_not_rendered(t *testing.T) {
+	np := &npstate.NPState{
+		Name:   "eth1",
+		Action: "port",
+		Online: true,
+		L2: npstate.L2State{
+			TxQLen: 2000,
+			Alias:  "uplink",
+			Ethtool: npstate.EthtoolState{
+				Ring:     map[string]int{"rx": 4096},
+				Coalesce: map[string]string{"rx-usecs": "50"},
+			},
+		},
+		L3: npstate.L3State{
+			Gateway: "10.10.10.1",
+			Routes:  []npstate.RouteState{{To: "192.168.0.0/16", Via: "10.10.10.2"}},
+		},
+	}
+
+	savedConfig := NewSavedConfig(logger.New())
+	td.CmpDeeply(t, savedConfig.unstorable(np), []string{"txqueuelen", "alias", "ethtool ring", "ethtool coalesce"},
+		"L2 properties, which netplan can't describe, should be reported")
+	td.CmpDeeply(t, savedConfig.storedOnly(np), []string{"gateway", "routes"},
+		"L3 properties, which are not applied to runtime, should be reported")
+	np.L2 = npstate.L2State{}
+	np.L3 = npstate.L3State{IPv4: []string{"10.10.10.131/25"}}
+	td.CmpNil(t, savedConfig.unstorable(np))
+	td.CmpNil(t, savedConfig.storedOnly(np))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	cli "github.com/urfave/cli"
+	npstate "github.com/xenolog/l23/npstate"
+	. "github.com/xenolog/l23/utils"
+)
+
+// UtilityExportScheme -- observe network topology of the host and store
+// network scheme, which describes it. Scheme can be limited by given
+// interfaces (and network primitives, they depend on)
+func UtilityExportScheme(c *cli.Context) (err error) {
+	var (
+		rtPlugins *RtPlugins
+		ns        *NetworkScheme
+	)
+	providers := []string{"lnx"}
+	if providerOverride != "" {
+		providers = []string{providerOverride}
+	}
+	if rtPlugins, err = NewRtPlugins(providers); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	if path := c.GlobalString("observe-from"); path != "" {
+		snapshot, err := loadSnapshot(path)
+		if err != nil {
+			return err
+		}
+		rtPlugins.UseSnapshot(snapshot)
+	}
+	if err = rtPlugins.Observe(); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+
+	topology := rtPlugins.Topology(npstate.NewTopologyState())
+	if ns, err = topologyToScheme(topology, c.Args()); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	if err = ns.Validate(); err != nil {
+		Log.Error("Exported network scheme is not valid: %v", err)
+		return err
+	}
+	return storeNetworkScheme(ns, "---\n", c.String("output"))
+}
+
+// exportedNames -- returns given network primitives with network
+// primitives, they depend on: vlan parents, bond slaves, bridges and
+// bridge members. All network primitives are returned if no one given
+func exportedNames(topology *npstate.TopologyState, names []string) ([]string, error) {
+	rv := []string{}
+	if len(names) == 0 {
+		for name := range topology.NP {
+			rv = append(rv, name)
+		}
+		sort.Strings(rv)
+		return rv, nil
+	}
+	for _, name := range names {
+		if _, ok := topology.NP[name]; !ok {
+			return nil, fmt.Errorf("interface '%s' not found", name)
+		}
+	}
+	for queue := names; len(queue) > 0; queue = queue[1:] {
+		name := queue[0]
+		if name == "" || IndexString(rv, name) >= 0 {
+			continue
+		}
+		rv = append(rv, name)
+		np := topology.NP[name]
+		queue = append(queue, np.L2.Parent, np.L2.Bridge)
+		queue = append(queue, np.L2.Slaves...)
+		if np.LinkType == "bridge" {
+			for _, member := range topology.NP {
+				if member.L2.Bridge == name {
+					queue = append(queue, member.Name)
+				}
+			}
+		}
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// topologyToScheme -- build network scheme, which describes given network
+// primitives of observed network topology. Physical interfaces are
+// described into 'interfaces' section, bridges, bonds, vlans and bridge
+// members -- into 'transformations' in the order of creation
+func topologyToScheme(topology *npstate.TopologyState, names []string) (rv *NetworkScheme, err error) {
+	if names, err = exportedNames(topology, names); err != nil {
+		return nil, err
+	}
+	rv = &NetworkScheme{
+		Version:    "1.2",
+		Provider:   "lnx",
+		Interfaces: make(NsIfaces),
+		Endpoints:  make(NsEps),
+	}
+
+	var bridges, bonds, vlans, members []NsPrimitive
+	for _, name := range names {
+		np := topology.NP[name]
+		state := ""
+		if !np.Online {
+			state = npstate.LinkStateDown
+		}
+		switch np.LinkType {
+		case "openvswitch":
+			// internal link of Open vSwitch datapath
+			continue
+		case "bridge":
+			bridges = append(bridges, NsPrimitive{
+				Action: "bridge",
+				Name:   name,
+				Mtu:    np.L2.Mtu,
+				Stp:    np.L2.Stp,
+				State:  state,
+			})
+		case "bond":
+			bonds = append(bonds, NsPrimitive{
+				Action:         "bond",
+				Name:           name,
+				Mtu:            np.L2.Mtu,
+				Slaves:         sortedSlaves(np.L2.Slaves),
+				Bridge:         np.L2.Bridge,
+				BondProperties: bondPropertiesCopy(np.L2.BondProperties),
+				State:          state,
+			})
+		case "vlan":
+			vlans = append(vlans, NsPrimitive{
+				Action:  "port",
+				Name:    name,
+				Mtu:     np.L2.Mtu,
+				Parent:  np.L2.Parent,
+				Vlan_id: np.L2.Vlan_id,
+				Bridge:  np.L2.Bridge,
+				State:   state,
+			})
+		default:
+			iface := NsPrimitive{
+				Mtu:   np.L2.Mtu,
+				State: state,
+			}
+			if np.LinkType == "dummy" {
+				iface.Type = np.LinkType
+			}
+			rv.Interfaces[name] = iface
+			if np.L2.Bridge != "" {
+				members = append(members, NsPrimitive{
+					Action: "port",
+					Name:   name,
+					Bridge: np.L2.Bridge,
+				})
+			}
+		}
+		if len(np.L3.IPv4) > 0 {
+			rv.Endpoints[name] = NsEp{IP: append([]string{}, np.L3.IPv4...)}
+		}
+	}
+	// vlans can be created on top of bonds, physical interfaces should be
+	// included into bridges after creation of all bridges
+	rv.Transformations = append(rv.Transformations, bridges...)
+	rv.Transformations = append(rv.Transformations, bonds...)
+	rv.Transformations = append(rv.Transformations, vlans...)
+	rv.Transformations = append(rv.Transformations, members...)
+	return rv, nil
+}
+
+func sortedSlaves(slaves []string) []string {
+	rv := append([]string{}, slaves...)
+	sort.Strings(rv)
+	return rv
+}
+
+// bondPropertiesCopy -- bonding parameters are not compared with runtime,
+// so mode of bond should be exported to be kept by stored network config
+func bondPropertiesCopy(properties map[string]string) map[string]string {
+	if len(properties) == 0 {
+		return nil
+	}
+	rv := make(map[string]string)
+	for key, value := range properties {
+		rv[key] = value
+	}
+	return rv
+}
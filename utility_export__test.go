@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	npstate "github.com/xenolog/l23/npstate"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// observedTopology -- network topology, like observed by lnx plugin
+func observedTopology() *npstate.TopologyState {
+	rv := npstate.NewTopologyState()
+	for _, np := range []*npstate.NPState{
+		{Name: "lo", LinkType: "device", Online: true, L2: npstate.L2State{Mtu: 65536}, L3: npstate.L3State{IPv4: []string{"127.0.0.1/8"}}},
+		{Name: "eth0", LinkType: "device", Online: true, L3: npstate.L3State{IPv4: []string{"192.168.0.10/24"}}},
+		{Name: "eth1", LinkType: "device", Online: true},
+		{Name: "eth2", LinkType: "device", Online: true},
+		{Name: "eth3", LinkType: "device", Online: false},
+		{Name: "dummy0", LinkType: "dummy", Online: true, L3: npstate.L3State{IPv4: []string{"10.255.0.1/32"}}},
+		{Name: "bond0", LinkType: "bond", Online: true, L2: npstate.L2State{Mtu: 9000, Slaves: []string{"eth2", "eth1"}, BondProperties: map[string]string{"mode": "802.3ad", "lacp_rate": "fast"}}},
+		{Name: "bond0.101", LinkType: "vlan", Online: true, L2: npstate.L2State{Parent: "bond0", Vlan_id: 101, Bridge: "br1"}},
+		{Name: "br1", LinkType: "bridge", Online: true, L2: npstate.L2State{Stp: true}, L3: npstate.L3State{IPv4: []string{"10.1.1.1/24", "10.1.2.1/24"}}},
+		{Name: "br2", LinkType: "bridge", Online: true},
+		{Name: "veth1", LinkType: "veth", Online: true, L2: npstate.L2State{Bridge: "br2"}},
+	} {
+		np.Provider = "lnx"
+		if np.L3.IPv4 == nil {
+			np.L3.IPv4 = []string{}
+		}
+		rv.NP[np.Name] = np
+	}
+	return rv
+}
+
+func TestExport__NoDiff(t *testing.T) {
+	observed := observedTopology()
+	ns, err := topologyToScheme(observed, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	// network scheme should be valid after serialization
+	data, _ := yaml.Marshal(ns)
+	loaded := new(NetworkScheme)
+	if err = loaded.Load(strings.NewReader(string(data))); err != nil {
+		t.FailNow()
+	}
+	if err = loaded.Validate(); err != nil {
+		t.Logf("Exported network scheme is not valid: %v\n%s", err, data)
+		t.FailNow()
+	}
+
+	wanted := loaded.TopologyState()
+	diff := observed.Compare(wanted)
+	if steps := planChanges(wanted, diff); len(steps) > 0 || !diff.IsEqual() {
+		t.Logf("Exported network scheme differs from observed topology:\n%s\n%s", diff, data)
+		t.Fail()
+	}
+	if wanted.NP["dummy0"].LinkType != "dummy" || wanted.NP["eth3"].Online {
+		t.Logf("Wrong link type or state:\n%s", data)
+		t.Fail()
+	}
+	// bonding parameters are not compared by diff, but should be stored
+	if !reflect.DeepEqual(wanted.NP["bond0"].L2.BondProperties, observed.NP["bond0"].L2.BondProperties) {
+		t.Logf("Wrong bonding parameters:\n%s", data)
+		t.Fail()
+	}
+
+	// bridges and bonds should be created before vlans and bridge members
+	transformations := []string{}
+	for _, tr := range loaded.Transformations {
+		transformations = append(transformations, tr.Name)
+	}
+	if wantedTr := []string{"br1", "br2", "bond0", "bond0.101", "veth1"}; !reflect.DeepEqual(transformations, wantedTr) {
+		t.Logf("Wrong order of transformations: %v, instead %v", transformations, wantedTr)
+		t.Fail()
+	}
+	names := append([]string{}, wanted.Order...)
+	sort.Strings(names)
+	if wantedNames := []string{"bond0", "bond0.101", "br1", "br2", "dummy0", "eth0", "eth1", "eth2", "eth3", "lo", "veth1"}; !reflect.DeepEqual(names, wantedNames) {
+		t.Logf("Wrong network primitives: %v, instead %v", names, wantedNames)
+		t.Fail()
+	}
+}
+
+func TestExport__Limited(t *testing.T) {
+	observed := observedTopology()
+	names, err := exportedNames(observed, []string{"bond0.101"})
+	if err != nil {
+		t.FailNow()
+	}
+	// vlan depends on the bond with slaves and on the bridge
+	if wanted := []string{"bond0", "bond0.101", "br1", "eth1", "eth2"}; !reflect.DeepEqual(names, wanted) {
+		t.Logf("Wrong exported network primitives: %v, instead %v", names, wanted)
+		t.Fail()
+	}
+
+	names, _ = exportedNames(observed, []string{"br2"})
+	if wanted := []string{"br2", "veth1"}; !reflect.DeepEqual(names, wanted) {
+		t.Logf("Wrong exported network primitives: %v, instead %v", names, wanted)
+		t.Fail()
+	}
+
+	if _, err = topologyToScheme(observed, []string{"eth9"}); err == nil {
+		t.Logf("Unknown interface should not be exported")
+		t.Fail()
+	}
+}
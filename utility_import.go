@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cli "github.com/urfave/cli"
+	"github.com/xenolog/l23/u1804"
+	. "github.com/xenolog/l23/utils"
+)
+
+// UtilityImportNetplan -- convert netplan configuration of the host into
+// network scheme. Netplan constructs, which can't be expressed by network
+// scheme, are reported and listed into head of network scheme
+func UtilityImportNetplan(c *cli.Context) (err error) {
+	var (
+		paths       []string
+		sc          *u1804.SavedConfig
+		unsupported []string
+	)
+	if paths, err = filepath.Glob(filepath.Join(c.String("netplan-dir"), "*.yaml")); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	if len(paths) == 0 {
+		err = fmt.Errorf("no netplan configuration found into '%s'", c.String("netplan-dir"))
+		Log.Error("%v", err)
+		return err
+	}
+	// netplan processes files in lexical order
+	sort.Strings(paths)
+	if sc, unsupported, err = u1804.LoadSavedConfig(paths); err != nil {
+		Log.Error("Can't load netplan configuration: %v", err)
+		return err
+	}
+
+	ns := netplanToScheme(sc)
+	if err = ns.Validate(); err != nil {
+		Log.Error("Imported network scheme is not valid: %v", err)
+		return err
+	}
+	header := "---\n"
+	for _, u := range unsupported {
+		Log.Warn("Can't be expressed by network scheme, skipped: %s", u)
+		header += fmt.Sprintf("# unsupported: %s\n", u)
+	}
+	return storeNetworkScheme(ns, header, c.String("output"))
+}
+
+// netplanToScheme -- build network scheme, equivalent to netplan
+// configuration. Physical interfaces are described into 'interfaces'
+// section, bridges, bonds, vlans and bridge members -- into 'transformations'
+// in the order of creation
+func netplanToScheme(sc *u1804.SavedConfig) (rv *NetworkScheme) {
+	rv = &NetworkScheme{
+		Version:        "1.2",
+		Provider:       "lnx",
+		ConfigProvider: "netplan",
+		Interfaces:     make(NsIfaces),
+		Endpoints:      make(NsEps),
+	}
+
+	// bridge of every bridge member
+	bridges := make(map[string]string)
+	for _, name := range sortedNames(sc.Bridges) {
+		for _, member := range sc.Bridges[name].Interfaces {
+			bridges[member] = name
+		}
+	}
+
+	for _, name := range sortedNames(sc.Ethernets) {
+		eth := sc.Ethernets[name]
+		iface := NsPrimitive{
+			Mtu:        eth.Mtu,
+			Macaddress: eth.Macaddress,
+		}
+		// unsupported match rules are reported by u1804.LoadSavedConfig
+		for key, value := range eth.Match {
+			if IndexString(NsMatchKeys, key) >= 0 {
+				if iface.Match == nil {
+					iface.Match = make(map[string]string)
+				}
+				iface.Match[key] = value
+			}
+		}
+		if len(iface.Match) > 0 {
+			iface.SetName = eth.SetName
+		}
+		iface.Ethtool.Offload = ethernetOffloads(eth)
+		rv.Interfaces[name] = iface
+		addEndpoint(rv, name, &eth.SCBase)
+	}
+	for _, name := range sortedNames(sc.Bridges) {
+		br := sc.Bridges[name]
+		// STP is enabled by netplan by default
+		stp := true
+		if br.Parameters != nil {
+			stp = br.Parameters.Stp
+		}
+		rv.Transformations = append(rv.Transformations, NsPrimitive{
+			Action: "bridge",
+			Name:   name,
+			Mtu:    br.Mtu,
+			Stp:    stp,
+		})
+		addEndpoint(rv, name, &br.SCBase)
+	}
+	for _, name := range sortedNames(sc.Bonds) {
+		bond := sc.Bonds[name]
+		rv.Transformations = append(rv.Transformations, NsPrimitive{
+			Action:         "bond",
+			Name:           name,
+			Mtu:            bond.Mtu,
+			Slaves:         bond.Interfaces,
+			Bridge:         bridges[name],
+			BondProperties: bondProperties(bond.Parameters),
+		})
+		addEndpoint(rv, name, &bond.SCBase)
+	}
+	for _, name := range sortedNames(sc.Vlans) {
+		vlan := sc.Vlans[name]
+		rv.Transformations = append(rv.Transformations, NsPrimitive{
+			Action:  "port",
+			Name:    name,
+			Mtu:     vlan.Mtu,
+			Parent:  vlan.Link,
+			Vlan_id: vlan.Id,
+			Bridge:  bridges[name],
+		})
+		addEndpoint(rv, name, &vlan.SCBase)
+	}
+	// bond slaves and vlan parents, which are not described by netplan, are
+	// physical interfaces
+	referenced := []string{}
+	for _, bond := range sc.Bonds {
+		referenced = append(referenced, bond.Interfaces...)
+	}
+	for _, vlan := range sc.Vlans {
+		referenced = append(referenced, vlan.Link)
+	}
+	for _, name := range referenced {
+		if _, ok := rv.Interfaces[name]; !ok && sc.Bonds[name] == nil && sc.Bridges[name] == nil && sc.Vlans[name] == nil {
+			rv.Interfaces[name] = NsPrimitive{}
+		}
+	}
+	// physical interfaces should be included into bridges after creation
+	// of all bridges
+	for _, name := range sortedNames(sc.Ethernets) {
+		if br, ok := bridges[name]; ok {
+			rv.Transformations = append(rv.Transformations, NsPrimitive{
+				Action: "port",
+				Name:   name,
+				Bridge: br,
+			})
+		}
+	}
+	return rv
+}
+
+// addEndpoint -- describe L3 properties of netplan device by endpoint
+func addEndpoint(ns *NetworkScheme, name string, base *u1804.SCBase) {
+	ep := NsEp{
+		Gateway: base.Gateway4,
+		IP:      []string{},
+	}
+	for _, addr := range base.Addresses {
+		// IPv6 addresses are not supported by network scheme
+		if !strings.Contains(addr, ":") {
+			ep.IP = append(ep.IP, addr)
+		}
+	}
+	for _, route := range base.Routes {
+		to := route.To
+		if to == "default" {
+			to = "0.0.0.0/0"
+		}
+		// gateway with metric is stored into netplan as default route
+		if to == "0.0.0.0/0" && ep.Gateway == "" {
+			ep.Gateway = route.Via
+			ep.GatewayMetric = route.Metric
+			continue
+		}
+		ep.Routes = append(ep.Routes, NsRoute{Net: to, Via: route.Via, Metric: route.Metric})
+	}
+	if base.Nameservers != nil {
+		ep.Nameservers.Addresses = base.Nameservers.Addresses
+		ep.Nameservers.Search = base.Nameservers.Search
+	}
+	if len(ep.IP) > 0 || ep.Gateway != "" || len(ep.Routes) > 0 || len(ep.Nameservers.Addresses) > 0 || len(ep.Nameservers.Search) > 0 {
+		ns.Endpoints[name] = ep
+	}
+}
+
+// ethernetOffloads -- returns ethtool offloads of netplan ethernet
+func ethernetOffloads(eth *u1804.SCEthernet) (rv map[string]bool) {
+	for key, value := range map[string]*bool{
+		"rx":  eth.ReceiveChecksumOffload,
+		"tx":  eth.TransmitChecksumOffload,
+		"tso": eth.TcpSegmentationOffload,
+		"gso": eth.GenericSegmentationOffload,
+		"gro": eth.GenericReceiveOffload,
+		"lro": eth.LargeReceiveOffload,
+	} {
+		if value != nil {
+			if rv == nil {
+				rv = make(map[string]bool)
+			}
+			rv[key] = *value
+		}
+	}
+	return rv
+}
+
+// bondProperties -- returns bond properties, corresponded to netplan bond
+// parameters (see u1804.NewSCBondParameters)
+func bondProperties(p *u1804.SCBondParameters) (rv map[string]string) {
+	if p == nil {
+		return nil
+	}
+	for key, value := range map[string]string{
+		"mode":             p.Mode,
+		"lacp_rate":        p.LacpRate,
+		"miimon":           p.MiiMonitorInterval,
+		"xmit_hash_policy": p.TransmitHashPolicy,
+		"updelay":          p.UpDelay,
+		"downdelay":        p.DownDelay,
+		"ad_select":        p.AdSelect,
+		"primary":          p.Primary,
+	} {
+		if value != "" {
+			if rv == nil {
+				rv = make(map[string]string)
+			}
+			rv[key] = value
+		}
+	}
+	return rv
+}
+
+// sortedNames -- returns sorted names of netplan devices
+func sortedNames(devices interface{}) (rv []string) {
+	switch dd := devices.(type) {
+	case u1804.SCEthernets:
+		for name := range dd {
+			rv = append(rv, name)
+		}
+	case u1804.SCBridges:
+		for name := range dd {
+			rv = append(rv, name)
+		}
+	case u1804.SCBonds:
+		for name := range dd {
+			rv = append(rv, name)
+		}
+	case u1804.SCVlans:
+		for name := range dd {
+			rv = append(rv, name)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/u1804"
+	. "github.com/xenolog/l23/utils"
+)
+
+// TestImport__Netplan -- network scheme, imported from netplan
+// configuration, generated by l23network, should describe the same topology
+func TestImport__Netplan(t *testing.T) {
+	ns := new(NetworkScheme)
+	if err := ns.Load(strings.NewReader(`
+version: 1.2
+provider: lnx
+interfaces:
+  eth1:
+    mtu: 9000
+  eth2: {}
+  eth3:
+    match:
+      macaddress: "52:54:00:12:34:56"
+    set-name: eth3
+transformations:
+  - name: br1
+    action: bridge
+    stp: true
+  - name: bond0
+    action: bond
+    slaves: [eth2, eth3]
+    bond_properties:
+      mode: 802.3ad
+      miimon: "100"
+  - name: eth1.101
+    action: port
+    parent: eth1
+    vlan_id: 101
+    bridge: br1
+endpoints:
+  br1:
+    IP: ['10.1.1.1/24']
+    gateway: 10.1.1.254
+    gateway_metric: 100
+  bond0:
+    IP: ['10.2.2.1/24', '10.3.3.1/24']
+    routes:
+      - net: 192.168.0.0/16
+        via: 10.2.2.254
+    nameservers:
+      addresses: [8.8.8.8]
+      search: [example.com]
+`)); err != nil {
+		t.FailNow()
+	}
+	wanted := ns.TopologyState()
+
+	dir, err := ioutil.TempDir("", "l23-netplan")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	sc := u1804.NewSavedConfig(logger.New())
+	sc.SetWantedState(&wanted.NP)
+	if err = sc.Generate(); err != nil {
+		t.FailNow()
+	}
+	path := filepath.Join(dir, "999-l23network.yaml")
+	ioutil.WriteFile(path, []byte(sc.String()), 0644)
+
+	imported, unsupported, err := u1804.LoadSavedConfig([]string{path})
+	if err != nil || len(unsupported) > 0 {
+		t.Logf("Can't import netplan configuration: %v, %v", err, unsupported)
+		t.FailNow()
+	}
+	importedNs := netplanToScheme(imported)
+	if err = importedNs.Validate(); err != nil {
+		t.Logf("Imported network scheme is not valid: %v", err)
+		t.FailNow()
+	}
+	actual := importedNs.TopologyState()
+	if diff := actual.Compare(wanted); !diff.IsEqual() {
+		t.Logf("Imported topology differs from original one:\n%s", diff)
+		t.Fail()
+	}
+	for name, np := range wanted.NP {
+		if !reflect.DeepEqual(actual.NP[name].L3, np.L3) {
+			t.Logf("Wrong L3 properties of '%s': %v, instead %v", name, actual.NP[name].L3, np.L3)
+			t.Fail()
+		}
+		if !reflect.DeepEqual(actual.NP[name].L2.BondProperties, np.L2.BondProperties) {
+			t.Logf("Wrong bond properties of '%s': %v, instead %v", name, actual.NP[name].L2.BondProperties, np.L2.BondProperties)
+			t.Fail()
+		}
+	}
+	if bond, vlan := IndexString(actual.Order, "bond0"), IndexString(actual.Order, "eth1.101"); bond < IndexString(actual.Order, "br1") || vlan < bond {
+		t.Logf("Wrong order of transformations: %v", actual.Order)
+		t.Fail()
+	}
+}
+
+func TestImport__Unsupported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l23-netplan")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "01-netcfg.yaml")
+	ioutil.WriteFile(path, []byte(`
+network:
+  version: 2
+  ethernets:
+    eth0:
+      dhcp4: true
+      optional: true
+  wifis:
+    wlan0: {}
+  bonds:
+    bond0:
+      interfaces: [eth1]
+      parameters: {mode: active-backup, arp-interval: 5}
+`), 0644)
+	sc, unsupported, err := u1804.LoadSavedConfig([]string{path})
+	if err != nil {
+		t.FailNow()
+	}
+	wantedUnsupported := []string{
+		path + ": network.bonds.bond0.parameters.arp-interval",
+		path + ": network.ethernets.eth0.dhcp4: true",
+		path + ": network.ethernets.eth0.optional",
+		path + ": network.wifis",
+	}
+	if !reflect.DeepEqual(unsupported, wantedUnsupported) {
+		t.Logf("Wrong unsupported constructs: %v", unsupported)
+		t.Fail()
+	}
+	ns := netplanToScheme(sc)
+	if _, ok := ns.Interfaces["eth1"]; !ok {
+		t.Logf("Undescribed bond slave is not added to interfaces: %v", ns.Interfaces)
+		t.Fail()
+	}
+	if err = ns.Validate(); err != nil {
+		t.Logf("Imported network scheme is not valid: %v", err)
+		t.Fail()
+	}
+}
@@ -6,7 +6,7 @@ import (
 
 	cli "github.com/urfave/cli"
 	"github.com/vishvananda/netlink"
-	"github.com/xenolog/l23/lnx"
+	"github.com/xenolog/l23/plugin"
 )
 
 func UtilityListNetworkPrimitivesOld(c *cli.Context) error {
@@ -52,13 +52,24 @@ func UtilityListNetworkPrimitives(c *cli.Context) error {
 		online  string
 	)
 
-	// initialize and configure LnxRtPlugin
-	lnxRtPlugin := lnx.NewLnxRtPlugin()
-	lnxRtPlugin.Init(Log, nil)
-	lnxRtPlugin.Observe()
-	Log.Debug("LnxRtPlugin initialized")
+	// initialize and configure runtime plugin ('lnx', if no one given)
+	provider := "lnx"
+	if providerOverride != "" {
+		provider = providerOverride
+	}
+	rtPlugin, err := plugin.NewRtPlugin(provider)
+	if err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	if err = rtPlugin.Init(Log, nil); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	rtPlugin.Observe()
+	Log.Debug("Runtime plugin '%s' initialized", provider)
 
-	nps := lnxRtPlugin.Topology()
+	nps := rtPlugin.Topology()
 
 	for _, link := range nps.NP {
 
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	cli "github.com/urfave/cli"
+	npstate "github.com/xenolog/l23/npstate"
+)
+
+// UtilitySnapshot -- observe network topology by providers of network scheme
+// (or by the default provider if network scheme is absent) and store it
+// to file
+func UtilitySnapshot(c *cli.Context) (err error) {
+	var (
+		rtPlugins *RtPlugins
+		data      []byte
+	)
+	format := c.String("format")
+	if format != "yaml" && format != "json" {
+		err = fmt.Errorf("unsupported snapshot format '%s'", format)
+		Log.Error("%v", err)
+		return err
+	}
+
+	providers := []string{"lnx"}
+	if providerOverride != "" {
+		providers = []string{providerOverride}
+	}
+	if _, err = os.Stat(c.GlobalString("ns")); err == nil {
+		ns, err := loadNetworkScheme(c.GlobalString("ns"))
+		if err != nil {
+			return err
+		}
+		providers = ns.Providers()
+	}
+	if rtPlugins, err = NewRtPlugins(providers); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	if err = rtPlugins.Observe(); err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+
+	topology := rtPlugins.Topology(npstate.NewTopologyState())
+	if data, err = npstate.NewTopologySnapshot(topology).Marshal(format); err != nil {
+		Log.Error("Can't serialize snapshot: %v", err)
+		return err
+	}
+
+	output := c.String("output")
+	if output == "stdout" || output == "tty" {
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+	if err = ioutil.WriteFile(output, data, 0644); err != nil {
+		Log.Error("Can't store snapshot to '%s': %v", output, err)
+		return err
+	}
+	Log.Info("Network topology snapshot stored to '%s'", output)
+	return nil
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// GeneratedHeader -- first line of network config files, generated by
+// l23network
+const GeneratedHeader = "# Generated by l23network, don't edit manually\n"
+
+// GeneratedFiles -- returns files into given directory, which match one of
+// given patterns and start from GeneratedHeader. Directories of network
+// config contain hand-written files too, which should be kept untouched
+func GeneratedFiles(path string, patterns ...string) ([]string, error) {
+	rv := []string{}
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(content, []byte(GeneratedHeader)) {
+				rv = append(rv, file)
+			}
+		}
+	}
+	return rv, nil
+}
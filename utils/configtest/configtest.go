@@ -0,0 +1,52 @@
+// Package configtest -- helpers for tests of config plugins
+package configtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logger "github.com/xenolog/go-tiny-logger"
+	"github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
+)
+
+// Generate -- generate config of wanted state by config plugin, test is
+// failed if config can't be generated
+func Generate(t *testing.T, configPlugin plugin.ConfigPlugin, wantedState npstate.NPStates) {
+	configPlugin.SetLogger(logger.New())
+	configPlugin.SetWantedState(&wantedState)
+	if err := configPlugin.Generate(); err != nil {
+		t.Logf("Can't generate config: %v", err)
+		t.FailNow()
+	}
+}
+
+// GeneratedFiles -- create files with given content into temporary
+// directory and returns names of ones, which are reported by config
+// plugin as generated
+func GeneratedFiles(t *testing.T, cleaner plugin.ConfigCleaner, files map[string]string) []string {
+	dir, err := ioutil.TempDir("", "l23-config")
+	if err != nil {
+		t.Logf("Can't create directory: %v", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Logf("Can't create file: %v", err)
+			t.FailNow()
+		}
+	}
+	generated, err := cleaner.GeneratedFiles(dir)
+	if err != nil {
+		t.Logf("Can't find generated files: %v", err)
+		t.FailNow()
+	}
+	rv := []string{}
+	for _, name := range generated {
+		rv = append(rv, filepath.Base(name))
+	}
+	return rv
+}
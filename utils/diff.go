@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffContext -- number of unchanged lines around changes into unified diff
+const DiffContext = 3
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+	a, b int // numbers of lines of 'a' and 'b' before this one
+}
+
+// UnifiedDiff -- returns differences between texts 'a' and 'b' in unified
+// format, or empty string if texts are equal. Texts are compared line by
+// line, so it is intended for small files, like network configs
+func UnifiedDiff(aName, bName string, a, b []byte) string {
+	lines := diffLines(splitLines(string(a)), splitLines(string(b)))
+	rv := ""
+	for start := 0; start < len(lines); {
+		// find next group of changes, which are close to each other
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first; i < len(lines) && i <= last+2*DiffContext; i++ {
+			if lines[i].op != ' ' {
+				last = i
+			}
+		}
+		from := first - DiffContext
+		if from < start {
+			from = start
+		}
+		to := last + DiffContext + 1
+		if to > len(lines) {
+			to = len(lines)
+		}
+		rv += diffHunk(lines[from:to])
+		start = to
+	}
+	if rv == "" {
+		return ""
+	}
+	return fmt.Sprintf("--- %s\n+++ %s\n%s", aName, bName, rv)
+}
+
+func diffHunk(lines []diffLine) string {
+	aCount, bCount := 0, 0
+	body := ""
+	for _, l := range lines {
+		if l.op != '+' {
+			aCount++
+		}
+		if l.op != '-' {
+			bCount++
+		}
+		body += string(l.op) + l.text
+		if !strings.HasSuffix(l.text, "\n") {
+			body += "\n\\ No newline at end of file\n"
+		}
+	}
+	return fmt.Sprintf("@@ -%s +%s @@\n%s", hunkRange(lines[0].a, aCount), hunkRange(lines[0].b, bCount), body)
+}
+
+// hunkRange -- returns range of hunk lines in unified diff format. Empty
+// range starts from the line before hunk
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// diffLines -- returns edit script, which transforms 'a' to 'b', based on
+// the longest common subsequence of lines
+func diffLines(a, b []string) (rv []diffLine) {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			rv = append(rv, diffLine{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			rv = append(rv, diffLine{'-', a[i], i, j})
+			i++
+		default:
+			rv = append(rv, diffLine{'+', b[j], i, j})
+			j++
+		}
+	}
+	return rv
+}
+
+// splitLines -- split text to lines, keeping line endings
+func splitLines(text string) []string {
+	rv := strings.SplitAfter(text, "\n")
+	if rv[len(rv)-1] == "" {
+		rv = rv[:len(rv)-1]
+	}
+	return rv
+}
@@ -1,5 +1,11 @@
 package utils
 
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
 type AppConfig struct {
 	Debug           bool
 	DryRun          bool
@@ -33,3 +39,17 @@ func ReverseString(a []string) []string {
 	}
 	return a
 }
+
+// SortedKeys -- returns keys of given map in sorted order. Keys, which are
+// not strings (keys of parsed YAML, for example), are formatted by fmt
+func SortedKeys(m interface{}) (rv []string) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map {
+		return nil
+	}
+	for _, key := range v.MapKeys() {
+		rv = append(rv, fmt.Sprintf("%v", key.Interface()))
+	}
+	sort.Strings(rv)
+	return rv
+}
@@ -39,3 +39,55 @@ func TestPrepndString(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "l1\nl2\nl3\nl4\nl5\nl6\nl7\nl8\nl9\nl10\nl11\nl12\n"
+	b := "l1\nl2\nl3 changed\nl4\nl5\nl6\nl7\nl8\nl9\nl10\nl11\nl12\nl13"
+	wanted := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ l1
+ l2
+-l3
++l3 changed
+ l4
+ l5
+ l6
+@@ -10,3 +10,4 @@
+ l10
+ l11
+ l12
++l13
+\ No newline at end of file
+`
+	if rv := UnifiedDiff("a", "b", []byte(a), []byte(b)); rv != wanted {
+		t.Logf("Wrong diff:\n%s", rv)
+		t.Fail()
+	}
+	if rv := UnifiedDiff("a", "b", []byte(a), []byte(a)); rv != "" {
+		t.Logf("Unexpected diff of equal texts:\n%s", rv)
+		t.Fail()
+	}
+	if rv := UnifiedDiff("/dev/null", "b", nil, []byte("l1\n")); rv != "--- /dev/null\n+++ b\n@@ -0,0 +1 @@\n+l1\n" {
+		t.Logf("Wrong diff of new file:\n%s", rv)
+		t.Fail()
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	for _, tc := range []struct {
+		m      interface{}
+		wanted []string
+	}{
+		{map[string]bool{"tso": true, "gro": false}, []string{"gro", "tso"}},
+		{map[string]int{"tx": 1, "rx": 2}, []string{"rx", "tx"}},
+		{map[interface{}]interface{}{"mtu": 1, 2: "x"}, []string{"2", "mtu"}},
+		{map[string]string{}, nil},
+		{nil, nil},
+	} {
+		if rv := SortedKeys(tc.m); !reflect.DeepEqual(rv, tc.wanted) {
+			t.Logf("Wrong sorted keys of %v: %v, instead %v", tc.m, rv, tc.wanted)
+			t.Fail()
+		}
+	}
+}
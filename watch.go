@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	cli "github.com/urfave/cli"
+	"github.com/xenolog/l23/lnx"
+	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
+	. "github.com/xenolog/l23/utils"
+)
+
+// WatchQuietPeriod -- runtime changes, which come during this time after
+// re-convergence, are considered as made by re-convergence itself
+const WatchQuietPeriod = time.Second
+
+// RunWatch -- implement network scheme, then watch for runtime changes of
+// managed network primitives and re-converge them
+func RunWatch(c *cli.Context) (err error) {
+	var ns *NetworkScheme
+	dryrun := c.GlobalBool("dry-run")
+
+	Log.Debug("Run Watch with network scheme: '%s'", c.GlobalString("ns"))
+	if ns, err = loadNetworkScheme(c.GlobalString("ns")); err != nil {
+		return err
+	}
+
+	rtPlugins, err := initRtPlugins(ns)
+	if err != nil {
+		return err
+	}
+	if err = resolveInterfaces(ns, rtPlugins, dryrun); err != nil {
+		return err
+	}
+
+	// initial convergence
+	converge(ns, rtPlugins, nil, dryrun)
+
+	done := make(chan struct{})
+	defer close(done)
+	events, err := watchRtPlugins(rtPlugins, done)
+	if err != nil {
+		Log.Error("%v", err)
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-signals
+		Log.Info("Signal '%v' received, watching stopped", sig)
+		close(stop)
+	}()
+
+	loop := &watchLoop{
+		managed:        ns.TopologyState().NP,
+		debounce:       c.Duration("debounce"),
+		quiet:          WatchQuietPeriod,
+		maxCorrections: c.Int("max-corrections"),
+		backoff:        c.Duration("backoff"),
+		converge: func(only []string) []string {
+			return converge(ns, rtPlugins, only, dryrun)
+		},
+	}
+	return loop.run(events, stop)
+}
+
+// watchLoop -- collects runtime changes of managed network primitives and
+// re-converges them
+type watchLoop struct {
+	managed        npstate.NPStates
+	debounce       time.Duration // wait after the last change before re-convergence
+	quiet          time.Duration // changes after re-convergence are made by it
+	maxCorrections int           // corrections in a row before backoff
+	backoff        time.Duration // ignore changes of non-converging primitive
+	// converge -- re-converge listed network primitives, returns ones, which
+	// were changed
+	converge func(only []string) []string
+}
+
+// run -- process runtime events until events channel or 'stop' will be
+// closed. Re-convergence is made in background, so events are drained while
+// it works, otherwise the netlink socket overflows. Events, which come while
+// re-convergence or during quiet period after it, are caused by it and
+// ignored
+func (s *watchLoop) run(events <-chan plugin.RtEvent, stop <-chan struct{}) error {
+	var (
+		converged   chan []string // not nil while re-convergence works
+		convergedOn []string
+		quietUntil  time.Time
+	)
+	pending := []string{}
+	corrections := make(map[string]int)
+	lastCorrected := make(map[string]time.Time)
+	suspended := make(map[string]time.Time)
+	timer := time.NewTimer(s.debounce)
+	timer.Stop()
+	defer timer.Stop()
+	wait := func() {
+		if converged != nil {
+			<-converged
+		}
+	}
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				wait()
+				err := errors.New("runtime events subscription closed")
+				Log.Error("%v", err)
+				return err
+			}
+			if _, ok := s.managed[ev.Name]; !ok {
+				continue
+			}
+			switch now := time.Now(); {
+			case converged != nil || now.Before(quietUntil):
+				Log.Debug("Runtime change of '%s' made by re-convergence: %s", ev.Name, ev.Reason)
+				continue
+			case now.Before(suspended[ev.Name]):
+				Log.Debug("Runtime change of '%s' ignored, it doesn't converge: %s", ev.Name, ev.Reason)
+				continue
+			}
+			Log.Info("Runtime change of '%s': %s", ev.Name, ev.Reason)
+			if IndexString(pending, ev.Name) < 0 {
+				pending = append(pending, ev.Name)
+			}
+			timer.Reset(s.debounce)
+		case <-timer.C:
+			sort.Strings(pending)
+			convergedOn = pending
+			pending = []string{}
+			converged = make(chan []string, 1)
+			go func(only []string, rv chan<- []string) {
+				rv <- s.converge(only)
+			}(convergedOn, converged)
+		case changed := <-converged:
+			converged = nil
+			now := time.Now()
+			if len(changed) > 0 {
+				quietUntil = now.Add(s.quiet)
+			}
+			for _, npName := range convergedOn {
+				if IndexString(changed, npName) < 0 {
+					// no drift, network primitive is converged
+					delete(corrections, npName)
+					continue
+				}
+				if now.Sub(lastCorrected[npName]) > s.backoff {
+					corrections[npName] = 0
+				}
+				corrections[npName]++
+				lastCorrected[npName] = now
+				if corrections[npName] >= s.maxCorrections {
+					Log.Warn("'%s' drifts again after %d corrections, its runtime changes are ignored for %v",
+						npName, corrections[npName], s.backoff)
+					suspended[npName] = now.Add(s.backoff)
+					delete(corrections, npName)
+				}
+			}
+		case <-stop:
+			wait()
+			return nil
+		}
+	}
+}
+
+// converge -- observe runtime, compare it with network scheme and implement
+// changes. If 'only' is not nil, only listed network primitives will be
+// processed. Returns network primitives, which were changed
+func converge(ns *NetworkScheme, rtPlugins *RtPlugins, only []string, dryrun bool) (changed []string) {
+	// wanted topology should be re-generated every time, because
+	// comparing may change it
+	wantedNetState := ns.TopologyState()
+	rtPlugins.LimitEthtool(wantedNetState)
+	if err := rtPlugins.Observe(); err != nil {
+		Log.Error("Can't observe runtime: %v", err)
+		return nil
+	}
+	diffNetState := rtPlugins.Topology(wantedNetState).Compare(wantedNetState)
+	if only != nil {
+		diffNetState = filterDiff(diffNetState, only)
+	}
+	if diffNetState.IsEqual() {
+		if only != nil {
+			Log.Debug("No drift found for %v", only)
+		}
+		return nil
+	}
+
+	if only != nil {
+		for _, npName := range diffNetState.New {
+			Log.Warn("Drift detected: '%s' is absent", npName)
+		}
+		for _, npName := range diffNetState.Different {
+			Log.Warn("Drift detected: '%s' differs from network scheme", npName)
+		}
+		for _, npName := range diffNetState.Waste {
+			Log.Warn("Drift detected: '%s' should be removed", npName)
+		}
+	}
+
+	failed := applyChanges(rtPlugins, wantedNetState, diffNetState, dryrun).Failed()
+	if !dryrun {
+		changed = append(changed, diffNetState.New...)
+		changed = append(changed, diffNetState.Different...)
+		changed = append(changed, diffNetState.Waste...)
+	}
+
+	if only != nil {
+		for _, npName := range only {
+			if IndexString(diffNetState.New, npName) < 0 &&
+				IndexString(diffNetState.Different, npName) < 0 &&
+				IndexString(diffNetState.Waste, npName) < 0 {
+				continue
+			}
+			if err, ok := failed[npName]; ok {
+				Log.Error("Drift of '%s' not corrected: %v", npName, err)
+			} else if dryrun {
+				Log.Info("Drift of '%s' not corrected (dry-run)", npName)
+			} else {
+				Log.Info("Drift of '%s' corrected", npName)
+			}
+		}
+	}
+	return changed
+}
+
+// watchRtPlugins -- subscribe to runtime changes by all providers, which
+// support it, and merge events into one channel. Returned channel will be
+// closed when all subscriptions are ended
+func watchRtPlugins(rtPlugins *RtPlugins, done <-chan struct{}) (<-chan plugin.RtEvent, error) {
+	var wg sync.WaitGroup
+	rv := make(chan plugin.RtEvent, lnx.WatchBufferSize)
+	watched := []string{}
+	for _, name := range rtPlugins.Providers {
+		watcher, ok := rtPlugins.Plugins[name].(plugin.Watcher)
+		if !ok {
+			Log.Warn("Provider '%s' does not support watching for runtime changes", name)
+			continue
+		}
+		events, err := watcher.Watch(done)
+		if err != nil {
+			return nil, fmt.Errorf("provider '%s': %v", name, err)
+		}
+		watched = append(watched, name)
+		wg.Add(1)
+		go func(events <-chan plugin.RtEvent) {
+			defer wg.Done()
+			for ev := range events {
+				select {
+				case rv <- ev:
+				case <-done:
+					return
+				}
+			}
+		}(events)
+	}
+	if len(watched) == 0 {
+		return nil, errors.New("no one provider supports watching for runtime changes")
+	}
+	go func() {
+		wg.Wait()
+		close(rv)
+	}()
+	return rv, nil
+}
+
+// filterDiff -- returns diff, which contains only listed network primitives
+func filterDiff(diff *npstate.DiffTopologyStatees, only []string) *npstate.DiffTopologyStatees {
+	rv := new(npstate.DiffTopologyStatees)
+	for _, npName := range diff.New {
+		if IndexString(only, npName) >= 0 {
+			rv.New = append(rv.New, npName)
+		}
+	}
+	for _, npName := range diff.Waste {
+		if IndexString(only, npName) >= 0 {
+			rv.Waste = append(rv.Waste, npName)
+		}
+	}
+	for _, npName := range diff.Different {
+		if IndexString(only, npName) >= 0 {
+			rv.Different = append(rv.Different, npName)
+		}
+	}
+	return rv
+}
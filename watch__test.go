@@ -0,0 +1,281 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/xenolog/l23/lnx"
+	npstate "github.com/xenolog/l23/npstate"
+	"github.com/xenolog/l23/plugin"
+)
+
+func TestWatch__FilterDiff(t *testing.T) {
+	diff := &npstate.DiffTopologyStatees{
+		New:       []string{"br1", "eth1.101"},
+		Waste:     []string{"eth1.222"},
+		Different: []string{"eth0", "eth1"},
+	}
+	rv := filterDiff(diff, []string{"eth1", "eth1.101", "eth1.222", "eth2"})
+	wanted := &npstate.DiffTopologyStatees{
+		New:       []string{"eth1.101"},
+		Waste:     []string{"eth1.222"},
+		Different: []string{"eth1"},
+	}
+	if !reflect.DeepEqual(rv, wanted) {
+		t.Logf("Wrong filtered diff:\n%s\ninstead:\n%s", rv, wanted)
+		t.Fail()
+	}
+	if rv = filterDiff(diff, []string{"lo"}); !rv.IsEqual() {
+		t.Logf("Filtered diff should be empty:\n%s", rv)
+		t.Fail()
+	}
+}
+
+// watchLoopTester -- runs watch loop with fake re-convergence, which reports
+// every call and returns given changed network primitives
+type watchLoopTester struct {
+	loop    *watchLoop
+	events  chan plugin.RtEvent
+	stop    chan struct{}
+	result  chan error
+	calls   chan []string
+	changed []string
+}
+
+func newWatchLoopTester(maxCorrections int) *watchLoopTester {
+	rv := new(watchLoopTester)
+	managed := npstate.NPStates{
+		"eth1": &npstate.NPState{Name: "eth1"},
+		"eth2": &npstate.NPState{Name: "eth2"},
+	}
+	rv.run(managed, maxCorrections, func(only []string) []string {
+		return rv.changed
+	})
+	return rv
+}
+
+// run -- start watch loop for given managed network primitives and
+// re-convergence. Every finished re-convergence is reported
+func (s *watchLoopTester) run(managed npstate.NPStates, maxCorrections int, converge func(only []string) []string) {
+	s.events = make(chan plugin.RtEvent)
+	s.stop = make(chan struct{})
+	s.result = make(chan error, 1)
+	s.calls = make(chan []string, 10)
+	s.loop = &watchLoop{
+		managed:        managed,
+		debounce:       10 * time.Millisecond,
+		quiet:          100 * time.Millisecond,
+		maxCorrections: maxCorrections,
+		backoff:        time.Hour,
+		converge: func(only []string) []string {
+			changed := converge(only)
+			s.calls <- only
+			return changed
+		},
+	}
+	go func() {
+		s.result <- s.loop.run(s.events, s.stop)
+	}()
+}
+
+func (s *watchLoopTester) send(names ...string) {
+	for _, name := range names {
+		s.events <- plugin.RtEvent{Name: name, Reason: "link changed"}
+	}
+}
+
+// wait -- returns network primitives of the next re-convergence, or nil if
+// there was no one
+func (s *watchLoopTester) wait() []string {
+	select {
+	case only := <-s.calls:
+		// let the loop get result of re-convergence
+		time.Sleep(10 * time.Millisecond)
+		return only
+	case <-time.After(200 * time.Millisecond):
+		return nil
+	}
+}
+
+func (s *watchLoopTester) finish(t *testing.T) {
+	close(s.stop)
+	if err := <-s.result; err != nil {
+		t.Logf("Watch loop finished with error: %v", err)
+		t.Fail()
+	}
+}
+
+func TestWatch__Debounce(t *testing.T) {
+	tester := newWatchLoopTester(3)
+	defer tester.finish(t)
+
+	// changes, which come together, are re-converged once, not managed
+	// network primitives are ignored
+	tester.send("eth2", "lo", "eth1", "eth2")
+	if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth1", "eth2"}) {
+		t.Logf("Wrong re-converged network primitives %v", only)
+		t.Fail()
+	}
+	if only := tester.wait(); only != nil {
+		t.Logf("Unexpected re-convergence of %v", only)
+		t.Fail()
+	}
+	tester.send("lo")
+	if only := tester.wait(); only != nil {
+		t.Logf("Not managed network primitive re-converged")
+		t.Fail()
+	}
+}
+
+func TestWatch__NoDiff(t *testing.T) {
+	tester := newWatchLoopTester(1)
+	defer tester.finish(t)
+
+	// nothing is changed by re-convergence, so next change is processed
+	// immediately and no one network primitive is suspended
+	for i := 0; i < 3; i++ {
+		tester.send("eth1")
+		if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth1"}) {
+			t.Logf("Wrong re-converged network primitives %v", only)
+			t.Fail()
+		}
+	}
+}
+
+func TestWatch__Correction(t *testing.T) {
+	tester := newWatchLoopTester(3)
+	tester.changed = []string{"eth1"}
+	defer tester.finish(t)
+
+	tester.send("eth1")
+	if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth1"}) {
+		t.Logf("Wrong re-converged network primitives %v", only)
+		t.FailNow()
+	}
+	// changes, made by re-convergence itself, are ignored
+	tester.send("eth1", "eth2")
+	if only := tester.wait(); only != nil {
+		t.Logf("Re-convergence caused by own changes of %v", only)
+		t.Fail()
+	}
+	// but not after the quiet period
+	time.Sleep(tester.loop.quiet)
+	tester.send("eth2")
+	if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth2"}) {
+		t.Logf("Wrong re-converged network primitives %v", only)
+		t.Fail()
+	}
+}
+
+func TestWatch__Backoff(t *testing.T) {
+	tester := newWatchLoopTester(2)
+	tester.changed = []string{"eth1"}
+	defer tester.finish(t)
+
+	for i := 0; i < 2; i++ {
+		tester.send("eth1")
+		if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth1"}) {
+			t.Logf("Wrong re-converged network primitives %v", only)
+			t.FailNow()
+		}
+		time.Sleep(tester.loop.quiet)
+	}
+	// eth1 drifts again after every correction, it is not corrected anymore
+	tester.send("eth1")
+	if only := tester.wait(); only != nil {
+		t.Logf("Non-converging network primitive re-converged again")
+		t.Fail()
+	}
+	tester.send("eth2")
+	if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth2"}) {
+		t.Logf("Wrong re-converged network primitives %v", only)
+		t.Fail()
+	}
+}
+
+func TestWatch__SubscriptionClosed(t *testing.T) {
+	tester := newWatchLoopTester(3)
+	close(tester.events)
+	if err := <-tester.result; err == nil {
+		t.Logf("Closed subscription should stop watching with error")
+		t.Fail()
+	}
+}
+
+// Watch loop with the real re-convergence of network primitives, kept into
+// in-memory kernel
+func TestWatch__MemKernel(t *testing.T) {
+	kernel := lnx.NewMemKernel()
+	eth1 := &netlink.Device{}
+	eth1.Name = "eth1"
+	kernel.AddLink(eth1)
+	lnxRtPlugin := lnx.NewLnxRtPluginWithKernel(kernel)
+	if err := lnxRtPlugin.Init(Log, nil); err != nil {
+		t.FailNow()
+	}
+	rtPlugins := &RtPlugins{
+		Providers: []string{"lnx"},
+		Plugins:   map[string]plugin.RtPlugin{"lnx": lnxRtPlugin},
+	}
+	ns := new(NetworkScheme)
+	if err := ns.Load(strings.NewReader(`
+version: 1.2
+provider: lnx
+interfaces:
+  eth1: {}
+transformations:
+  - name: eth1.101
+    action: port
+    parent: eth1
+    vlan_id: 101
+endpoints:
+  eth1.101:
+    IP:
+      - '10.30.30.30/26'
+`)); err != nil {
+		t.FailNow()
+	}
+	// initial convergence
+	converge(ns, rtPlugins, nil, false)
+
+	tester := new(watchLoopTester)
+	tester.run(ns.TopologyState().NP, 3, func(only []string) []string {
+		return converge(ns, rtPlugins, only, false)
+	})
+	defer tester.finish(t)
+
+	// no drift, nothing is changed
+	kernel.ResetOps()
+	tester.send("eth1.101")
+	if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth1.101"}) {
+		t.Logf("Wrong re-converged network primitives %v", only)
+		t.Fail()
+	}
+	if ops := kernel.Ops(); len(ops) > 0 {
+		t.Logf("Converged network primitive is changed: %v", ops)
+		t.Fail()
+	}
+
+	// drift is corrected
+	vlan, _ := kernel.LinkByName("eth1.101")
+	kernel.LinkSetDown(vlan)
+	kernel.ResetOps()
+	tester.send("eth1.101", "eth1")
+	if only := tester.wait(); !reflect.DeepEqual(only, []string{"eth1", "eth1.101"}) {
+		t.Logf("Wrong re-converged network primitives %v", only)
+		t.Fail()
+	}
+	if ops := kernel.Ops(); !reflect.DeepEqual(ops, []string{"link set eth1.101 up"}) {
+		t.Logf("Wrong correction of drift: %v", ops)
+		t.Fail()
+	}
+	// changes of correction itself are not re-converged
+	tester.send("eth1.101")
+	if only := tester.wait(); only != nil {
+		t.Logf("Re-convergence caused by own changes of %v", only)
+		t.Fail()
+	}
+}